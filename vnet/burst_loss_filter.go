@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+var errInvalidPercentage = errors.New("percentage must be between 0 and 100")
+
+// BurstLossFilter is a wrapper around NICs, that drops packets passed to
+// onInboundChunk in bursts, like a wireless link does. It follows the
+// Gilbert-Elliott model: a two-state Markov chain with a good and a bad
+// state, each with a loss percentage of its own. Before each packet, the
+// filter moves from the good to the bad state with the probability
+// GoodToBad, and back with the probability BadToGood. The mean length of a
+// bad period is 100/BadToGood packets.
+type BurstLossFilter struct {
+	NIC
+	goodToBad float64    // read-only, percent
+	badToGood float64    // read-only, percent
+	lossGood  float64    // read-only, percent
+	lossBad   float64    // read-only, percent
+	bad       bool       // requires mutex
+	rand      *rand.Rand // requires mutex
+	mutex     sync.Mutex
+}
+
+// BurstLossFilterOption is the option type to configure a BurstLossFilter
+type BurstLossFilterOption func(*BurstLossFilter)
+
+// BurstLossFilterTransitions sets the percentages of packets on which the
+// filter moves from the good to the bad state, and from the bad to the good
+// state. Default to 0 and 100, which never leaves the good state.
+func BurstLossFilterTransitions(goodToBad, badToGood float64) BurstLossFilterOption {
+	return func(f *BurstLossFilter) {
+		f.goodToBad = goodToBad
+		f.badToGood = badToGood
+	}
+}
+
+// BurstLossFilterLoss sets the percentages of packets dropped in the good
+// and in the bad state. Default to 0 and 100, the Gilbert model.
+func BurstLossFilterLoss(lossGood, lossBad float64) BurstLossFilterOption {
+	return func(f *BurstLossFilter) {
+		f.lossGood = lossGood
+		f.lossBad = lossBad
+	}
+}
+
+// BurstLossFilterRand sets the random number generator that decides which
+// packets are dropped, for a reproducible run. It must not be shared.
+// Defaults to a generator seeded from the system clock.
+func BurstLossFilterRand(rng *rand.Rand) BurstLossFilterOption {
+	return func(f *BurstLossFilter) {
+		f.rand = randOrSeeded(rng)
+	}
+}
+
+// NewBurstLossFilter creates a new BurstLossFilter, starting in the good
+// state. Every packet that is not dropped is passed on to the given NIC.
+func NewBurstLossFilter(nic NIC, opts ...BurstLossFilterOption) (*BurstLossFilter, error) {
+	f := &BurstLossFilter{
+		NIC:       nic,
+		badToGood: 100,
+		lossBad:   100,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	for _, percent := range []float64{f.goodToBad, f.badToGood, f.lossGood, f.lossBad} {
+		if percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("%w: %v", errInvalidPercentage, percent)
+		}
+	}
+	if f.rand == nil {
+		f.rand = randOrSeeded(nil)
+	}
+	return f, nil
+}
+
+func (f *BurstLossFilter) onInboundChunk(c Chunk) {
+	f.mutex.Lock()
+	if f.bad {
+		f.bad = f.rand.Float64()*100 >= f.badToGood
+	} else {
+		f.bad = f.rand.Float64()*100 < f.goodToBad
+	}
+
+	loss := f.lossGood
+	if f.bad {
+		loss = f.lossBad
+	}
+	drop := f.rand.Float64()*100 < loss
+	f.mutex.Unlock()
+
+	if drop {
+		return
+	}
+
+	f.NIC.onInboundChunk(c)
+}
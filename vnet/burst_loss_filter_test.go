@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBurstLossFilter(t *testing.T) {
+	// run passes packets through a filter and returns the numbers of those
+	// received
+	run := func(t *testing.T, packets int, opts ...BurstLossFilterOption) []int {
+		t.Helper()
+
+		mnic := newMockNIC(t)
+		f, err := NewBurstLossFilter(mnic, opts...)
+		if !assert.NoError(t, err, "should succeed") {
+			return nil
+		}
+
+		var received []int
+		mnic.mockOnInboundChunk = func(c Chunk) {
+			received = append(received, int(binary.BigEndian.Uint32(c.UserData())))
+		}
+		for i := 0; i < packets; i++ {
+			userData := make([]byte, 4)
+			binary.BigEndian.PutUint32(userData, uint32(i))
+			f.onInboundChunk(&chunkUDP{userData: userData})
+		}
+		return received
+	}
+
+	t.Run("NoLoss", func(t *testing.T) {
+		assert.Equal(t, 100, len(run(t, 100)), "should receive all")
+	})
+
+	t.Run("InvalidPercentage", func(t *testing.T) {
+		_, err := NewBurstLossFilter(newMockNIC(t), BurstLossFilterTransitions(-1, 50))
+		assert.ErrorIs(t, err, errInvalidPercentage, "should fail")
+		_, err = NewBurstLossFilter(newMockNIC(t), BurstLossFilterLoss(0, 100.5))
+		assert.ErrorIs(t, err, errInvalidPercentage, "should fail")
+	})
+
+	t.Run("AlwaysBad", func(t *testing.T) {
+		received := run(t, 100, BurstLossFilterTransitions(100, 0))
+		assert.Equal(t, 0, len(received), "should drop all")
+	})
+
+	t.Run("Bursts", func(t *testing.T) {
+		packets := 100000
+		received := run(t, packets,
+			BurstLossFilterTransitions(1, 10),
+			BurstLossFilterLoss(0.5, 100),
+			BurstLossFilterRand(rand.New(rand.NewSource(1))), //nolint:gosec
+		)
+
+		// the bad state lasts 10 packets on average, and is entered once
+		// every 100 packets of the good state
+		var bursts, lost int
+		next := 0
+		for _, nr := range received {
+			if nr > next {
+				lost += nr - next
+				if nr-next > 1 {
+					bursts++
+				}
+			}
+			next = nr + 1
+		}
+		lossRate := float64(lost) / float64(packets)
+		assert.InDelta(t, 1.0/11+0.005*10/11, lossRate, 0.01, "should match the stationary loss")
+		assert.InDelta(t, 10, float64(lost)/float64(bursts), 2, "should lose packets in bursts")
+	})
+
+	t.Run("Seeded", func(t *testing.T) {
+		seeded := func() []int {
+			return run(t, 1000,
+				BurstLossFilterTransitions(2.5, 25),
+				BurstLossFilterLoss(1, 75),
+				BurstLossFilterRand(rand.New(rand.NewSource(1))), //nolint:gosec
+			)
+		}
+		assert.Equal(t, seeded(), seeded(), "should drop the same packets")
+	})
+}
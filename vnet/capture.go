@@ -0,0 +1,331 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pcapng block types and options, see
+// https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-02.html
+const (
+	pcapngSectionHeaderBlock        = 0x0A0D0D0A
+	pcapngInterfaceDescriptionBlock = 0x00000001
+	pcapngEnhancedPacketBlock       = 0x00000006
+	pcapngByteOrderMagic            = 0x1A2B3C4D
+
+	pcapngOptEndOfOpt      = 0
+	pcapngOptSHBUserAppl   = 4
+	pcapngOptIfName        = 2
+	pcapngOptIfDescription = 3
+	pcapngOptIfIPv4Addr    = 4
+	pcapngOptIfIPv6Addr    = 5
+	pcapngOptIfTSResol     = 9
+
+	linkTypeRaw = 101 // raw IPv4 or IPv6 packets, without a link layer
+	tsResolNano = 9   // timestamps in units of 10^-9 seconds
+)
+
+var errCaptureClosed = errors.New("capture closed")
+
+// Capture writes the chunks seen at one or more points of a virtual network
+// to a pcapng stream, which can be opened with Wireshark or tcpdump. Each
+// point is recorded as an interface of its own. The chunks are written as
+// raw IP packets synthesized from their addresses, ports and payload.
+type Capture struct {
+	w      io.Writer // requires mutex
+	closer io.Closer // read-only, nil if the writer is not owned
+	nextID uint32    // requires mutex, id of the next interface description
+	err    error     // requires mutex, the first write error
+	mutex  sync.Mutex
+}
+
+// NewCapture creates a new Capture that writes to w.
+func NewCapture(w io.Writer) (*Capture, error) {
+	c := &Capture{w: w}
+	if err := c.writeSectionHeader(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewCaptureFile creates a new Capture that writes to the named file. The
+// file is created or truncated, and is closed by Close.
+func NewCaptureFile(name string) (*Capture, error) {
+	f, err := os.Create(name) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := NewCapture(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	c.closer = f
+	return c, nil
+}
+
+// Close stops the capture. It closes the file of a Capture created with
+// NewCaptureFile, and returns the first error that occurred while writing.
+func (c *Capture) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.w == nil {
+		return errCaptureClosed
+	}
+	c.w = nil
+
+	err := c.err
+	if c.closer != nil {
+		if cerr := c.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// CaptureOption is the option type to configure an interface of a Capture
+type CaptureOption func(*captureInterface)
+
+// CaptureInterfaceName sets the name of the interface the chunks are
+// recorded on.
+func CaptureInterfaceName(name string) CaptureOption {
+	return func(ifc *captureInterface) {
+		ifc.name = name
+	}
+}
+
+// CaptureInterfaceDescription sets the description of the interface the
+// chunks are recorded on.
+func CaptureInterfaceDescription(description string) CaptureOption {
+	return func(ifc *captureInterface) {
+		ifc.description = description
+	}
+}
+
+// captureInterface is a capture point. Its interface description is written
+// along with its first chunk, once the addresses of the NIC are assigned.
+type captureInterface struct {
+	capture     *Capture
+	clock       Clock
+	name        string
+	description string
+	addrs       func() []*net.IPNet
+	id          uint32 // requires capture mutex
+	described   bool   // requires capture mutex
+}
+
+func (c *Capture) newInterface(clock Clock, name, description string, addrs func() []*net.IPNet, opts []CaptureOption) *captureInterface {
+	ifc := &captureInterface{
+		capture:     c,
+		clock:       clockOrSystem(clock),
+		name:        name,
+		description: description,
+		addrs:       addrs,
+	}
+	for _, opt := range opts {
+		opt(ifc)
+	}
+	return ifc
+}
+
+// AttachRouter records all chunks that traverse the router, in both
+// directions, as a chunk filter that passes every chunk. The interface is
+// named after the router by default. Chunks dropped by filters added
+// before are not recorded.
+func (c *Capture) AttachRouter(r *Router, opts ...CaptureOption) {
+	var subnets []*net.IPNet
+	var cidrs []string
+	for _, ipNet := range []*net.IPNet{r.ipv4Net, r.ipv6Net} {
+		if ipNet != nil {
+			subnets = append(subnets, ipNet)
+			cidrs = append(cidrs, ipNet.String())
+		}
+	}
+
+	ifc := c.newInterface(r.clock, r.name, "vnet router "+strings.Join(cidrs, " "), func() []*net.IPNet {
+		return subnets
+	}, opts)
+
+	r.AddChunkFilter(func(chunk Chunk) bool {
+		ifc.write(chunk)
+		return true
+	})
+}
+
+// CaptureFilter is a wrapper around NICs, that records the chunks passed to
+// onInboundChunk
+type CaptureFilter struct {
+	NIC
+	ifc *captureInterface
+}
+
+// NewCaptureFilter creates a new CaptureFilter that records every chunk
+// delivered to the given NIC to the capture. The interface is named eth0
+// and carries the addresses of the NIC's eth0 by default. The chunks are
+// timestamped with the clock of the NIC if it is a Net or a Router.
+func NewCaptureFilter(nic NIC, capture *Capture, opts ...CaptureOption) (*CaptureFilter, error) {
+	addrs := func() []*net.IPNet {
+		eth0, err := nic.getInterface("eth0")
+		if err != nil {
+			return nil
+		}
+		ifAddrs, err := eth0.Addrs()
+		if err != nil {
+			return nil
+		}
+
+		var ipNets []*net.IPNet
+		for _, addr := range ifAddrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				ipNets = append(ipNets, ipNet)
+			}
+		}
+		return ipNets
+	}
+
+	return &CaptureFilter{
+		NIC: nic,
+		ifc: capture.newInterface(nicClock(nic), "eth0", "vnet NIC", addrs, opts),
+	}, nil
+}
+
+// nicClock returns the clock of the Net or Router, or the system clock for
+// other NICs
+func nicClock(nic NIC) Clock {
+	switch n := nic.(type) {
+	case *Net:
+		return n.clock
+	case *Router:
+		return n.clock
+	default:
+		return systemClock{}
+	}
+}
+
+func (f *CaptureFilter) onInboundChunk(c Chunk) {
+	f.ifc.write(c)
+	f.NIC.onInboundChunk(c)
+}
+
+func (ifc *captureInterface) write(chunk Chunk) {
+	frame := marshalChunk(chunk)
+	now := ifc.clock.Now()
+
+	c := ifc.capture
+	c.mutex.Lock()
+	described := ifc.described
+	c.mutex.Unlock()
+
+	// look up the addresses without the mutex, as the NIC may be routing
+	var addrs []*net.IPNet
+	if !described {
+		addrs = ifc.addrs()
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.w == nil || c.err != nil {
+		return
+	}
+
+	if !ifc.described {
+		ifc.id = c.nextID
+		c.nextID++
+		ifc.described = true
+		if c.err = c.writeInterfaceDescription(ifc, addrs); c.err != nil {
+			return
+		}
+	}
+
+	c.err = c.writeEnhancedPacket(ifc.id, now, frame)
+}
+
+// caller must hold the mutex
+func (c *Capture) writeBlock(blockType uint32, body []byte) error {
+	length := uint32(12 + len(body))
+
+	b := make([]byte, 0, length)
+	b = binary.LittleEndian.AppendUint32(b, blockType)
+	b = binary.LittleEndian.AppendUint32(b, length)
+	b = append(b, body...)
+	b = binary.LittleEndian.AppendUint32(b, length)
+
+	if _, err := c.w.Write(b); err != nil {
+		return fmt.Errorf("failed to write capture: %w", err)
+	}
+	return nil
+}
+
+func (c *Capture) writeSectionHeader() error {
+	b := binary.LittleEndian.AppendUint32(nil, pcapngByteOrderMagic)
+	b = binary.LittleEndian.AppendUint16(b, 1) // major version
+	b = binary.LittleEndian.AppendUint16(b, 0) // minor version
+	b = binary.LittleEndian.AppendUint64(b, 0xFFFFFFFFFFFFFFFF)
+	b = appendOption(b, pcapngOptSHBUserAppl, []byte("pion/transport vnet"))
+	b = appendOption(b, pcapngOptEndOfOpt, nil)
+
+	return c.writeBlock(pcapngSectionHeaderBlock, b)
+}
+
+// caller must hold the mutex
+func (c *Capture) writeInterfaceDescription(ifc *captureInterface, addrs []*net.IPNet) error {
+	b := binary.LittleEndian.AppendUint16(nil, linkTypeRaw)
+	b = binary.LittleEndian.AppendUint16(b, 0) // reserved
+	b = binary.LittleEndian.AppendUint32(b, 0) // no snap length
+	b = appendOption(b, pcapngOptIfName, []byte(ifc.name))
+	if len(ifc.description) > 0 {
+		b = appendOption(b, pcapngOptIfDescription, []byte(ifc.description))
+	}
+	for _, ipNet := range addrs {
+		if ip := ipNet.IP.To4(); ip != nil {
+			b = appendOption(b, pcapngOptIfIPv4Addr, append(append([]byte{}, ip...), net.IP(ipNet.Mask).To4()...))
+		} else {
+			ones, _ := ipNet.Mask.Size()
+			b = appendOption(b, pcapngOptIfIPv6Addr, append(append([]byte{}, ipNet.IP.To16()...), byte(ones)))
+		}
+	}
+	b = appendOption(b, pcapngOptIfTSResol, []byte{tsResolNano})
+	b = appendOption(b, pcapngOptEndOfOpt, nil)
+
+	return c.writeBlock(pcapngInterfaceDescriptionBlock, b)
+}
+
+// caller must hold the mutex
+func (c *Capture) writeEnhancedPacket(ifID uint32, t time.Time, frame []byte) error {
+	ts := uint64(t.UnixNano())
+
+	b := binary.LittleEndian.AppendUint32(nil, ifID)
+	b = binary.LittleEndian.AppendUint32(b, uint32(ts>>32))
+	b = binary.LittleEndian.AppendUint32(b, uint32(ts))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(frame))) // captured length
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(frame))) // original length
+	b = append(b, frame...)
+	b = append(b, make([]byte, padding(len(frame)))...)
+
+	return c.writeBlock(pcapngEnhancedPacketBlock, b)
+}
+
+// appendOption appends an option, padded to 32 bits, to a block body
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return append(b, make([]byte, padding(len(value)))...)
+}
+
+func padding(n int) int {
+	return (4 - n%4) % 4
+}
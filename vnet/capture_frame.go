@@ -0,0 +1,259 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"encoding/binary"
+)
+
+const (
+	ipProtoICMP   = 1
+	ipProtoTCP    = 6
+	ipProtoUDP    = 17
+	ipProtoFrag   = 44 // IPv6 fragment extension header
+	ipProtoICMPv6 = 58
+
+	ipv4FlagMoreFragments = 0x2000
+	ipv6FragHeaderSize    = 8
+	defaultTTL            = 64
+	tcpOptionMSS          = 2
+)
+
+// marshalChunk synthesizes the raw IP packet that carries the chunk, as it
+// would appear on the wire. The chunks of vnet carry no checksums, window
+// sizes and such, which are filled in with plausible values. The transport
+// header of the first fragment of a chunk is a header for the fragment's
+// data only, since the size of the original chunk is unknown; its checksum
+// is zero.
+func marshalChunk(c Chunk) []byte {
+	proto, l4 := marshalTransport(c)
+	if c.getDestinationIP().To4() != nil {
+		return marshalIPv4(c, proto, l4)
+	}
+	return marshalIPv6(c, proto, l4)
+}
+
+func marshalIPv4(c Chunk, proto byte, l4 []byte) []byte {
+	b := make([]byte, ipv4HeaderSize, ipv4HeaderSize+len(l4))
+	b[0] = 0x45 // version 4, 5 words of header
+	binary.BigEndian.PutUint16(b[2:], uint16(ipv4HeaderSize+len(l4)))
+	if f := c.getFragment(); f != nil {
+		flags := uint16(fragmentOffset(c)/8) & 0x1FFF
+		if f.more {
+			flags |= ipv4FlagMoreFragments
+		}
+		binary.BigEndian.PutUint16(b[4:], uint16(f.id))
+		binary.BigEndian.PutUint16(b[6:], flags)
+	}
+	b[8] = defaultTTL
+	b[9] = proto
+	copy(b[12:16], c.getSourceIP().To4())
+	copy(b[16:20], c.getDestinationIP().To4())
+	binary.BigEndian.PutUint16(b[10:], checksum(b, 0))
+
+	return append(b, l4...)
+}
+
+func marshalIPv6(c Chunk, proto byte, l4 []byte) []byte {
+	var ext []byte
+	nextHeader := proto
+	if f := c.getFragment(); f != nil {
+		ext = make([]byte, ipv6FragHeaderSize)
+		ext[0] = proto
+		offset := uint16(fragmentOffset(c)/8) << 3
+		if f.more {
+			offset |= 1
+		}
+		binary.BigEndian.PutUint16(ext[2:], offset)
+		binary.BigEndian.PutUint32(ext[4:], f.id)
+		nextHeader = ipProtoFrag
+	}
+
+	b := make([]byte, ipv6HeaderSize, ipv6HeaderSize+len(ext)+len(l4))
+	b[0] = 0x60 // version 6
+	binary.BigEndian.PutUint16(b[4:], uint16(len(ext)+len(l4)))
+	b[6] = nextHeader
+	b[7] = defaultTTL
+	copy(b[8:24], c.getSourceIP().To16())
+	copy(b[24:40], c.getDestinationIP().To16())
+
+	return append(append(b, ext...), l4...)
+}
+
+// fragmentOffset returns the offset of a fragment's data within the
+// transport segment of the original chunk.
+func fragmentOffset(c Chunk) int {
+	f := c.getFragment()
+	if f.offset == 0 {
+		return 0
+	}
+	switch c.(type) {
+	case *chunkUDP:
+		return udpHeaderSize + f.offset
+	case *chunkTCP:
+		return tcpHeaderSize + f.offset
+	default:
+		return f.offset
+	}
+}
+
+// marshalTransport returns the IP protocol number and the transport segment
+// of the chunk, or of the part of it that the chunk carries if it is a
+// fragment.
+func marshalTransport(c Chunk) (byte, []byte) {
+	var proto byte
+	var l4 []byte
+	switch c := c.(type) {
+	case *chunkUDP:
+		proto, l4 = ipProtoUDP, marshalUDP(c)
+	case *chunkTCP:
+		proto, l4 = ipProtoTCP, marshalTCP(c)
+	case *chunkICMP:
+		if c.getDestinationIP().To4() != nil {
+			return ipProtoICMP, marshalICMPv4(c)
+		}
+		return ipProtoICMPv6, marshalICMPv6(c)
+	}
+
+	f := c.getFragment()
+	if f == nil {
+		sum := checksum(l4, pseudoHeaderSum(c, proto, len(l4)))
+		if sum == 0 && proto == ipProtoUDP {
+			sum = 0xFFFF // zero means no checksum
+		}
+		binary.BigEndian.PutUint16(l4[checksumOffset(proto):], sum)
+	} else if f.offset > 0 {
+		return proto, c.UserData()
+	}
+
+	return proto, l4
+}
+
+func checksumOffset(proto byte) int {
+	if proto == ipProtoUDP {
+		return 6
+	}
+	return 16
+}
+
+func marshalUDP(c *chunkUDP) []byte {
+	b := make([]byte, udpHeaderSize, udpHeaderSize+len(c.userData))
+	binary.BigEndian.PutUint16(b[0:], uint16(c.sourcePort))
+	binary.BigEndian.PutUint16(b[2:], uint16(c.destinationPort))
+	binary.BigEndian.PutUint16(b[4:], uint16(udpHeaderSize+len(c.userData)))
+
+	return append(b, c.userData...)
+}
+
+func marshalTCP(c *chunkTCP) []byte {
+	headerSize := tcpHeaderSize
+	if c.mss > 0 {
+		headerSize += 4
+	}
+
+	b := make([]byte, headerSize, headerSize+len(c.userData))
+	binary.BigEndian.PutUint16(b[0:], uint16(c.sourcePort))
+	binary.BigEndian.PutUint16(b[2:], uint16(c.destinationPort))
+	binary.BigEndian.PutUint32(b[4:], c.seq)
+	binary.BigEndian.PutUint32(b[8:], c.ack)
+	b[12] = byte(headerSize/4) << 4
+	b[13] = byte(c.flags)
+	binary.BigEndian.PutUint16(b[14:], uint16(c.window)) //nolint:gosec
+	if c.mss > 0 {
+		b[20] = tcpOptionMSS
+		b[21] = 4
+		binary.BigEndian.PutUint16(b[22:], uint16(c.mss))
+	}
+
+	return append(b, c.userData...)
+}
+
+// quote returns the IP header and the first 8 bytes of the transport
+// segment of the original chunk of an ICMP error.
+func quote(c *chunkICMP) []byte {
+	orig := marshalChunk(c.original)
+	n := ipHeaderSize(c.original.getSourceIP()) + 8
+	if n > len(orig) {
+		n = len(orig)
+	}
+	return orig[:n]
+}
+
+func marshalICMPv4(c *chunkICMP) []byte {
+	b := make([]byte, icmpHeaderSize)
+	b[0] = 3 // destination unreachable
+	switch c.code {
+	case icmpNetUnreachable:
+		b[1] = 0
+	case icmpHostUnreachable:
+		b[1] = 1
+	case icmpPortUnreachable:
+		b[1] = 3
+	case icmpFragmentationNeeded:
+		b[1] = 4
+		binary.BigEndian.PutUint16(b[6:], uint16(c.mtu))
+	}
+	b = append(b, quote(c)...)
+	binary.BigEndian.PutUint16(b[2:], checksum(b, 0))
+
+	return b
+}
+
+func marshalICMPv6(c *chunkICMP) []byte {
+	b := make([]byte, icmpHeaderSize)
+	b[0] = 1 // destination unreachable
+	switch c.code {
+	case icmpNetUnreachable:
+		b[1] = 0
+	case icmpHostUnreachable:
+		b[1] = 3
+	case icmpPortUnreachable:
+		b[1] = 4
+	case icmpFragmentationNeeded:
+		b[0] = 2 // packet too big
+		binary.BigEndian.PutUint32(b[4:], uint32(c.mtu))
+	}
+	b = append(b, quote(c)...)
+	binary.BigEndian.PutUint16(b[2:], checksum(b, pseudoHeaderSum(c, ipProtoICMPv6, len(b))))
+
+	return b
+}
+
+// pseudoHeaderSum returns the partial checksum of the IP pseudo header of a
+// transport segment.
+func pseudoHeaderSum(c Chunk, proto byte, length int) uint32 {
+	var ph []byte
+	if src := c.getSourceIP().To4(); src != nil {
+		ph = append(ph, src...)
+		ph = append(ph, c.getDestinationIP().To4()...)
+		ph = append(ph, 0, proto)
+		ph = binary.BigEndian.AppendUint16(ph, uint16(length))
+	} else {
+		ph = append(ph, c.getSourceIP().To16()...)
+		ph = append(ph, c.getDestinationIP().To16()...)
+		ph = binary.BigEndian.AppendUint32(ph, uint32(length))
+		ph = append(ph, 0, 0, 0, proto)
+	}
+	return sum16(ph, 0)
+}
+
+func sum16(b []byte, sum uint32) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
+
+// checksum returns the internet checksum (RFC 1071) of b, starting from the
+// partial sum of a pseudo header.
+func checksum(b []byte, initial uint32) uint16 {
+	sum := sum16(b, initial)
+	for sum>>16 != 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+	return ^uint16(sum)
+}
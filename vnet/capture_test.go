@@ -0,0 +1,249 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/ipv4"
+)
+
+type pcapngBlock struct {
+	blockType uint32
+	body      []byte
+}
+
+func readPcapng(t *testing.T, b []byte) []pcapngBlock {
+	t.Helper()
+
+	var blocks []pcapngBlock
+	for len(b) > 0 {
+		if !assert.True(t, len(b) >= 12, "should hold a block") {
+			return nil
+		}
+		length := binary.LittleEndian.Uint32(b[4:])
+		if !assert.True(t, int(length) <= len(b) && length%4 == 0, "should have a valid length") {
+			return nil
+		}
+		assert.Equal(t, length, binary.LittleEndian.Uint32(b[length-4:]), "should repeat the length")
+		blocks = append(blocks, pcapngBlock{
+			blockType: binary.LittleEndian.Uint32(b),
+			body:      b[8 : length-4],
+		})
+		b = b[length:]
+	}
+	return blocks
+}
+
+// packetOf returns the interface id and the frame of an enhanced packet block
+func packetOf(block pcapngBlock) (uint32, []byte) {
+	n := binary.LittleEndian.Uint32(block.body[12:])
+	return binary.LittleEndian.Uint32(block.body), block.body[20 : 20+n]
+}
+
+func TestMarshalChunk(t *testing.T) {
+	src := &net.UDPAddr{IP: net.ParseIP("192.168.0.2"), Port: 1234}
+	dst := &net.UDPAddr{IP: net.ParseIP(demoIP), Port: 5678}
+
+	t.Run("UDP", func(t *testing.T) {
+		c := newChunkUDP(src, dst)
+		c.userData = []byte("hello")
+
+		frame := marshalChunk(c)
+		assert.Equal(t, chunkSize(c), len(frame), "should match")
+
+		h, err := ipv4.ParseHeader(frame)
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, src.IP.String(), h.Src.String(), "should match")
+		assert.Equal(t, dst.IP.String(), h.Dst.String(), "should match")
+		assert.Equal(t, ipProtoUDP, h.Protocol, "should match")
+		assert.Equal(t, uint16(0), checksum(frame[:ipv4HeaderSize], 0), "should have a valid checksum")
+
+		l4 := frame[ipv4HeaderSize:]
+		assert.Equal(t, uint16(1234), binary.BigEndian.Uint16(l4[0:]), "should match")
+		assert.Equal(t, uint16(5678), binary.BigEndian.Uint16(l4[2:]), "should match")
+		assert.Equal(t, uint16(0), checksum(l4, pseudoHeaderSum(c, ipProtoUDP, len(l4))), "should have a valid checksum")
+		assert.Equal(t, []byte("hello"), l4[udpHeaderSize:], "should match")
+	})
+
+	t.Run("UDP over IPv6", func(t *testing.T) {
+		c := newChunkUDP(&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}, &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 5678})
+		c.userData = []byte("hello")
+
+		frame := marshalChunk(c)
+		assert.Equal(t, chunkSize(c), len(frame), "should match")
+		assert.Equal(t, byte(0x60), frame[0], "should be IPv6")
+		assert.Equal(t, byte(ipProtoUDP), frame[6], "should match")
+
+		l4 := frame[ipv6HeaderSize:]
+		assert.Equal(t, uint16(0), checksum(l4, pseudoHeaderSum(c, ipProtoUDP, len(l4))), "should have a valid checksum")
+	})
+
+	t.Run("TCP SYN with MSS", func(t *testing.T) {
+		c := newChunkTCP(&net.TCPAddr{IP: src.IP, Port: src.Port}, &net.TCPAddr{IP: dst.IP, Port: dst.Port}, tcpSYN)
+		c.seq = 1000
+		c.mss = 1460
+
+		l4 := marshalChunk(c)[ipv4HeaderSize:]
+		assert.Equal(t, 24, len(l4), "should carry the MSS option")
+		assert.Equal(t, uint32(1000), binary.BigEndian.Uint32(l4[4:]), "should match")
+		assert.Equal(t, byte(6<<4), l4[12], "should match the data offset")
+		assert.Equal(t, byte(tcpSYN), l4[13], "should match")
+		assert.Equal(t, uint16(1460), binary.BigEndian.Uint16(l4[22:]), "should match")
+		assert.Equal(t, uint16(0), checksum(l4, pseudoHeaderSum(c, ipProtoTCP, len(l4))), "should have a valid checksum")
+	})
+
+	t.Run("ICMP fragmentation needed", func(t *testing.T) {
+		orig := newChunkUDP(src, dst)
+		orig.userData = make([]byte, 2000)
+		c := newChunkICMPUnreachable(net.ParseIP("192.168.0.1"), orig, icmpFragmentationNeeded)
+		c.mtu = 1400
+
+		frame := marshalChunk(c)
+		assert.Equal(t, chunkSize(c), len(frame), "should match")
+
+		l4 := frame[ipv4HeaderSize:]
+		assert.Equal(t, byte(3), l4[0], "should be destination unreachable")
+		assert.Equal(t, byte(4), l4[1], "should be fragmentation needed")
+		assert.Equal(t, uint16(1400), binary.BigEndian.Uint16(l4[6:]), "should match")
+		assert.Equal(t, uint16(0), checksum(l4, 0), "should have a valid checksum")
+		assert.Equal(t, marshalChunk(orig)[:ipv4HeaderSize+8], l4[icmpHeaderSize:], "should quote the original")
+	})
+
+	t.Run("fragments", func(t *testing.T) {
+		c := newChunkUDP(src, dst)
+		c.userData = make([]byte, 3000)
+
+		fragments := fragmentChunk(c, 1500)
+		var total int
+		for i, fragment := range fragments {
+			frame := marshalChunk(fragment)
+			assert.Equal(t, chunkSize(fragment), len(frame), "should match")
+
+			h, err := ipv4.ParseHeader(frame)
+			assert.NoError(t, err, "should succeed")
+			assert.Equal(t, total/8, h.FragOff, "should match in units of 8 bytes")
+			assert.Equal(t, i < len(fragments)-1, h.Flags&ipv4.MoreFragments != 0, "should match")
+			assert.Equal(t, int(uint16(fragment.getFragment().id)), h.ID, "should match")
+			total += len(frame) - ipv4HeaderSize
+		}
+		assert.Equal(t, udpHeaderSize+len(c.userData), total, "should cover the UDP datagram")
+	})
+}
+
+func TestCapture(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	setup := func(t *testing.T) (*Router, *Net, *Net) {
+		t.Helper()
+
+		wan, err := NewRouter(&RouterConfig{
+			Name:          "wan",
+			CIDR:          "1.2.3.0/24",
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		net1, err := NewNet(&NetConfig{StaticIPs: []string{"1.2.3.4"}})
+		assert.NoError(t, err, "should succeed")
+		net2, err := NewNet(&NetConfig{StaticIPs: []string{"1.2.3.5"}})
+		assert.NoError(t, err, "should succeed")
+		return wan, net1, net2
+	}
+
+	send := func(t *testing.T, wan *Router, from, to *Net) {
+		t.Helper()
+
+		assert.NoError(t, wan.Start(), "should succeed")
+		defer func() {
+			assert.NoError(t, wan.Stop(), "should succeed")
+		}()
+
+		conn2, err := to.ListenPacket(udp, "1.2.3.5:5678")
+		assert.NoError(t, err, "should succeed")
+		conn1, err := from.Dial(udp, "1.2.3.5:5678")
+		assert.NoError(t, err, "should succeed")
+
+		_, err = conn1.Write([]byte("hello"))
+		assert.NoError(t, err, "should succeed")
+
+		buf := make([]byte, 1500)
+		assert.NoError(t, conn2.SetReadDeadline(time.Now().Add(time.Second)))
+		_, _, err = conn2.ReadFrom(buf)
+		assert.NoError(t, err, "should succeed")
+
+		assert.NoError(t, conn1.Close(), "should succeed")
+		assert.NoError(t, conn2.Close(), "should succeed")
+	}
+
+	t.Run("router", func(t *testing.T) {
+		wan, net1, net2 := setup(t)
+		assert.NoError(t, wan.AddNet(net1), "should succeed")
+		assert.NoError(t, wan.AddNet(net2), "should succeed")
+
+		var buf bytes.Buffer
+		capture, err := NewCapture(&buf)
+		assert.NoError(t, err, "should succeed")
+		capture.AttachRouter(wan)
+
+		send(t, wan, net1, net2)
+		assert.NoError(t, capture.Close(), "should succeed")
+		assert.ErrorIs(t, capture.Close(), errCaptureClosed, "should fail")
+
+		blocks := readPcapng(t, buf.Bytes())
+		if !assert.Equal(t, 3, len(blocks), "should match") {
+			return
+		}
+		assert.Equal(t, uint32(pcapngSectionHeaderBlock), blocks[0].blockType, "should match")
+		assert.Equal(t, uint32(pcapngByteOrderMagic), binary.LittleEndian.Uint32(blocks[0].body), "should match")
+
+		assert.Equal(t, uint32(pcapngInterfaceDescriptionBlock), blocks[1].blockType, "should match")
+		assert.Equal(t, uint16(linkTypeRaw), binary.LittleEndian.Uint16(blocks[1].body), "should match")
+		assert.True(t, bytes.Contains(blocks[1].body, []byte("wan")), "should carry the name")
+		assert.True(t, bytes.Contains(blocks[1].body, []byte{1, 2, 3, 0, 255, 255, 255, 0}), "should carry the subnet")
+
+		assert.Equal(t, uint32(pcapngEnhancedPacketBlock), blocks[2].blockType, "should match")
+		ifID, frame := packetOf(blocks[2])
+		assert.Equal(t, uint32(0), ifID, "should match")
+		h, err := ipv4.ParseHeader(frame)
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, "1.2.3.4", h.Src.String(), "should match")
+		assert.Equal(t, "1.2.3.5", h.Dst.String(), "should match")
+		assert.Equal(t, []byte("hello"), frame[ipv4HeaderSize+udpHeaderSize:], "should match")
+	})
+
+	t.Run("NIC and file", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "vnet.pcapng")
+		capture, err := NewCaptureFile(name)
+		assert.NoError(t, err, "should succeed")
+
+		wan, net1, net2 := setup(t)
+		nic, err := NewCaptureFilter(net2, capture, CaptureInterfaceName("net2"))
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, wan.AddNet(net1), "should succeed")
+		assert.NoError(t, wan.AddNet(nic), "should succeed")
+
+		send(t, wan, net1, net2)
+		assert.NoError(t, capture.Close(), "should succeed")
+
+		b, err := os.ReadFile(name) //nolint:gosec
+		assert.NoError(t, err, "should succeed")
+		blocks := readPcapng(t, b)
+		if !assert.Equal(t, 3, len(blocks), "should match") {
+			return
+		}
+		assert.True(t, bytes.Contains(blocks[1].body, []byte("net2")), "should carry the name")
+		assert.True(t, bytes.Contains(blocks[1].body, []byte{1, 2, 3, 5, 255, 255, 255, 0}), "should carry the address")
+		_, frame := packetOf(blocks[2])
+		assert.Equal(t, []byte("hello"), frame[ipv4HeaderSize+udpHeaderSize:], "should match")
+	})
+}
@@ -56,19 +56,21 @@ var assignChunkTag = func() func() string { //nolint:gochecknoglobals
 
 // Chunk represents a packet passed around in the vnet
 type Chunk interface {
-	setTimestamp() time.Time                 // used by router
+	setTimestamp(now time.Time) time.Time    // used by router
 	getTimestamp() time.Time                 // used by router
 	getSourceIP() net.IP                     // used by router
 	getDestinationIP() net.IP                // used by router
 	setSourceAddr(address string) error      // used by nat
 	setDestinationAddr(address string) error // used by nat
+	getFragment() *fragmentInfo              // used by router and net
+	addHop() int                             // used by router
 
 	SourceAddr() net.Addr
 	DestinationAddr() net.Addr
 	UserData() []byte
 	Tag() string
 	Clone() Chunk
-	Network() string // returns "udp" or "tcp"
+	Network() string // returns "udp", "tcp" or "icmp"
 	String() string
 }
 
@@ -77,10 +79,12 @@ type chunkIP struct {
 	sourceIP      net.IP
 	destinationIP net.IP
 	tag           string
+	fragment      *fragmentInfo // nil unless the chunk is an IP fragment
+	hops          int           // number of routers that forwarded the chunk
 }
 
-func (c *chunkIP) setTimestamp() time.Time {
-	c.timestamp = time.Now()
+func (c *chunkIP) setTimestamp(now time.Time) time.Time {
+	c.timestamp = now
 	return c.timestamp
 }
 
@@ -100,6 +104,25 @@ func (c *chunkIP) Tag() string {
 	return c.tag
 }
 
+func (c *chunkIP) getFragment() *fragmentInfo {
+	return c.fragment
+}
+
+// addHop counts one more router that forwarded the chunk, and returns the
+// number of hops so far.
+func (c *chunkIP) addHop() int {
+	c.hops++
+	return c.hops
+}
+
+// fragmentString describes the fragment for String(), if the chunk is one.
+func (c *chunkIP) fragmentString() string {
+	if c.fragment == nil {
+		return ""
+	}
+	return " " + c.fragment.String()
+}
+
 type chunkUDP struct {
 	chunkIP
 	sourcePort      int
@@ -150,6 +173,8 @@ func (c *chunkUDP) Clone() Chunk {
 			sourceIP:      c.sourceIP,
 			destinationIP: c.destinationIP,
 			tag:           c.tag,
+			fragment:      c.fragment,
+			hops:          c.hops,
 		},
 		sourcePort:      c.sourcePort,
 		destinationPort: c.destinationPort,
@@ -164,11 +189,12 @@ func (c *chunkUDP) Network() string {
 func (c *chunkUDP) String() string {
 	src := c.SourceAddr()
 	dst := c.DestinationAddr()
-	return fmt.Sprintf("%s chunk %s %s => %s",
+	return fmt.Sprintf("%s chunk %s %s => %s%s",
 		src.Network(),
 		c.tag,
 		src.String(),
 		dst.String(),
+		c.fragmentString(),
 	)
 }
 
@@ -197,9 +223,11 @@ type chunkTCP struct {
 	sourcePort      int
 	destinationPort int
 	flags           tcpFlag // control bits
+	seq             uint32  // sequence number of the first byte (or SYN/FIN)
+	ack             uint32  // next sequence number expected, valid with ACK flag
+	mss             int     // MSS option, with SYN flag only
+	window          int     // receive window of the sender
 	userData        []byte  // only with PSH flag
-	// seq             uint32  // always starts with 0
-	// ack             uint32  // always starts with 0
 }
 
 func newChunkTCP(srcAddr, dstAddr *net.TCPAddr, flags tcpFlag) *chunkTCP {
@@ -234,39 +262,60 @@ func (c *chunkTCP) UserData() []byte {
 }
 
 func (c *chunkTCP) Clone() Chunk {
-	userData := make([]byte, len(c.userData))
-	copy(userData, c.userData)
+	var userData []byte
+	if c.userData != nil {
+		userData = make([]byte, len(c.userData))
+		copy(userData, c.userData)
+	}
 
 	return &chunkTCP{
 		chunkIP: chunkIP{
 			timestamp:     c.timestamp,
 			sourceIP:      c.sourceIP,
 			destinationIP: c.destinationIP,
+			tag:           c.tag,
+			fragment:      c.fragment,
+			hops:          c.hops,
 		},
 		sourcePort:      c.sourcePort,
 		destinationPort: c.destinationPort,
+		flags:           c.flags,
+		seq:             c.seq,
+		ack:             c.ack,
+		mss:             c.mss,
+		window:          c.window,
 		userData:        userData,
 	}
 }
 
 func (c *chunkTCP) Network() string {
-	return "tcp"
+	return tcp
+}
+
+// segmentLen returns the amount of sequence space this chunk occupies.
+func (c *chunkTCP) segmentLen() uint32 {
+	n := uint32(len(c.userData))
+	if c.flags&(tcpSYN|tcpFIN) != 0 {
+		n++
+	}
+	return n
 }
 
 func (c *chunkTCP) String() string {
 	src := c.SourceAddr()
 	dst := c.DestinationAddr()
-	return fmt.Sprintf("%s %s chunk %s %s => %s",
+	return fmt.Sprintf("%s %s chunk %s %s => %s%s",
 		src.Network(),
 		c.flags.String(),
 		c.tag,
 		src.String(),
 		dst.String(),
+		c.fragmentString(),
 	)
 }
 
 func (c *chunkTCP) setSourceAddr(address string) error {
-	addr, err := net.ResolveTCPAddr("tcp", address)
+	addr, err := net.ResolveTCPAddr(tcp, address)
 	if err != nil {
 		return err
 	}
@@ -276,7 +325,7 @@ func (c *chunkTCP) setSourceAddr(address string) error {
 }
 
 func (c *chunkTCP) setDestinationAddr(address string) error {
-	addr, err := net.ResolveTCPAddr("tcp", address)
+	addr, err := net.ResolveTCPAddr(tcp, address)
 	if err != nil {
 		return err
 	}
@@ -284,3 +333,116 @@ func (c *chunkTCP) setDestinationAddr(address string) error {
 	c.destinationPort = addr.Port
 	return nil
 }
+
+// icmpUnreachableCode tells why a destination was unreachable.
+type icmpUnreachableCode uint8
+
+const (
+	icmpNetUnreachable      icmpUnreachableCode = iota // no route to the network
+	icmpHostUnreachable                                // no NIC with the address
+	icmpPortUnreachable                                // nobody listens on the port
+	icmpFragmentationNeeded                            // the chunk does not fit the next-hop MTU
+)
+
+func (c icmpUnreachableCode) String() string {
+	switch c {
+	case icmpNetUnreachable:
+		return "net unreachable"
+	case icmpHostUnreachable:
+		return "host unreachable"
+	case icmpPortUnreachable:
+		return "port unreachable"
+	case icmpFragmentationNeeded:
+		return "fragmentation needed"
+	default:
+		return "unreachable"
+	}
+}
+
+// chunkICMP is an ICMP (or ICMPv6) destination unreachable message. Like a
+// real one, it carries the chunk that could not be delivered, which lets the
+// receiver (and NATs on the way) find the socket it is meant for.
+type chunkICMP struct {
+	chunkIP
+	code     icmpUnreachableCode
+	mtu      int   // next-hop MTU, with icmpFragmentationNeeded only
+	original Chunk // the chunk that could not be delivered
+}
+
+// newChunkICMPUnreachable returns an ICMP error from srcIP back to the sender
+// of original.
+func newChunkICMPUnreachable(srcIP net.IP, original Chunk, code icmpUnreachableCode) *chunkICMP {
+	return &chunkICMP{
+		chunkIP: chunkIP{
+			sourceIP:      srcIP,
+			destinationIP: original.getSourceIP(),
+			tag:           assignChunkTag(),
+		},
+		code:     code,
+		original: original.Clone(),
+	}
+}
+
+func (c *chunkICMP) SourceAddr() net.Addr {
+	return &net.IPAddr{IP: c.sourceIP}
+}
+
+func (c *chunkICMP) DestinationAddr() net.Addr {
+	return &net.IPAddr{IP: c.destinationIP}
+}
+
+func (c *chunkICMP) UserData() []byte {
+	return nil
+}
+
+func (c *chunkICMP) Clone() Chunk {
+	return &chunkICMP{
+		chunkIP: chunkIP{
+			timestamp:     c.timestamp,
+			sourceIP:      c.sourceIP,
+			destinationIP: c.destinationIP,
+			tag:           c.tag,
+			fragment:      c.fragment,
+			hops:          c.hops,
+		},
+		code:     c.code,
+		mtu:      c.mtu,
+		original: c.original.Clone(),
+	}
+}
+
+func (c *chunkICMP) Network() string {
+	return icmp
+}
+
+func (c *chunkICMP) String() string {
+	code := c.code.String()
+	if c.code == icmpFragmentationNeeded {
+		code = fmt.Sprintf("%s (mtu %d)", code, c.mtu)
+	}
+	return fmt.Sprintf("icmp %s chunk %s %s => %s for %s",
+		code,
+		c.tag,
+		c.sourceIP.String(),
+		c.destinationIP.String(),
+		c.original.String(),
+	)
+}
+
+func (c *chunkICMP) setSourceAddr(address string) error {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return &net.AddrError{Err: "invalid IP address", Addr: address}
+	}
+	c.sourceIP = ip
+	return nil
+}
+
+func (c *chunkICMP) setDestinationAddr(address string) error {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return &net.AddrError{Err: "invalid IP address", Addr: address}
+	}
+	c.destinationIP = ip
+	return nil
+}
@@ -5,19 +5,26 @@ package vnet
 
 import (
 	"sync"
+	"time"
 )
 
 type chunkQueue struct {
-	chunks       []Chunk
+	chunks       []queuedChunk
 	maxSize      int // 0 or negative value: unlimited
 	maxBytes     int // 0 or negative value: unlimited
 	currentBytes int
 	mutex        sync.RWMutex
 }
 
+// queuedChunk is a chunk with the time it entered the queue
+type queuedChunk struct {
+	Chunk
+	enqueuedAt time.Time
+}
+
 func newChunkQueue(maxSize int, maxBytes int) *chunkQueue {
 	return &chunkQueue{
-		chunks:       []Chunk{},
+		chunks:       []queuedChunk{},
 		maxSize:      maxSize,
 		maxBytes:     maxBytes,
 		currentBytes: 0,
@@ -26,6 +33,11 @@ func newChunkQueue(maxSize int, maxBytes int) *chunkQueue {
 }
 
 func (q *chunkQueue) push(c Chunk) bool {
+	return q.pushAt(c, time.Time{})
+}
+
+// pushAt adds a chunk that entered the queue at now
+func (q *chunkQueue) pushAt(c Chunk, now time.Time) bool {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 
@@ -37,23 +49,33 @@ func (q *chunkQueue) push(c Chunk) bool {
 	}
 
 	q.currentBytes += len(c.UserData())
-	q.chunks = append(q.chunks, c)
+	q.chunks = append(q.chunks, queuedChunk{Chunk: c, enqueuedAt: now})
 	return true
 }
 
 func (q *chunkQueue) pop() (Chunk, bool) {
+	e, ok := q.popEntry()
+	if !ok {
+		return nil, false
+	}
+	return e.Chunk, true
+}
+
+// popEntry removes the first chunk, along with the time it entered the queue
+func (q *chunkQueue) popEntry() (queuedChunk, bool) {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 
 	if len(q.chunks) == 0 {
-		return nil, false
+		return queuedChunk{}, false
 	}
 
-	c := q.chunks[0]
+	e := q.chunks[0]
+	q.chunks[0] = queuedChunk{}
 	q.chunks = q.chunks[1:]
-	q.currentBytes -= len(c.UserData())
+	q.currentBytes -= len(e.UserData())
 
-	return c, true
+	return e, true
 }
 
 func (q *chunkQueue) peek() Chunk {
@@ -64,5 +86,13 @@ func (q *chunkQueue) peek() Chunk {
 		return nil
 	}
 
-	return q.chunks[0]
+	return q.chunks[0].Chunk
+}
+
+// size returns the number of chunks and bytes in the queue
+func (q *chunkQueue) size() (int, int) {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	return len(q.chunks), q.currentBytes
 }
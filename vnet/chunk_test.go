@@ -7,6 +7,7 @@ import (
 	"net"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/pion/logging"
 	"github.com/stretchr/testify/assert"
@@ -52,7 +53,7 @@ func TestChunk(t *testing.T) {
 		assert.True(t, c.getDestinationIP().Equal(dst.IP), "ip should match")
 
 		// Test timestamp
-		ts := c.setTimestamp()
+		ts := c.setTimestamp(time.Now())
 		assert.Equal(t, ts, c.getTimestamp(), "timestamp should match")
 
 		uc := c.(*chunkUDP) //nolint:forcetypeassert
@@ -102,7 +103,7 @@ func TestChunk(t *testing.T) {
 		assert.Equal(t, tcp.flags, tcpSYN, "flags should match")
 
 		// Test timestamp
-		ts := c.setTimestamp()
+		ts := c.setTimestamp(time.Now())
 		assert.Equal(t, ts, c.getTimestamp(), "timestamp should match")
 
 		tc := c.(*chunkTCP) //nolint:forcetypeassert
@@ -131,4 +132,42 @@ func TestChunk(t *testing.T) {
 		assert.Nil(t, err, "should succeed")
 		assert.Equal(t, "3.4.5.6:7000", tc.DestinationAddr().String())
 	})
+
+	t.Run("ChunkICMP", func(t *testing.T) {
+		src := &net.UDPAddr{
+			IP:   net.ParseIP("192.168.0.2"),
+			Port: 1234,
+		}
+		dst := &net.UDPAddr{
+			IP:   net.ParseIP(demoIP),
+			Port: 5678,
+		}
+
+		original := newChunkUDP(src, dst)
+		var c Chunk = newChunkICMPUnreachable(dst.IP, original, icmpPortUnreachable)
+		str := c.String()
+		log.Debugf("chunk: %s", str)
+		assert.Equal(t, "icmp", c.Network(), "should match")
+		assert.True(t, strings.Contains(str, "port unreachable"), "should include the code")
+		assert.True(t, strings.Contains(str, original.String()), "should include the original chunk")
+		assert.True(t, c.getSourceIP().Equal(dst.IP), "ip should match")
+		assert.True(t, c.getDestinationIP().Equal(src.IP), "ip should match")
+		assert.Nil(t, c.UserData(), "should be nil")
+
+		cloned := c.Clone().(*chunkICMP) //nolint:forcetypeassert
+
+		// Test setSourceAddr and setDestinationAddr
+		assert.NoError(t, c.setSourceAddr("2.3.4.5"), "should succeed")
+		assert.Equal(t, "2.3.4.5", c.SourceAddr().String())
+		assert.NoError(t, c.setDestinationAddr("3.4.5.6"), "should succeed")
+		assert.Equal(t, "3.4.5.6", c.DestinationAddr().String())
+		assert.Error(t, c.setDestinationAddr("3.4.5.6:7000"), "should fail")
+
+		// Verify the cloned chunk and its original were not affected
+		ic := c.(*chunkICMP) //nolint:forcetypeassert
+		assert.NoError(t, ic.original.setSourceAddr("4.5.6.7:8000"), "should succeed")
+		assert.Equal(t, src.String(), cloned.original.SourceAddr().String(), "should match")
+		assert.True(t, cloned.getSourceIP().Equal(dst.IP), "ip should match")
+		assert.Equal(t, src.String(), original.SourceAddr().String(), "should match")
+	})
 }
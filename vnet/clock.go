@@ -0,0 +1,228 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time of routers, NATs and filters. The system
+// clock is used unless one is configured. A ManualClock lets a test control
+// the passing of time.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// NewTimer creates a Timer that fires once after d
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a single event timer of a Clock, like time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is delivered
+	C() <-chan time.Time
+	// Stop prevents the Timer from firing. It returns false if the timer
+	// already expired or was stopped.
+	Stop() bool
+	// Reset changes the timer to expire after d. It returns false if the
+	// timer had expired or been stopped.
+	Reset(d time.Duration) bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// clockOrSystem returns the clock, or the system clock if it is nil.
+func clockOrSystem(clock Clock) Clock {
+	if clock == nil {
+		return systemClock{}
+	}
+	return clock
+}
+
+// afterFunc calls f in its own goroutine once d passed on the clock, like
+// time.AfterFunc. The returned stop prevents the call if it did not start.
+func afterFunc(clock Clock, d time.Duration, f func()) (stop func()) {
+	t := clock.NewTimer(d)
+	stopCh := make(chan struct{})
+	go func() {
+		select {
+		case <-t.C():
+			f()
+		case <-stopCh:
+			t.Stop()
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stopCh)
+		})
+	}
+}
+
+// randOrSeeded returns the generator, or one seeded from the system clock if
+// it is nil.
+func randOrSeeded(rng *rand.Rand) *rand.Rand {
+	if rng == nil {
+		return rand.New(rand.NewSource(time.Now().UnixNano())) //nolint:gosec
+	}
+	return rng
+}
+
+// ManualClock is a Clock whose time moves only when it is advanced. Timers
+// fire from Advance, in the order of their deadlines.
+type ManualClock struct {
+	now    time.Time      // requires mutex
+	timers []*manualTimer // requires mutex, the pending timers
+	mutex  sync.Mutex
+	cond   *sync.Cond // signaled when a timer is scheduled
+}
+
+// NewManualClock creates a new ManualClock set to the given time.
+func NewManualClock(now time.Time) *ManualClock {
+	c := &ManualClock{now: now}
+	c.cond = sync.NewCond(&c.mutex)
+	return c
+}
+
+// Now returns the current time of the clock
+func (c *ManualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+// NewTimer creates a Timer that fires once the clock was advanced by d
+func (c *ManualClock) NewTimer(d time.Duration) Timer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	t := &manualTimer{
+		clock: c,
+		ch:    make(chan time.Time, 1),
+	}
+	c.schedule(t, d)
+	return t
+}
+
+// Advance moves the clock forward by d and fires the timers that expire
+// on the way.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	end := c.now.Add(d)
+	c.mutex.Unlock()
+
+	c.AdvanceTo(end)
+}
+
+// AdvanceTo moves the clock forward to t and fires the timers that expire
+// on the way. The clock never goes backward.
+func (c *ManualClock) AdvanceTo(t time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.advanceTo(t)
+}
+
+// BlockUntil waits until at least n timers are pending. Routers and filters
+// run in their own goroutines; this lets a test advance the clock once they
+// are all waiting for it.
+func (c *ManualClock) BlockUntil(n int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
+
+// caller must hold the mutex
+func (c *ManualClock) advanceTo(t time.Time) {
+	for len(c.timers) > 0 && !c.timers[0].deadline.After(t) {
+		next := c.timers[0]
+		c.timers = c.timers[1:]
+		if next.deadline.After(c.now) {
+			c.now = next.deadline
+		}
+		select {
+		case next.ch <- c.now:
+		default:
+		}
+	}
+	if t.After(c.now) {
+		c.now = t
+	}
+}
+
+// caller must hold the mutex
+func (c *ManualClock) schedule(t *manualTimer, d time.Duration) {
+	t.deadline = c.now.Add(d)
+	c.timers = append(c.timers, t)
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		return c.timers[i].deadline.Before(c.timers[j].deadline)
+	})
+	c.cond.Broadcast()
+
+	// a timer that is due fires right away, like time.Timer
+	if d <= 0 {
+		c.advanceTo(c.now)
+	}
+}
+
+// caller must hold the mutex
+func (c *ManualClock) unschedule(t *manualTimer) bool {
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type manualTimer struct {
+	clock    *ManualClock
+	ch       chan time.Time
+	deadline time.Time // requires clock mutex
+}
+
+func (t *manualTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *manualTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	return t.clock.unschedule(t)
+}
+
+func (t *manualTimer) Reset(d time.Duration) bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	active := t.clock.unschedule(t)
+	t.clock.schedule(t, d)
+	return active
+}
@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManualClock(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	fired := func(timer Timer) (time.Time, bool) {
+		select {
+		case now := <-timer.C():
+			return now, true
+		default:
+			return time.Time{}, false
+		}
+	}
+
+	t.Run("fire in order", func(t *testing.T) {
+		clock := NewManualClock(start)
+		t2 := clock.NewTimer(2 * time.Second)
+		t1 := clock.NewTimer(time.Second)
+
+		clock.Advance(500 * time.Millisecond)
+		_, ok := fired(t1)
+		assert.False(t, ok, "should not fire yet")
+		assert.Equal(t, start.Add(500*time.Millisecond), clock.Now(), "should match")
+
+		clock.Advance(2 * time.Second)
+		now, ok := fired(t1)
+		assert.True(t, ok, "should fire")
+		assert.Equal(t, start.Add(time.Second), now, "should fire at its deadline")
+		now, ok = fired(t2)
+		assert.True(t, ok, "should fire")
+		assert.Equal(t, start.Add(2*time.Second), now, "should fire at its deadline")
+		assert.Equal(t, start.Add(2500*time.Millisecond), clock.Now(), "should match")
+
+		clock.AdvanceTo(start)
+		assert.Equal(t, start.Add(2500*time.Millisecond), clock.Now(), "should not go backward")
+	})
+
+	t.Run("stop and reset", func(t *testing.T) {
+		clock := NewManualClock(start)
+		timer := clock.NewTimer(time.Second)
+
+		assert.True(t, timer.Stop(), "should stop")
+		assert.False(t, timer.Stop(), "should be stopped")
+		clock.Advance(time.Second)
+		_, ok := fired(timer)
+		assert.False(t, ok, "should not fire")
+
+		assert.False(t, timer.Reset(time.Second), "should be stopped")
+		assert.True(t, timer.Reset(2*time.Second), "should be pending")
+		clock.Advance(time.Second)
+		_, ok = fired(timer)
+		assert.False(t, ok, "should not fire yet")
+		clock.Advance(time.Second)
+		_, ok = fired(timer)
+		assert.True(t, ok, "should fire")
+	})
+
+	t.Run("due timer", func(t *testing.T) {
+		clock := NewManualClock(start)
+		timer := clock.NewTimer(0)
+		now, ok := fired(timer)
+		assert.True(t, ok, "should fire right away")
+		assert.Equal(t, start, now, "should match")
+	})
+
+	t.Run("block until", func(t *testing.T) {
+		clock := NewManualClock(start)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			<-clock.NewTimer(time.Second).C()
+		}()
+
+		clock.BlockUntil(1)
+		clock.Advance(time.Second)
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			assert.Fail(t, "should fire")
+		}
+	})
+}
@@ -26,6 +26,9 @@ var (
 	errLocAddr              = errors.New("something went wrong with locAddr")
 	errAlreadyClosed        = errors.New("already closed")
 	errNoRemAddr            = errors.New("no remAddr defined")
+	errHostUnreachable      = errors.New("no route to host")
+	errNetUnreachable       = errors.New("network is unreachable")
+	errMessageTooLong       = errors.New("message too long")
 )
 
 // vNet implements this
@@ -159,6 +162,20 @@ loop:
 			if !ok {
 				break loop
 			}
+			if icmp, ok := chunk.(*chunkICMP); ok {
+				// Like the kernel, only report ICMP errors on connected
+				// sockets, and only those about the connected peer.
+				if c.remAddr == nil || icmp.original.DestinationAddr().String() != c.remAddr.String() {
+					break // discard
+				}
+				return 0, c.remAddr, &net.OpError{
+					Op:     "read",
+					Net:    c.locAddr.Network(),
+					Source: c.locAddr,
+					Addr:   c.remAddr,
+					Err:    icmpError(icmp.code),
+				}
+			}
 			var err error
 			n := copy(p, chunk.UserData())
 			addr := chunk.SourceAddr()
@@ -296,3 +313,18 @@ func (c *UDPConn) onInboundChunk(chunk Chunk) {
 	default:
 	}
 }
+
+// icmpError returns the error a connected socket reports for an ICMP
+// destination unreachable message.
+func icmpError(code icmpUnreachableCode) error {
+	switch code {
+	case icmpPortUnreachable:
+		return errConnectionRefused
+	case icmpHostUnreachable:
+		return errHostUnreachable
+	case icmpFragmentationNeeded:
+		return errMessageTooLong
+	default:
+		return errNetUnreachable
+	}
+}
@@ -35,13 +35,9 @@ func (m *udpConnMap) insert(conn *UDPConn) error {
 	// check if the port has a listener
 	conns, ok := m.portMap[udpAddr.Port]
 	if ok {
-		if udpAddr.IP.IsUnspecified() {
-			return errAddressAlreadyInUse
-		}
-
 		for _, conn := range conns {
 			laddr := conn.LocalAddr().(*net.UDPAddr) //nolint:forcetypeassert
-			if laddr.IP.IsUnspecified() || laddr.IP.Equal(udpAddr.IP) {
+			if ipMatches(udpAddr.IP, laddr.IP) || ipMatches(laddr.IP, udpAddr.IP) {
 				return errAddressAlreadyInUse
 			}
 		}
@@ -63,18 +59,24 @@ func (m *udpConnMap) find(addr net.Addr) (*UDPConn, bool) {
 
 	if conns, ok := m.portMap[udpAddr.Port]; ok {
 		if udpAddr.IP.IsUnspecified() {
-			// pick the first one appears in the iteration
+			// pick the first one of the same address family appears in the iteration
 			if len(conns) == 0 {
 				// This can't happen!
 				delete(m.portMap, udpAddr.Port)
 				return nil, false
 			}
-			return conns[0], true
+			for _, conn := range conns {
+				laddr := conn.LocalAddr().(*net.UDPAddr) //nolint:forcetypeassert
+				if sameFamily(laddr.IP, udpAddr.IP) {
+					return conn, true
+				}
+			}
+			return nil, false
 		}
 
 		for _, conn := range conns {
 			laddr := conn.LocalAddr().(*net.UDPAddr) //nolint:forcetypeassert
-			if laddr.IP.IsUnspecified() || laddr.IP.Equal(udpAddr.IP) {
+			if ipMatches(laddr.IP, udpAddr.IP) {
 				return conn, ok
 			}
 		}
@@ -95,8 +97,19 @@ func (m *udpConnMap) delete(addr net.Addr) error {
 	}
 
 	if udpAddr.IP.IsUnspecified() {
-		// remove all from this port
-		delete(m.portMap, udpAddr.Port)
+		// remove all of the same address family from this port
+		newConns := []*UDPConn{}
+		for _, conn := range conns {
+			laddr := conn.LocalAddr().(*net.UDPAddr) //nolint:forcetypeassert
+			if !sameFamily(laddr.IP, udpAddr.IP) {
+				newConns = append(newConns, conn)
+			}
+		}
+		if len(newConns) == 0 {
+			delete(m.portMap, udpAddr.Port)
+		} else {
+			m.portMap[udpAddr.Port] = newConns
+		}
 		return nil
 	}
 
@@ -105,8 +118,12 @@ func (m *udpConnMap) delete(addr net.Addr) error {
 	for _, conn := range conns {
 		laddr := conn.LocalAddr().(*net.UDPAddr) //nolint:forcetypeassert
 		if laddr.IP.IsUnspecified() {
-			// This can't happen!
-			return errCannotRemoveUnspecifiedIP
+			if sameFamily(laddr.IP, udpAddr.IP) {
+				// This can't happen!
+				return errCannotRemoveUnspecifiedIP
+			}
+			newConns = append(newConns, conn)
+			continue
 		}
 
 		if laddr.IP.Equal(udpAddr.IP) {
@@ -125,6 +142,19 @@ func (m *udpConnMap) delete(addr net.Addr) error {
 	return nil
 }
 
+// all returns the UDPConns (UDP listeners)
+func (m *udpConnMap) all() []*UDPConn {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var all []*UDPConn
+	for _, conns := range m.portMap {
+		all = append(all, conns...)
+	}
+
+	return all
+}
+
 // size returns the number of UDPConns (UDP listeners)
 func (m *udpConnMap) size() int {
 	m.mutex.RLock()
@@ -137,3 +167,17 @@ func (m *udpConnMap) size() int {
 
 	return n
 }
+
+// sameFamily returns true if both IPs are IPv4, or both are IPv6.
+func sameFamily(ip1, ip2 net.IP) bool {
+	return (ip1.To4() == nil) == (ip2.To4() == nil)
+}
+
+// ipMatches returns true if a socket bound to locIP receives traffic
+// destined to ip. The unspecified address matches any IP of its family.
+func ipMatches(locIP, ip net.IP) bool {
+	if locIP.IsUnspecified() {
+		return sameFamily(locIP, ip)
+	}
+	return locIP.Equal(ip)
+}
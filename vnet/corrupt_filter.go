@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+var errInvalidCorruptBits = errors.New("number of corrupted bits must be positive")
+
+// CorruptFilter is a wrapper around NICs, that flips random bits in the
+// payload of some of the packets passed to onInboundChunk, like netem
+// corrupt. Packets without payload are passed on unchanged.
+type CorruptFilter struct {
+	NIC
+	chance float64    // read-only, percent
+	bits   int        // read-only
+	rand   *rand.Rand // requires mutex
+	mutex  sync.Mutex
+}
+
+// CorruptFilterOption is the option type to configure a CorruptFilter
+type CorruptFilterOption func(*CorruptFilter)
+
+// CorruptFilterBits sets the number of distinct bits flipped in a corrupted
+// packet, at most all the bits of its payload. Defaults to 1.
+func CorruptFilterBits(bits int) CorruptFilterOption {
+	return func(f *CorruptFilter) {
+		f.bits = bits
+	}
+}
+
+// CorruptFilterRand sets the random number generator that decides which
+// packets and bits are corrupted, for a reproducible run. It must not be
+// shared. Defaults to a generator seeded from the system clock.
+func CorruptFilterRand(rng *rand.Rand) CorruptFilterOption {
+	return func(f *CorruptFilter) {
+		f.rand = randOrSeeded(rng)
+	}
+}
+
+// NewCorruptFilter creates a new CorruptFilter that corrupts every packet
+// with a probability of chance/100.
+func NewCorruptFilter(nic NIC, chance float64, opts ...CorruptFilterOption) (*CorruptFilter, error) {
+	if chance < 0 || chance > 100 {
+		return nil, fmt.Errorf("%w: %v", errInvalidPercentage, chance)
+	}
+
+	f := &CorruptFilter{
+		NIC:    nic,
+		chance: chance,
+		bits:   1,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.bits <= 0 {
+		return nil, fmt.Errorf("%w: %d", errInvalidCorruptBits, f.bits)
+	}
+	if f.rand == nil {
+		f.rand = randOrSeeded(nil)
+	}
+	return f, nil
+}
+
+func (f *CorruptFilter) onInboundChunk(c Chunk) {
+	f.mutex.Lock()
+	if len(c.UserData()) > 0 && f.rand.Float64()*100 < f.chance {
+		// the sender may still hold the chunk, corrupt a copy
+		c = c.Clone()
+		userData := c.UserData()
+		for bit := range f.pickBits(8 * len(userData)) {
+			userData[bit/8] ^= 1 << (bit % 8)
+		}
+	}
+	f.mutex.Unlock()
+
+	f.NIC.onInboundChunk(c)
+}
+
+// pickBits draws min(f.bits, n) distinct positions out of n with Floyd's
+// algorithm.
+// caller must hold the mutex
+func (f *CorruptFilter) pickBits(n int) map[int]struct{} {
+	k := f.bits
+	if k > n {
+		k = n
+	}
+	picked := make(map[int]struct{}, k)
+	for j := n - k; j < n; j++ {
+		bit := f.rand.Intn(j + 1)
+		if _, ok := picked[bit]; ok {
+			bit = j
+		}
+		picked[bit] = struct{}{}
+	}
+	return picked
+}
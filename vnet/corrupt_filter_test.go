@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"bytes"
+	"math/bits"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorruptFilter(t *testing.T) {
+	payload := []byte("0123456789abcdef")
+
+	// run passes a chunk through a filter and returns the chunk received
+	run := func(t *testing.T, c Chunk, chance float64, opts ...CorruptFilterOption) Chunk {
+		t.Helper()
+
+		mnic := newMockNIC(t)
+		f, err := NewCorruptFilter(mnic, chance, opts...)
+		if !assert.NoError(t, err, "should succeed") {
+			return nil
+		}
+
+		var received Chunk
+		mnic.mockOnInboundChunk = func(c Chunk) {
+			received = c
+		}
+		f.onInboundChunk(c)
+		return received
+	}
+
+	// flipped returns the number of bits that differ
+	flipped := func(a, b []byte) int {
+		n := 0
+		for i := range a {
+			n += bits.OnesCount8(a[i] ^ b[i])
+		}
+		return n
+	}
+
+	t.Run("NoCorruption", func(t *testing.T) {
+		c := &chunkUDP{userData: append([]byte{}, payload...)}
+		assert.Same(t, c, run(t, c, 0), "should pass the chunk as-is")
+	})
+
+	t.Run("FlipBits", func(t *testing.T) {
+		c := &chunkUDP{userData: append([]byte{}, payload...)}
+		received := run(t, c, 100)
+		assert.Equal(t, payload, c.UserData(), "should not modify the original")
+		assert.Equal(t, 1, flipped(payload, received.UserData()), "should flip a bit")
+
+		tcp := &chunkTCP{userData: append([]byte{}, payload...)}
+		received = run(t, tcp, 100, CorruptFilterBits(3), CorruptFilterRand(rand.New(rand.NewSource(1)))) //nolint:gosec
+
+		receivedTCP := received.(*chunkTCP) //nolint:forcetypeassert
+		assert.Equal(t, tcp.chunkIP, receivedTCP.chunkIP, "should keep the header")
+		assert.Equal(t, 3, flipped(payload, received.UserData()), "should flip 3 distinct bits")
+		assert.False(t, bytes.Equal(payload, received.UserData()), "should be corrupted")
+	})
+
+	t.Run("AllBits", func(t *testing.T) {
+		// more bits than the payload has flip each of them once
+		c := &chunkUDP{userData: []byte{0x0f, 0xf0}}
+		received := run(t, c, 100, CorruptFilterBits(100))
+		assert.Equal(t, []byte{0xf0, 0x0f}, received.UserData(), "should flip every bit once")
+	})
+
+	t.Run("NoPayload", func(t *testing.T) {
+		c := &chunkUDP{}
+		assert.Same(t, c, run(t, c, 100), "should pass the chunk as-is")
+	})
+
+	t.Run("InvalidPercentage", func(t *testing.T) {
+		_, err := NewCorruptFilter(newMockNIC(t), -0.1)
+		assert.ErrorIs(t, err, errInvalidPercentage, "should fail")
+	})
+
+	t.Run("InvalidBits", func(t *testing.T) {
+		_, err := NewCorruptFilter(newMockNIC(t), 100, CorruptFilterBits(0))
+		assert.ErrorIs(t, err, errInvalidCorruptBits, "should fail")
+		_, err = NewCorruptFilter(newMockNIC(t), 100, CorruptFilterBits(-1))
+		assert.ErrorIs(t, err, errInvalidCorruptBits, "should fail")
+	})
+}
@@ -0,0 +1,212 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var errInvalidJitter = errors.New("jitter deviation must not be negative")
+
+// DelayDistribution is the distribution of the jitter of a delay, like the
+// distribution tables of netem.
+type DelayDistribution int
+
+const (
+	// DelayUniform spreads the delay evenly around its base
+	DelayUniform DelayDistribution = iota
+	// DelayNormal spreads the delay along a bell curve around its base
+	DelayNormal
+	// DelayPareto has a heavy tail of long delays
+	DelayPareto
+	// DelayParetoNormal mixes normal (25%) and pareto (75%) variations
+	DelayParetoNormal
+)
+
+func (d DelayDistribution) String() string {
+	switch d {
+	case DelayUniform:
+		return "uniform"
+	case DelayNormal:
+		return "normal"
+	case DelayPareto:
+		return "pareto"
+	case DelayParetoNormal:
+		return "pareto-normal"
+	default:
+		return "unknown"
+	}
+}
+
+// Jitter is the random variation of the delay of each chunk, like the jitter
+// of netem delay. Chunks are never delayed by less than 0, and may overtake
+// each other when the jitter is larger than the time between them.
+type Jitter struct {
+	// Distribution of the variation. Defaults to DelayUniform.
+	Distribution DelayDistribution
+	// Deviation is the half-width of the uniform distribution, and the
+	// standard deviation of the others
+	Deviation time.Duration
+	// Correlation of the variation of each chunk with the previous one, in
+	// percent
+	Correlation float64
+}
+
+// delayGenerator draws the delay of each chunk
+type delayGenerator struct {
+	base   time.Duration
+	jitter Jitter
+	last   float64 // variation of the previous chunk, in deviations
+	rand   *rand.Rand
+}
+
+func newDelayGenerator(base time.Duration, jitter Jitter, rng *rand.Rand) (*delayGenerator, error) {
+	if jitter.Deviation < 0 {
+		return nil, fmt.Errorf("%w: %v", errInvalidJitter, jitter.Deviation)
+	}
+	if jitter.Correlation < 0 || jitter.Correlation > 100 {
+		return nil, fmt.Errorf("%w: %v", errInvalidPercentage, jitter.Correlation)
+	}
+
+	return &delayGenerator{
+		base:   base,
+		jitter: jitter,
+		rand:   rng,
+	}, nil
+}
+
+// next returns the delay of the next chunk
+func (g *delayGenerator) next() time.Duration {
+	if g.jitter.Deviation == 0 {
+		return g.base
+	}
+
+	// like netem, mix the new variation with the previous one
+	rho := g.jitter.Correlation / 100
+	x := rho*g.last + (1-rho)*g.sample()
+	g.last = x
+
+	if d := g.base + time.Duration(x*float64(g.jitter.Deviation)); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// sample returns a variation in units of the deviation: within [-1, 1) for
+// the uniform distribution, with a mean of 0 and a standard deviation of 1
+// for the others.
+func (g *delayGenerator) sample() float64 {
+	switch g.jitter.Distribution {
+	case DelayNormal:
+		return g.rand.NormFloat64()
+	case DelayPareto:
+		return paretoVariate(g.rand)
+	case DelayParetoNormal:
+		x := 0.25*g.rand.NormFloat64() + 0.75*paretoVariate(g.rand)
+		return x / math.Sqrt(0.25*0.25+0.75*0.75)
+	default:
+		return 2*g.rand.Float64() - 1
+	}
+}
+
+// paretoVariate returns a pareto distributed value of shape 3, shifted and
+// scaled to a mean of 0 and a standard deviation of 1.
+func paretoVariate(rng *rand.Rand) float64 {
+	const shape = 3
+	mean := shape / (shape - 1.0)
+	stdDev := math.Sqrt(shape/(shape-2.0)) / (shape - 1)
+
+	x := 1 / math.Pow(1-rng.Float64(), 1.0/shape)
+	return (x - mean) / stdDev
+}
+
+type timedChunk struct {
+	Chunk
+	deadline time.Time
+	seq      uint64 // order of arrival, for chunks with the same deadline
+}
+
+// delayQueue holds chunks until their deadlines, which may differ for each
+// chunk. Chunks leave in the order of their deadlines.
+type delayQueue struct {
+	chunks  timedChunks
+	maxSize int    // 0 or negative value: unlimited
+	seq     uint64 // requires mutex
+	mutex   sync.Mutex
+}
+
+func newDelayQueue(maxSize int) *delayQueue {
+	return &delayQueue{maxSize: maxSize}
+}
+
+func (q *delayQueue) push(c Chunk, deadline time.Time) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.maxSize > 0 && len(q.chunks) >= q.maxSize {
+		return false // dropped
+	}
+
+	q.seq++
+	heap.Push(&q.chunks, timedChunk{Chunk: c, deadline: deadline, seq: q.seq})
+	return true
+}
+
+func (q *delayQueue) pop() (timedChunk, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.chunks) == 0 {
+		return timedChunk{}, false
+	}
+	return heap.Pop(&q.chunks).(timedChunk), true //nolint:forcetypeassert
+}
+
+func (q *delayQueue) peek() (timedChunk, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.chunks) == 0 {
+		return timedChunk{}, false
+	}
+	return q.chunks[0], true
+}
+
+func (q *delayQueue) len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.chunks)
+}
+
+// timedChunks implements heap.Interface
+type timedChunks []timedChunk
+
+func (h timedChunks) Len() int { return len(h) }
+
+func (h timedChunks) Less(i, j int) bool {
+	if h[i].deadline.Equal(h[j].deadline) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].deadline.Before(h[j].deadline)
+}
+
+func (h timedChunks) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *timedChunks) Push(x interface{}) {
+	*h = append(*h, x.(timedChunk)) //nolint:forcetypeassert
+}
+
+func (h *timedChunks) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -5,38 +5,76 @@ package vnet
 
 import (
 	"context"
+	"math/rand"
+	"sync"
 	"time"
 )
 
-// DelayFilter delays outgoing packets by the given delay. Run must be called
-// before any packets will be forwarded.
+// DelayFilter delays outgoing packets by the given delay, and a jitter if
+// one is set. Run must be called before any packets will be forwarded.
 type DelayFilter struct {
 	NIC
-	delay time.Duration
-	push  chan struct{}
-	queue *chunkQueue
+	delay  *delayGenerator // requires mutex
+	jitter Jitter
+	rand   *rand.Rand
+	push   chan struct{}
+	queue  *delayQueue
+	clock  Clock
+	mutex  sync.Mutex
 }
 
-type timedChunk struct {
-	Chunk
-	deadline time.Time
+// DelayFilterOption is the option type to configure a DelayFilter
+type DelayFilterOption func(*DelayFilter)
+
+// DelayFilterClock sets the clock the delay is measured with. Defaults to
+// the system clock.
+func DelayFilterClock(clock Clock) DelayFilterOption {
+	return func(f *DelayFilter) {
+		f.clock = clockOrSystem(clock)
+	}
+}
+
+// DelayFilterJitter varies the delay of each packet around the given delay.
+func DelayFilterJitter(jitter Jitter) DelayFilterOption {
+	return func(f *DelayFilter) {
+		f.jitter = jitter
+	}
+}
+
+// DelayFilterRand sets the random number generator of the jitter, for a
+// reproducible run. It must not be shared. Defaults to a generator seeded
+// from the system clock.
+func DelayFilterRand(rng *rand.Rand) DelayFilterOption {
+	return func(f *DelayFilter) {
+		f.rand = randOrSeeded(rng)
+	}
 }
 
 // NewDelayFilter creates a new DelayFilter with the given nic and delay.
-func NewDelayFilter(nic NIC, delay time.Duration) (*DelayFilter, error) {
-	return &DelayFilter{
+func NewDelayFilter(nic NIC, delay time.Duration, opts ...DelayFilterOption) (*DelayFilter, error) {
+	f := &DelayFilter{
 		NIC:   nic,
-		delay: delay,
 		push:  make(chan struct{}),
-		queue: newChunkQueue(0, 0),
-	}, nil
+		queue: newDelayQueue(0),
+		clock: systemClock{},
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	var err error
+	if f.delay, err = newDelayGenerator(delay, f.jitter, randOrSeeded(f.rand)); err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
 func (f *DelayFilter) onInboundChunk(c Chunk) {
-	f.queue.push(timedChunk{
-		Chunk:    c,
-		deadline: time.Now().Add(f.delay),
-	})
+	f.mutex.Lock()
+	delay := f.delay.next()
+	f.mutex.Unlock()
+
+	f.queue.push(c, f.clock.Now().Add(delay))
 	f.push <- struct{}{}
 }
 
@@ -44,35 +82,33 @@ func (f *DelayFilter) onInboundChunk(c Chunk) {
 // >delay time in the internal queue. Must be called before any packet will be
 // forwarded.
 func (f *DelayFilter) Run(ctx context.Context) {
-	timer := time.NewTimer(0)
+	timer := f.clock.NewTimer(0)
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-f.push:
-			next := f.queue.peek().(timedChunk) //nolint:forcetypeassert
+			// the new packet may be due before the others
+			next, _ := f.queue.peek()
 			if !timer.Stop() {
-				<-timer.C
+				<-timer.C()
 			}
-			timer.Reset(time.Until(next.deadline))
-		case now := <-timer.C:
-			next := f.queue.peek()
-			if next == nil {
-				timer.Reset(time.Minute)
-				continue
+			timer.Reset(next.deadline.Sub(f.clock.Now()))
+		case now := <-timer.C():
+			for {
+				next, ok := f.queue.peek()
+				if !ok || next.deadline.After(now) {
+					break
+				}
+				f.queue.pop() // ignore result because we already got it from peek
+				f.NIC.onInboundChunk(next.Chunk)
 			}
-			if n, ok := next.(timedChunk); ok && n.deadline.Before(now) {
-				f.queue.pop() // ignore result because we already got and casted it from peek
-				f.NIC.onInboundChunk(n.Chunk)
-			}
-			next = f.queue.peek()
-			if next == nil {
+			next, ok := f.queue.peek()
+			if !ok {
 				timer.Reset(time.Minute)
 				continue
 			}
-			if n, ok := next.(timedChunk); ok {
-				timer.Reset(time.Until(n.deadline))
-			}
+			timer.Reset(next.deadline.Sub(f.clock.Now()))
 		}
 	}
 }
@@ -103,4 +103,41 @@ func TestDelayFilter(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("manualClock", func(t *testing.T) {
+		clock := NewManualClock(time.Now())
+		nic := newMockNIC(t)
+		df, err := NewDelayFilter(nic, 10*time.Millisecond, DelayFilterClock(clock))
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go df.Run(ctx)
+
+		receiveCh := make(chan time.Time, 1)
+		nic.mockOnInboundChunk = func(Chunk) {
+			receiveCh <- clock.Now()
+		}
+
+		sent := clock.Now()
+		df.onInboundChunk(&chunkUDP{})
+		clock.BlockUntil(1)
+
+		clock.Advance(9 * time.Millisecond)
+		select {
+		case <-receiveCh:
+			assert.Fail(t, "should not receive before the delay")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		clock.Advance(time.Millisecond)
+		select {
+		case ts := <-receiveCh:
+			assert.Equal(t, 10*time.Millisecond, ts.Sub(sent), "should match")
+		case <-time.After(time.Second):
+			assert.Fail(t, "expected to receive the chunk")
+		}
+	})
 }
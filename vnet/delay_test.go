@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelayGenerator(t *testing.T) {
+	const base, deviation = 50 * time.Millisecond, 10 * time.Millisecond
+
+	// stats returns the mean and the standard deviation in ms of delays,
+	// their lag-1 autocorrelation and their maximum
+	stats := func(t *testing.T, jitter Jitter) (mean, stdDev, autoCorr float64, maxDelay time.Duration) {
+		t.Helper()
+
+		g, err := newDelayGenerator(base, jitter, rand.New(rand.NewSource(1))) //nolint:gosec
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		const n = 20000
+		samples := make([]float64, n)
+		for i := range samples {
+			d := g.next()
+			if d > maxDelay {
+				maxDelay = d
+			}
+			samples[i] = float64(d) / float64(time.Millisecond)
+			mean += samples[i] / n
+		}
+
+		var variance, covariance float64
+		for i, x := range samples {
+			variance += (x - mean) * (x - mean) / n
+			if i > 0 {
+				covariance += (x - mean) * (samples[i-1] - mean) / (n - 1)
+			}
+		}
+		return mean, math.Sqrt(variance), covariance / variance, maxDelay
+	}
+
+	t.Run("NoJitter", func(t *testing.T) {
+		g, err := newDelayGenerator(base, Jitter{}, rand.New(rand.NewSource(1))) //nolint:gosec
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, base, g.next(), "should match")
+	})
+
+	t.Run("Distributions", func(t *testing.T) {
+		for _, test := range []struct {
+			distribution DelayDistribution
+			stdDev       float64
+			delta        float64 // the sample deviation of heavy tails converges slowly
+		}{
+			{DelayUniform, 10 / math.Sqrt(3), 0.5},
+			{DelayNormal, 10, 0.5},
+			{DelayPareto, 10, 3},
+			{DelayParetoNormal, 10, 3},
+		} {
+			test := test
+			t.Run(test.distribution.String(), func(t *testing.T) {
+				mean, stdDev, autoCorr, maxDelay := stats(t, Jitter{Distribution: test.distribution, Deviation: deviation})
+				assert.InDelta(t, 50, mean, 0.5, "should match the base delay")
+				assert.InDelta(t, test.stdDev, stdDev, test.delta, "should match the deviation")
+				assert.InDelta(t, 0, autoCorr, 0.05, "should not be correlated")
+				if test.distribution == DelayUniform {
+					assert.LessOrEqual(t, maxDelay, base+deviation, "should be within the deviation")
+				}
+				if test.distribution == DelayPareto {
+					assert.Greater(t, maxDelay, base+5*deviation, "should have a heavy tail")
+				}
+			})
+		}
+	})
+
+	t.Run("Correlation", func(t *testing.T) {
+		_, _, autoCorr, _ := stats(t, Jitter{Distribution: DelayNormal, Deviation: deviation, Correlation: 75})
+		assert.InDelta(t, 0.75, autoCorr, 0.05, "should be correlated")
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := newDelayGenerator(base, Jitter{Deviation: -1}, nil)
+		assert.ErrorIs(t, err, errInvalidJitter, "should fail")
+		_, err = newDelayGenerator(base, Jitter{Correlation: 101}, nil)
+		assert.ErrorIs(t, err, errInvalidPercentage, "should fail")
+	})
+}
+
+func TestDelayQueue(t *testing.T) {
+	now := time.Now()
+	q := newDelayQueue(3)
+	assert.True(t, q.push(&chunkUDP{userData: []byte{0}}, now.Add(2*time.Millisecond)), "should succeed")
+	assert.True(t, q.push(&chunkUDP{userData: []byte{1}}, now.Add(time.Millisecond)), "should succeed")
+	assert.True(t, q.push(&chunkUDP{userData: []byte{2}}, now.Add(2*time.Millisecond)), "should succeed")
+	assert.False(t, q.push(&chunkUDP{userData: []byte{3}}, now), "should be full")
+
+	next, ok := q.peek()
+	assert.True(t, ok, "should succeed")
+	assert.Equal(t, byte(1), next.UserData()[0], "should be the earliest")
+
+	var order []byte
+	for c, ok := q.pop(); ok; c, ok = q.pop() {
+		order = append(order, c.UserData()[0])
+	}
+	assert.Equal(t, []byte{1, 0, 2}, order, "should leave by deadline, then by arrival")
+}
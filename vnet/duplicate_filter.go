@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+// DuplicateFilter is a wrapper around NICs, that passes some of the packets
+// passed to onInboundChunk twice, like netem duplicate.
+type DuplicateFilter struct {
+	NIC
+	chance float64    // read-only, percent
+	rand   *rand.Rand // requires mutex
+	mutex  sync.Mutex
+}
+
+// DuplicateFilterOption is the option type to configure a DuplicateFilter
+type DuplicateFilterOption func(*DuplicateFilter)
+
+// DuplicateFilterRand sets the random number generator that decides which
+// packets are duplicated, for a reproducible run. It must not be shared.
+// Defaults to a generator seeded from the system clock.
+func DuplicateFilterRand(rng *rand.Rand) DuplicateFilterOption {
+	return func(f *DuplicateFilter) {
+		f.rand = randOrSeeded(rng)
+	}
+}
+
+// NewDuplicateFilter creates a new DuplicateFilter that duplicates every
+// packet with a probability of chance/100.
+func NewDuplicateFilter(nic NIC, chance float64, opts ...DuplicateFilterOption) (*DuplicateFilter, error) {
+	if chance < 0 || chance > 100 {
+		return nil, fmt.Errorf("%w: %v", errInvalidPercentage, chance)
+	}
+
+	f := &DuplicateFilter{
+		NIC:    nic,
+		chance: chance,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.rand == nil {
+		f.rand = randOrSeeded(nil)
+	}
+	return f, nil
+}
+
+func (f *DuplicateFilter) onInboundChunk(c Chunk) {
+	f.mutex.Lock()
+	duplicate := f.rand.Float64()*100 < f.chance
+	f.mutex.Unlock()
+
+	if duplicate {
+		f.NIC.onInboundChunk(c.Clone())
+	}
+	f.NIC.onInboundChunk(c)
+}
@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuplicateFilter(t *testing.T) {
+	run := func(t *testing.T, chance float64, opts ...DuplicateFilterOption) []Chunk {
+		t.Helper()
+
+		mnic := newMockNIC(t)
+		f, err := NewDuplicateFilter(mnic, chance, opts...)
+		if !assert.NoError(t, err, "should succeed") {
+			return nil
+		}
+
+		var received []Chunk
+		mnic.mockOnInboundChunk = func(c Chunk) {
+			received = append(received, c)
+		}
+		for i := 0; i < 100; i++ {
+			f.onInboundChunk(&chunkUDP{userData: []byte{byte(i)}})
+		}
+		return received
+	}
+
+	t.Run("NoDuplicates", func(t *testing.T) {
+		assert.Equal(t, 100, len(run(t, 0)), "should match")
+	})
+
+	t.Run("AllDuplicated", func(t *testing.T) {
+		received := run(t, 100)
+		if !assert.Equal(t, 200, len(received), "should match") {
+			return
+		}
+		for i := 0; i < 100; i++ {
+			assert.Equal(t, byte(i), received[2*i].UserData()[0], "should match")
+			assert.Equal(t, byte(i), received[2*i+1].UserData()[0], "should match")
+			assert.NotSame(t, received[2*i], received[2*i+1], "should be a copy")
+		}
+	})
+
+	t.Run("Seeded", func(t *testing.T) {
+		seeded := func() int {
+			return len(run(t, 12.5, DuplicateFilterRand(rand.New(rand.NewSource(1))))) //nolint:gosec
+		}
+		n := seeded()
+		assert.Greater(t, n, 100, "should duplicate some")
+		assert.Less(t, n, 200, "should not duplicate all")
+		assert.Equal(t, n, seeded(), "should duplicate the same packets")
+	})
+
+	t.Run("InvalidPercentage", func(t *testing.T) {
+		_, err := NewDuplicateFilter(newMockNIC(t), 101)
+		assert.ErrorIs(t, err, errInvalidPercentage, "should fail")
+	})
+}
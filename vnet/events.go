@@ -0,0 +1,176 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// EventKind is the kind of an Event. The kinds are bit flags, so that a
+// subscriber may select several of them.
+type EventKind uint32
+
+const (
+	// EventRouted is a chunk passed on to a NIC or another router
+	EventRouted EventKind = 1 << iota
+	// EventDropped is a chunk dropped, for the reason of the event
+	EventDropped
+	// EventFilterRejected is a chunk a ChunkFilter rejected. It is also
+	// dropped with DropFiltered.
+	EventFilterRejected
+	// EventNATMappingCreated is a new NAT mapping
+	EventNATMappingCreated
+	// EventNATMappingRefreshed is a NAT mapping whose lifetime was extended
+	// by a chunk through it
+	EventNATMappingRefreshed
+	// EventNATMappingExpired is a NAT mapping removed, once it expired or
+	// its host left the network
+	EventNATMappingExpired
+	// EventInterfaceChanged is a NIC attached to the router, detached from
+	// it, or renumbered
+	EventInterfaceChanged
+
+	// EventAll selects all kinds of events
+	EventAll EventKind = 1<<iota - 1
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventRouted:
+		return "routed"
+	case EventDropped:
+		return "dropped"
+	case EventFilterRejected:
+		return "filter-rejected"
+	case EventNATMappingCreated:
+		return "nat-mapping-created"
+	case EventNATMappingRefreshed:
+		return "nat-mapping-refreshed"
+	case EventNATMappingExpired:
+		return "nat-mapping-expired"
+	case EventInterfaceChanged:
+		return "interface-changed"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is something that happened in a router or its NAT. The fields that
+// do not apply to its kind are left empty.
+type Event struct {
+	Kind EventKind
+	// Time is when the event happened, by the clock of the router
+	Time time.Time
+	// Router is the name of the router
+	Router string
+	// Chunk is the chunk routed, dropped or rejected
+	Chunk Chunk
+	// Reason is the reason a chunk was dropped
+	Reason DropReason
+	// NIC is the NIC a chunk was routed to, nil for another router, or the
+	// NIC whose interface changed
+	NIC NIC
+	// IPs are the addresses of the NIC whose interface changed, none if it
+	// was detached
+	IPs []net.IP
+	// Mapping is the NAT mapping created, refreshed or expired
+	Mapping NATMapping
+}
+
+// EventHandler is called for each event a subscriber selected. It is called
+// synchronously by the router, with its locks held: it must not block, nor
+// call back into the router.
+type EventHandler func(e Event)
+
+type eventSubscriber struct {
+	kinds   EventKind
+	handler EventHandler
+}
+
+// eventBus passes the events of a router and its NATs on to the subscribers.
+// A nil eventBus drops the events.
+type eventBus struct {
+	subscribers []*eventSubscriber // requires mutex
+	mutex       sync.RWMutex
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{}
+}
+
+// subscribe adds a subscriber to the kinds of events, and returns the
+// function that removes it.
+func (b *eventBus) subscribe(kinds EventKind, handler EventHandler) func() {
+	s := &eventSubscriber{kinds: kinds, handler: handler}
+
+	b.mutex.Lock()
+	b.subscribers = append(b.subscribers, s)
+	b.mutex.Unlock()
+
+	return func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+
+		for i, other := range b.subscribers {
+			if other == s {
+				b.subscribers = append(b.subscribers[:i:i], b.subscribers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// wants returns true if a subscriber selected the kind of events, so that
+// the event is worth building.
+func (b *eventBus) wants(kind EventKind) bool {
+	if b == nil {
+		return false
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for _, s := range b.subscribers {
+		if s.kinds&kind != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *eventBus) publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.RLock()
+	subscribers := b.subscribers
+	b.mutex.RUnlock()
+
+	for _, s := range subscribers {
+		if s.kinds&e.Kind != 0 {
+			s.handler(e)
+		}
+	}
+}
+
+// Subscribe registers a handler for the kinds of events of the router and
+// its NATs, like EventDropped|EventNATMappingCreated, or EventAll. It returns
+// the function that unsubscribes the handler.
+func (r *Router) Subscribe(kinds EventKind, handler EventHandler) func() {
+	return r.events.subscribe(kinds, handler)
+}
+
+// publish publishes an event of the router, at the current time
+func (r *Router) publish(e Event) {
+	if !r.events.wants(e.Kind) {
+		return
+	}
+
+	e.Time = r.clock.Now()
+	e.Router = r.name
+	r.events.publish(e)
+}
@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvents(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	t.Run("Router", func(t *testing.T) {
+		r, err := NewRouter(&RouterConfig{
+			Name:          "r0",
+			CIDR:          "1.2.3.0/24",
+			LoggerFactory: loggerFactory,
+		})
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		eventCh := make(chan Event, 16)
+		unsubscribe := r.Subscribe(EventAll, func(e Event) {
+			eventCh <- e
+		})
+		filteredCh := make(chan Event, 16)
+		r.Subscribe(EventFilterRejected, func(e Event) {
+			filteredCh <- e
+		})
+
+		nics := make([]*dummyNIC, 2)
+		arrivalCh := make(chan Chunk, 1)
+		for i := range nics {
+			n, err := NewNet(&NetConfig{StaticIPs: []string{fmt.Sprintf("1.2.3.%d", i+1)}})
+			assert.NoError(t, err, "should succeed")
+			nics[i] = &dummyNIC{Net: n, onInboundChunkHandler: func(c Chunk) {
+				arrivalCh <- c
+			}}
+			assert.NoError(t, r.AddNet(nics[i]), "should succeed")
+
+			e := <-eventCh
+			assert.Equal(t, EventInterfaceChanged, e.Kind)
+			assert.Equal(t, "r0", e.Router)
+			assert.Equal(t, nics[i], e.NIC)
+			assert.Equal(t, []net.IP{net.ParseIP(fmt.Sprintf("1.2.3.%d", i+1))}, e.IPs)
+		}
+
+		r.AddChunkFilter(func(c Chunk) bool {
+			return c.DestinationAddr().(*net.UDPAddr).Port != 3333 //nolint:forcetypeassert
+		})
+
+		assert.NoError(t, r.Start(), "should succeed")
+		defer func() {
+			assert.NoError(t, r.Stop(), "should succeed")
+		}()
+
+		src := &net.UDPAddr{IP: net.ParseIP("1.2.3.1"), Port: 1111}
+		routed := newChunkUDP(src, &net.UDPAddr{IP: net.ParseIP("1.2.3.2"), Port: 2222})
+		r.push(routed)
+		<-arrivalCh
+
+		e := <-eventCh
+		assert.Equal(t, EventRouted, e.Kind)
+		assert.Equal(t, routed.Tag(), e.Chunk.Tag())
+		assert.Equal(t, nics[1], e.NIC)
+		assert.False(t, e.Time.IsZero(), "should have the time")
+
+		rejected := newChunkUDP(src, &net.UDPAddr{IP: net.ParseIP("1.2.3.2"), Port: 3333})
+		r.push(rejected)
+
+		e = <-eventCh
+		assert.Equal(t, EventFilterRejected, e.Kind)
+		assert.Equal(t, rejected.Tag(), e.Chunk.Tag())
+		e = <-eventCh
+		assert.Equal(t, EventDropped, e.Kind)
+		assert.Equal(t, DropFiltered, e.Reason)
+		assert.Equal(t, rejected.Tag(), e.Chunk.Tag())
+
+		e = <-filteredCh
+		assert.Equal(t, EventFilterRejected, e.Kind, "should select by kind")
+
+		unsubscribe()
+		assert.NoError(t, r.RemoveNet(nics[1]), "should succeed")
+		select {
+		case e := <-eventCh:
+			assert.Fail(t, "should be unsubscribed", "got %s", e.Kind)
+		default:
+		}
+		assert.Empty(t, filteredCh, "should select by kind")
+	})
+
+	t.Run("NAT", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(0, 0))
+		events := newEventBus()
+		nat, err := newNAT(&natConfig{
+			name: "nat",
+			natType: NATType{
+				MappingBehavior:   EndpointIndependent,
+				FilteringBehavior: EndpointIndependent,
+				MappingLifeTime:   time.Minute,
+			},
+			mappedIPs:     []net.IP{net.ParseIP(demoIP)},
+			clock:         clock,
+			events:        events,
+			loggerFactory: loggerFactory,
+		})
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		var got []Event
+		events.subscribe(EventNATMappingCreated|EventNATMappingRefreshed|EventNATMappingExpired, func(e Event) {
+			got = append(got, e)
+		})
+
+		src := &net.UDPAddr{IP: net.ParseIP("192.168.0.2"), Port: 1234}
+		dst := &net.UDPAddr{IP: net.ParseIP("5.6.7.8"), Port: 5678}
+
+		oec, err := nat.translateOutbound(newChunkUDP(src, dst))
+		assert.NoError(t, err, "should succeed")
+		clock.Advance(30 * time.Second)
+		_, err = nat.translateOutbound(newChunkUDP(src, dst))
+		assert.NoError(t, err, "should succeed")
+		clock.Advance(2 * time.Minute)
+		_, err = nat.translateOutbound(newChunkUDP(src, dst))
+		assert.NoError(t, err, "should succeed")
+
+		kinds := make([]EventKind, 0, len(got))
+		for _, e := range got {
+			kinds = append(kinds, e.Kind)
+		}
+		assert.Equal(t, []EventKind{
+			EventNATMappingCreated,
+			EventNATMappingRefreshed,
+			EventNATMappingExpired,
+			EventNATMappingCreated,
+		}, kinds)
+
+		assert.Equal(t, NATMapping{
+			Protocol: udp,
+			Local:    src.String(),
+			Mapped:   oec.SourceAddr().String(),
+			Filters:  []string{""},
+			Expires:  time.Unix(60, 0),
+		}, got[0].Mapping)
+		assert.Equal(t, "nat", got[0].Router)
+		assert.Equal(t, time.Unix(90, 0), got[1].Mapping.Expires, "should be refreshed")
+		assert.Equal(t, time.Unix(150, 0), got[2].Time)
+	})
+}
@@ -0,0 +1,322 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	defaultFirewallFlowLifeTime = 2 * time.Minute
+	firewallMinSweepSize        = 1024
+)
+
+var errInvalidFirewallRule = errors.New("invalid firewall rule")
+
+// FirewallAction is what a firewall does with a chunk
+type FirewallAction uint8
+
+const (
+	// FirewallAllow passes a chunk on
+	FirewallAllow FirewallAction = iota
+	// FirewallDeny drops a chunk silently
+	FirewallDeny
+)
+
+// FirewallDirection is the direction of a chunk through the router of a
+// firewall, across the edge of its subnet
+type FirewallDirection uint8
+
+const (
+	// FirewallAnyDirection matches the chunks in both directions
+	FirewallAnyDirection FirewallDirection = iota
+	// FirewallInbound matches the chunks from outside the subnet of the
+	// router to a host in it
+	FirewallInbound
+	// FirewallOutbound matches the chunks from a host in the subnet of the
+	// router to outside of it
+	FirewallOutbound
+)
+
+// PortRange is a range of ports, from First to Last. The zero PortRange
+// matches any port.
+type PortRange struct {
+	First uint16
+	Last  uint16
+}
+
+func (p PortRange) any() bool {
+	return p.First == 0 && p.Last == 0
+}
+
+func (p PortRange) contains(port int) bool {
+	return p.any() || (port >= int(p.First) && port <= int(p.Last))
+}
+
+// FirewallRule matches chunks by their protocol, addresses, ports and
+// direction. The empty fields match any chunk.
+type FirewallRule struct {
+	Action    FirewallAction
+	Direction FirewallDirection
+	// Protocol is "udp", "tcp" or "icmp"
+	Protocol string
+	// Source and Destination are CIDRs, like "10.0.0.0/8"
+	Source      string
+	Destination string
+	// SourcePorts and DestinationPorts apply to UDP and TCP only
+	SourcePorts      PortRange
+	DestinationPorts PortRange
+	// Established matches only the chunks of flows the firewall passed on
+	// before, in either direction, like the replies to outbound chunks
+	Established bool
+}
+
+// FirewallConfig describes the firewall of a router. The firewall checks
+// the chunks that cross the edge of the subnet of the router, after the
+// ChunkFilters. The first rule that matches a chunk decides; the
+// DefaultAction decides the chunks no rule matches.
+type FirewallConfig struct {
+	Rules []FirewallRule
+	// DefaultAction defaults to FirewallAllow
+	DefaultAction FirewallAction
+	// FlowLifeTime is how long a flow counts as established after its last
+	// chunk passed. Defaults to 2 minutes.
+	FlowLifeTime time.Duration
+}
+
+// FirewallRuleStats counts the chunks a rule matched
+type FirewallRuleStats struct {
+	Rule FirewallRule
+	Hits uint64
+}
+
+// FirewallStats is a snapshot of the statistics of a firewall
+type FirewallStats struct {
+	// Rules are the rules, in order, with the chunks each matched
+	Rules []FirewallRuleStats
+	// DefaultHits counts the chunks no rule matched
+	DefaultHits uint64
+	// Flows is the number of flows tracked as established
+	Flows int
+}
+
+// firewallRule is a FirewallRule with its CIDRs parsed
+type firewallRule struct {
+	FirewallRule
+	source      *net.IPNet
+	destination *net.IPNet
+	hits        uint64
+}
+
+func newFirewallRule(rule FirewallRule) (*firewallRule, error) {
+	switch rule.Protocol {
+	case "", udp, tcp:
+	case "icmp":
+		if !rule.SourcePorts.any() || !rule.DestinationPorts.any() {
+			return nil, fmt.Errorf("%w: ports of icmp", errInvalidFirewallRule)
+		}
+	default:
+		return nil, fmt.Errorf("%w: protocol %s", errInvalidFirewallRule, rule.Protocol)
+	}
+	for _, ports := range []PortRange{rule.SourcePorts, rule.DestinationPorts} {
+		if ports.First > ports.Last {
+			return nil, fmt.Errorf("%w: port range %d-%d", errInvalidFirewallRule, ports.First, ports.Last)
+		}
+	}
+
+	r := &firewallRule{FirewallRule: rule}
+	var err error
+	if rule.Source != "" {
+		if _, r.source, err = net.ParseCIDR(rule.Source); err != nil {
+			return nil, fmt.Errorf("%w: %s", errInvalidFirewallRule, err.Error())
+		}
+	}
+	if rule.Destination != "" {
+		if _, r.destination, err = net.ParseCIDR(rule.Destination); err != nil {
+			return nil, fmt.Errorf("%w: %s", errInvalidFirewallRule, err.Error())
+		}
+	}
+	return r, nil
+}
+
+func (r *firewallRule) matches(c Chunk, direction FirewallDirection, established bool) bool {
+	if r.Direction != FirewallAnyDirection && r.Direction != direction {
+		return false
+	}
+	if r.Protocol != "" && r.Protocol != c.Network() {
+		return false
+	}
+	if r.source != nil && !r.source.Contains(c.getSourceIP()) {
+		return false
+	}
+	if r.destination != nil && !r.destination.Contains(c.getDestinationIP()) {
+		return false
+	}
+	if !r.SourcePorts.contains(addrPort(c.SourceAddr())) ||
+		!r.DestinationPorts.contains(addrPort(c.DestinationAddr())) {
+		return false
+	}
+	return !r.Established || established
+}
+
+// firewall checks chunks against ordered rules, and tracks the flows it
+// passed on.
+type firewall struct {
+	rules         []*firewallRule
+	defaultAction FirewallAction
+	defaultHits   uint64
+	flowLifeTime  time.Duration
+	flows         map[string]time.Time // key: flowKey, value: time to expire
+	nextSweep     int                  // number of flows at which the expired ones are removed
+}
+
+func newFirewall(config *FirewallConfig) (*firewall, error) {
+	f := &firewall{
+		flowLifeTime: defaultFirewallFlowLifeTime,
+		flows:        map[string]time.Time{},
+		nextSweep:    firewallMinSweepSize,
+	}
+	if config == nil {
+		return f, nil
+	}
+
+	f.defaultAction = config.DefaultAction
+	if config.FlowLifeTime > 0 {
+		f.flowLifeTime = config.FlowLifeTime
+	}
+	for _, rule := range config.Rules {
+		if err := f.addRule(rule); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (f *firewall) addRule(rule FirewallRule) error {
+	r, err := newFirewallRule(rule)
+	if err != nil {
+		return err
+	}
+	f.rules = append(f.rules, r)
+	return nil
+}
+
+// enabled returns true if the firewall may deny a chunk
+func (f *firewall) enabled() bool {
+	return len(f.rules) > 0 || f.defaultAction != FirewallAllow
+}
+
+// allow returns true if the chunk may pass in the direction, and tracks its
+// flow if so
+func (f *firewall) allow(c Chunk, direction FirewallDirection, now time.Time) bool {
+	key := flowKey(c)
+	established := f.isEstablished(key, now) || f.isEstablished(reverseFlowKey(c), now)
+
+	action := f.defaultAction
+	matched := false
+	for _, r := range f.rules {
+		if r.matches(c, direction, established) {
+			r.hits++
+			action = r.Action
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		f.defaultHits++
+	}
+	if action != FirewallAllow {
+		return false
+	}
+
+	f.track(key, now)
+	return true
+}
+
+func (f *firewall) isEstablished(key string, now time.Time) bool {
+	expires, ok := f.flows[key]
+	return ok && !now.After(expires)
+}
+
+func (f *firewall) track(key string, now time.Time) {
+	f.flows[key] = now.Add(f.flowLifeTime)
+	if len(f.flows) < f.nextSweep {
+		return
+	}
+
+	for k, expires := range f.flows {
+		if now.After(expires) {
+			delete(f.flows, k)
+		}
+	}
+	f.nextSweep = 2 * len(f.flows)
+	if f.nextSweep < firewallMinSweepSize {
+		f.nextSweep = firewallMinSweepSize
+	}
+}
+
+func (f *firewall) stats(now time.Time) FirewallStats {
+	s := FirewallStats{
+		Rules:       make([]FirewallRuleStats, 0, len(f.rules)),
+		DefaultHits: f.defaultHits,
+	}
+	for _, r := range f.rules {
+		s.Rules = append(s.Rules, FirewallRuleStats{Rule: r.FirewallRule, Hits: r.hits})
+	}
+	for _, expires := range f.flows {
+		if !now.After(expires) {
+			s.Flows++
+		}
+	}
+	return s
+}
+
+// reverseFlowKey returns the 5-tuple of the replies to a chunk
+func reverseFlowKey(c Chunk) string {
+	return c.Network() + " " + c.DestinationAddr().String() + " " + c.SourceAddr().String()
+}
+
+// AddFirewallRule appends a rule to the firewall of the router, after the
+// rules of RouterConfig.Firewall.
+func (r *Router) AddFirewallRule(rule FirewallRule) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.firewall.addRule(rule)
+}
+
+// FirewallStats returns a snapshot of the statistics of the firewall of the
+// router
+func (r *Router) FirewallStats() FirewallStats {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.firewall.stats(r.clock.Now())
+}
+
+// checkFirewall returns true if the firewall lets the chunk pass. The
+// chunks that do not cross the edge of the subnet of the router pass.
+// caller must hold the mutex
+func (r *Router) checkFirewall(c Chunk) bool {
+	if !r.firewall.enabled() {
+		return true
+	}
+
+	srcInside := subnetContains(r.ipv4Net, r.ipv6Net, c.getSourceIP())
+	dstInside := subnetContains(r.ipv4Net, r.ipv6Net, c.getDestinationIP())
+
+	var direction FirewallDirection
+	switch {
+	case srcInside && !dstInside:
+		direction = FirewallOutbound
+	case !srcInside && dstInside:
+		direction = FirewallInbound
+	default:
+		return true
+	}
+	return r.firewall.allow(c, direction, r.clock.Now())
+}
@@ -0,0 +1,173 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFirewall(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, rule := range []FirewallRule{
+			{Protocol: "sctp"},
+			{Protocol: "icmp", DestinationPorts: PortRange{First: 1, Last: 2}},
+			{SourcePorts: PortRange{First: 2, Last: 1}},
+			{Source: "10.0.0.1"},
+			{Destination: "10.0.0.0/33"},
+		} {
+			_, err := newFirewall(&FirewallConfig{Rules: []FirewallRule{rule}})
+			assert.ErrorIs(t, err, errInvalidFirewallRule, "should fail")
+		}
+
+		_, err := NewRouter(&RouterConfig{
+			CIDR:          "1.2.3.0/24",
+			Firewall:      &FirewallConfig{Rules: []FirewallRule{{Protocol: "sctp"}}},
+			LoggerFactory: loggerFactory,
+		})
+		assert.ErrorIs(t, err, errInvalidFirewallRule, "should fail")
+	})
+
+	t.Run("Rules", func(t *testing.T) {
+		f, err := newFirewall(&FirewallConfig{
+			Rules: []FirewallRule{
+				{Action: FirewallDeny, Source: "192.168.0.66/32"},
+				{Protocol: tcp, DestinationPorts: PortRange{First: 443, Last: 443}},
+				{Direction: FirewallInbound, Protocol: udp, SourcePorts: PortRange{First: 3478, Last: 3479}},
+			},
+			DefaultAction: FirewallDeny,
+		})
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		now := time.Unix(0, 0)
+		for _, test := range []struct {
+			chunk     Chunk
+			direction FirewallDirection
+			allowed   bool
+		}{
+			{newChunkTCP(&net.TCPAddr{IP: net.ParseIP("192.168.0.66"), Port: 1234}, &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 443}, tcpSYN), FirewallOutbound, false},
+			{newChunkTCP(&net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 1234}, &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 443}, tcpSYN), FirewallOutbound, true},
+			{newChunkTCP(&net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 1234}, &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 80}, tcpSYN), FirewallOutbound, false},
+			{newChunkUDP(&net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 3479}, &net.UDPAddr{IP: net.ParseIP("192.168.0.2"), Port: 1234}), FirewallInbound, true},
+			{newChunkUDP(&net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 3479}, &net.UDPAddr{IP: net.ParseIP("192.168.0.2"), Port: 1234}), FirewallOutbound, false},
+			{newChunkUDP(&net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 3480}, &net.UDPAddr{IP: net.ParseIP("192.168.0.2"), Port: 1234}), FirewallInbound, false},
+		} {
+			assert.Equal(t, test.allowed, f.allow(test.chunk, test.direction, now), test.chunk.String())
+		}
+
+		stats := f.stats(now)
+		hits := make([]uint64, 0, len(stats.Rules))
+		for _, rule := range stats.Rules {
+			hits = append(hits, rule.Hits)
+		}
+		assert.Equal(t, []uint64{1, 1, 1}, hits, "should count the first rule that matches")
+		assert.Equal(t, uint64(3), stats.DefaultHits)
+		assert.Equal(t, 2, stats.Flows, "should track the allowed flows")
+	})
+
+	t.Run("Established", func(t *testing.T) {
+		wan, err := NewRouter(&RouterConfig{
+			CIDR:          "1.2.3.0/24",
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		// only STUN out, and the replies back in
+		lan, err := NewRouter(&RouterConfig{
+			CIDR: "192.168.0.0/24",
+			NATType: &NATType{
+				MappingBehavior:   EndpointIndependent,
+				FilteringBehavior: EndpointIndependent,
+			},
+			Firewall: &FirewallConfig{
+				Rules: []FirewallRule{
+					{Direction: FirewallOutbound, Protocol: udp, DestinationPorts: PortRange{First: 3478, Last: 3478}},
+					{Direction: FirewallInbound, Established: true},
+				},
+				DefaultAction: FirewallDeny,
+			},
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, wan.AddRouter(lan), "should succeed")
+
+		serverNet, err := NewNet(&NetConfig{StaticIPs: []string{"1.2.3.4"}})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, wan.AddNet(serverNet), "should succeed")
+		clientNet, err := NewNet(&NetConfig{})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, lan.AddNet(clientNet), "should succeed")
+
+		assert.NoError(t, wan.Start(), "should succeed")
+		defer func() {
+			assert.NoError(t, wan.Stop(), "should succeed")
+		}()
+
+		stun, err := serverNet.ListenUDP(udp4, &net.UDPAddr{Port: 3478})
+		assert.NoError(t, err, "should succeed")
+		other, err := serverNet.ListenUDP(udp4, &net.UDPAddr{Port: 4000})
+		assert.NoError(t, err, "should succeed")
+		client, err := clientNet.ListenUDP(udp4, nil)
+		assert.NoError(t, err, "should succeed")
+		defer func() {
+			assert.NoError(t, stun.Close(), "should succeed")
+			assert.NoError(t, other.Close(), "should succeed")
+			assert.NoError(t, client.Close(), "should succeed")
+		}()
+
+		stunAddr := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 3478}
+		otherAddr := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 4000}
+		firewallDrops := func() uint64 {
+			return lan.Stats().Dropped[DropFirewall].Packets
+		}
+
+		_, err = client.WriteTo([]byte("binding"), stunAddr)
+		assert.NoError(t, err, "should succeed")
+		buf := make([]byte, 1500)
+		_, mapped, err := stun.ReadFrom(buf)
+		assert.NoError(t, err, "should succeed")
+
+		_, err = stun.WriteTo([]byte("reply"), mapped)
+		assert.NoError(t, err, "should succeed")
+		n, _, err := client.ReadFrom(buf)
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, "reply", string(buf[:n]), "should let the reply in")
+
+		_, err = client.WriteTo([]byte("hello"), otherAddr)
+		assert.NoError(t, err, "should succeed")
+		assert.Eventually(t, func() bool { return firewallDrops() == 1 }, time.Second, time.Millisecond, "should deny other ports out")
+
+		_, err = other.WriteTo([]byte("hello"), mapped)
+		assert.NoError(t, err, "should succeed")
+		assert.Eventually(t, func() bool { return firewallDrops() == 2 }, time.Second, time.Millisecond, "should deny flows that are not established in")
+
+		assert.NoError(t, lan.AddFirewallRule(FirewallRule{
+			Direction:   FirewallInbound,
+			Protocol:    udp,
+			SourcePorts: PortRange{First: 4000, Last: 4000},
+		}), "should succeed")
+		_, err = other.WriteTo([]byte("hello"), mapped)
+		assert.NoError(t, err, "should succeed")
+		n, _, err = client.ReadFrom(buf)
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, "hello", string(buf[:n]), "should let the added rule in")
+
+		stats := lan.FirewallStats()
+		hits := make([]uint64, 0, len(stats.Rules))
+		for _, rule := range stats.Rules {
+			hits = append(hits, rule.Hits)
+		}
+		assert.Equal(t, []uint64{1, 1, 1}, hits)
+		assert.Equal(t, uint64(2), stats.DefaultHits)
+		assert.Equal(t, "firewall", DropFirewall.String())
+	})
+}
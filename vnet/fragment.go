@@ -0,0 +1,227 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ipv4HeaderSize = 20
+	ipv6HeaderSize = 40
+	udpHeaderSize  = 8
+	tcpHeaderSize  = 20
+	icmpHeaderSize = 8
+
+	minMTU                    = 68 // RFC 791
+	defaultMTU                = 1500
+	maxIPPacketSize           = 65535
+	fragmentReassemblyTimeout = 30 * time.Second
+)
+
+// MTUPolicy defines what a router does with a chunk larger than the MTU of
+// the link it is forwarded on.
+type MTUPolicy uint8
+
+const (
+	// MTUPolicyFragment splits oversize IPv4 chunks into fragments, which are
+	// reassembled by the destination Net. IPv6 chunks are never fragmented by
+	// routers (RFC 8200) and are dropped as with MTUPolicyDrop.
+	MTUPolicyFragment MTUPolicy = iota
+	// MTUPolicyDrop drops oversize chunks and replies with an ICMP
+	// "fragmentation needed" carrying the MTU, as with the DF bit set.
+	MTUPolicyDrop
+)
+
+// Generate an identification unique across the vnet for the fragments of
+// a chunk
+var assignFragmentID = func() func() uint32 { //nolint:gochecknoglobals
+	var idCtr uint32
+
+	return func() uint32 {
+		return atomic.AddUint32(&idCtr, 1)
+	}
+}()
+
+// fragmentInfo tells which part of the user data of the original chunk an
+// IP fragment carries.
+type fragmentInfo struct {
+	id     uint32
+	offset int  // offset of the user data in the original chunk
+	more   bool // more fragments follow
+}
+
+func (f *fragmentInfo) String() string {
+	return fmt.Sprintf("fragment id=%d offset=%d more=%t", f.id, f.offset, f.more)
+}
+
+func ipHeaderSize(ip net.IP) int {
+	if ip.To4() != nil {
+		return ipv4HeaderSize
+	}
+	return ipv6HeaderSize
+}
+
+// transportHeaderSize returns the size of the header of the chunk's
+// transport protocol, carried by the first fragment only.
+func transportHeaderSize(c Chunk) int {
+	if f := c.getFragment(); f != nil && f.offset > 0 {
+		return 0
+	}
+
+	switch c := c.(type) {
+	case *chunkUDP:
+		return udpHeaderSize
+	case *chunkTCP:
+		return tcpHeaderSize
+	case *chunkICMP:
+		// the message carries the IP header and the first 8 bytes of the original
+		return icmpHeaderSize + ipHeaderSize(c.original.getSourceIP()) + 8
+	default:
+		return 0
+	}
+}
+
+// chunkSize returns the size of the IP packet that carries the chunk.
+func chunkSize(c Chunk) int {
+	return ipHeaderSize(c.getDestinationIP()) + transportHeaderSize(c) + len(c.UserData())
+}
+
+// fragmentChunk splits a UDP or TCP chunk into IP fragments that fit the
+// MTU. Fragments of a fragment keep the identification of the original
+// chunk. It returns nil if the chunk cannot be fragmented.
+func fragmentChunk(c Chunk, mtu int) []Chunk {
+	if c.Network() != udp && c.Network() != tcp {
+		return nil
+	}
+
+	// the payload of each fragment but the last is a multiple of 8 bytes
+	maxPayload := (mtu - ipHeaderSize(c.getDestinationIP())) &^ 7
+	header := transportHeaderSize(c)
+	if maxPayload <= header {
+		return nil
+	}
+
+	id, offset, more := assignFragmentID(), 0, false
+	if f := c.getFragment(); f != nil {
+		id, offset, more = f.id, f.offset, f.more
+	}
+
+	data := c.UserData()
+	var fragments []Chunk
+	for pos := 0; pos == 0 || pos < len(data); {
+		n := maxPayload - header
+		if n > len(data)-pos {
+			n = len(data) - pos
+		}
+
+		fragment := withUserData(c.Clone(), data[pos:pos+n])
+		setFragment(fragment, &fragmentInfo{
+			id:     id,
+			offset: offset + pos,
+			more:   pos+n < len(data) || more,
+		})
+		fragments = append(fragments, fragment)
+
+		pos += n
+		header = 0
+	}
+
+	return fragments
+}
+
+// withUserData replaces the user data of a UDP or TCP chunk with a copy of data.
+func withUserData(c Chunk, data []byte) Chunk {
+	userData := make([]byte, len(data))
+	copy(userData, data)
+
+	switch c := c.(type) {
+	case *chunkUDP:
+		c.userData = userData
+	case *chunkTCP:
+		c.userData = userData
+	}
+	return c
+}
+
+func setFragment(c Chunk, f *fragmentInfo) {
+	switch c := c.(type) {
+	case *chunkUDP:
+		c.fragment = f
+	case *chunkTCP:
+		c.fragment = f
+	}
+}
+
+type reassemblyBuffer struct {
+	fragments []Chunk
+	expires   time.Time
+}
+
+// reassembler puts the fragments of chunks back together. Incomplete
+// chunks are discarded after fragmentReassemblyTimeout.
+type reassembler struct {
+	buffers map[string]*reassemblyBuffer // key: "<proto>:<src-ip>:<dst-ip>:<id>"
+	clock   Clock
+	mutex   sync.Mutex
+}
+
+func newReassembler(clock Clock) *reassembler {
+	return &reassembler{
+		buffers: map[string]*reassemblyBuffer{},
+		clock:   clockOrSystem(clock),
+	}
+}
+
+// add returns the original chunk once all of its fragments arrived, nil
+// otherwise.
+func (r *reassembler) add(c Chunk) Chunk {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := r.clock.Now()
+	for key, buf := range r.buffers {
+		if now.After(buf.expires) {
+			delete(r.buffers, key)
+		}
+	}
+
+	f := c.getFragment()
+	key := fmt.Sprintf("%s:%s:%s:%d", c.Network(), c.getSourceIP(), c.getDestinationIP(), f.id)
+	buf, ok := r.buffers[key]
+	if !ok {
+		buf = &reassemblyBuffer{expires: now.Add(fragmentReassemblyTimeout)}
+		r.buffers[key] = buf
+	}
+	buf.fragments = append(buf.fragments, c)
+
+	sort.SliceStable(buf.fragments, func(i, j int) bool {
+		return buf.fragments[i].getFragment().offset < buf.fragments[j].getFragment().offset
+	})
+
+	// the fragments must cover the user data from the start to the last fragment
+	var data []byte
+	for _, fragment := range buf.fragments {
+		f := fragment.getFragment()
+		if f.offset > len(data) {
+			return nil // a hole
+		}
+		if end := f.offset + len(fragment.UserData()); end > len(data) {
+			data = append(data, fragment.UserData()[len(data)-f.offset:]...)
+		}
+		if !f.more {
+			delete(r.buffers, key)
+			reassembled := withUserData(buf.fragments[0].Clone(), data)
+			setFragment(reassembled, nil)
+			return reassembled
+		}
+	}
+
+	return nil
+}
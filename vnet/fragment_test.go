@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunkSize(t *testing.T) {
+	src := &net.UDPAddr{IP: net.ParseIP("192.168.0.2"), Port: 1234}
+	dst := &net.UDPAddr{IP: net.ParseIP(demoIP), Port: 5678}
+
+	c := newChunkUDP(src, dst)
+	c.userData = make([]byte, 100)
+	assert.Equal(t, 128, chunkSize(c), "should match")
+
+	c6 := newChunkUDP(&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}, &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 5678})
+	c6.userData = make([]byte, 100)
+	assert.Equal(t, 148, chunkSize(c6), "should match")
+
+	tc := newChunkTCP(&net.TCPAddr{IP: src.IP, Port: src.Port}, &net.TCPAddr{IP: dst.IP, Port: dst.Port}, tcpACK)
+	tc.userData = make([]byte, 100)
+	assert.Equal(t, 140, chunkSize(tc), "should match")
+
+	icmp := newChunkICMPUnreachable(dst.IP, c, icmpPortUnreachable)
+	assert.Equal(t, 56, chunkSize(icmp), "should match")
+}
+
+func TestFragmentChunk(t *testing.T) {
+	src := &net.UDPAddr{IP: net.ParseIP("192.168.0.2"), Port: 1234}
+	dst := &net.UDPAddr{IP: net.ParseIP(demoIP), Port: 5678}
+
+	c := newChunkUDP(src, dst)
+	c.userData = make([]byte, 3000)
+	_, err := rand.Read(c.userData)
+	assert.NoError(t, err, "should succeed")
+
+	t.Run("fragment", func(t *testing.T) {
+		fragments := fragmentChunk(c, 1500)
+		assert.Equal(t, 3, len(fragments), "should match")
+
+		offset := 0
+		for i, fragment := range fragments {
+			f := fragment.getFragment()
+			assert.True(t, chunkSize(fragment) <= 1500, "should fit the MTU")
+			assert.Equal(t, offset, f.offset, "should match")
+			assert.Equal(t, i < len(fragments)-1, f.more, "should match")
+			assert.Equal(t, fragments[0].getFragment().id, f.id, "should share the id")
+			assert.Equal(t, c.SourceAddr().String(), fragment.SourceAddr().String(), "should match")
+			offset += len(fragment.UserData())
+		}
+		assert.Equal(t, 1472, len(fragments[0].UserData()), "should match")
+		assert.Equal(t, len(c.userData), offset, "should match")
+		assert.Nil(t, c.getFragment(), "original should not be modified")
+	})
+
+	t.Run("fragment a fragment", func(t *testing.T) {
+		fragments := fragmentChunk(c, 1500)
+		smaller := fragmentChunk(fragments[1], 576)
+		assert.Equal(t, 3, len(smaller), "should match")
+
+		offset := fragments[1].getFragment().offset
+		for _, fragment := range smaller {
+			assert.True(t, chunkSize(fragment) <= 576, "should fit the MTU")
+			assert.Equal(t, offset, fragment.getFragment().offset, "should match")
+			assert.True(t, fragment.getFragment().more, "more fragments follow the middle one")
+			offset += len(fragment.UserData())
+		}
+	})
+
+	t.Run("cannot fragment", func(t *testing.T) {
+		assert.Nil(t, fragmentChunk(c, 24), "no room for the UDP header")
+		assert.Nil(t, fragmentChunk(newChunkICMPUnreachable(dst.IP, c, icmpPortUnreachable), 68), "should not fragment ICMP")
+	})
+
+	t.Run("reassemble", func(t *testing.T) {
+		fragments := fragmentChunk(c, 576)
+		r := newReassembler(nil)
+
+		// out of order, with a duplicate
+		last := len(fragments) - 1
+		assert.Nil(t, r.add(fragments[last]), "should wait for the others")
+		for i := last - 1; i > 0; i-- {
+			assert.Nil(t, r.add(fragments[i]), "should wait for the others")
+		}
+		assert.Nil(t, r.add(fragments[1]), "should wait for the first")
+
+		reassembled := r.add(fragments[0])
+		if assert.NotNil(t, reassembled, "should be complete") {
+			assert.Nil(t, reassembled.getFragment(), "should not be a fragment")
+			assert.True(t, bytes.Equal(c.userData, reassembled.UserData()), "should match")
+			assert.Equal(t, c.DestinationAddr().String(), reassembled.DestinationAddr().String(), "should match")
+		}
+		assert.Equal(t, 0, len(r.buffers), "should be released")
+	})
+
+	t.Run("reassemble with a hole", func(t *testing.T) {
+		fragments := fragmentChunk(c, 1500)
+		r := newReassembler(nil)
+
+		assert.Nil(t, r.add(fragments[0]), "should wait for the others")
+		assert.Nil(t, r.add(fragments[2]), "should not be complete")
+		assert.Equal(t, 1, len(r.buffers), "should match")
+	})
+
+	t.Run("reassembly timeout", func(t *testing.T) {
+		fragments := fragmentChunk(c, 576)
+		clock := NewManualClock(time.Unix(0, 0))
+		r := newReassembler(clock)
+
+		assert.Nil(t, r.add(fragments[0]), "should wait for the others")
+		clock.Advance(fragmentReassemblyTimeout + time.Nanosecond)
+		for _, fragment := range fragments[1:] {
+			assert.Nil(t, r.add(fragment), "should have discarded the first")
+		}
+	})
+}
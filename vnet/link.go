@@ -0,0 +1,293 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var errInvalidLinkRate = errors.New("link rate must not be negative")
+
+// LinkProfile describes one direction of a Link
+type LinkProfile struct {
+	// Rate in bits per second. Chunks wait in the queue while the link is
+	// busy sending the previous ones. 0 is unlimited.
+	Rate int
+	// Delay of each chunk once it was sent, like the propagation delay
+	Delay time.Duration
+	// Jitter varies the delay of each chunk around Delay
+	Jitter Jitter
+	// Loss is the percentage of chunks dropped before they enter the queue
+	Loss float64
+	// Queue holds the chunks waiting to be sent. Each direction needs a
+	// queue of its own. Defaults to an unlimited DropTailQueue.
+	Queue QueueDiscipline
+}
+
+// LinkConfig describes a Link
+type LinkConfig struct {
+	// Uplink shapes the chunks from the NIC to its router
+	Uplink LinkProfile
+	// Downlink shapes the chunks from the router to the NIC
+	Downlink LinkProfile
+	// Clock is the source of time of the rates and the delays. Defaults to
+	// the system clock.
+	Clock Clock
+	// Rand is the random number generator of the loss and the jitter, for a
+	// reproducible run. It must not be shared. Defaults to a generator
+	// seeded from the system clock.
+	Rand *rand.Rand
+}
+
+// Link is the link between a NIC and its router, like an ADSL, cable or
+// cellular access line. It shapes the chunks in each direction on its own:
+// the uplink the chunks the NIC sends, the downlink the chunks it receives.
+// A Link wraps a NIC, and is added to the router in its place with AddNet.
+// It must be the outermost wrapper, so that the router finds it. Close
+// stops it.
+type Link struct {
+	NIC
+	uplink   *linkDirection // read-only
+	downlink *linkDirection // read-only
+	router   *Router        // requires mutex, set when the link is attached
+	mutex    sync.RWMutex
+}
+
+// NewLink creates and starts a new Link to the given NIC
+func NewLink(nic NIC, config *LinkConfig) (*Link, error) {
+	clock := clockOrSystem(config.Clock)
+	rng := randOrSeeded(config.Rand)
+
+	l := &Link{NIC: nic}
+
+	var err error
+	l.uplink, err = newLinkDirection(&config.Uplink, clock, rand.New(rand.NewSource(rng.Int63())), l.sendToRouter) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	l.downlink, err = newLinkDirection(&config.Downlink, clock, rand.New(rand.NewSource(rng.Int63())), l.NIC.onInboundChunk) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+
+	l.uplink.start()
+	l.downlink.start()
+	return l, nil
+}
+
+func (l *Link) onInboundChunk(c Chunk) {
+	l.downlink.send(c)
+}
+
+// sendUplink is called by the router for the chunks the NIC sends
+func (l *Link) sendUplink(c Chunk) {
+	l.uplink.send(c)
+}
+
+func (l *Link) sendToRouter(c Chunk) {
+	l.mutex.RLock()
+	router := l.router
+	l.mutex.RUnlock()
+
+	if router != nil {
+		router.enqueue(c)
+	}
+}
+
+func (l *Link) setRouter(r *Router) error {
+	l.mutex.Lock()
+	l.router = r
+	l.mutex.Unlock()
+
+	return l.NIC.setRouter(r)
+}
+
+func (l *Link) unsetRouter(r *Router) error {
+	l.mutex.Lock()
+	if l.router == r {
+		l.router = nil
+	}
+	l.mutex.Unlock()
+
+	return l.NIC.unsetRouter(r)
+}
+
+// QueueStats returns the statistics of the queues of the uplink and the
+// downlink
+func (l *Link) QueueStats() (uplink, downlink QueueStats) {
+	return l.uplink.queue.Stats(), l.downlink.queue.Stats()
+}
+
+// Close stops the link. The chunks on it are dropped.
+func (l *Link) Close() error {
+	l.uplink.stop()
+	l.downlink.stop()
+	return nil
+}
+
+// linkDirection sends the chunks one after the other at its rate, and
+// delivers each after its delay.
+type linkDirection struct {
+	rate      int             // read-only
+	loss      float64         // read-only, percent
+	queue     QueueDiscipline // read-only
+	inFlight  *delayQueue     // read-only, chunks sent and not yet delivered
+	delay     *delayGenerator // requires mutex
+	rand      *rand.Rand      // requires mutex
+	busy      bool            // owned by the run goroutine, sending a chunk
+	busyUntil time.Time       // owned by the run goroutine
+	deliver   func(Chunk)     // read-only
+	clock     Clock           // read-only
+	pushCh    chan struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+	mutex     sync.Mutex
+}
+
+func newLinkDirection(profile *LinkProfile, clock Clock, rng *rand.Rand, deliver func(Chunk)) (*linkDirection, error) {
+	if profile.Rate < 0 {
+		return nil, fmt.Errorf("%w: %d", errInvalidLinkRate, profile.Rate)
+	}
+	if profile.Loss < 0 || profile.Loss > 100 {
+		return nil, fmt.Errorf("%w: %v", errInvalidPercentage, profile.Loss)
+	}
+	delay, err := newDelayGenerator(profile.Delay, profile.Jitter, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	queue := profile.Queue
+	if queue == nil {
+		queue = NewDropTailQueue(0, 0)
+	}
+
+	return &linkDirection{
+		rate:     profile.Rate,
+		loss:     profile.Loss,
+		queue:    queue,
+		inFlight: newDelayQueue(0),
+		delay:    delay,
+		rand:     rng,
+		deliver:  deliver,
+		clock:    clock,
+		pushCh:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+func (d *linkDirection) send(c Chunk) {
+	d.mutex.Lock()
+	drop := d.rand.Float64()*100 < d.loss
+	d.mutex.Unlock()
+
+	if drop || !d.queue.Enqueue(c, d.clock.Now()) {
+		return
+	}
+
+	select {
+	case d.pushCh <- struct{}{}:
+	default:
+	}
+}
+
+func (d *linkDirection) start() {
+	d.wg.Add(1)
+	go d.run()
+}
+
+func (d *linkDirection) stop() {
+	close(d.done)
+	d.wg.Wait()
+}
+
+func (d *linkDirection) run() {
+	defer d.wg.Done()
+
+	for {
+		wait := d.process()
+
+		if wait <= 0 {
+			select {
+			case <-d.pushCh:
+			case <-d.done:
+				return
+			}
+			continue
+		}
+
+		timer := d.clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-d.pushCh:
+			timer.Stop()
+		case <-d.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// process sends the chunks whose turn has come, delivers those whose delay
+// has passed, and returns the time until there is more to do, or 0 if it
+// waits for new chunks.
+func (d *linkDirection) process() time.Duration {
+	now := d.clock.Now()
+
+	// a chunk is sent once the previous one was, back to back while
+	// chunks are waiting
+	for !d.busy || !d.busyUntil.After(now) {
+		c, ok := d.queue.Dequeue(now)
+		if !ok {
+			d.busy = false
+			break
+		}
+
+		start := now
+		if d.busy {
+			start = d.busyUntil
+		}
+		d.busy = true
+		d.busyUntil = start.Add(transmissionTime(c, d.rate))
+
+		d.mutex.Lock()
+		delay := d.delay.next()
+		d.mutex.Unlock()
+		d.inFlight.push(c, d.busyUntil.Add(delay))
+	}
+
+	var wait time.Duration
+	for {
+		next, ok := d.inFlight.peek()
+		if !ok {
+			break
+		}
+		if next.deadline.After(now) {
+			wait = next.deadline.Sub(now)
+			break
+		}
+		d.inFlight.pop()
+		d.deliver(next.Chunk)
+	}
+
+	// wake up when the chunk is sent, to send the next one or to become idle
+	if d.busy {
+		if untilSent := d.busyUntil.Sub(now); wait <= 0 || untilSent < wait {
+			wait = untilSent
+		}
+	}
+	return wait
+}
+
+// transmissionTime returns the time to send a chunk at the rate in bits per
+// second, 0 if the rate is unlimited
+func transmissionTime(c Chunk, rate int) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(len(c.UserData())*8) / float64(rate) * float64(time.Second))
+}
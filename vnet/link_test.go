@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLink(t *testing.T) {
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := NewLink(newMockNIC(t), &LinkConfig{Uplink: LinkProfile{Rate: -1}})
+		assert.ErrorIs(t, err, errInvalidLinkRate, "should fail")
+		_, err = NewLink(newMockNIC(t), &LinkConfig{Downlink: LinkProfile{Loss: 101}})
+		assert.ErrorIs(t, err, errInvalidPercentage, "should fail")
+		_, err = NewLink(newMockNIC(t), &LinkConfig{Downlink: LinkProfile{Jitter: Jitter{Deviation: -1}}})
+		assert.ErrorIs(t, err, errInvalidJitter, "should fail")
+	})
+
+	t.Run("RateAndDelay", func(t *testing.T) {
+		mnic := newMockNIC(t)
+		clock := NewManualClock(time.Unix(0, 0))
+		start := clock.Now()
+
+		// 1200 bytes take 100ms to send
+		link, err := NewLink(mnic, &LinkConfig{
+			Downlink: LinkProfile{Rate: 96 * KBit, Delay: 20 * time.Millisecond},
+			Clock:    clock,
+		})
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		arrivalCh := make(chan time.Duration, 3)
+		mnic.mockOnInboundChunk = func(Chunk) {
+			arrivalCh <- clock.Now().Sub(start)
+		}
+
+		for i := 0; i < 3; i++ {
+			link.onInboundChunk(&chunkUDP{userData: make([]byte, 1200)})
+		}
+
+		var arrivals []time.Duration
+		for len(arrivals) < 3 {
+			select {
+			case a := <-arrivalCh:
+				arrivals = append(arrivals, a)
+			default:
+				// advance by 10ms whenever the link waits for its timer
+				clock.mutex.Lock()
+				pending := len(clock.timers)
+				clock.mutex.Unlock()
+				if pending > 0 {
+					clock.Advance(10 * time.Millisecond)
+				} else {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}
+		assert.Equal(t, []time.Duration{
+			120 * time.Millisecond,
+			220 * time.Millisecond,
+			320 * time.Millisecond,
+		}, arrivals, "should send back to back, then delay")
+
+		_, downlink := link.QueueStats()
+		assert.Equal(t, uint64(3), downlink.Dequeued)
+		assert.Equal(t, 200*time.Millisecond, downlink.MaxSojourn)
+		assert.NoError(t, link.Close(), "should succeed")
+	})
+
+	t.Run("Asymmetric", func(t *testing.T) {
+		r, err := NewRouter(&RouterConfig{
+			CIDR:          "1.2.3.0/24",
+			LoggerFactory: logging.NewDefaultLoggerFactory(),
+		})
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		nics := make([]*dummyNIC, 2)
+		arrivalChs := make([]chan Chunk, 2)
+		for i := range nics {
+			n, err := NewNet(&NetConfig{StaticIPs: []string{fmt.Sprintf("1.2.3.%d", i+1)}})
+			assert.NoError(t, err, "should succeed")
+			arrivalCh := make(chan Chunk, 1)
+			arrivalChs[i] = arrivalCh
+			nics[i] = &dummyNIC{Net: n, onInboundChunkHandler: func(c Chunk) {
+				arrivalCh <- c
+			}}
+		}
+
+		// a slow uplink and an unlimited downlink for the first NIC
+		clock := NewManualClock(time.Unix(0, 0))
+		link, err := NewLink(nics[0], &LinkConfig{
+			Uplink: LinkProfile{Rate: 96 * KBit},
+			Clock:  clock,
+		})
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+		assert.NoError(t, r.AddNet(link), "should succeed")
+		assert.NoError(t, r.AddNet(nics[1]), "should succeed")
+
+		assert.NoError(t, r.Start(), "should succeed")
+		defer func() {
+			assert.NoError(t, r.Stop(), "should succeed")
+			assert.NoError(t, link.Close(), "should succeed")
+		}()
+
+		addrs := []*net.UDPAddr{
+			{IP: net.ParseIP("1.2.3.1"), Port: 1111},
+			{IP: net.ParseIP("1.2.3.2"), Port: 2222},
+		}
+		up := newChunkUDP(addrs[0], addrs[1])
+		up.userData = make([]byte, 1200)
+		down := newChunkUDP(addrs[1], addrs[0])
+		down.userData = make([]byte, 1200)
+
+		r.push(down)
+		assert.Equal(t, down.Tag(), (<-arrivalChs[0]).Tag(), "should arrive right away")
+
+		r.push(up)
+		select {
+		case <-arrivalChs[1]:
+			assert.Fail(t, "should wait for the uplink")
+		case <-time.After(50 * time.Millisecond):
+		}
+		clock.BlockUntil(1)
+		clock.Advance(100 * time.Millisecond)
+		assert.Equal(t, up.Tag(), (<-arrivalChs[1]).Tag(), "should arrive once sent")
+
+		uplink, downlink := link.QueueStats()
+		assert.Equal(t, uint64(1), uplink.Dequeued)
+		assert.Equal(t, uint64(1), downlink.Dequeued)
+	})
+
+	t.Run("Loss", func(t *testing.T) {
+		mnic := newMockNIC(t)
+		link, err := NewLink(mnic, &LinkConfig{Downlink: LinkProfile{Loss: 100}})
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		for i := 0; i < 10; i++ {
+			link.onInboundChunk(&chunkUDP{userData: make([]byte, 100)})
+		}
+		assert.NoError(t, link.Close(), "should succeed")
+
+		_, downlink := link.QueueStats()
+		assert.Equal(t, uint64(0), downlink.Enqueued, "should drop all")
+	})
+}
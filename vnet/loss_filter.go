@@ -5,7 +5,7 @@ package vnet
 
 import (
 	"math/rand"
-	"time"
+	"sync"
 )
 
 // LossFilter is a wrapper around NICs, that drops some of the packets passed to
@@ -13,22 +13,45 @@ import (
 type LossFilter struct {
 	NIC
 	chance int
+	rand   *rand.Rand // requires mutex
+	mutex  sync.Mutex
+}
+
+// LossFilterOption is the option type to configure a LossFilter
+type LossFilterOption func(*LossFilter)
+
+// LossFilterRand sets the random number generator that decides which
+// packets are dropped, for a reproducible run. It must not be shared.
+// Defaults to a generator seeded from the system clock.
+func LossFilterRand(rng *rand.Rand) LossFilterOption {
+	return func(f *LossFilter) {
+		f.rand = randOrSeeded(rng)
+	}
 }
 
 // NewLossFilter creates a new LossFilter that drops every packet with a
 // probability of chance/100. Every packet that is not dropped is passed on to
 // the given NIC.
-func NewLossFilter(nic NIC, chance int) (*LossFilter, error) {
+func NewLossFilter(nic NIC, chance int, opts ...LossFilterOption) (*LossFilter, error) {
 	f := &LossFilter{
 		NIC:    nic,
 		chance: chance,
 	}
-	rand.Seed(time.Now().UTC().UnixNano())
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.rand == nil {
+		f.rand = randOrSeeded(nil)
+	}
 	return f, nil
 }
 
 func (f *LossFilter) onInboundChunk(c Chunk) {
-	if rand.Intn(100) < f.chance { //nolint:gosec
+	f.mutex.Lock()
+	drop := f.rand.Intn(100) < f.chance
+	f.mutex.Unlock()
+
+	if drop {
 		return
 	}
 
@@ -4,6 +4,7 @@
 package vnet
 
 import (
+	"math/rand"
 	"net"
 	"testing"
 
@@ -16,6 +17,7 @@ type mockNIC struct {
 	mockOnInboundChunk func(c Chunk)
 	mockGetStaticIPs   func() []net.IP
 	mockSetRouter      func(r *Router) error
+	mockUnsetRouter    func(r *Router) error
 }
 
 func (n *mockNIC) getInterface(ifName string) (*transport.Interface, error) {
@@ -34,6 +36,10 @@ func (n *mockNIC) setRouter(r *Router) error {
 	return n.mockSetRouter(r)
 }
 
+func (n *mockNIC) unsetRouter(r *Router) error {
+	return n.mockUnsetRouter(r)
+}
+
 func newMockNIC(t *testing.T) *mockNIC {
 	return &mockNIC{
 		mockGetInterface: func(string) (*transport.Interface, error) {
@@ -51,6 +57,10 @@ func newMockNIC(t *testing.T) *mockNIC {
 			assert.Fail(t, "unexpected call to mockSetRouter")
 			return nil
 		},
+		mockUnsetRouter: func(*Router) error {
+			assert.Fail(t, "unexpected call to mockUnsetRouter")
+			return nil
+		},
 	}
 }
 
@@ -109,4 +119,23 @@ func TestLossFilter(t *testing.T) {
 		assert.Less(t, 0, received)
 		assert.Greater(t, packets, received)
 	})
+
+	t.Run("Seeded", func(t *testing.T) {
+		run := func() []int {
+			mnic := newMockNIC(t)
+			f, err := NewLossFilter(mnic, 50, LossFilterRand(rand.New(rand.NewSource(1)))) //nolint:gosec
+			assert.NoError(t, err, "should succeed")
+
+			var received []int
+			mnic.mockOnInboundChunk = func(c Chunk) {
+				received = append(received, int(c.UserData()[0]))
+			}
+			for i := 0; i < 100; i++ {
+				f.onInboundChunk(&chunkUDP{userData: []byte{byte(i)}})
+			}
+			return received
+		}
+
+		assert.Equal(t, run(), run(), "should drop the same packets")
+	})
 }
@@ -6,7 +6,9 @@ package vnet
 import (
 	"errors"
 	"fmt"
+	"math/rand"
 	"net"
+	"sort"
 	"sync"
 	"time"
 
@@ -14,12 +16,17 @@ import (
 )
 
 var (
-	errNATRequriesMapping            = errors.New("1:1 NAT requires more than one mapping")
-	errMismatchLengthIP              = errors.New("length mismtach between mappedIPs and localIPs")
-	errNonUDPTranslationNotSupported = errors.New("non-udp translation is not supported yet")
-	errNoAssociatedLocalAddress      = errors.New("no associated local address")
-	errNoNATBindingFound             = errors.New("no NAT binding found")
-	errHasNoPermission               = errors.New("has no permission")
+	errNATRequriesMapping       = errors.New("1:1 NAT requires more than one mapping")
+	errMismatchLengthIP         = errors.New("length mismtach between mappedIPs and localIPs")
+	errTranslationNotSupported  = errors.New("translation of this network is not supported yet")
+	errNoAssociatedLocalAddress = errors.New("no associated local address")
+	errNoNATBindingFound        = errors.New("no NAT binding found")
+	errHasNoPermission          = errors.New("has no permission")
+	errNATPortExhausted         = errors.New("no free port left on the mapped IP")
+	errPreservedPortInUse       = errors.New("preserved port is already in use on the mapped IP")
+	errInvalidPortRange         = errors.New("invalid NAT port range")
+	errInvalidPortBlockSize     = errors.New("invalid NAT port block size")
+	errInvalidWellKnownPort     = errors.New("port is not a well-known port")
 )
 
 // EndpointDependencyType defines a type of behavioral dependendency on the
@@ -49,12 +56,49 @@ const (
 	// NATModeNAT1To1 exhibits 1:1 DNAT where the external IP address is statically mapped to
 	// a specific local IP address with port number is preserved always between them.
 	// When this mode is selected, MappingBehavior, FilteringBehavior, PortPreservation and
-	// MappingLifeTime of NATType are ignored.
+	// the lifetimes of NATType are ignored.
 	NATModeNAT1To1
 )
 
+// PortPreservationFallback defines what a port preserving NAT does when the
+// local source port is already in use on the mapped IP.
+type PortPreservationFallback uint8
+
+const (
+	// PortFallbackAny allocates any free port on the mapped IP
+	PortFallbackAny PortPreservationFallback = iota
+	// PortFallbackParity allocates a free port with the same parity as the
+	// local port (RFC 4787 Section 4.2.2)
+	PortFallbackParity
+	// PortFallbackRange allocates a free port from the same range as the local
+	// port, either 1-1023 or 1024-65535 (RFC 4787 Section 4.2.1)
+	PortFallbackRange
+	// PortFallbackDrop does not create a mapping and drops the packet
+	PortFallbackDrop
+)
+
+// MappingRefreshBehavior defines which chunks refresh a UDP mapping.
+//
+// See: https://tools.ietf.org/html/rfc4787#section-4.3
+type MappingRefreshBehavior uint8
+
+const (
+	// MappingRefreshOutbound refreshes a mapping with the chunks from the
+	// local host only (RFC 4787 REQ-6)
+	MappingRefreshOutbound MappingRefreshBehavior = iota
+	// MappingRefreshBidirectional refreshes a mapping with the chunks in
+	// both directions, like a NAT with inbound refresh
+	MappingRefreshBidirectional
+	// MappingRefreshNone never refreshes a mapping, which expires its
+	// lifetime after it was created
+	MappingRefreshNone
+)
+
 const (
 	defaultNATMappingLifeTime = 30 * time.Second
+	natPortRangeStart         = 0xC000
+	natPortRangeEnd           = 0xFFFF
+	wellKnownPortEnd          = 1023
 )
 
 // NATType has a set of parameters that define the behavior of NAT.
@@ -62,19 +106,67 @@ type NATType struct {
 	Mode              NATMode
 	MappingBehavior   EndpointDependencyType
 	FilteringBehavior EndpointDependencyType
-	Hairpinning       bool // Not implemented yet
-	PortPreservation  bool // Not implemented yet
-	MappingLifeTime   time.Duration
+	Hairpinning       bool // Allow hosts behind the NAT to reach each other via their mapped addresses
+	PortPreservation  bool // Keep the local source port when it is free on the mapped IP
+	// PortPreservationFallback is used when PortPreservation is enabled and the
+	// local port is already in use. Defaults to PortFallbackAny.
+	PortPreservationFallback PortPreservationFallback
+	// PortAllocation defines how mapped ports are picked when they are not
+	// preserved. Defaults to PortAllocationSequential.
+	PortAllocation  PortAllocationBehavior
+	PortRangeStart  uint16        // First port handed out to mappings, defaults to 49152
+	PortRangeEnd    uint16        // Last port handed out to mappings, defaults to 65535
+	PortBlockSize   int           // Ports per block with PortAllocationBlock, defaults to 256
+	MappingLifeTime time.Duration // Lifetime of UDP mappings
+	// MappingUnrepliedLifeTime is the lifetime of UDP mappings through which
+	// no chunk came back yet, like the first packet of a flow. Defaults to
+	// MappingLifeTime.
+	MappingUnrepliedLifeTime time.Duration
+	// WellKnownPortLifeTimes are shorter lifetimes of UDP mappings by the
+	// remote port, for ports 0-1023 only, like 53 for DNS (RFC 4787 REQ-5a)
+	WellKnownPortLifeTimes map[uint16]time.Duration
+	// MappingRefresh defines which chunks refresh UDP mappings. Defaults to
+	// MappingRefreshOutbound.
+	MappingRefresh MappingRefreshBehavior
+	// TCPEstablishedLifeTime is the idle timeout of established TCP
+	// connections, defaults to 2h4m (RFC 5382 REQ-5)
+	TCPEstablishedLifeTime time.Duration
+	// TCPTransitoryLifeTime is the idle timeout of TCP connections that are
+	// partially open, closing or reset, defaults to 4m (RFC 5382 REQ-5)
+	TCPTransitoryLifeTime time.Duration
 }
 
 type natConfig struct {
 	name          string
 	natType       NATType
-	mappedIPs     []net.IP // mapped IPv4
-	localIPs      []net.IP // local IPv4, required only when the mode is NATModeNAT1To1
+	mappedIPs     []net.IP   // mapped IPv4
+	localIPs      []net.IP   // local IPv4, required only when the mode is NATModeNAT1To1
+	clock         Clock      // defaults to the system clock
+	rand          *rand.Rand // defaults to a generator seeded from the system clock
+	events        *eventBus  // the events of the router, may be nil
 	loggerFactory logging.LoggerFactory
 }
 
+// NATMapping is a mapping of a NAT between a local and a mapped address
+type NATMapping struct {
+	Protocol string // "udp" or "tcp"
+	Local    string // "<local-ip>:<local-port>"
+	Mapped   string // "<mapped-ip>:<mapped-port>"
+	// Remote is the part of the remote address the mapping depends on, by
+	// MappingBehavior: empty, "<remote-ip>" or "<remote-ip>:<remote-port>"
+	Remote string
+	// Filters are the remotes permitted to send through the mapping, by
+	// FilteringBehavior, in the format of Remote. An empty one permits any
+	// remote.
+	Filters []string
+	// Expires is the time the mapping expires unless it is refreshed, zero
+	// for a port forward
+	Expires time.Time
+	// Static is true for a port forward, which is open to any remote and
+	// never expires
+	Static bool
+}
+
 type mapping struct {
 	proto   string              // "udp" or "tcp"
 	local   string              // "<local-ip>:<local-port>"
@@ -82,18 +174,25 @@ type mapping struct {
 	bound   string              // key: "[<remote-ip>[:<remote-port>]]"
 	filters map[string]struct{} // key: "[<remote-ip>[:<remote-port>]]"
 	expires time.Time           // time to expire
+	static  bool                // a port forward, open to any remote and never expiring
+	replied bool                // a chunk came back through the mapping, UDP only
+	dstPort uint16              // port of the outbound destination, which picks the lifetime, UDP only
+
+	tcpConns map[string]*natTCPConn // key: "<remote-ip>:<remote-port>", TCP only
 }
 
 type networkAddressTranslator struct {
-	name           string
-	natType        NATType
-	mappedIPs      []net.IP            // mapped IPv4
-	localIPs       []net.IP            // local IPv4, required only when the mode is NATModeNAT1To1
-	outboundMap    map[string]*mapping // key: "<proto>:<local-ip>:<local-port>[:remote-ip[:remote-port]]
-	inboundMap     map[string]*mapping // key: "<proto>:<mapped-ip>:<mapped-port>"
-	udpPortCounter int
-	mutex          sync.RWMutex
-	log            logging.LeveledLogger
+	name        string
+	natType     NATType
+	mappedIPs   []net.IP            // mapped IPv4
+	localIPs    []net.IP            // local IPv4, required only when the mode is NATModeNAT1To1
+	outboundMap map[string]*mapping // key: "<proto>:<local-ip>:<local-port>[:remote-ip[:remote-port]]
+	inboundMap  map[string]*mapping // key: "<proto>:<mapped-ip>:<mapped-port>"
+	ports       portAllocator
+	clock       Clock
+	events      *eventBus
+	mutex       sync.RWMutex
+	log         logging.LeveledLogger
 }
 
 func newNAT(config *natConfig) (*networkAddressTranslator, error) {
@@ -105,6 +204,10 @@ func newNAT(config *natConfig) (*networkAddressTranslator, error) {
 		natType.FilteringBehavior = EndpointIndependent
 		natType.PortPreservation = true
 		natType.MappingLifeTime = 0
+		natType.MappingUnrepliedLifeTime = 0
+		natType.WellKnownPortLifeTimes = nil
+		natType.TCPEstablishedLifeTime = 0
+		natType.TCPTransitoryLifeTime = 0
 
 		if len(config.mappedIPs) == 0 {
 			return nil, errNATRequriesMapping
@@ -118,6 +221,39 @@ func newNAT(config *natConfig) (*networkAddressTranslator, error) {
 		if natType.MappingLifeTime == 0 {
 			natType.MappingLifeTime = defaultNATMappingLifeTime
 		}
+		if natType.MappingUnrepliedLifeTime == 0 {
+			natType.MappingUnrepliedLifeTime = natType.MappingLifeTime
+		}
+		for port := range natType.WellKnownPortLifeTimes {
+			if port > wellKnownPortEnd {
+				return nil, fmt.Errorf("%w: %d", errInvalidWellKnownPort, port)
+			}
+		}
+		if natType.TCPEstablishedLifeTime == 0 {
+			natType.TCPEstablishedLifeTime = defaultNATTCPEstablishedLifeTime
+		}
+		if natType.TCPTransitoryLifeTime == 0 {
+			natType.TCPTransitoryLifeTime = defaultNATTCPTransitoryLifeTime
+		}
+		if natType.PortRangeStart == 0 {
+			natType.PortRangeStart = natPortRangeStart
+		}
+		if natType.PortRangeEnd == 0 {
+			natType.PortRangeEnd = natPortRangeEnd
+		}
+		if natType.PortRangeStart > natType.PortRangeEnd {
+			return nil, errInvalidPortRange
+		}
+		rangeSize := int(natType.PortRangeEnd-natType.PortRangeStart) + 1
+		if natType.PortBlockSize == 0 {
+			natType.PortBlockSize = defaultNATPortBlockSize
+			if natType.PortBlockSize > rangeSize {
+				natType.PortBlockSize = rangeSize
+			}
+		}
+		if natType.PortBlockSize < 0 || natType.PortBlockSize > rangeSize {
+			return nil, errInvalidPortBlockSize
+		}
 	}
 
 	return &networkAddressTranslator{
@@ -127,6 +263,9 @@ func newNAT(config *natConfig) (*networkAddressTranslator, error) {
 		localIPs:    config.localIPs,
 		outboundMap: map[string]*mapping{},
 		inboundMap:  map[string]*mapping{},
+		ports:       newPortAllocator(natType, randOrSeeded(config.rand)),
+		clock:       clockOrSystem(config.clock),
+		events:      config.events,
 		log:         config.loggerFactory.NewLogger("vnet"),
 	}, nil
 }
@@ -140,6 +279,15 @@ func (n *networkAddressTranslator) getPairedMappedIP(locIP net.IP) net.IP {
 	return nil
 }
 
+func (n *networkAddressTranslator) isMappedIP(ip net.IP) bool {
+	for _, mappedIP := range n.mappedIPs {
+		if mappedIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func (n *networkAddressTranslator) getPairedLocalIP(mappedIP net.IP) net.IP {
 	for i, ip := range n.mappedIPs {
 		if ip.Equal(mappedIP) {
@@ -153,62 +301,67 @@ func (n *networkAddressTranslator) translateOutbound(from Chunk) (Chunk, error)
 	n.mutex.Lock()
 	defer n.mutex.Unlock()
 
+	if icmp, ok := from.(*chunkICMP); ok {
+		return n.translateOutboundICMP(icmp)
+	}
+
 	to := from.Clone()
 
-	if from.Network() == udp {
+	if from.Network() == udp || from.Network() == tcp {
 		if n.natType.Mode == NATModeNAT1To1 {
 			// 1:1 NAT behavior
-			srcAddr := from.SourceAddr().(*net.UDPAddr) //nolint:forcetypeassert
-			srcIP := n.getPairedMappedIP(srcAddr.IP)
+			srcIP := n.getPairedMappedIP(from.getSourceIP())
 			if srcIP == nil {
 				n.log.Debugf("[%s] drop outbound chunk %s with not route", n.name, from.String())
 				return nil, nil // nolint:nilnil
 			}
-			srcPort := srcAddr.Port
+			srcPort := addrPort(from.SourceAddr())
 			if err := to.setSourceAddr(fmt.Sprintf("%s:%d", srcIP.String(), srcPort)); err != nil {
 				return nil, err
 			}
 		} else {
 			// Normal (NAPT) behavior
-			var bound, filterKey string
-			switch n.natType.MappingBehavior {
-			case EndpointIndependent:
-				bound = ""
-			case EndpointAddrDependent:
-				bound = from.getDestinationIP().String()
-			case EndpointAddrPortDependent:
-				bound = from.DestinationAddr().String()
-			}
-
-			switch n.natType.FilteringBehavior {
-			case EndpointIndependent:
-				filterKey = ""
-			case EndpointAddrDependent:
-				filterKey = from.getDestinationIP().String()
-			case EndpointAddrPortDependent:
-				filterKey = from.DestinationAddr().String()
-			}
+			bound := endpointKey(n.natType.MappingBehavior, from.DestinationAddr())
+			filterKey := endpointKey(n.natType.FilteringBehavior, from.DestinationAddr())
 
-			oKey := fmt.Sprintf("udp:%s:%s", from.SourceAddr().String(), bound)
+			oKey := fmt.Sprintf("%s:%s:%s", from.Network(), from.SourceAddr().String(), bound)
 
 			m := n.findOutboundMapping(oKey)
 			if m == nil {
+				m = n.findPortForward(from.Network(), from.SourceAddr().String())
+			}
+			created := m == nil
+			if m == nil {
+				if len(n.mappedIPs) == 0 {
+					n.log.Debugf("[%s] drop outbound chunk %s with no mapped IP", n.name, from.String())
+					return nil, nil // nolint:nilnil
+				}
+
 				// Create a new mapping
-				mappedPort := 0xC000 + n.udpPortCounter
-				n.udpPortCounter++
+				proto := from.SourceAddr().Network()
+				mappedIP := n.mappedIPs[0].String()
+				mappedPort, err := n.allocateMappedPort(proto, mappedIP, from.getSourceIP().String(), addrPort(from.SourceAddr()))
+				if err != nil {
+					n.log.Debugf("[%s] drop outbound chunk %s: %s", n.name, from.String(), err)
+					return nil, nil // nolint:nilnil
+				}
 
 				m = &mapping{
-					proto:   from.SourceAddr().Network(),
+					proto:   proto,
 					local:   from.SourceAddr().String(),
 					bound:   bound,
-					mapped:  fmt.Sprintf("%s:%d", n.mappedIPs[0].String(), mappedPort),
+					mapped:  fmt.Sprintf("%s:%d", mappedIP, mappedPort),
 					filters: map[string]struct{}{},
-					expires: time.Now().Add(n.natType.MappingLifeTime),
+					dstPort: uint16(addrPort(from.DestinationAddr())), //nolint:gosec
+				}
+				m.expires = n.clock.Now().Add(n.mappingLifeTime(m))
+				if proto == tcp {
+					m.tcpConns = map[string]*natTCPConn{}
 				}
 
 				n.outboundMap[oKey] = m
 
-				iKey := fmt.Sprintf("udp:%s", m.mapped)
+				iKey := fmt.Sprintf("%s:%s", m.proto, m.mapped)
 
 				n.log.Debugf("[%s] created a new NAT binding oKey=%s iKey=%s",
 					n.name,
@@ -223,6 +376,18 @@ func (n *networkAddressTranslator) translateOutbound(from Chunk) (Chunk, error)
 				m.filters[filterKey] = struct{}{}
 			}
 
+			refreshed := true
+			if c, ok := from.(*chunkTCP); ok {
+				n.trackTCP(m, c, true)
+			} else if !created {
+				refreshed = n.refreshMapping(m, from.DestinationAddr(), true)
+			}
+			if created {
+				n.publish(EventNATMappingCreated, m)
+			} else if refreshed {
+				n.publish(EventNATMappingRefreshed, m)
+			}
+
 			if err := to.setSourceAddr(m.mapped); err != nil {
 				return nil, err
 			}
@@ -233,46 +398,40 @@ func (n *networkAddressTranslator) translateOutbound(from Chunk) (Chunk, error)
 		return to, nil
 	}
 
-	return nil, errNonUDPTranslationNotSupported
+	return nil, errTranslationNotSupported
 }
 
 func (n *networkAddressTranslator) translateInbound(from Chunk) (Chunk, error) {
 	n.mutex.Lock()
 	defer n.mutex.Unlock()
 
+	if icmp, ok := from.(*chunkICMP); ok {
+		return n.translateInboundICMP(icmp)
+	}
+
 	to := from.Clone()
 
-	if from.Network() == udp {
+	if from.Network() == udp || from.Network() == tcp {
 		if n.natType.Mode == NATModeNAT1To1 {
 			// 1:1 NAT behavior
-			dstAddr := from.DestinationAddr().(*net.UDPAddr) //nolint:forcetypeassert
-			dstIP := n.getPairedLocalIP(dstAddr.IP)
+			dstIP := n.getPairedLocalIP(from.getDestinationIP())
 			if dstIP == nil {
 				return nil, fmt.Errorf("drop %s as %w", from.String(), errNoAssociatedLocalAddress)
 			}
-			dstPort := from.DestinationAddr().(*net.UDPAddr).Port //nolint:forcetypeassert
+			dstPort := addrPort(from.DestinationAddr())
 			if err := to.setDestinationAddr(fmt.Sprintf("%s:%d", dstIP, dstPort)); err != nil {
 				return nil, err
 			}
 		} else {
 			// Normal (NAPT) behavior
-			iKey := fmt.Sprintf("udp:%s", from.DestinationAddr().String())
+			iKey := fmt.Sprintf("%s:%s", from.Network(), from.DestinationAddr().String())
 			m := n.findInboundMapping(iKey)
 			if m == nil {
 				return nil, fmt.Errorf("drop %s as %w", from.String(), errNoNATBindingFound)
 			}
 
-			var filterKey string
-			switch n.natType.FilteringBehavior {
-			case EndpointIndependent:
-				filterKey = ""
-			case EndpointAddrDependent:
-				filterKey = from.getSourceIP().String()
-			case EndpointAddrPortDependent:
-				filterKey = from.SourceAddr().String()
-			}
-
-			if _, ok := m.filters[filterKey]; !ok {
+			filterKey := endpointKey(n.natType.FilteringBehavior, from.SourceAddr())
+			if !m.permits(filterKey) {
 				return nil, fmt.Errorf("drop %s as the remote %s %w", from.String(), filterKey, errHasNoPermission)
 			}
 
@@ -283,6 +442,20 @@ func (n *networkAddressTranslator) translateInbound(from Chunk) (Chunk, error) {
 			//   alive indefinitely.  This may be a security risk.  Also, if the
 			//   process is repeated with different ports, over time, it could
 			//   use up all the ports on the NAT.
+			// UDP mappings are refreshed by inbound chunks only with
+			// MappingRefreshBidirectional.
+
+			// TCP connections are refreshed by segments in both directions
+			// (RFC 5382 Section 5)
+			if c, ok := from.(*chunkTCP); ok {
+				n.trackTCP(m, c, false)
+				n.publish(EventNATMappingRefreshed, m)
+			} else {
+				replied := n.setReplied(m)
+				if n.refreshMapping(m, from.SourceAddr(), false) || replied {
+					n.publish(EventNATMappingRefreshed, m)
+				}
+			}
 
 			if err := to.setDestinationAddr(m.local); err != nil {
 				return nil, err
@@ -294,37 +467,130 @@ func (n *networkAddressTranslator) translateInbound(from Chunk) (Chunk, error) {
 		return to, nil
 	}
 
-	return nil, errNonUDPTranslationNotSupported
+	return nil, errTranslationNotSupported
+}
+
+// allocateMappedPort picks the port of a new mapping on the mapped IP. The
+// local port is kept when PortPreservation is enabled and the port is free,
+// otherwise PortPreservationFallback decides which port, if any, to use.
+// caller must hold the mutex
+func (n *networkAddressTranslator) allocateMappedPort(proto, mappedIP, localIP string, localPort int) (int, error) {
+	start, end := int(n.natType.PortRangeStart), int(n.natType.PortRangeEnd)
+	accept := func(int) bool { return true }
+
+	if n.natType.PortPreservation && localPort > 0 {
+		if !n.isPortInUse(proto, mappedIP, localPort) {
+			return localPort, nil
+		}
+
+		switch n.natType.PortPreservationFallback {
+		case PortFallbackAny:
+		case PortFallbackParity:
+			accept = func(port int) bool { return port%2 == localPort%2 }
+		case PortFallbackRange:
+			if localPort <= wellKnownPortEnd {
+				start, end = 1, wellKnownPortEnd
+			}
+		case PortFallbackDrop:
+			return 0, fmt.Errorf("%w: %d", errPreservedPortInUse, localPort)
+		}
+	}
+
+	return n.ports.allocate(localIP, start, end, func(port int) bool {
+		return accept(port) && !n.isPortInUse(proto, mappedIP, port)
+	})
+}
+
+// isPortInUse returns true if an unexpired mapping holds the port on the
+// mapped IP.
+// caller must hold the mutex
+func (n *networkAddressTranslator) isPortInUse(proto, mappedIP string, port int) bool {
+	iKey := fmt.Sprintf("%s:%s:%d", proto, mappedIP, port)
+	return n.findInboundMapping(iKey) != nil
 }
 
 // caller must hold the mutex
 func (n *networkAddressTranslator) findOutboundMapping(oKey string) *mapping {
-	now := time.Now()
+	now := n.clock.Now()
 
 	m, ok := n.outboundMap[oKey]
 	if ok {
 		// check if this mapping is expired
-		if now.After(m.expires) {
+		if m.expired(now) {
 			n.removeMapping(m)
 			m = nil // expired
-		} else {
-			m.expires = time.Now().Add(n.natType.MappingLifeTime)
 		}
 	}
 
 	return m
 }
 
+// refreshMapping extends the lifetime of a UDP mapping for a chunk to or
+// from the remote, as the MappingRefresh behavior allows. An outbound chunk
+// also sets the destination the lifetime follows. It returns true if the
+// mapping was refreshed.
+// caller must hold the mutex
+func (n *networkAddressTranslator) refreshMapping(m *mapping, remote net.Addr, outbound bool) bool {
+	if m.static {
+		return false
+	}
+	switch n.natType.MappingRefresh {
+	case MappingRefreshOutbound:
+		if !outbound {
+			return false
+		}
+	case MappingRefreshBidirectional:
+	case MappingRefreshNone:
+		return false
+	}
+
+	if outbound {
+		m.dstPort = uint16(addrPort(remote)) //nolint:gosec
+	}
+	m.expires = n.clock.Now().Add(n.mappingLifeTime(m))
+	return true
+}
+
+// setReplied marks a UDP mapping as replied on the first chunk that comes
+// back, and extends the lifetime it was last refreshed with to that of
+// replied mappings, whatever refreshes it. It returns true if it did.
+// caller must hold the mutex
+func (n *networkAddressTranslator) setReplied(m *mapping) bool {
+	if m.replied || m.static {
+		return false
+	}
+
+	unreplied := n.mappingLifeTime(m)
+	m.replied = true
+	m.expires = m.expires.Add(n.mappingLifeTime(m) - unreplied)
+	return true
+}
+
+// mappingLifeTime returns the lifetime of a UDP mapping: that of the
+// well-known port of its outbound destination if any, otherwise whether a
+// chunk came back through the mapping or not. The remotes that reply do not
+// change it.
+// caller must hold the mutex
+func (n *networkAddressTranslator) mappingLifeTime(m *mapping) time.Duration {
+	if lifeTime, ok := n.natType.WellKnownPortLifeTimes[m.dstPort]; ok {
+		return lifeTime
+	}
+	if !m.replied {
+		return n.natType.MappingUnrepliedLifeTime
+	}
+	return n.natType.MappingLifeTime
+}
+
 // caller must hold the mutex
 func (n *networkAddressTranslator) findInboundMapping(iKey string) *mapping {
-	now := time.Now()
+	now := n.clock.Now()
 	m, ok := n.inboundMap[iKey]
 	if !ok {
 		return nil
 	}
 
 	// check if this mapping is expired
-	if now.After(m.expires) {
+	if m.expired(now) {
 		n.removeMapping(m)
 		return nil
 	}
@@ -339,4 +605,105 @@ func (n *networkAddressTranslator) removeMapping(m *mapping) {
 
 	delete(n.outboundMap, oKey)
 	delete(n.inboundMap, iKey)
+	n.publish(EventNATMappingExpired, m)
+}
+
+// publish publishes an event about a mapping
+// caller must hold the mutex
+func (n *networkAddressTranslator) publish(kind EventKind, m *mapping) {
+	if !n.events.wants(kind) {
+		return
+	}
+
+	n.events.publish(Event{
+		Kind:    kind,
+		Time:    n.clock.Now(),
+		Router:  n.name,
+		Mapping: m.snapshot(),
+	})
+}
+
+// removeMappingsOf removes the mappings of the local IPs, like the bindings
+// of a host that left the network, and releases their ports.
+func (n *networkAddressTranslator) removeMappingsOf(ips []net.IP) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for _, ip := range ips {
+		for _, m := range n.outboundMap {
+			if host, _, err := net.SplitHostPort(m.local); err == nil && net.ParseIP(host).Equal(ip) {
+				n.removeMapping(m)
+			}
+		}
+		n.ports.release(ip.String())
+	}
+}
+
+func (m *mapping) expired(now time.Time) bool {
+	return !m.static && now.After(m.expires)
+}
+
+// permits returns true if the remote of the filter key may send through the
+// mapping
+func (m *mapping) permits(filterKey string) bool {
+	if m.static {
+		return true
+	}
+	_, ok := m.filters[filterKey]
+	return ok
+}
+
+func (m *mapping) snapshot() NATMapping {
+	s := NATMapping{
+		Protocol: m.proto,
+		Local:    m.local,
+		Mapped:   m.mapped,
+		Remote:   m.bound,
+		Static:   m.static,
+	}
+	if !m.static {
+		s.Expires = m.expires
+		for filterKey := range m.filters {
+			s.Filters = append(s.Filters, filterKey)
+		}
+		sort.Strings(s.Filters)
+	}
+	return s
+}
+
+// addrPort returns the port number of a UDP or TCP address
+func addrPort(addr net.Addr) int {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.Port
+	case *net.TCPAddr:
+		return a.Port
+	default:
+		return 0
+	}
+}
+
+// addrIP returns the IP address of a UDP or TCP address
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	default:
+		return nil
+	}
+}
+
+// endpointKey returns the part of the remote address a mapping or
+// filtering behavior depends on.
+func endpointKey(behavior EndpointDependencyType, remote net.Addr) string {
+	switch behavior {
+	case EndpointAddrDependent:
+		return addrIP(remote).String()
+	case EndpointAddrPortDependent:
+		return remote.String()
+	default:
+		return ""
+	}
 }
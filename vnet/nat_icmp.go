@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"fmt"
+	"net"
+)
+
+// ICMP errors are translated with the mapping of the chunk they carry, as
+// described in RFC 5508 Section 4. ICMP errors never create or refresh a
+// mapping.
+
+// translateOutboundICMP translates an ICMP error sent by a local host about
+// a chunk it received from outside.
+// caller must hold the mutex
+func (n *networkAddressTranslator) translateOutboundICMP(from *chunkICMP) (Chunk, error) {
+	to := from.Clone().(*chunkICMP) //nolint:forcetypeassert
+	original := to.original         // "<remote>" => "<local>"
+
+	if original.Network() != udp && original.Network() != tcp {
+		return nil, errTranslationNotSupported
+	}
+
+	var mappedAddr string
+	if n.natType.Mode == NATModeNAT1To1 {
+		mappedIP := n.getPairedMappedIP(original.getDestinationIP())
+		if mappedIP == nil {
+			n.log.Debugf("[%s] drop outbound chunk %s with not route", n.name, from.String())
+			return nil, nil // nolint:nilnil
+		}
+		mappedAddr = fmt.Sprintf("%s:%d", mappedIP, addrPort(original.DestinationAddr()))
+	} else {
+		bound := endpointKey(n.natType.MappingBehavior, original.SourceAddr())
+		oKey := fmt.Sprintf("%s:%s:%s", original.Network(), original.DestinationAddr().String(), bound)
+		m, ok := n.outboundMap[oKey]
+		if !ok {
+			m = n.findPortForward(original.Network(), original.DestinationAddr().String())
+			ok = m != nil
+		}
+		if !ok || m.expired(n.clock.Now()) {
+			n.log.Debugf("[%s] drop outbound chunk %s with no NAT binding", n.name, from.String())
+			return nil, nil // nolint:nilnil
+		}
+		mappedAddr = m.mapped
+	}
+
+	mappedIP, _, err := net.SplitHostPort(mappedAddr)
+	if err != nil {
+		return nil, err
+	}
+	if err = original.setDestinationAddr(mappedAddr); err != nil {
+		return nil, err
+	}
+	if err = to.setSourceAddr(mappedIP); err != nil {
+		return nil, err
+	}
+
+	n.log.Debugf("[%s] translate outbound chunk from %s to %s", n.name, from.String(), to.String())
+
+	return to, nil
+}
+
+// translateInboundICMP translates an ICMP error about a chunk a local host
+// sent outside.
+// caller must hold the mutex
+func (n *networkAddressTranslator) translateInboundICMP(from *chunkICMP) (Chunk, error) {
+	to := from.Clone().(*chunkICMP) //nolint:forcetypeassert
+	original := to.original         // "<mapped>" => "<remote>"
+
+	if original.Network() != udp && original.Network() != tcp {
+		return nil, errTranslationNotSupported
+	}
+
+	var localAddr string
+	if n.natType.Mode == NATModeNAT1To1 {
+		localIP := n.getPairedLocalIP(original.getSourceIP())
+		if localIP == nil {
+			return nil, fmt.Errorf("drop %s as %w", from.String(), errNoAssociatedLocalAddress)
+		}
+		localAddr = fmt.Sprintf("%s:%d", localIP, addrPort(original.SourceAddr()))
+	} else {
+		iKey := fmt.Sprintf("%s:%s", original.Network(), original.SourceAddr().String())
+		m := n.findInboundMapping(iKey)
+		if m == nil {
+			return nil, fmt.Errorf("drop %s as %w", from.String(), errNoNATBindingFound)
+		}
+
+		filterKey := endpointKey(n.natType.FilteringBehavior, original.DestinationAddr())
+		if !m.permits(filterKey) {
+			return nil, fmt.Errorf("drop %s as the remote %s %w", from.String(), filterKey, errHasNoPermission)
+		}
+		localAddr = m.local
+	}
+
+	localIP, _, err := net.SplitHostPort(localAddr)
+	if err != nil {
+		return nil, err
+	}
+	if err = original.setSourceAddr(localAddr); err != nil {
+		return nil, err
+	}
+	if err = to.setDestinationAddr(localIP); err != nil {
+		return nil, err
+	}
+
+	n.log.Debugf("[%s] translate inbound chunk from %s to %s", n.name, from.String(), to.String())
+
+	return to, nil
+}
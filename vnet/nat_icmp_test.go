@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNATICMP(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	local := &net.UDPAddr{IP: net.ParseIP("192.168.0.2"), Port: 1234}
+	remote := &net.UDPAddr{IP: net.ParseIP("5.6.7.8"), Port: 5678}
+
+	t.Run("inbound error about an outbound chunk", func(t *testing.T) {
+		nat, err := newNAT(&natConfig{
+			natType: NATType{
+				MappingBehavior:   EndpointIndependent,
+				FilteringBehavior: EndpointAddrPortDependent,
+			},
+			mappedIPs:     []net.IP{net.ParseIP(demoIP)},
+			loggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		oec, err := nat.translateOutbound(newChunkUDP(local, remote))
+		assert.NoError(t, err, "should succeed")
+
+		iec := newChunkICMPUnreachable(remote.IP, oec, icmpPortUnreachable)
+		iic, err := nat.translateInbound(iec)
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, local.IP.String(), iic.DestinationAddr().String(), "should match")
+
+		icmp := iic.(*chunkICMP) //nolint:forcetypeassert
+		assert.Equal(t, local.String(), icmp.original.SourceAddr().String(), "should match")
+		assert.Equal(t, remote.String(), icmp.original.DestinationAddr().String(), "should match")
+
+		// an error about a chunk sent to another remote is filtered
+		other := newChunkUDP(oec.SourceAddr().(*net.UDPAddr), &net.UDPAddr{IP: remote.IP, Port: 9999}) //nolint:forcetypeassert
+		_, err = nat.translateInbound(newChunkICMPUnreachable(remote.IP, other, icmpPortUnreachable))
+		assert.ErrorIs(t, err, errHasNoPermission, "should fail")
+
+		// an error about an unknown mapping is dropped
+		unknown := newChunkUDP(&net.UDPAddr{IP: net.ParseIP(demoIP), Port: 1}, remote)
+		_, err = nat.translateInbound(newChunkICMPUnreachable(remote.IP, unknown, icmpPortUnreachable))
+		assert.ErrorIs(t, err, errNoNATBindingFound, "should fail")
+	})
+
+	t.Run("outbound error about an inbound chunk", func(t *testing.T) {
+		nat, err := newNAT(&natConfig{
+			natType:       NATType{},
+			mappedIPs:     []net.IP{net.ParseIP(demoIP)},
+			loggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		oec, err := nat.translateOutbound(newChunkUDP(local, remote))
+		assert.NoError(t, err, "should succeed")
+
+		iic, err := nat.translateInbound(newChunkUDP(remote, oec.SourceAddr().(*net.UDPAddr))) //nolint:forcetypeassert
+		assert.NoError(t, err, "should succeed")
+
+		oic := newChunkICMPUnreachable(local.IP, iic, icmpPortUnreachable)
+		icmp, err := nat.translateOutbound(oic)
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, demoIP, icmp.SourceAddr().String(), "should match")
+		assert.Equal(t, remote.IP.String(), icmp.DestinationAddr().String(), "should match")
+		assert.Equal(t, oec.SourceAddr().String(), icmp.(*chunkICMP).original.DestinationAddr().String(), "should match") //nolint:forcetypeassert
+
+		// no mapping is created for an error about an unknown chunk
+		stray := newChunkUDP(remote, &net.UDPAddr{IP: local.IP, Port: 4321})
+		icmp, err = nat.translateOutbound(newChunkICMPUnreachable(local.IP, stray, icmpPortUnreachable))
+		assert.NoError(t, err, "should succeed")
+		assert.Nil(t, icmp, "should be dropped")
+		assert.Equal(t, 1, len(nat.outboundMap), "should match")
+	})
+
+	t.Run("1:1 NAT", func(t *testing.T) {
+		nat, err := newNAT(&natConfig{
+			natType:       NATType{Mode: NATModeNAT1To1},
+			mappedIPs:     []net.IP{net.ParseIP(demoIP)},
+			localIPs:      []net.IP{local.IP},
+			loggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		oec, err := nat.translateOutbound(newChunkUDP(local, remote))
+		assert.NoError(t, err, "should succeed")
+
+		iic, err := nat.translateInbound(newChunkICMPUnreachable(remote.IP, oec, icmpHostUnreachable))
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, local.IP.String(), iic.DestinationAddr().String(), "should match")
+		assert.Equal(t, local.String(), iic.(*chunkICMP).original.SourceAddr().String(), "should match") //nolint:forcetypeassert
+	})
+}
@@ -0,0 +1,206 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+)
+
+var (
+	errNoNATForMappedIP   = errors.New("no NAT of the router has the mapped IP")
+	errInvalidPortForward = errors.New("invalid port forward")
+	errMappedPortInUse    = errors.New("mapped port is already in use")
+)
+
+// mappings returns the mappings that have not expired, in the order of
+// their mapped addresses. The expired ones are removed.
+func (n *networkAddressTranslator) mappings() []NATMapping {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	now := n.clock.Now()
+	mappings := make([]NATMapping, 0, len(n.inboundMap))
+	for _, m := range n.inboundMap {
+		if m.expired(now) {
+			n.removeMapping(m)
+			continue
+		}
+		mappings = append(mappings, m.snapshot())
+	}
+
+	sort.Slice(mappings, func(i, j int) bool {
+		if mappings[i].Mapped != mappings[j].Mapped {
+			return mappings[i].Mapped < mappings[j].Mapped
+		}
+		return mappings[i].Protocol < mappings[j].Protocol
+	})
+	return mappings
+}
+
+// expireMapping removes the mapping of the mapped address right away, like a
+// port forward or a mapping that timed out.
+func (n *networkAddressTranslator) expireMapping(proto, mapped string) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	m, ok := n.inboundMap[fmt.Sprintf("%s:%s", proto, mapped)]
+	if !ok {
+		return fmt.Errorf("%w: %s %s", errNoNATBindingFound, proto, mapped)
+	}
+	n.removeMapping(m)
+	return nil
+}
+
+// expireMappings removes all mappings right away, except the port forwards
+func (n *networkAddressTranslator) expireMappings() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for _, m := range n.inboundMap {
+		if !m.static {
+			n.removeMapping(m)
+		}
+	}
+}
+
+// addPortForward installs a static mapping from the mapped address to the
+// local one, open to any remote and never expiring. The local host replies
+// through it.
+func (n *networkAddressTranslator) addPortForward(proto, mapped, local string) error {
+	if n.natType.Mode == NATModeNAT1To1 {
+		return errTranslationNotSupported
+	}
+	if proto != udp && proto != tcp {
+		return fmt.Errorf("%w: protocol %s", errInvalidPortForward, proto)
+	}
+	localIP, localPort, err := parsePortForwardAddr(local)
+	if err != nil {
+		return err
+	}
+	mappedIP, mappedPort, err := parsePortForwardAddr(mapped)
+	if err != nil {
+		return err
+	}
+	local = fmt.Sprintf("%s:%d", localIP, localPort)
+	mapped = fmt.Sprintf("%s:%d", mappedIP, mappedPort)
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.isPortInUse(proto, mappedIP.String(), mappedPort) {
+		return fmt.Errorf("%w: %s %s", errMappedPortInUse, proto, mapped)
+	}
+
+	// the forward replaces the mapping of the local address for any remote
+	oKey := fmt.Sprintf("%s:%s:", proto, local)
+	if m, ok := n.outboundMap[oKey]; ok {
+		n.removeMapping(m)
+	}
+
+	m := &mapping{
+		proto:   proto,
+		local:   local,
+		mapped:  mapped,
+		filters: map[string]struct{}{},
+		static:  true,
+	}
+	if proto == tcp {
+		m.tcpConns = map[string]*natTCPConn{}
+	}
+	n.outboundMap[oKey] = m
+	n.inboundMap[fmt.Sprintf("%s:%s", proto, mapped)] = m
+
+	n.log.Debugf("[%s] forward %s %s to %s", n.name, proto, mapped, local)
+	n.publish(EventNATMappingCreated, m)
+	return nil
+}
+
+// findPortForward returns the port forward to the local address, if any
+// caller must hold the mutex
+func (n *networkAddressTranslator) findPortForward(proto, local string) *mapping {
+	m, ok := n.outboundMap[fmt.Sprintf("%s:%s:", proto, local)]
+	if !ok || !m.static {
+		return nil
+	}
+	return m
+}
+
+// parsePortForwardAddr parses an "<ipv4>:<port>" address
+func parsePortForwardAddr(addr string) (net.IP, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %s", errInvalidPortForward, err.Error())
+	}
+	ip := net.ParseIP(host).To4()
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 0xFFFF || ip == nil {
+		return nil, 0, fmt.Errorf("%w: address %s", errInvalidPortForward, addr)
+	}
+	return ip, port, nil
+}
+
+// NATMappings returns the mappings of the NATs of the router that have not
+// expired, for each uplink in turn.
+func (r *Router) NATMappings() []NATMapping {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var mappings []NATMapping
+	for _, up := range r.uplinks {
+		mappings = append(mappings, up.nat.mappings()...)
+	}
+	return mappings
+}
+
+// ExpireNATMapping removes the NAT mapping of the protocol, "udp" or "tcp",
+// and the mapped address "<mapped-ip>:<mapped-port>" right away, as if it
+// timed out. Port forwards are removed this way too.
+func (r *Router) ExpireNATMapping(proto, mapped string) error {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, up := range r.uplinks {
+		if err := up.nat.expireMapping(proto, mapped); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s %s", errNoNATBindingFound, proto, mapped)
+}
+
+// ExpireNATMappings removes all NAT mappings of the router right away, as if
+// they timed out. Port forwards are kept.
+func (r *Router) ExpireNATMappings() {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, up := range r.uplinks {
+		up.nat.expireMappings()
+	}
+}
+
+// AddPortForward forwards the chunks of the protocol, "udp" or "tcp", to the
+// mapped address "<mapped-ip>:<mapped-port>" on to the local address
+// "<local-ip>:<local-port>", from any remote. The forward never expires,
+// and the local host replies through it. The router must have an uplink
+// with the mapped IP.
+func (r *Router) AddPortForward(proto, mapped, local string) error {
+	host, _, err := net.SplitHostPort(mapped)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errInvalidPortForward, err.Error())
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, up := range r.uplinks {
+		if up.nat.isMappedIP(net.ParseIP(host)) {
+			return up.nat.addPortForward(proto, mapped, local)
+		}
+	}
+	return fmt.Errorf("%w: %s", errNoNATForMappedIP, host)
+}
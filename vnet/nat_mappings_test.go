@@ -0,0 +1,203 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNATMappings(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	newTestNAT := func(t *testing.T, clock Clock) *networkAddressTranslator {
+		t.Helper()
+
+		nat, err := newNAT(&natConfig{
+			natType: NATType{
+				MappingBehavior:   EndpointAddrPortDependent,
+				FilteringBehavior: EndpointAddrPortDependent,
+				MappingLifeTime:   time.Minute,
+			},
+			mappedIPs:     []net.IP{net.ParseIP(demoIP)},
+			clock:         clock,
+			loggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+		return nat
+	}
+
+	local := &net.UDPAddr{IP: net.ParseIP("192.168.0.2"), Port: 1234}
+	remotes := []*net.UDPAddr{
+		{IP: net.ParseIP("5.6.7.8"), Port: 5678},
+		{IP: net.ParseIP("5.6.7.9"), Port: 5678},
+	}
+
+	t.Run("List", func(t *testing.T) {
+		clock := NewManualClock(time.Unix(0, 0))
+		nat := newTestNAT(t, clock)
+
+		var mapped []string
+		for _, remote := range remotes {
+			oec, err := nat.translateOutbound(newChunkUDP(local, remote))
+			assert.NoError(t, err, "should succeed")
+			mapped = append(mapped, oec.SourceAddr().String())
+		}
+
+		assert.Equal(t, []NATMapping{
+			{
+				Protocol: udp,
+				Local:    local.String(),
+				Mapped:   mapped[0],
+				Remote:   remotes[0].String(),
+				Filters:  []string{remotes[0].String()},
+				Expires:  time.Unix(60, 0),
+			},
+			{
+				Protocol: udp,
+				Local:    local.String(),
+				Mapped:   mapped[1],
+				Remote:   remotes[1].String(),
+				Filters:  []string{remotes[1].String()},
+				Expires:  time.Unix(60, 0),
+			},
+		}, nat.mappings())
+
+		clock.Advance(time.Minute + time.Nanosecond)
+		assert.Empty(t, nat.mappings(), "should leave out expired mappings")
+		assert.Empty(t, nat.outboundMap, "should remove expired mappings")
+	})
+
+	t.Run("Expire", func(t *testing.T) {
+		nat := newTestNAT(t, nil)
+
+		var inbound []Chunk
+		for _, remote := range remotes {
+			oec, err := nat.translateOutbound(newChunkUDP(local, remote))
+			assert.NoError(t, err, "should succeed")
+			inbound = append(inbound, newChunkUDP(remote, oec.SourceAddr().(*net.UDPAddr))) //nolint:forcetypeassert
+		}
+
+		mapped := inbound[0].DestinationAddr().String()
+		assert.NoError(t, nat.expireMapping(udp, mapped), "should succeed")
+		assert.ErrorIs(t, nat.expireMapping(udp, mapped), errNoNATBindingFound, "should be gone")
+		_, err := nat.translateInbound(inbound[0])
+		assert.ErrorIs(t, err, errNoNATBindingFound, "should expire")
+		_, err = nat.translateInbound(inbound[1])
+		assert.NoError(t, err, "should not expire the other mapping")
+
+		nat.expireMappings()
+		_, err = nat.translateInbound(inbound[1])
+		assert.ErrorIs(t, err, errNoNATBindingFound, "should expire")
+	})
+
+	t.Run("PortForward", func(t *testing.T) {
+		nat := newTestNAT(t, nil)
+		mapped := &net.UDPAddr{IP: net.ParseIP(demoIP), Port: 8080}
+		assert.NoError(t, nat.addPortForward(udp, mapped.String(), local.String()), "should succeed")
+
+		for _, remote := range remotes {
+			iec, err := nat.translateInbound(newChunkUDP(remote, mapped))
+			assert.NoError(t, err, "should permit any remote")
+			assert.Equal(t, local.String(), iec.DestinationAddr().String())
+
+			oec, err := nat.translateOutbound(newChunkUDP(local, remote))
+			assert.NoError(t, err, "should succeed")
+			assert.Equal(t, mapped.String(), oec.SourceAddr().String(), "should reply through the forward")
+		}
+
+		nat.expireMappings()
+		assert.Equal(t, []NATMapping{{
+			Protocol: udp,
+			Local:    local.String(),
+			Mapped:   mapped.String(),
+			Static:   true,
+		}}, nat.mappings(), "should keep the forward")
+
+		assert.ErrorIs(t, nat.addPortForward(udp, mapped.String(), "192.168.0.3:80"), errMappedPortInUse, "should fail")
+		assert.ErrorIs(t, nat.addPortForward("icmp", "1.2.3.4:8081", local.String()), errInvalidPortForward, "should fail")
+		assert.ErrorIs(t, nat.addPortForward(udp, "1.2.3.4:0", local.String()), errInvalidPortForward, "should fail")
+		assert.ErrorIs(t, nat.addPortForward(udp, "1.2.3.4:8081", "192.168.0.2"), errInvalidPortForward, "should fail")
+
+		assert.NoError(t, nat.expireMapping(udp, mapped.String()), "should succeed")
+		_, err := nat.translateInbound(newChunkUDP(remotes[0], mapped))
+		assert.ErrorIs(t, err, errNoNATBindingFound, "should remove the forward")
+	})
+
+	t.Run("Router", func(t *testing.T) {
+		wan, err := NewRouter(&RouterConfig{
+			CIDR:          "1.2.3.0/24",
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		lan, err := NewRouter(&RouterConfig{
+			CIDR:      "192.168.0.0/24",
+			StaticIPs: []string{"1.2.3.10"},
+			NATType: &NATType{
+				MappingBehavior:   EndpointAddrPortDependent,
+				FilteringBehavior: EndpointAddrPortDependent,
+			},
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		assert.ErrorIs(t, lan.AddPortForward(udp, "1.2.3.10:8080", "192.168.0.2:80"), errNoNATForMappedIP, "should fail with no uplink")
+		assert.NoError(t, wan.AddRouter(lan), "should succeed")
+
+		remoteNet, err := NewNet(&NetConfig{StaticIPs: []string{"1.2.3.4"}})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, wan.AddNet(remoteNet), "should succeed")
+		serverNet, err := NewNet(&NetConfig{StaticIPs: []string{"192.168.0.2"}})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, lan.AddNet(serverNet), "should succeed")
+
+		assert.NoError(t, wan.Start(), "should succeed")
+		defer func() {
+			assert.NoError(t, wan.Stop(), "should succeed")
+		}()
+
+		assert.NoError(t, lan.AddPortForward(udp, "1.2.3.10:8080", "192.168.0.2:80"), "should succeed")
+		assert.ErrorIs(t, lan.AddPortForward(udp, "1.2.3.11:8080", "192.168.0.2:80"), errNoNATForMappedIP, "should fail")
+
+		server, err := serverNet.ListenUDP(udp4, &net.UDPAddr{Port: 80})
+		assert.NoError(t, err, "should succeed")
+		remote, err := remoteNet.ListenUDP(udp4, nil)
+		assert.NoError(t, err, "should succeed")
+
+		forwarded := &net.UDPAddr{IP: net.ParseIP("1.2.3.10"), Port: 8080}
+		_, err = remote.WriteTo([]byte("hello"), forwarded)
+		assert.NoError(t, err, "should succeed")
+
+		buf := make([]byte, 1500)
+		n, from, err := server.ReadFrom(buf)
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, "hello", string(buf[:n]))
+
+		_, err = server.WriteTo([]byte("world"), from)
+		assert.NoError(t, err, "should succeed")
+		n, from, err = remote.ReadFrom(buf)
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, "world", string(buf[:n]))
+		assert.Equal(t, forwarded.String(), from.String(), "should reply from the forwarded address")
+
+		mappings := lan.NATMappings()
+		if assert.Len(t, mappings, 1) {
+			assert.True(t, mappings[0].Static, "should be the forward")
+		}
+
+		lan.ExpireNATMappings()
+		assert.Len(t, lan.NATMappings(), 1, "should keep the forward")
+		assert.NoError(t, lan.ExpireNATMapping(udp, forwarded.String()), "should succeed")
+		assert.Empty(t, lan.NATMappings())
+		assert.ErrorIs(t, lan.ExpireNATMapping(udp, forwarded.String()), errNoNATBindingFound, "should fail")
+
+		assert.NoError(t, remote.Close(), "should succeed")
+		assert.NoError(t, server.Close(), "should succeed")
+	})
+}
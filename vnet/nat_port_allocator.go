@@ -0,0 +1,141 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"math/rand"
+)
+
+// PortAllocationBehavior defines how a NAT picks the mapped port of a new
+// mapping when the port is not preserved.
+type PortAllocationBehavior uint8
+
+const (
+	// PortAllocationSequential hands out ports one after another from the port range
+	PortAllocationSequential PortAllocationBehavior = iota
+	// PortAllocationRandom hands out a random free port from the port range
+	PortAllocationRandom
+	// PortAllocationBlock assigns each internal host (subscriber) its own block
+	// of PortBlockSize consecutive ports and hands out ports from its blocks
+	// sequentially, as carrier-grade NATs do. A new block is assigned when all
+	// ports of the subscriber's blocks are in use.
+	PortAllocationBlock
+)
+
+const (
+	defaultNATPortBlockSize = 256
+)
+
+// portAllocator picks a free port in [start, end] for a subscriber, which is
+// the local IP address of the host that creates the mapping.
+type portAllocator interface {
+	allocate(subscriber string, start, end int, isFree func(port int) bool) (int, error)
+	// release frees what is held for a subscriber that left
+	release(subscriber string)
+}
+
+func newPortAllocator(natType NATType, rng *rand.Rand) portAllocator {
+	switch natType.PortAllocation {
+	case PortAllocationRandom:
+		return &randomPortAllocator{rand: rng}
+	case PortAllocationBlock:
+		return &blockPortAllocator{
+			blockSize: natType.PortBlockSize,
+			owners:    map[int]string{},
+			blocks:    map[string][]int{},
+		}
+	default:
+		return &sequentialPortAllocator{}
+	}
+}
+
+// scanPorts returns the first free port in [start, end], starting at
+// start+offset and wrapping around, along with its distance from there.
+func scanPorts(start, end, offset int, isFree func(int) bool) (int, int, bool) {
+	size := end - start + 1
+	for i := 0; i < size; i++ {
+		port := start + (offset+i)%size
+		if isFree(port) {
+			return port, i, true
+		}
+	}
+	return 0, 0, false
+}
+
+type sequentialPortAllocator struct {
+	counter int
+}
+
+func (a *sequentialPortAllocator) allocate(_ string, start, end int, isFree func(int) bool) (int, error) {
+	port, skipped, ok := scanPorts(start, end, a.counter, isFree)
+	if !ok {
+		return 0, errNATPortExhausted
+	}
+	a.counter += skipped + 1
+	return port, nil
+}
+
+func (a *sequentialPortAllocator) release(string) {}
+
+type randomPortAllocator struct {
+	rand *rand.Rand
+}
+
+func (a *randomPortAllocator) allocate(_ string, start, end int, isFree func(int) bool) (int, error) {
+	offset := a.rand.Intn(end - start + 1)
+	port, _, ok := scanPorts(start, end, offset, isFree)
+	if !ok {
+		return 0, errNATPortExhausted
+	}
+	return port, nil
+}
+
+func (a *randomPortAllocator) release(string) {}
+
+type blockPortAllocator struct {
+	blockSize int
+	owners    map[int]string   // key: first port of the block, value: subscriber
+	blocks    map[string][]int // key: subscriber, value: first ports of its blocks
+}
+
+func (a *blockPortAllocator) allocate(subscriber string, start, end int, isFree func(int) bool) (int, error) {
+	for _, first := range a.blocks[subscriber] {
+		if first < start || first > end {
+			continue
+		}
+		if port, ok := a.allocateInBlock(first, end, isFree); ok {
+			return port, nil
+		}
+	}
+
+	// All blocks of the subscriber are in use, assign a new one
+	for first := start; first <= end; first += a.blockSize {
+		if _, ok := a.owners[first]; ok {
+			continue
+		}
+		a.owners[first] = subscriber
+		a.blocks[subscriber] = append(a.blocks[subscriber], first)
+		if port, ok := a.allocateInBlock(first, end, isFree); ok {
+			return port, nil
+		}
+	}
+
+	return 0, errNATPortExhausted
+}
+
+func (a *blockPortAllocator) release(subscriber string) {
+	for _, first := range a.blocks[subscriber] {
+		delete(a.owners, first)
+	}
+	delete(a.blocks, subscriber)
+}
+
+func (a *blockPortAllocator) allocateInBlock(first, end int, isFree func(int) bool) (int, bool) {
+	last := first + a.blockSize - 1
+	if last > end {
+		last = end
+	}
+	port, _, ok := scanPorts(first, last, 0, isFree)
+	return port, ok
+}
@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPortAllocator(t *testing.T) {
+	used := map[int]bool{}
+	isFree := func(port int) bool { return !used[port] }
+
+	t.Run("sequential", func(t *testing.T) {
+		a := newPortAllocator(NATType{PortAllocation: PortAllocationSequential}, nil)
+		used = map[int]bool{101: true}
+
+		for _, expected := range []int{100, 102, 103, 100, 102} {
+			port, err := a.allocate("10.0.0.1", 100, 103, isFree)
+			assert.NoError(t, err, "should succeed")
+			assert.Equal(t, expected, port, "should match")
+		}
+
+		used = map[int]bool{100: true, 101: true, 102: true, 103: true}
+		_, err := a.allocate("10.0.0.1", 100, 103, isFree)
+		assert.ErrorIs(t, err, errNATPortExhausted, "should fail")
+	})
+
+	t.Run("random", func(t *testing.T) {
+		a := newPortAllocator(NATType{PortAllocation: PortAllocationRandom}, rand.New(rand.NewSource(1)))
+		used = map[int]bool{}
+
+		for i := 0; i < 100; i++ {
+			port, err := a.allocate("10.0.0.1", 1000, 1099, isFree)
+			assert.NoError(t, err, "should succeed")
+			assert.False(t, used[port], "should be a free port")
+			used[port] = true
+		}
+
+		_, err := a.allocate("10.0.0.1", 1000, 1099, isFree)
+		assert.ErrorIs(t, err, errNATPortExhausted, "should fail")
+
+		// the same seed gives the same ports
+		a1 := newPortAllocator(NATType{PortAllocation: PortAllocationRandom}, rand.New(rand.NewSource(1)))
+		a2 := newPortAllocator(NATType{PortAllocation: PortAllocationRandom}, rand.New(rand.NewSource(1)))
+		used = map[int]bool{}
+		for i := 0; i < 10; i++ {
+			port1, err1 := a1.allocate("10.0.0.1", 1000, 1099, isFree)
+			port2, err2 := a2.allocate("10.0.0.1", 1000, 1099, isFree)
+			assert.NoError(t, err1, "should succeed")
+			assert.NoError(t, err2, "should succeed")
+			assert.Equal(t, port1, port2, "should match")
+			used[port1] = true
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		a := newPortAllocator(NATType{PortAllocation: PortAllocationBlock, PortBlockSize: 4}, nil)
+		used = map[int]bool{}
+
+		allocate := func(subscriber string) int {
+			port, err := a.allocate(subscriber, 1000, 1011, isFree)
+			assert.NoError(t, err, "should succeed")
+			used[port] = true
+			return port
+		}
+
+		assert.Equal(t, 1000, allocate("10.0.0.1"), "should match")
+		assert.Equal(t, 1004, allocate("10.0.0.2"), "should match")
+		assert.Equal(t, 1001, allocate("10.0.0.1"), "should match")
+		assert.Equal(t, 1005, allocate("10.0.0.2"), "should match")
+
+		// a port released within the block is handed out again
+		delete(used, 1000)
+		assert.Equal(t, 1000, allocate("10.0.0.1"), "should match")
+
+		// the subscriber gets a new block once its block is full
+		assert.Equal(t, 1002, allocate("10.0.0.1"), "should match")
+		assert.Equal(t, 1003, allocate("10.0.0.1"), "should match")
+		assert.Equal(t, 1008, allocate("10.0.0.1"), "should match")
+
+		// no blocks left for a new subscriber
+		_, err := a.allocate("10.0.0.3", 1000, 1011, isFree)
+		assert.ErrorIs(t, err, errNATPortExhausted, "should fail")
+	})
+}
+
+func TestNATPortAllocation(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+	dst := &net.UDPAddr{IP: net.ParseIP("5.6.7.8"), Port: 5678}
+
+	t.Run("port range", func(t *testing.T) {
+		nat, err := newNAT(&natConfig{
+			natType: NATType{
+				PortAllocation: PortAllocationRandom,
+				PortRangeStart: 20000,
+				PortRangeEnd:   20009,
+			},
+			mappedIPs:     []net.IP{net.ParseIP(demoIP)},
+			loggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		for i := 0; i < 11; i++ {
+			src := &net.UDPAddr{IP: net.ParseIP("192.168.0.2"), Port: 1000 + i}
+			oec, err := nat.translateOutbound(newChunkUDP(src, dst))
+			assert.NoError(t, err, "should succeed")
+			if i == 10 {
+				assert.Nil(t, oec, "should be dropped as the range is exhausted")
+				break
+			}
+			port := oec.SourceAddr().(*net.UDPAddr).Port //nolint:forcetypeassert
+			assert.True(t, port >= 20000 && port <= 20009, "should be in range")
+		}
+		assert.Equal(t, 10, len(nat.inboundMap), "should match")
+	})
+
+	t.Run("block per subscriber", func(t *testing.T) {
+		nat, err := newNAT(&natConfig{
+			natType: NATType{
+				PortAllocation: PortAllocationBlock,
+				PortBlockSize:  64,
+			},
+			mappedIPs:     []net.IP{net.ParseIP(demoIP)},
+			loggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		mappedPort := func(srcIP string, srcPort int) int {
+			src := &net.UDPAddr{IP: net.ParseIP(srcIP), Port: srcPort}
+			oec, err := nat.translateOutbound(newChunkUDP(src, dst))
+			assert.NoError(t, err, "should succeed")
+			return oec.SourceAddr().(*net.UDPAddr).Port //nolint:forcetypeassert
+		}
+
+		assert.Equal(t, natPortRangeStart, mappedPort("192.168.0.2", 1000), "should match")
+		assert.Equal(t, natPortRangeStart+64, mappedPort("192.168.0.3", 1000), "should match")
+		assert.Equal(t, natPortRangeStart+1, mappedPort("192.168.0.2", 1001), "should match")
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := newNAT(&natConfig{
+			natType:       NATType{PortRangeStart: 2000, PortRangeEnd: 1000},
+			mappedIPs:     []net.IP{net.ParseIP(demoIP)},
+			loggerFactory: loggerFactory,
+		})
+		assert.ErrorIs(t, err, errInvalidPortRange, "should fail")
+
+		_, err = newNAT(&natConfig{
+			natType:       NATType{PortRangeStart: 1000, PortRangeEnd: 1009, PortBlockSize: 11},
+			mappedIPs:     []net.IP{net.ParseIP(demoIP)},
+			loggerFactory: loggerFactory,
+		})
+		assert.ErrorIs(t, err, errInvalidPortBlockSize, "should fail")
+	})
+}
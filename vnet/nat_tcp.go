@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"time"
+)
+
+// See RFC 5382 Section 5. Idle-Timeouts
+const (
+	defaultNATTCPEstablishedLifeTime = 2*time.Hour + 4*time.Minute
+	defaultNATTCPTransitoryLifeTime  = 4 * time.Minute
+)
+
+// natTCPConn tracks the state of a TCP connection that uses a mapping, as
+// observed from the control bits of the segments crossing the NAT.
+type natTCPConn struct {
+	synOut  bool // SYN seen from the internal endpoint
+	synIn   bool // SYN seen from the external endpoint
+	finOut  bool // FIN seen from the internal endpoint
+	finIn   bool // FIN seen from the external endpoint
+	reset   bool // RST seen in either direction
+	expires time.Time
+}
+
+// established returns true once both SYNs have been seen and the connection
+// is neither closing nor reset. Any other state is transitory.
+func (c *natTCPConn) established() bool {
+	return c.synOut && c.synIn && !(c.finOut && c.finIn) && !c.reset
+}
+
+func (c *natTCPConn) update(flags tcpFlag, outbound bool) {
+	if flags&tcpRST != 0 {
+		c.reset = true
+		return
+	}
+
+	if flags&tcpSYN != 0 {
+		if outbound {
+			c.synOut = true
+		} else {
+			c.synIn = true
+		}
+	}
+
+	if flags&tcpFIN != 0 {
+		if outbound {
+			c.finOut = true
+		} else {
+			c.finIn = true
+		}
+	}
+}
+
+// trackTCP updates the connection a TCP segment belongs to and refreshes
+// the mapping, which lives as long as its longest-lived connection.
+// caller must hold the mutex
+func (n *networkAddressTranslator) trackTCP(m *mapping, c *chunkTCP, outbound bool) {
+	var remote string
+	if outbound {
+		remote = c.DestinationAddr().String()
+	} else {
+		remote = c.SourceAddr().String()
+	}
+
+	conn, ok := m.tcpConns[remote]
+	if !ok {
+		conn = &natTCPConn{}
+		m.tcpConns[remote] = conn
+	}
+
+	conn.update(c.flags, outbound)
+
+	now := n.clock.Now()
+	if conn.established() {
+		conn.expires = now.Add(n.natType.TCPEstablishedLifeTime)
+	} else {
+		conn.expires = now.Add(n.natType.TCPTransitoryLifeTime)
+	}
+
+	m.expires = now
+	for key, conn := range m.tcpConns {
+		if now.After(conn.expires) {
+			delete(m.tcpConns, key)
+			continue
+		}
+		if conn.expires.After(m.expires) {
+			m.expires = conn.expires
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNATTCP(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	src := &net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 1234}
+	dst := &net.TCPAddr{IP: net.ParseIP("5.6.7.8"), Port: 443}
+
+	newTCPNAT := func(filtering EndpointDependencyType) *networkAddressTranslator {
+		nat, err := newNAT(&natConfig{
+			natType: NATType{
+				FilteringBehavior:      filtering,
+				TCPEstablishedLifeTime: time.Hour,
+				TCPTransitoryLifeTime:  200 * time.Millisecond,
+			},
+			mappedIPs:     []net.IP{net.ParseIP(demoIP)},
+			loggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+		return nat
+	}
+
+	// outbound sends a segment from the internal endpoint to the remote and
+	// returns the mapped address
+	outbound := func(t *testing.T, nat *networkAddressTranslator, rem *net.TCPAddr, flags tcpFlag) *net.TCPAddr {
+		t.Helper()
+		oec, err := nat.translateOutbound(newChunkTCP(src, rem, flags))
+		assert.NoError(t, err, "should succeed")
+		return oec.SourceAddr().(*net.TCPAddr) //nolint:forcetypeassert
+	}
+
+	inbound := func(nat *networkAddressTranslator, rem, mapped *net.TCPAddr, flags tcpFlag) error {
+		_, err := nat.translateInbound(newChunkTCP(rem, mapped, flags))
+		return err
+	}
+
+	lifetime := func(nat *networkAddressTranslator) time.Duration {
+		assert.Equal(t, 1, len(nat.outboundMap), "should match")
+		for _, m := range nat.outboundMap {
+			return time.Until(m.expires)
+		}
+		return 0
+	}
+
+	t.Run("handshake and teardown", func(t *testing.T) {
+		nat := newTCPNAT(EndpointIndependent)
+
+		mapped := outbound(t, nat, dst, tcpSYN)
+		assert.Equal(t, demoIP, mapped.IP.String(), "should match")
+		assert.True(t, lifetime(nat) <= 200*time.Millisecond, "should be transitory")
+
+		assert.NoError(t, inbound(nat, dst, mapped, tcpSYN|tcpACK), "should succeed")
+		assert.True(t, lifetime(nat) > time.Minute, "should be established")
+
+		outbound(t, nat, dst, tcpFIN|tcpACK)
+		assert.True(t, lifetime(nat) > time.Minute, "half-closed should stay established")
+
+		assert.NoError(t, inbound(nat, dst, mapped, tcpFIN|tcpACK), "should succeed")
+		assert.True(t, lifetime(nat) <= 200*time.Millisecond, "should be transitory")
+
+		time.Sleep(300 * time.Millisecond)
+		assert.ErrorIs(t, inbound(nat, dst, mapped, tcpACK), errNoNATBindingFound, "should be expired")
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		nat := newTCPNAT(EndpointIndependent)
+
+		mapped := outbound(t, nat, dst, tcpSYN)
+		assert.NoError(t, inbound(nat, dst, mapped, tcpSYN|tcpACK), "should succeed")
+		assert.True(t, lifetime(nat) > time.Minute, "should be established")
+
+		assert.NoError(t, inbound(nat, dst, mapped, tcpRST), "should succeed")
+		assert.True(t, lifetime(nat) <= 200*time.Millisecond, "should be transitory")
+	})
+
+	t.Run("mapping outlives a reset connection", func(t *testing.T) {
+		nat := newTCPNAT(EndpointIndependent)
+		other := &net.TCPAddr{IP: net.ParseIP("5.6.7.9"), Port: 443}
+
+		mapped := outbound(t, nat, dst, tcpSYN)
+		assert.NoError(t, inbound(nat, dst, mapped, tcpSYN|tcpACK), "should succeed")
+
+		// a second connection shares the endpoint-independent mapping
+		assert.Equal(t, mapped.String(), outbound(t, nat, other, tcpSYN).String(), "should match")
+		assert.NoError(t, inbound(nat, other, mapped, tcpRST), "should succeed")
+
+		assert.True(t, lifetime(nat) > time.Minute, "should be kept by the established connection")
+	})
+
+	t.Run("filtering", func(t *testing.T) {
+		nat := newTCPNAT(EndpointAddrPortDependent)
+
+		mapped := outbound(t, nat, dst, tcpSYN)
+		assert.NoError(t, inbound(nat, dst, mapped, tcpSYN|tcpACK), "should succeed")
+
+		stranger := &net.TCPAddr{IP: dst.IP, Port: 8443}
+		assert.ErrorIs(t, inbound(nat, stranger, mapped, tcpSYN), errHasNoPermission, "should be filtered")
+	})
+}
@@ -638,6 +638,181 @@ func TestNATMappingTimeout(t *testing.T) {
 		assert.Equal(t, 0, len(nat.outboundMap), "should have no binding")
 		assert.Equal(t, 0, len(nat.inboundMap), "should have no binding")
 	})
+
+	t.Run("manual clock", func(t *testing.T) {
+		clock := NewManualClock(time.Now())
+		nat, err := newNAT(&natConfig{
+			natType: NATType{
+				MappingBehavior:   EndpointIndependent,
+				FilteringBehavior: EndpointIndependent,
+				MappingLifeTime:   time.Minute,
+			},
+			mappedIPs:     []net.IP{net.ParseIP(demoIP)},
+			clock:         clock,
+			loggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		src := &net.UDPAddr{IP: net.ParseIP("192.168.0.2"), Port: 1234}
+		dst := &net.UDPAddr{IP: net.ParseIP("5.6.7.8"), Port: 5678}
+
+		oec, err := nat.translateOutbound(newChunkUDP(src, dst))
+		assert.NoError(t, err, "should succeed")
+		iec := newChunkUDP(dst, oec.SourceAddr().(*net.UDPAddr)) //nolint:forcetypeassert
+
+		clock.Advance(time.Minute)
+		_, err = nat.translateInbound(iec)
+		assert.NoError(t, err, "should not expire yet")
+
+		clock.Advance(time.Minute + time.Nanosecond)
+		_, err = nat.translateInbound(iec)
+		assert.ErrorIs(t, err, errNoNATBindingFound, "should expire")
+	})
+}
+
+func TestNATMappingRefresh(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	src := &net.UDPAddr{IP: net.ParseIP("192.168.0.2"), Port: 1234}
+	dst := &net.UDPAddr{IP: net.ParseIP("5.6.7.8"), Port: 5678}
+
+	// setup returns a NAT with a mapping created at time 0, and the chunk
+	// coming back through it
+	setup := func(t *testing.T, natType NATType) (*networkAddressTranslator, *ManualClock, Chunk) {
+		t.Helper()
+
+		natType.MappingBehavior = EndpointIndependent
+		natType.FilteringBehavior = EndpointIndependent
+		if natType.MappingLifeTime == 0 {
+			natType.MappingLifeTime = time.Minute
+		}
+
+		clock := NewManualClock(time.Unix(0, 0))
+		nat, err := newNAT(&natConfig{
+			natType:       natType,
+			mappedIPs:     []net.IP{net.ParseIP(demoIP)},
+			clock:         clock,
+			loggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		oec, err := nat.translateOutbound(newChunkUDP(src, dst))
+		assert.NoError(t, err, "should succeed")
+		return nat, clock, newChunkUDP(dst, oec.SourceAddr().(*net.UDPAddr)) //nolint:forcetypeassert
+	}
+
+	expires := func(t *testing.T, nat *networkAddressTranslator) time.Time {
+		t.Helper()
+
+		mappings := nat.mappings()
+		if !assert.Len(t, mappings, 1, "should have the mapping") {
+			return time.Time{}
+		}
+		return mappings[0].Expires
+	}
+
+	t.Run("outbound only", func(t *testing.T) {
+		nat, clock, iec := setup(t, NATType{})
+
+		clock.Advance(30 * time.Second)
+		_, err := nat.translateInbound(iec)
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, time.Unix(60, 0), expires(t, nat), "should not refresh on inbound")
+
+		_, err = nat.translateOutbound(newChunkUDP(src, dst))
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, time.Unix(90, 0), expires(t, nat), "should refresh on outbound")
+	})
+
+	t.Run("bidirectional", func(t *testing.T) {
+		nat, clock, iec := setup(t, NATType{MappingRefresh: MappingRefreshBidirectional})
+
+		for i := 0; i < 3; i++ {
+			clock.Advance(45 * time.Second)
+			_, err := nat.translateInbound(iec)
+			assert.NoError(t, err, "should be kept alive by inbound chunks")
+		}
+		assert.Equal(t, time.Unix(195, 0), expires(t, nat), "should refresh on inbound")
+	})
+
+	t.Run("none", func(t *testing.T) {
+		nat, clock, iec := setup(t, NATType{MappingRefresh: MappingRefreshNone})
+
+		clock.Advance(45 * time.Second)
+		_, err := nat.translateOutbound(newChunkUDP(src, dst))
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, time.Unix(60, 0), expires(t, nat), "should not refresh")
+
+		clock.Advance(15*time.Second + time.Nanosecond)
+		_, err = nat.translateInbound(iec)
+		assert.ErrorIs(t, err, errNoNATBindingFound, "should expire")
+	})
+
+	t.Run("unreplied", func(t *testing.T) {
+		nat, clock, iec := setup(t, NATType{
+			MappingLifeTime:          2 * time.Minute,
+			MappingUnrepliedLifeTime: 30 * time.Second,
+		})
+		assert.Equal(t, time.Unix(30, 0), expires(t, nat), "should expire soon after the first chunk")
+
+		clock.Advance(10 * time.Second)
+		_, err := nat.translateOutbound(newChunkUDP(src, dst))
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, time.Unix(40, 0), expires(t, nat), "should expire soon until a reply")
+
+		_, err = nat.translateInbound(iec)
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, time.Unix(130, 0), expires(t, nat), "should live longer once replied")
+		_, err = nat.translateOutbound(newChunkUDP(src, dst))
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, time.Unix(130, 0), expires(t, nat), "should live longer once replied")
+	})
+
+	t.Run("unreplied without refresh", func(t *testing.T) {
+		nat, clock, iec := setup(t, NATType{
+			MappingRefresh:           MappingRefreshNone,
+			MappingLifeTime:          2 * time.Minute,
+			MappingUnrepliedLifeTime: 30 * time.Second,
+		})
+
+		clock.Advance(10 * time.Second)
+		_, err := nat.translateInbound(iec)
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, time.Unix(120, 0), expires(t, nat), "should live longer once replied")
+
+		clock.Advance(time.Minute)
+		_, err = nat.translateInbound(iec)
+		assert.NoError(t, err, "should not expire after the unreplied lifetime")
+		assert.Equal(t, time.Unix(120, 0), expires(t, nat), "should not refresh")
+	})
+
+	t.Run("well-known port", func(t *testing.T) {
+		nat, clock, iec := setup(t, NATType{
+			MappingLifeTime:          2 * time.Minute,
+			MappingUnrepliedLifeTime: 30 * time.Second,
+			WellKnownPortLifeTimes:   map[uint16]time.Duration{53: 10 * time.Second},
+		})
+
+		dns := &net.UDPAddr{IP: net.ParseIP("5.6.7.8"), Port: 53}
+		clock.Advance(time.Second)
+		_, err := nat.translateOutbound(newChunkUDP(src, dns))
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, time.Unix(11, 0), expires(t, nat), "should have the lifetime of the port")
+
+		// another remote replies through the endpoint-independent mapping
+		_, err = nat.translateInbound(iec)
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, time.Unix(11, 0), expires(t, nat), "should keep the lifetime of the destination port")
+
+		_, err = newNAT(&natConfig{
+			natType: NATType{
+				WellKnownPortLifeTimes: map[uint16]time.Duration{5353: 10 * time.Second},
+			},
+			mappedIPs:     []net.IP{net.ParseIP(demoIP)},
+			loggerFactory: loggerFactory,
+		})
+		assert.ErrorIs(t, err, errInvalidWellKnownPort, "should fail")
+	})
 }
 
 func TestNAT1To1Behavior(t *testing.T) {
@@ -865,3 +1040,85 @@ func TestNAT1To1Behavior(t *testing.T) {
 		assert.Error(t, err, "should fail")
 	})
 }
+
+func TestNATPortPreservation(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+	dst := &net.UDPAddr{IP: net.ParseIP("5.6.7.8"), Port: 5678}
+
+	newPreservingNAT := func(fallback PortPreservationFallback) *networkAddressTranslator {
+		nat, err := newNAT(&natConfig{
+			natType: NATType{
+				PortPreservation:         true,
+				PortPreservationFallback: fallback,
+			},
+			mappedIPs:     []net.IP{net.ParseIP(demoIP)},
+			loggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+		return nat
+	}
+
+	mappedPort := func(t *testing.T, nat *networkAddressTranslator, srcIP string, srcPort int) int {
+		t.Helper()
+		oec, err := nat.translateOutbound(newChunkUDP(&net.UDPAddr{IP: net.ParseIP(srcIP), Port: srcPort}, dst))
+		assert.NoError(t, err, "should succeed")
+		if oec == nil {
+			return 0
+		}
+		return oec.SourceAddr().(*net.UDPAddr).Port //nolint:forcetypeassert
+	}
+
+	t.Run("keeps the local port", func(t *testing.T) {
+		nat := newPreservingNAT(PortFallbackAny)
+		assert.Equal(t, 1234, mappedPort(t, nat, "192.168.0.2", 1234), "should match")
+
+		iic, err := nat.translateInbound(newChunkUDP(dst, &net.UDPAddr{IP: net.ParseIP(demoIP), Port: 1234}))
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, "192.168.0.2:1234", iic.DestinationAddr().String(), "should match")
+	})
+
+	t.Run("falls back to any port", func(t *testing.T) {
+		nat := newPreservingNAT(PortFallbackAny)
+		assert.Equal(t, 1234, mappedPort(t, nat, "192.168.0.2", 1234), "should match")
+		assert.Equal(t, natPortRangeStart, mappedPort(t, nat, "192.168.0.3", 1234), "should match")
+	})
+
+	t.Run("falls back to a port with the same parity", func(t *testing.T) {
+		nat := newPreservingNAT(PortFallbackParity)
+		assert.Equal(t, 1235, mappedPort(t, nat, "192.168.0.2", 1235), "should match")
+		port := mappedPort(t, nat, "192.168.0.3", 1235)
+		assert.Equal(t, natPortRangeStart+1, port, "should match")
+	})
+
+	t.Run("falls back to a port in the same range", func(t *testing.T) {
+		nat := newPreservingNAT(PortFallbackRange)
+		assert.Equal(t, 80, mappedPort(t, nat, "192.168.0.2", 80), "should match")
+		port := mappedPort(t, nat, "192.168.0.3", 80)
+		assert.True(t, port > 0 && port <= wellKnownPortEnd, "should be a well-known port")
+
+		assert.Equal(t, 5000, mappedPort(t, nat, "192.168.0.2", 5000), "should match")
+		assert.True(t, mappedPort(t, nat, "192.168.0.3", 5000) > wellKnownPortEnd, "should not be a well-known port")
+	})
+
+	t.Run("drops on collision", func(t *testing.T) {
+		nat := newPreservingNAT(PortFallbackDrop)
+		assert.Equal(t, 1234, mappedPort(t, nat, "192.168.0.2", 1234), "should match")
+		assert.Equal(t, 0, mappedPort(t, nat, "192.168.0.3", 1234), "should be dropped")
+		assert.Equal(t, 1, len(nat.inboundMap), "should match")
+	})
+
+	t.Run("sequential ports skip preserved ones", func(t *testing.T) {
+		nat, err := newNAT(&natConfig{
+			mappedIPs:     []net.IP{net.ParseIP(demoIP)},
+			loggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		// a preserved mapping already holds the first port of the range
+		nat.natType.PortPreservation = true
+		assert.Equal(t, natPortRangeStart, mappedPort(t, nat, "192.168.0.2", natPortRangeStart), "should match")
+		nat.natType.PortPreservation = false
+
+		assert.Equal(t, natPortRangeStart+1, mappedPort(t, nat, "192.168.0.3", 1234), "should match")
+	})
+}
@@ -20,6 +20,11 @@ const (
 	lo0String = "lo0String"
 	udp       = "udp"
 	udp4      = "udp4"
+	udp6      = "udp6"
+	tcp       = "tcp"
+	tcp4      = "tcp4"
+	tcp6      = "tcp6"
+	icmp      = "icmp"
 )
 
 var (
@@ -46,11 +51,17 @@ func newMACAddress() net.HardwareAddr {
 // Net represents a local network stack equivalent to a set of layers from NIC
 // up to the transport (UDP / TCP) layer.
 type Net struct {
-	interfaces []*transport.Interface // read-only
-	staticIPs  []net.IP               // read-only
-	router     *Router                // read-only
-	udpConns   *udpConnMap            // read-only
-	mutex      sync.RWMutex
+	interfaces    []*transport.Interface          // read-only
+	links         []*netLink                      // read-only, eth0 first
+	ifcHandlers   map[uint64]func(InterfaceEvent) // requires mutex
+	lastHandlerID uint64                          // requires mutex
+	udpConns      *udpConnMap                     // read-only
+	tcpConns      *tcpConnMap                     // read-only
+	frags         *reassembler                    // read-only
+	clock         Clock                           // read-only
+	rand          *rand.Rand                      // requires randMutex
+	randMutex     sync.Mutex
+	mutex         sync.RWMutex
 }
 
 // Compile-time assertion
@@ -134,10 +145,8 @@ func (v *Net) getAllIPAddrs(ipv6 bool) []net.IP {
 				continue
 			}
 
-			if !ipv6 {
-				if ip.To4() != nil {
-					ips = append(ips, ip)
-				}
+			if (ip.To4() == nil) == ipv6 {
+				ips = append(ips, ip)
 			}
 		}
 	}
@@ -146,37 +155,78 @@ func (v *Net) getAllIPAddrs(ipv6 bool) []net.IP {
 }
 
 func (v *Net) setRouter(r *Router) error {
-	v.mutex.Lock()
-	defer v.mutex.Unlock()
+	return v.links[0].setRouter(r)
+}
 
-	v.router = r
-	return nil
+func (v *Net) unsetRouter(r *Router) error {
+	return v.links[0].unsetRouter(r)
 }
 
 func (v *Net) onInboundChunk(c Chunk) {
+	if dstIP := c.getDestinationIP(); !dstIP.IsLoopback() {
+		if link := v.linkOf(dstIP); link == nil || !link.isUp() {
+			return // the interface is down, or no longer has the address
+		}
+	}
+
+	if c.getFragment() != nil {
+		if c = v.frags.add(c); c == nil {
+			return // wait for the other fragments
+		}
+	}
+
+	if c.Network() == tcp {
+		// TCP connections may respond synchronously, which could loop back
+		// to this method. Do not hold the mutex.
+		v.onInboundTCPChunk(c)
+		return
+	}
+
+	if icmp, ok := c.(*chunkICMP); ok {
+		v.onInboundICMPChunk(icmp)
+		return
+	}
+
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
 
 	if c.Network() == udp {
 		if conn, ok := v.udpConns.find(c.DestinationAddr()); ok {
 			conn.onInboundChunk(c)
+			return
 		}
+
+		// Nobody is listening. Reply with an ICMP port unreachable as a real
+		// stack would do.
+		_ = v.write(newChunkICMPUnreachable(c.getDestinationIP(), c, icmpPortUnreachable)) //nolint:errcheck
+	}
+}
+
+// onInboundICMPChunk passes an ICMP error to the UDPConn that sent the chunk
+// it carries.
+func (v *Net) onInboundICMPChunk(c *chunkICMP) {
+	if c.original.Network() != udp {
+		return
+	}
+
+	if conn, ok := v.udpConns.find(c.original.SourceAddr()); ok {
+		conn.onInboundChunk(c)
 	}
 }
 
 // caller must hold the mutex
 func (v *Net) _dialUDP(network string, locAddr, remAddr *net.UDPAddr) (transport.UDPConn, error) {
 	// validate network
-	if network != udp && network != udp4 {
+	if network != udp && network != udp4 && network != udp6 {
 		return nil, fmt.Errorf("%w: %s", errUnexpectedNetwork, network)
 	}
 
 	if locAddr == nil {
 		locAddr = &net.UDPAddr{
-			IP: net.IPv4zero,
+			IP: unspecifiedIP(network),
 		}
 	} else if locAddr.IP == nil {
-		locAddr.IP = net.IPv4zero
+		locAddr.IP = unspecifiedIP(network)
 	}
 
 	// validate address. do we have that address?
@@ -223,6 +273,14 @@ func (v *Net) _dialUDP(network string, locAddr, remAddr *net.UDPAddr) (transport
 	return conn, nil
 }
 
+// unspecifiedIP returns "::" for IPv6-only networks, "0.0.0.0" otherwise.
+func unspecifiedIP(network string) net.IP {
+	if network == udp6 || network == tcp6 {
+		return net.IPv6unspecified
+	}
+	return net.IPv4zero
+}
+
 // ListenPacket announces on the local network address.
 func (v *Net) ListenPacket(network string, address string) (net.PacketConn, error) {
 	v.mutex.Lock()
@@ -252,8 +310,52 @@ func (v *Net) DialUDP(network string, locAddr, remAddr *net.UDPAddr) (transport.
 	return v._dialUDP(network, locAddr, remAddr)
 }
 
+func (v *Net) onInboundTCPChunk(c Chunk) {
+	seg, ok := c.(*chunkTCP)
+	if !ok {
+		return
+	}
+
+	if conn, ok := v.tcpConns.findConn(seg.DestinationAddr(), seg.SourceAddr()); ok {
+		conn.onInboundChunk(seg)
+		return
+	}
+
+	if seg.flags&(tcpSYN|tcpACK|tcpRST) == tcpSYN {
+		if l, ok := v.tcpConns.findListener(seg.DestinationAddr()); ok {
+			locAddr := seg.DestinationAddr().(*net.TCPAddr) //nolint:forcetypeassert
+			remAddr := seg.SourceAddr().(*net.TCPAddr)      //nolint:forcetypeassert
+			conn, err := newTCPConn(locAddr, remAddr, v, v.clock, v.newISN())
+			if err != nil {
+				return
+			}
+			conn.listener = l
+			conn.mss = v.tcpMSS(locAddr.IP)
+			if err = v.tcpConns.insertConn(conn); err != nil {
+				return
+			}
+			conn.acceptSYN(seg)
+			return
+		}
+	}
+
+	// Nobody is listening. Reply with a RST as a real stack would do.
+	if seg.flags&tcpRST == 0 {
+		_ = v.write(newTCPReset(seg)) //nolint:errcheck
+	}
+}
+
 // Dial connects to the address on the named network.
 func (v *Net) Dial(network string, address string) (net.Conn, error) {
+	if network == tcp || network == tcp4 || network == tcp6 {
+		remAddr, err := v.ResolveTCPAddr(network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		return v.DialTCP(network, nil, remAddr)
+	}
+
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
 
@@ -282,11 +384,12 @@ func (v *Net) ResolveIPAddr(_, address string) (*net.IPAddr, error) {
 			ip = net.IPv4(127, 0, 0, 1)
 		} else {
 			// host is a domain name. resolve IP address by the name
-			if v.router == nil {
+			router := v.defaultRouter()
+			if router == nil {
 				return nil, errNoRouterLinked
 			}
 
-			ip, err = v.router.resolver.lookUp(address)
+			ip, err = router.resolver.lookUp(address)
 			if err != nil {
 				return nil, err
 			}
@@ -300,7 +403,7 @@ func (v *Net) ResolveIPAddr(_, address string) (*net.IPAddr, error) {
 
 // ResolveUDPAddr returns an address of UDP end point.
 func (v *Net) ResolveUDPAddr(network, address string) (*net.UDPAddr, error) {
-	if network != udp && network != udp4 {
+	if network != udp && network != udp4 && network != udp6 {
 		return nil, fmt.Errorf("%w %s", errUnknownNetwork, network)
 	}
 
@@ -330,7 +433,7 @@ func (v *Net) ResolveUDPAddr(network, address string) (*net.UDPAddr, error) {
 
 // ResolveTCPAddr returns an address of TCP end point.
 func (v *Net) ResolveTCPAddr(network, address string) (*net.TCPAddr, error) {
-	if network != udp && network != "udp4" {
+	if network != tcp && network != tcp4 && network != tcp6 {
 		return nil, fmt.Errorf("%w %s", errUnknownNetwork, network)
 	}
 
@@ -349,13 +452,13 @@ func (v *Net) ResolveTCPAddr(network, address string) (*net.TCPAddr, error) {
 		return nil, errInvalidPortNumber
 	}
 
-	udpAddr := &net.TCPAddr{
+	tcpAddr := &net.TCPAddr{
 		IP:   ipAddr.IP,
 		Zone: ipAddr.Zone,
 		Port: port,
 	}
 
-	return udpAddr, nil
+	return tcpAddr, nil
 }
 
 func (v *Net) write(c Chunk) error {
@@ -364,6 +467,8 @@ func (v *Net) write(c Chunk) error {
 			if c.getDestinationIP().IsLoopback() {
 				if conn, ok := v.udpConns.find(udp.DestinationAddr()); ok {
 					conn.onInboundChunk(udp)
+				} else {
+					v.onInboundICMPChunk(newChunkICMPUnreachable(udp.getDestinationIP(), udp, icmpPortUnreachable))
 				}
 				return nil
 			}
@@ -372,72 +477,99 @@ func (v *Net) write(c Chunk) error {
 		}
 	}
 
-	if v.router == nil {
+	if c.Network() == tcp && c.getDestinationIP().IsLoopback() {
+		v.onInboundChunk(c)
+		return nil
+	}
+
+	// the chunk leaves from the interface of its source address
+	link := v.linkOf(c.getSourceIP())
+	if link == nil {
+		if _, router := v.links[0].state(); router == nil {
+			return errNoRouterLinked
+		}
+		return fmt.Errorf("%w: %s", errCantAssignRequestedAddr, c.getSourceIP())
+	}
+	ifc, router := link.state()
+	if router == nil {
 		return errNoRouterLinked
 	}
+	if ifc.Flags&net.FlagUp == 0 {
+		return errNetworkIsDown
+	}
+
+	size := chunkSize(c)
+	if size > maxIPPacketSize {
+		return errMessageTooLong
+	}
+	if size <= link.mtu {
+		router.push(c)
+		return nil
+	}
 
-	v.router.push(c)
+	// Like the kernel, fragment chunks larger than the MTU of the interface
+	fragments := fragmentChunk(c, link.mtu)
+	if fragments == nil {
+		return errMessageTooLong
+	}
+	for _, fragment := range fragments {
+		router.push(fragment)
+	}
 	return nil
 }
 
+// tcpMSS returns the max segment size for TCP connections from locIP, which
+// keeps segments within the MTU of its interface.
+func (v *Net) tcpMSS(locIP net.IP) int {
+	link := v.linkOf(locIP)
+	if link == nil {
+		link = v.links[0]
+	}
+	if mss := link.mtu - ipHeaderSize(locIP) - tcpHeaderSize; mss < tcpMSS {
+		return mss
+	}
+	return tcpMSS
+}
+
 func (v *Net) onClosed(addr net.Addr) {
-	if addr.Network() == udp {
+	switch addr.Network() {
+	case udp:
 		//nolint:errcheck
 		v.udpConns.delete(addr) // #nosec
+	case tcp:
+		//nolint:errcheck
+		v.tcpConns.deleteListener(addr) // #nosec
 	}
 }
 
+func (v *Net) onTCPConnClosed(conn *TCPConn) {
+	v.tcpConns.deleteConn(conn)
+}
+
 // This method determines the srcIP based on the dstIP when locIP
 // is any IP address ("0.0.0.0" or "::"). If locIP is a non-any addr,
-// this method simply returns locIP.
+// this method simply returns locIP. With several interfaces, the address
+// is taken from the one on the most specific subnet containing dstIP, or
+// else from the first one that has an address of the family, eth0 first.
 // caller must hold the mutex
 func (v *Net) determineSourceIP(locIP, dstIP net.IP) net.IP {
 	if locIP != nil && !locIP.IsUnspecified() {
 		return locIP
 	}
 
-	var srcIP net.IP
-
 	if dstIP.IsLoopback() {
-		srcIP = net.ParseIP("127.0.0.1")
-	} else {
-		ifc, err2 := v._getInterface("eth0")
-		if err2 != nil {
-			return nil
-		}
-
-		addrs, err2 := ifc.Addrs()
-		if err2 != nil {
-			return nil
-		}
-
-		if len(addrs) == 0 {
-			return nil
-		}
-
-		var findIPv4 bool
-		if locIP != nil {
-			findIPv4 = (locIP.To4() != nil)
-		} else {
-			findIPv4 = (dstIP.To4() != nil)
-		}
+		return net.ParseIP("127.0.0.1")
+	}
 
-		for _, addr := range addrs {
-			ip := addr.(*net.IPNet).IP //nolint:forcetypeassert
-			if findIPv4 {
-				if ip.To4() != nil {
-					srcIP = ip
-					break
-				}
-			} else {
-				if ip.To4() == nil {
-					srcIP = ip
-					break
-				}
-			}
-		}
+	var findIPv4 bool
+	if locIP != nil {
+		findIPv4 = (locIP.To4() != nil)
+	} else {
+		findIPv4 = (dstIP.To4() != nil)
 	}
 
+	// pick the interface to reach dstIP from, like a routing table lookup
+	_, srcIP := v.egressLink(dstIP, findIPv4)
 	return srcIP
 }
 
@@ -509,19 +641,56 @@ func (v *Net) allocateLocalAddr(ip net.IP, port int) error {
 	return nil
 }
 
+// caller must hold the mutex
+func (v *Net) allocateLocalTCPAddr(ip net.IP, port int) error {
+	if !ip.IsUnspecified() && !v.hasIPAddr(ip) {
+		return fmt.Errorf("%w %s", errBindFailedFor, ip.String())
+	}
+
+	addr := &net.TCPAddr{
+		IP:   ip,
+		Port: port,
+	}
+	if v.tcpConns.inUse(addr) {
+		return &net.OpError{
+			Op:   "bind",
+			Net:  tcp,
+			Addr: addr,
+			Err:  fmt.Errorf("bind: %w", errAddressAlreadyInUse),
+		}
+	}
+
+	return nil
+}
+
 // caller must hold the mutex
 func (v *Net) assignPort(ip net.IP, start, end int) (int, error) {
+	return v.assignPortWith(start, end, func(port int) error {
+		return v.allocateLocalAddr(ip, port)
+	})
+}
+
+// caller must hold the mutex
+func (v *Net) assignTCPPort(ip net.IP, start, end int) (int, error) {
+	return v.assignPortWith(start, end, func(port int) error {
+		return v.allocateLocalTCPAddr(ip, port)
+	})
+}
+
+func (v *Net) assignPortWith(start, end int, allocate func(port int) error) (int, error) {
 	// choose randomly from the range between start and end (inclusive)
 	if end < start {
 		return -1, errEndPortLessThanStart
 	}
 
 	space := end + 1 - start
-	offset := rand.Intn(space) //nolint:gosec
+	v.randMutex.Lock()
+	offset := v.rand.Intn(space)
+	v.randMutex.Unlock()
 	for i := 0; i < space; i++ {
 		port := ((offset + i) % space) + start
 
-		err := v.allocateLocalAddr(ip, port)
+		err := allocate(port)
 		if err == nil {
 			return port, nil
 		}
@@ -530,8 +699,16 @@ func (v *Net) assignPort(ip net.IP, start, end int) (int, error) {
 	return -1, errPortSpaceExhausted
 }
 
+// newISN returns a random initial sequence number for a TCPConn
+func (v *Net) newISN() uint32 {
+	v.randMutex.Lock()
+	defer v.randMutex.Unlock()
+
+	return v.rand.Uint32()
+}
+
 func (v *Net) getStaticIPs() []net.IP {
-	return v.staticIPs
+	return v.links[0].staticIPs
 }
 
 // NetConfig is a bag of configuration parameters passed to NewNet().
@@ -543,11 +720,31 @@ type NetConfig struct {
 
 	// StaticIP is deprecated. Use StaticIPs.
 	StaticIP string
+
+	// MTU of eth0, the link to the router. Defaults to 1500.
+	MTU int
+
+	// Interfaces declares more interfaces, like "wlan0" or "tun0". Each of
+	// them is added to a router of its own, with the NIC returned by
+	// Net.NIC.
+	Interfaces []InterfaceConfig
+
+	// Clock is the source of time for the TCP retransmissions and
+	// TIME-WAIT, and the reassembly of fragments. Defaults to the system
+	// clock.
+	Clock Clock
+
+	// Rand is the random number generator for the ephemeral ports and the
+	// TCP initial sequence numbers of this Net, for a reproducible run. It
+	// must not be shared. Defaults to a generator seeded from the system
+	// clock.
+	Rand *rand.Rand
 }
 
 // NewNet creates an instance of a virtual network.
 //
-// By design, it always have lo0 and eth0 interfaces.
+// By design, it always have lo0 and eth0 interfaces, followed by the
+// interfaces of NetConfig.Interfaces.
 // The lo0 has the address 127.0.0.1 assigned by default.
 // IP address for eth0 will be assigned when this Net is added to a router.
 func NewNet(config *NetConfig) (*Net, error) {
@@ -565,39 +762,200 @@ func NewNet(config *NetConfig) (*Net, error) {
 
 	eth0 := transport.NewInterface(net.Interface{
 		Index:        2,
-		MTU:          1500,
 		Name:         "eth0",
 		HardwareAddr: newMACAddress(),
 		Flags:        net.FlagUp | net.FlagMulticast,
 	})
 
-	var staticIPs []net.IP
-	for _, ipStr := range config.StaticIPs {
-		if ip := net.ParseIP(ipStr); ip != nil {
-			staticIPs = append(staticIPs, ip)
+	staticIPs := config.StaticIPs
+	if len(config.StaticIP) > 0 {
+		staticIPs = append(staticIPs[:len(staticIPs):len(staticIPs)], config.StaticIP)
+	}
+
+	clock := clockOrSystem(config.Clock)
+	v := &Net{
+		interfaces:  []*transport.Interface{lo0, eth0},
+		udpConns:    newUDPConnMap(),
+		tcpConns:    newTCPConnMap(),
+		frags:       newReassembler(clock),
+		clock:       clock,
+		rand:        randOrSeeded(config.Rand),
+		ifcHandlers: map[uint64]func(InterfaceEvent){},
+	}
+
+	link, err := newNetLink(v, eth0, staticIPs, config.MTU)
+	if err != nil {
+		return nil, err
+	}
+	v.links = append(v.links, link)
+
+	for i, ifcConfig := range config.Interfaces {
+		if ifcConfig.Name == "" || ifcConfig.Name == lo0String || ifcConfig.Name == "eth0" {
+			return nil, fmt.Errorf("%w: %q", errInvalidInterfaceName, ifcConfig.Name)
 		}
+		if _, err := v._getInterface(ifcConfig.Name); err == nil {
+			return nil, fmt.Errorf("%w: %s", errDuplicateInterfaceName, ifcConfig.Name)
+		}
+
+		ifc := transport.NewInterface(net.Interface{
+			Index:        3 + i,
+			Name:         ifcConfig.Name,
+			HardwareAddr: newMACAddress(),
+			Flags:        net.FlagUp | net.FlagMulticast,
+		})
+		link, err := newNetLink(v, ifc, ifcConfig.StaticIPs, ifcConfig.MTU)
+		if err != nil {
+			return nil, err
+		}
+		v.interfaces = append(v.interfaces, ifc)
+		v.links = append(v.links, link)
 	}
-	if len(config.StaticIP) > 0 {
-		if ip := net.ParseIP(config.StaticIP); ip != nil {
-			staticIPs = append(staticIPs, ip)
+
+	return v, nil
+}
+
+// caller must hold the mutex
+func (v *Net) _dialTCP(network string, locAddr, remAddr *net.TCPAddr) (*TCPConn, error) {
+	// validate network
+	if network != tcp && network != tcp4 && network != tcp6 {
+		return nil, fmt.Errorf("%w: %s", errUnexpectedNetwork, network)
+	}
+
+	if remAddr == nil {
+		return nil, errNoRemAddr
+	}
+
+	var locIP net.IP
+	locPort := 0
+	if locAddr != nil {
+		locIP = locAddr.IP
+		locPort = locAddr.Port
+	}
+
+	srcIP := v.determineSourceIP(locIP, remAddr.IP)
+	if srcIP == nil || !v.hasIPAddr(srcIP) {
+		return nil, &net.OpError{
+			Op:   "dial",
+			Net:  network,
+			Addr: remAddr,
+			Err:  fmt.Errorf("bind: %w", errCantAssignRequestedAddr),
 		}
 	}
 
-	return &Net{
-		interfaces: []*transport.Interface{lo0, eth0},
-		staticIPs:  staticIPs,
-		udpConns:   newUDPConnMap(),
-	}, nil
+	if locPort == 0 {
+		// choose randomly from the range between 5000 and 5999
+		port, err := v.assignTCPPort(srcIP, 5000, 5999)
+		if err != nil {
+			return nil, &net.OpError{
+				Op:   "dial",
+				Net:  network,
+				Addr: remAddr,
+				Err:  err,
+			}
+		}
+		locPort = port
+	}
+
+	conn, err := newTCPConn(&net.TCPAddr{IP: srcIP, Port: locPort}, remAddr, v, v.clock, v.newISN())
+	if err != nil {
+		return nil, err
+	}
+	conn.mss = v.tcpMSS(srcIP)
+
+	if err = v.tcpConns.insertConn(conn); err != nil {
+		return nil, &net.OpError{
+			Op:     "dial",
+			Net:    network,
+			Source: conn.locAddr,
+			Addr:   remAddr,
+			Err:    fmt.Errorf("bind: %w", err),
+		}
+	}
+
+	return conn, nil
 }
 
 // DialTCP acts like Dial for TCP networks.
-func (v *Net) DialTCP(string, *net.TCPAddr, *net.TCPAddr) (transport.TCPConn, error) {
-	return nil, transport.ErrNotSupported
+func (v *Net) DialTCP(network string, locAddr, remAddr *net.TCPAddr) (transport.TCPConn, error) {
+	v.mutex.Lock()
+	conn, err := v._dialTCP(network, locAddr, remAddr)
+	v.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// the handshake must run without the mutex
+	if err = conn.connect(); err != nil {
+		v.onTCPConnClosed(conn)
+		return nil, &net.OpError{
+			Op:     "dial",
+			Net:    network,
+			Source: conn.locAddr,
+			Addr:   remAddr,
+			Err:    err,
+		}
+	}
+
+	return conn, nil
 }
 
 // ListenTCP acts like Listen for TCP networks.
-func (v *Net) ListenTCP(string, *net.TCPAddr) (transport.TCPListener, error) {
-	return nil, transport.ErrNotSupported
+func (v *Net) ListenTCP(network string, locAddr *net.TCPAddr) (transport.TCPListener, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	// validate network
+	if network != tcp && network != tcp4 && network != tcp6 {
+		return nil, fmt.Errorf("%w: %s", errUnexpectedNetwork, network)
+	}
+
+	addr := &net.TCPAddr{IP: unspecifiedIP(network)}
+	if locAddr != nil {
+		if locAddr.IP != nil {
+			addr.IP = locAddr.IP
+		}
+		addr.Port = locAddr.Port
+	}
+
+	// validate address. do we have that address?
+	if !v.hasIPAddr(addr.IP) {
+		return nil, &net.OpError{
+			Op:   "listen",
+			Net:  network,
+			Addr: addr,
+			Err:  fmt.Errorf("bind: %w", errCantAssignRequestedAddr),
+		}
+	}
+
+	if addr.Port == 0 {
+		// choose randomly from the range between 5000 and 5999
+		port, err := v.assignTCPPort(addr.IP, 5000, 5999)
+		if err != nil {
+			return nil, &net.OpError{
+				Op:   "listen",
+				Net:  network,
+				Addr: addr,
+				Err:  err,
+			}
+		}
+		addr.Port = port
+	}
+
+	l, err := newTCPListener(addr, v)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = v.tcpConns.insertListener(l); err != nil {
+		return nil, &net.OpError{
+			Op:   "listen",
+			Net:  network,
+			Addr: addr,
+			Err:  fmt.Errorf("bind: %w", err),
+		}
+	}
+
+	return l, nil
 }
 
 // CreateDialer creates an instance of vnet.Dialer
@@ -0,0 +1,452 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/pion/transport/v3"
+)
+
+var (
+	errInvalidInterfaceName   = errors.New("invalid interface name")
+	errDuplicateInterfaceName = errors.New("duplicate interface name")
+	errNetworkIsDown          = errors.New("network is down")
+	errInvalidIPAddress       = errors.New("invalid IP address")
+)
+
+// InterfaceEventType is the type of an InterfaceEvent
+type InterfaceEventType int
+
+const (
+	// InterfaceUp is reported when an interface is brought up
+	InterfaceUp InterfaceEventType = iota + 1
+	// InterfaceDown is reported when an interface is taken down
+	InterfaceDown
+	// InterfaceRenumbered is reported when the addresses of an interface
+	// change
+	InterfaceRenumbered
+)
+
+func (t InterfaceEventType) String() string {
+	switch t {
+	case InterfaceUp:
+		return "up"
+	case InterfaceDown:
+		return "down"
+	case InterfaceRenumbered:
+		return "renumbered"
+	default:
+		return "unknown"
+	}
+}
+
+// InterfaceEvent is a change of an interface of a Net.
+type InterfaceEvent struct {
+	Type InterfaceEventType
+	// Interface is the interface after the change
+	Interface *transport.Interface
+	// OldAddrs are the addresses of the interface before it was renumbered
+	OldAddrs []net.Addr
+}
+
+// InterfaceConfig declares an interface of a Net in addition to lo0 and eth0,
+// like a WiFi, cellular or VPN interface.
+type InterfaceConfig struct {
+	// Name of the interface, like "eth1", "wlan0" or "tun0"
+	Name string
+	// StaticIPs is an array of static IP addresses to be assigned for this
+	// interface. If no static IP address is given, the router will
+	// automatically assign an IP address.
+	StaticIPs []string
+	// MTU of the interface, the link to its router. Defaults to 1500.
+	MTU int
+}
+
+// netLink is an interface of a Net that attaches to a router. The router
+// sees the interface as the eth0 of its NIC.
+type netLink struct {
+	net       *Net
+	ifc       *transport.Interface // requires mutex, replaced on every change
+	staticIPs []net.IP             // read-only
+	mtu       int                  // read-only
+	router    *Router              // requires mutex, set when the link is attached
+	mutex     sync.RWMutex
+}
+
+func newNetLink(n *Net, ifc *transport.Interface, staticIPs []string, mtu int) (*netLink, error) {
+	if mtu == 0 {
+		mtu = defaultMTU
+	} else if mtu < minMTU {
+		return nil, fmt.Errorf("%w: %d", errInvalidMTU, mtu)
+	}
+	ifc.MTU = mtu
+
+	link := &netLink{
+		net: n,
+		ifc: ifc,
+		mtu: mtu,
+	}
+	for _, ipStr := range staticIPs {
+		if ip := net.ParseIP(ipStr); ip != nil {
+			link.staticIPs = append(link.staticIPs, ip)
+		}
+	}
+	return link, nil
+}
+
+func (l *netLink) getInterface(ifName string) (*transport.Interface, error) {
+	ifc, _ := l.state()
+	if ifName != "eth0" && ifName != ifc.Name {
+		return nil, fmt.Errorf("%w: %s", transport.ErrInterfaceNotFound, ifName)
+	}
+	return ifc, nil
+}
+
+func (l *netLink) onInboundChunk(c Chunk) {
+	l.net.onInboundChunk(c)
+}
+
+func (l *netLink) getStaticIPs() []net.IP {
+	return l.staticIPs
+}
+
+func (l *netLink) setRouter(r *Router) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.router = r
+	return nil
+}
+
+func (l *netLink) unsetRouter(r *Router) error {
+	v := l.net
+
+	v.mutex.Lock()
+	l.mutex.Lock()
+	if l.router != r {
+		l.mutex.Unlock()
+		v.mutex.Unlock()
+		return errNICNotFound
+	}
+	l.router = nil
+	ifc := l.ifc
+	l.mutex.Unlock()
+
+	// the addresses were assigned by the router
+	old, updated := l.update(ifc.Flags, nil)
+	attached := v.defaultRouter() != nil
+	handlers := v.interfaceHandlers()
+	v.mutex.Unlock()
+
+	var ips []net.IP
+	for _, addr := range interfaceIPNets(old) {
+		ips = append(ips, addr.IP)
+	}
+	v.closeConns(ips, !attached)
+
+	oldAddrs, _ := old.Addrs() //nolint:errcheck
+	event := InterfaceEvent{Type: InterfaceRenumbered, Interface: updated, OldAddrs: oldAddrs}
+	for _, handler := range handlers {
+		handler(event)
+	}
+	return nil
+}
+
+// state returns the interface and the router of the link
+func (l *netLink) state() (*transport.Interface, *Router) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	return l.ifc, l.router
+}
+
+func (l *netLink) isUp() bool {
+	ifc, _ := l.state()
+	return ifc.Flags&net.FlagUp != 0
+}
+
+// update replaces the interface with a copy having the given flags and
+// addresses. The interface is never modified in place, as it is read
+// without the mutex of the Net on the send path.
+// caller must hold the mutex of the Net
+func (l *netLink) update(flags net.Flags, addrs []net.Addr) (old, updated *transport.Interface) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	old = l.ifc
+	ifc := old.Interface
+	ifc.Flags = flags
+	updated = transport.NewInterface(ifc)
+	for _, addr := range addrs {
+		updated.AddAddress(addr)
+	}
+	l.ifc = updated
+
+	for i, other := range l.net.interfaces {
+		if other == old {
+			l.net.interfaces[i] = updated
+		}
+	}
+	return old, updated
+}
+
+// NIC returns the NIC of the named interface, to add to the router the
+// interface is attached to. The Net itself is the NIC of eth0.
+func (v *Net) NIC(ifName string) (NIC, error) {
+	if ifName == "eth0" {
+		return v, nil
+	}
+
+	link, err := v.linkByName(ifName)
+	if err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// SetInterfaceUp brings the named interface up or takes it down, like
+// unplugging a cable or losing the WiFi signal. While an interface is down,
+// sends from its addresses fail, inbound chunks to them are dropped, and
+// no source address is picked from it.
+func (v *Net) SetInterfaceUp(ifName string, up bool) error {
+	link, err := v.linkByName(ifName)
+	if err != nil {
+		return err
+	}
+
+	v.mutex.Lock()
+	ifc, _ := link.state()
+	flags := ifc.Flags
+	if up {
+		flags |= net.FlagUp
+	} else {
+		flags &^= net.FlagUp
+	}
+	if flags == ifc.Flags {
+		v.mutex.Unlock()
+		return nil
+	}
+	addrs, _ := ifc.Addrs() //nolint:errcheck
+	_, updated := link.update(flags, addrs)
+	handlers := v.interfaceHandlers()
+	v.mutex.Unlock()
+
+	event := InterfaceEvent{Type: InterfaceDown, Interface: updated}
+	if up {
+		event.Type = InterfaceUp
+	}
+	for _, handler := range handlers {
+		handler(event)
+	}
+	return nil
+}
+
+// Renumber replaces the addresses of the named interface with the given
+// ones, or with new ones from its router if none is given, like a DHCP
+// lease to another address. Connections bound to the old addresses can no
+// longer send.
+func (v *Net) Renumber(ifName string, staticIPs ...string) error {
+	link, err := v.linkByName(ifName)
+	if err != nil {
+		return err
+	}
+
+	var ips []net.IP
+	for _, ipStr := range staticIPs {
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			return fmt.Errorf("%w: %s", errInvalidIPAddress, ipStr)
+		}
+		ips = append(ips, ip)
+	}
+
+	ifc, router := link.state()
+	if router == nil {
+		return errNoRouterLinked
+	}
+
+	var nic NIC = link
+	if link == v.links[0] {
+		nic = v
+	}
+	ipNets, err := router.renumberNIC(nic, ifc.HardwareAddr, ips)
+	if err != nil {
+		return err
+	}
+	addrs := make([]net.Addr, 0, len(ipNets))
+	for _, ipNet := range ipNets {
+		addrs = append(addrs, ipNet)
+	}
+
+	v.mutex.Lock()
+	ifc, _ = link.state()
+	old, updated := link.update(ifc.Flags, addrs)
+	handlers := v.interfaceHandlers()
+	v.mutex.Unlock()
+
+	oldAddrs, _ := old.Addrs() //nolint:errcheck
+	event := InterfaceEvent{Type: InterfaceRenumbered, Interface: updated, OldAddrs: oldAddrs}
+	for _, handler := range handlers {
+		handler(event)
+	}
+	return nil
+}
+
+// OnInterfaceEvent registers a handler called after an interface is
+// brought up or down, or renumbered. It is called from the goroutine
+// making the change. The returned function unregisters the handler.
+func (v *Net) OnInterfaceEvent(handler func(InterfaceEvent)) (cancel func()) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.lastHandlerID++
+	id := v.lastHandlerID
+	v.ifcHandlers[id] = handler
+
+	return func() {
+		v.mutex.Lock()
+		defer v.mutex.Unlock()
+
+		delete(v.ifcHandlers, id)
+	}
+}
+
+// interfaceHandlers returns the handlers of interface events in the order
+// they were registered.
+// caller must hold the mutex
+func (v *Net) interfaceHandlers() []func(InterfaceEvent) {
+	ids := make([]uint64, 0, len(v.ifcHandlers))
+	for id := range v.ifcHandlers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	handlers := make([]func(InterfaceEvent), 0, len(ids))
+	for _, id := range ids {
+		handlers = append(handlers, v.ifcHandlers[id])
+	}
+	return handlers
+}
+
+// closeConns closes the UDPConns and the TCPListeners bound to the IPs, and
+// those bound to the unspecified address if unspecified is set. The
+// TCPConns bound to the IPs are reset.
+func (v *Net) closeConns(ips []net.IP, unspecified bool) {
+	closing := func(locIP net.IP) bool {
+		if unspecified && locIP.IsUnspecified() {
+			return true
+		}
+		for _, ip := range ips {
+			if locIP.Equal(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, conn := range v.udpConns.all() {
+		if closing(conn.locAddr.IP) {
+			_ = conn.Close() //nolint:errcheck
+		}
+	}
+
+	listeners, conns := v.tcpConns.all()
+	for _, l := range listeners {
+		if closing(l.locAddr.IP) {
+			_ = l.Close() //nolint:errcheck
+		}
+	}
+	for _, conn := range conns {
+		if closing(conn.locAddr.IP) {
+			conn.reset(errConnectionReset)
+		}
+	}
+}
+
+// linkByName returns the link of the named interface
+func (v *Net) linkByName(ifName string) (*netLink, error) {
+	for _, link := range v.links {
+		if ifc, _ := link.state(); ifc.Name == ifName {
+			return link, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", transport.ErrInterfaceNotFound, ifName)
+}
+
+// linkOf returns the link that has the IP address, nil if there is none.
+func (v *Net) linkOf(ip net.IP) *netLink {
+	for _, link := range v.links {
+		ifc, _ := link.state()
+		for _, addr := range interfaceIPNets(ifc) {
+			if addr.IP.Equal(ip) {
+				return link
+			}
+		}
+	}
+	return nil
+}
+
+// egressLink picks the link to send to dstIP from, among the links that are
+// up with an address of the given family: the one on the most specific
+// subnet that contains dstIP, or else the first one, eth0 first.
+func (v *Net) egressLink(dstIP net.IP, ipv4 bool) (*netLink, net.IP) {
+	var egress *netLink
+	var srcIP net.IP
+	bestLen := -1
+	for _, link := range v.links {
+		ifc, _ := link.state()
+		if ifc.Flags&net.FlagUp == 0 {
+			continue
+		}
+		for _, addr := range interfaceIPNets(ifc) {
+			if (addr.IP.To4() != nil) != ipv4 {
+				continue
+			}
+
+			prefixLen := 0
+			if subnet := (&net.IPNet{IP: addr.IP.Mask(addr.Mask), Mask: addr.Mask}); subnet.Contains(dstIP) {
+				prefixLen, _ = addr.Mask.Size()
+			}
+			if prefixLen > bestLen {
+				egress, srcIP, bestLen = link, addr.IP, prefixLen
+			}
+		}
+	}
+	return egress, srcIP
+}
+
+// defaultRouter returns the router of eth0, or of the first interface that
+// is attached to one.
+func (v *Net) defaultRouter() *Router {
+	for _, link := range v.links {
+		if _, router := link.state(); router != nil {
+			return router
+		}
+	}
+	return nil
+}
+
+// interfaceIPNets returns the addresses of the interface.
+func interfaceIPNets(ifc *transport.Interface) []*net.IPNet {
+	addrs, err := ifc.Addrs()
+	if err != nil {
+		return nil
+	}
+
+	ipNets := make([]*net.IPNet, 0, len(addrs))
+	for _, addr := range addrs {
+		switch addr := addr.(type) {
+		case *net.IPNet:
+			ipNets = append(ipNets, addr)
+		case *net.IPAddr:
+			ipNets = append(ipNets, &net.IPNet{IP: addr.IP, Mask: net.CIDRMask(8*len(addr.IP), 8*len(addr.IP))})
+		}
+	}
+	return ipNets
+}
@@ -5,8 +5,11 @@ package vnet
 
 import (
 	"fmt"
+	"math/rand"
 	"net"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/pion/logging"
 	"github.com/pion/transport/v3"
@@ -406,6 +409,23 @@ func TestNetVirtual(t *testing.T) {
 		assert.Equal(t, 0, nw.udpConns.size(), "should match")
 	})
 
+	t.Run("Dial (UDP) lo0 port unreachable", func(t *testing.T) {
+		nw, err := NewNet(&NetConfig{})
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		conn, err := nw.Dial(udp, "127.0.0.1:1234")
+		assert.NoError(t, err, "should succeed")
+
+		_, err = conn.Write([]byte("Hello"))
+		assert.NoError(t, err, "should succeed")
+
+		_, err = conn.Read(make([]byte, 1500))
+		assert.ErrorIs(t, err, errConnectionRefused, "should be refused")
+		assert.NoError(t, conn.Close(), "should succeed")
+	})
+
 	t.Run("Dial (UDP) eth0", func(t *testing.T) {
 		wan, err := NewRouter(&RouterConfig{
 			CIDR:          "1.2.3.0/24",
@@ -802,4 +822,296 @@ func TestNetVirtual(t *testing.T) {
 
 		assert.NoError(t, wan.Stop(), "should succeed")
 	})
+
+	t.Run("Multi-homed", func(t *testing.T) {
+		_, err := NewNet(&NetConfig{Interfaces: []InterfaceConfig{{Name: "eth0"}}})
+		assert.ErrorIs(t, err, errInvalidInterfaceName, "should fail")
+		_, err = NewNet(&NetConfig{Interfaces: []InterfaceConfig{{Name: "wlan0"}, {Name: "wlan0"}}})
+		assert.ErrorIs(t, err, errDuplicateInterfaceName, "should fail")
+
+		lan, err := NewRouter(&RouterConfig{CIDR: "192.168.0.0/24", LoggerFactory: loggerFactory})
+		assert.NoError(t, err, "should succeed")
+		wifi, err := NewRouter(&RouterConfig{CIDR: "10.0.0.0/24", LoggerFactory: loggerFactory})
+		assert.NoError(t, err, "should succeed")
+
+		host, err := NewNet(&NetConfig{
+			StaticIPs: []string{"192.168.0.2"},
+			Interfaces: []InterfaceConfig{
+				{Name: "wlan0", StaticIPs: []string{"10.0.0.2"}, MTU: 1280},
+			},
+		})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, lan.AddNet(host), "should succeed")
+		wlan0, err := host.NIC("wlan0")
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, wifi.AddNet(wlan0), "should succeed")
+		_, err = host.NIC("tun0")
+		assert.ErrorIs(t, err, transport.ErrInterfaceNotFound, "should fail")
+
+		ifcs, err := host.Interfaces()
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, 3, len(ifcs), "should be 3")
+		assert.Equal(t, "wlan0", ifcs[2].Name, "should match")
+		assert.Equal(t, 3, ifcs[2].Index, "should match")
+		assert.Equal(t, 1280, ifcs[2].MTU, "should match")
+
+		peer1, err := NewNet(&NetConfig{StaticIPs: []string{"192.168.0.3"}})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, lan.AddNet(peer1), "should succeed")
+		peer2, err := NewNet(&NetConfig{StaticIPs: []string{"10.0.0.3"}})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, wifi.AddNet(peer2), "should succeed")
+
+		assert.Equal(t, "192.168.0.2", host.determineSourceIP(nil, net.ParseIP("192.168.0.3")).String(), "should match")
+		assert.Equal(t, "10.0.0.2", host.determineSourceIP(nil, net.ParseIP("10.0.0.3")).String(), "should match")
+		assert.Equal(t, "192.168.0.2", host.determineSourceIP(nil, net.ParseIP("8.8.8.8")).String(), "should prefer eth0")
+		assert.Equal(t, 1280-20-20, host.tcpMSS(net.ParseIP("10.0.0.2")), "should fit the MTU of wlan0")
+
+		assert.NoError(t, lan.Start(), "should succeed")
+		assert.NoError(t, wifi.Start(), "should succeed")
+		defer func() {
+			assert.NoError(t, lan.Stop(), "should succeed")
+			assert.NoError(t, wifi.Stop(), "should succeed")
+		}()
+
+		conn, err := host.ListenPacket(udp, "0.0.0.0:1234")
+		assert.NoError(t, err, "should succeed")
+		defer func() {
+			assert.NoError(t, conn.Close(), "should succeed")
+		}()
+
+		for _, peer := range []struct {
+			nw   *Net
+			addr string
+			src  string
+		}{
+			{peer1, "192.168.0.3:5678", "192.168.0.2"},
+			{peer2, "10.0.0.3:5678", "10.0.0.2"},
+		} {
+			peerConn, err := peer.nw.ListenPacket(udp, peer.addr)
+			assert.NoError(t, err, "should succeed")
+
+			dst, err := net.ResolveUDPAddr(udp, peer.addr)
+			assert.NoError(t, err, "should succeed")
+			_, err = conn.WriteTo([]byte("hello"), dst)
+			assert.NoError(t, err, "should succeed")
+
+			buf := make([]byte, 1500)
+			assert.NoError(t, peerConn.SetReadDeadline(time.Now().Add(time.Second)))
+			_, srcAddr, err := peerConn.ReadFrom(buf)
+			assert.NoError(t, err, "should succeed")
+			assert.Equal(t, peer.src, srcAddr.(*net.UDPAddr).IP.String(), "should leave from the interface on the peer's subnet") //nolint:forcetypeassert
+
+			_, err = peerConn.WriteTo([]byte("hi"), srcAddr)
+			assert.NoError(t, err, "should succeed")
+			assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+			_, _, err = conn.ReadFrom(buf)
+			assert.NoError(t, err, "should receive the reply")
+			assert.NoError(t, peerConn.Close(), "should succeed")
+		}
+	})
+}
+
+func TestNetInterfaceChanges(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	lan, err := NewRouter(&RouterConfig{CIDR: "192.168.0.0/24", LoggerFactory: loggerFactory})
+	assert.NoError(t, err, "should succeed")
+	wifi, err := NewRouter(&RouterConfig{CIDR: "10.0.0.0/24", LoggerFactory: loggerFactory})
+	assert.NoError(t, err, "should succeed")
+
+	host, err := NewNet(&NetConfig{
+		StaticIPs:  []string{"192.168.0.2"},
+		Interfaces: []InterfaceConfig{{Name: "wlan0", StaticIPs: []string{"10.0.0.2"}}},
+	})
+	assert.NoError(t, err, "should succeed")
+	assert.NoError(t, lan.AddNet(host), "should succeed")
+	wlan0, err := host.NIC("wlan0")
+	assert.NoError(t, err, "should succeed")
+	assert.NoError(t, wifi.AddNet(wlan0), "should succeed")
+
+	peer, err := NewNet(&NetConfig{StaticIPs: []string{"10.0.0.3"}})
+	assert.NoError(t, err, "should succeed")
+	assert.NoError(t, wifi.AddNet(peer), "should succeed")
+
+	assert.NoError(t, lan.Start(), "should succeed")
+	assert.NoError(t, wifi.Start(), "should succeed")
+	defer func() {
+		assert.NoError(t, lan.Stop(), "should succeed")
+		assert.NoError(t, wifi.Stop(), "should succeed")
+	}()
+
+	var events []InterfaceEvent
+	cancel := host.OnInterfaceEvent(func(e InterfaceEvent) {
+		events = append(events, e)
+	})
+
+	peerConn, err := peer.ListenPacket(udp, "10.0.0.3:5678")
+	assert.NoError(t, err, "should succeed")
+	defer func() {
+		assert.NoError(t, peerConn.Close(), "should succeed")
+	}()
+
+	// receive reads a datagram sent by the peer to addr, or fails
+	receive := func(t *testing.T, conn net.PacketConn, addr string) error {
+		t.Helper()
+
+		dst, err := net.ResolveUDPAddr(udp, addr)
+		assert.NoError(t, err, "should succeed")
+		_, err = peerConn.WriteTo([]byte("hello"), dst)
+		assert.NoError(t, err, "should succeed")
+
+		buf := make([]byte, 1500)
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+		_, _, err = conn.ReadFrom(buf)
+		return err
+	}
+
+	conn, err := host.ListenPacket(udp, "10.0.0.2:1234")
+	assert.NoError(t, err, "should succeed")
+	defer func() {
+		assert.NoError(t, conn.Close(), "should succeed")
+	}()
+	assert.NoError(t, receive(t, conn, "10.0.0.2:1234"), "should succeed")
+
+	t.Run("down and up", func(t *testing.T) {
+		assert.NoError(t, host.SetInterfaceUp("wlan0", false), "should succeed")
+		assert.NoError(t, host.SetInterfaceUp("wlan0", false), "should not report twice")
+		if assert.Equal(t, 1, len(events), "should be 1") {
+			assert.Equal(t, InterfaceDown, events[0].Type, "should match")
+			assert.Equal(t, "wlan0", events[0].Interface.Name, "should match")
+			assert.Equal(t, net.Flags(0), events[0].Interface.Flags&net.FlagUp, "should be down")
+		}
+		ifc, err := host.InterfaceByName("wlan0")
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, net.Flags(0), ifc.Flags&net.FlagUp, "should be down")
+
+		_, err = conn.WriteTo([]byte("hello"), peerConn.LocalAddr())
+		assert.ErrorIs(t, err, errNetworkIsDown, "should fail")
+		assert.Error(t, receive(t, conn, "10.0.0.2:1234"), "should be dropped")
+		assert.Equal(t, "192.168.0.2", host.determineSourceIP(nil, net.ParseIP("10.0.0.3")).String(), "should not pick wlan0")
+
+		assert.NoError(t, host.SetInterfaceUp("wlan0", true), "should succeed")
+		if assert.Equal(t, 2, len(events), "should be 2") {
+			assert.Equal(t, InterfaceUp, events[1].Type, "should match")
+		}
+		assert.NoError(t, receive(t, conn, "10.0.0.2:1234"), "should succeed")
+		assert.ErrorIs(t, host.SetInterfaceUp("lo0", false), transport.ErrInterfaceNotFound, "should fail")
+	})
+
+	t.Run("renumber", func(t *testing.T) {
+		events = nil
+		assert.ErrorIs(t, host.Renumber("wlan0", "10.0.0.3"), errIPAddressInUse, "should fail")
+		assert.ErrorIs(t, host.Renumber("wlan0", "192.168.0.9"), errStaticIPisBeyondSubnet, "should fail")
+		assert.ErrorIs(t, host.Renumber("wlan0", "bad"), errInvalidIPAddress, "should fail")
+		assert.Equal(t, 0, len(events), "should be 0")
+
+		assert.NoError(t, host.Renumber("wlan0"), "should succeed")
+		if !assert.Equal(t, 1, len(events), "should be 1") {
+			return
+		}
+		assert.Equal(t, InterfaceRenumbered, events[0].Type, "should match")
+		assert.Equal(t, "10.0.0.2/24", events[0].OldAddrs[0].String(), "should match")
+		addrs, err := events[0].Interface.Addrs()
+		assert.NoError(t, err, "should succeed")
+		newIP := addrs[0].(*net.IPNet).IP //nolint:forcetypeassert
+		assert.NotEqual(t, "10.0.0.2", newIP.String(), "should have a new address")
+
+		// the old address is gone
+		_, err = conn.WriteTo([]byte("hello"), peerConn.LocalAddr())
+		assert.ErrorIs(t, err, errCantAssignRequestedAddr, "should fail")
+
+		newConn, err := host.ListenPacket(udp, net.JoinHostPort(newIP.String(), "1234"))
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, receive(t, newConn, net.JoinHostPort(newIP.String(), "1234")), "should succeed")
+		assert.NoError(t, newConn.Close(), "should succeed")
+
+		// more renumberings than host IDs in the subnet
+		for i := 0; i < 300; i++ {
+			if !assert.NoError(t, host.Renumber("wlan0"), "should reuse free host IDs") {
+				break
+			}
+		}
+
+		assert.NoError(t, host.Renumber("wlan0", "10.0.0.2"), "should succeed")
+		assert.NoError(t, receive(t, conn, "10.0.0.2:1234"), "should succeed")
+	})
+
+	cancel()
+	events = nil
+	assert.NoError(t, host.SetInterfaceUp("eth0", false), "should succeed")
+	assert.Equal(t, 0, len(events), "should not be reported after cancel")
+}
+
+func TestNetRand(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	// run returns the ephemeral ports and the TCP initial sequence numbers
+	// of Nets seeded with seed
+	run := func(t *testing.T, seed int64) []uint32 {
+		t.Helper()
+
+		r, err := NewRouter(&RouterConfig{
+			CIDR:          "1.2.3.0/24",
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		var mutex sync.Mutex
+		var values []uint32
+		r.AddChunkFilter(func(c Chunk) bool {
+			if seg, ok := c.(*chunkTCP); ok && seg.flags&tcpSYN != 0 {
+				mutex.Lock()
+				values = append(values, seg.seq)
+				mutex.Unlock()
+			}
+			return true
+		})
+
+		nets := make([]*Net, 2)
+		for i := range nets {
+			nets[i], err = NewNet(&NetConfig{
+				StaticIPs: []string{fmt.Sprintf("1.2.3.%d", i+1)},
+				Rand:      rand.New(rand.NewSource(seed + int64(i))), //nolint:gosec
+			})
+			assert.NoError(t, err, "should succeed")
+			assert.NoError(t, r.AddNet(nets[i]), "should succeed")
+		}
+
+		assert.NoError(t, r.Start(), "should succeed")
+		defer func() {
+			assert.NoError(t, r.Stop(), "should succeed")
+		}()
+
+		udpConn, err := nets[0].ListenPacket(udp, "1.2.3.1:0")
+		assert.NoError(t, err, "should succeed")
+		defer func() {
+			assert.NoError(t, udpConn.Close(), "should succeed")
+		}()
+
+		listener, err := nets[1].ListenTCP(tcp4, &net.TCPAddr{IP: net.ParseIP("1.2.3.2"), Port: 80})
+		assert.NoError(t, err, "should succeed")
+		defer func() {
+			assert.NoError(t, listener.Close(), "should succeed")
+		}()
+
+		conn, err := nets[0].Dial(tcp, "1.2.3.2:80")
+		assert.NoError(t, err, "should succeed")
+		accepted, err := listener.Accept()
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, conn.Close(), "should succeed")
+		assert.NoError(t, accepted.Close(), "should succeed")
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append(values,
+			uint32(udpConn.LocalAddr().(*net.UDPAddr).Port), //nolint:forcetypeassert,gosec
+			uint32(conn.LocalAddr().(*net.TCPAddr).Port),    //nolint:forcetypeassert,gosec
+		)
+	}
+
+	values := run(t, 1)
+	assert.Len(t, values, 4, "should have both ISNs and ports")
+	assert.Equal(t, values, run(t, 1), "should replay with the same seed")
+	assert.NotEqual(t, values, run(t, 2), "should differ with another seed")
 }
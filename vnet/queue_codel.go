@@ -0,0 +1,216 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCoDelLimit    = 1000
+	defaultCoDelTarget   = 5 * time.Millisecond
+	defaultCoDelInterval = 100 * time.Millisecond
+)
+
+var errInvalidCoDelTimes = errors.New("CoDel target and interval must be positive")
+
+// CoDelQueue is a FIFO queue with Controlled Delay (RFC 8289), like the codel
+// qdisc of Linux. Once the sojourn time of the chunks leaving has stayed
+// above the target for an interval, it drops chunks on the way out, at a
+// rate that grows with the square root of the number of drops, until the
+// sojourn time is below the target again.
+type CoDelQueue struct {
+	queue *chunkQueue // read-only
+	codel codel       // requires mutex
+	stats queueStats  // requires mutex
+	mutex sync.Mutex
+}
+
+// CoDelQueueOption is the option type to configure a CoDelQueue
+type CoDelQueueOption func(*CoDelQueue)
+
+// CoDelQueueLimit sets the number of chunks the queue holds at most.
+// Defaults to 1000.
+func CoDelQueueLimit(maxSize int) CoDelQueueOption {
+	return func(q *CoDelQueue) {
+		q.queue = newChunkQueue(maxSize, 0)
+	}
+}
+
+// CoDelQueueTarget sets the acceptable sojourn time. Defaults to 5ms.
+func CoDelQueueTarget(target time.Duration) CoDelQueueOption {
+	return func(q *CoDelQueue) {
+		q.codel.target = target
+	}
+}
+
+// CoDelQueueInterval sets how long the sojourn time may stay above the
+// target before chunks are dropped, which should be about the round-trip
+// time of the path. Defaults to 100ms.
+func CoDelQueueInterval(interval time.Duration) CoDelQueueOption {
+	return func(q *CoDelQueue) {
+		q.codel.interval = interval
+	}
+}
+
+// NewCoDelQueue creates a new CoDelQueue
+func NewCoDelQueue(opts ...CoDelQueueOption) (*CoDelQueue, error) {
+	q := &CoDelQueue{
+		queue: newChunkQueue(defaultCoDelLimit, 0),
+		codel: codel{
+			target:   defaultCoDelTarget,
+			interval: defaultCoDelInterval,
+		},
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	if err := q.codel.validate(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Enqueue implements QueueDiscipline
+func (q *CoDelQueue) Enqueue(c Chunk, now time.Time) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if !q.queue.pushAt(c, now) {
+		q.stats.tailDrops++
+		return false
+	}
+	q.stats.enqueued++
+	return true
+}
+
+// Dequeue implements QueueDiscipline
+func (q *CoDelQueue) Dequeue(now time.Time) (Chunk, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	e, ok := q.codel.dequeue(q.queue, now, func() {
+		q.stats.aqmDrops++
+	})
+	if !ok {
+		return nil, false
+	}
+	q.stats.leave(e, now)
+	return e.Chunk, true
+}
+
+// Peek implements QueueDiscipline
+func (q *CoDelQueue) Peek() Chunk {
+	return q.queue.peek()
+}
+
+// Stats implements QueueDiscipline
+func (q *CoDelQueue) Stats() QueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.stats.snapshot(q.queue.size())
+}
+
+// codel is the state of the CoDel algorithm for one queue, following the
+// pseudocode of RFC 8289.
+type codel struct {
+	target         time.Duration
+	interval       time.Duration
+	firstAboveTime time.Time // zero while the sojourn time is below the target
+	dropNext       time.Time
+	count          int
+	lastCount      int
+	dropping       bool
+}
+
+func (c *codel) validate() error {
+	if c.target <= 0 || c.interval <= 0 {
+		return fmt.Errorf("%w: %v, %v", errInvalidCoDelTimes, c.target, c.interval)
+	}
+	return nil
+}
+
+// dequeue removes the next chunk of the queue that is not dropped, and
+// calls drop for each chunk dropped on the way.
+func (c *codel) dequeue(q *chunkQueue, now time.Time, drop func()) (queuedChunk, bool) {
+	e, ok, okToDrop := c.doDequeue(q, now)
+	if !ok {
+		c.dropping = false
+		return e, false
+	}
+
+	if c.dropping {
+		if !okToDrop {
+			// the sojourn time is below the target again
+			c.dropping = false
+			return e, true
+		}
+		for c.dropping && !now.Before(c.dropNext) {
+			drop()
+			c.count++
+			e, ok, okToDrop = c.doDequeue(q, now)
+			if !ok {
+				c.dropping = false
+				return e, false
+			}
+			if okToDrop {
+				c.dropNext = c.controlLaw(c.dropNext)
+			} else {
+				c.dropping = false
+			}
+		}
+		return e, true
+	}
+
+	if okToDrop {
+		drop()
+		e, ok, _ = c.doDequeue(q, now)
+		c.dropping = true
+
+		// start where the last dropping state left off, if it ended
+		// recently
+		delta := c.count - c.lastCount
+		if delta > 1 && now.Sub(c.dropNext) < 16*c.interval {
+			c.count = delta
+		} else {
+			c.count = 1
+		}
+		c.dropNext = c.controlLaw(now)
+		c.lastCount = c.count
+	}
+	return e, ok
+}
+
+// doDequeue removes the next chunk of the queue, and tells whether its
+// sojourn time has been above the target for at least an interval.
+func (c *codel) doDequeue(q *chunkQueue, now time.Time) (e queuedChunk, ok, okToDrop bool) {
+	e, ok = q.popEntry()
+	if !ok {
+		c.firstAboveTime = time.Time{}
+		return e, false, false
+	}
+
+	// a queue of less than a packet is no standing queue
+	_, bytes := q.size()
+	if now.Sub(e.enqueuedAt) < c.target || bytes <= defaultMTU {
+		c.firstAboveTime = time.Time{}
+		return e, true, false
+	}
+
+	if c.firstAboveTime.IsZero() {
+		c.firstAboveTime = now.Add(c.interval)
+		return e, true, false
+	}
+	return e, true, !now.Before(c.firstAboveTime)
+}
+
+// controlLaw returns the time of the next drop
+func (c *codel) controlLaw(t time.Time) time.Time {
+	return t.Add(time.Duration(float64(c.interval) / math.Sqrt(float64(c.count))))
+}
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoDelQueue(t *testing.T) {
+	start := time.Now()
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := NewCoDelQueue(CoDelQueueTarget(0))
+		assert.ErrorIs(t, err, errInvalidCoDelTimes, "should fail")
+		_, err = NewCoDelQueue(CoDelQueueInterval(-time.Second))
+		assert.ErrorIs(t, err, errInvalidCoDelTimes, "should fail")
+	})
+
+	t.Run("NoStandingQueue", func(t *testing.T) {
+		q, err := NewCoDelQueue()
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		// each chunk leaves 1ms after it arrived
+		for i := 0; i < 1000; i++ {
+			now := start.Add(time.Duration(i) * time.Millisecond)
+			q.Enqueue(newQueueTestChunk(1234, 1200), now)
+			q.Dequeue(now.Add(time.Millisecond))
+		}
+
+		stats := q.Stats()
+		assert.Equal(t, uint64(1000), stats.Dequeued)
+		assert.Equal(t, uint64(0), stats.AQMDrops, "should not drop")
+		assert.Equal(t, time.Millisecond, stats.MaxSojourn)
+	})
+
+	t.Run("StandingQueue", func(t *testing.T) {
+		q, err := NewCoDelQueue(CoDelQueueTarget(5*time.Millisecond), CoDelQueueInterval(100*time.Millisecond))
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		for i := 0; i < 100; i++ {
+			q.Enqueue(newQueueTestChunk(1234, 1200), start)
+		}
+
+		// a chunk leaves every 10ms, so the sojourn time is above the target
+		// from the first one on
+		var dropTimes []time.Duration
+		var drops uint64
+		for elapsed := 10 * time.Millisecond; q.Peek() != nil; elapsed += 10 * time.Millisecond {
+			q.Dequeue(start.Add(elapsed))
+			if stats := q.Stats(); stats.AQMDrops > drops {
+				dropTimes = append(dropTimes, elapsed)
+				drops = stats.AQMDrops
+			}
+		}
+
+		stats := q.Stats()
+		assert.Equal(t, uint64(100), stats.Dequeued+stats.AQMDrops)
+		if assert.GreaterOrEqual(t, len(dropTimes), 3) {
+			// the first drop after an interval above the target, then
+			// every interval/sqrt(count)
+			assert.Equal(t, []time.Duration{
+				110 * time.Millisecond,
+				210 * time.Millisecond,
+				290 * time.Millisecond,
+			}, dropTimes[:3])
+		}
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		q, err := NewCoDelQueue(CoDelQueueLimit(2))
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		for i := 0; i < 3; i++ {
+			q.Enqueue(newQueueTestChunk(1234, 1200), start)
+		}
+		assert.Equal(t, uint64(1), q.Stats().TailDrops)
+	})
+}
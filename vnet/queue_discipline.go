@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"sync"
+	"time"
+)
+
+// QueueDiscipline decides which chunks wait in a queue, which are dropped,
+// and in which order they leave, like a qdisc of Linux. Routers
+// (RouterConfig.QueueDiscipline), Links (LinkProfile.Queue) and
+// TokenBucketFilters (TBFQueueDiscipline) hold the chunks waiting to be sent
+// at their rate in one. Implementations must be safe for concurrent use.
+type QueueDiscipline interface {
+	// Enqueue adds a chunk arriving at now. It returns false if the chunk
+	// was dropped instead.
+	Enqueue(c Chunk, now time.Time) bool
+	// Dequeue removes the chunk that leaves at now, if any. Chunks may also
+	// be dropped on the way out.
+	Dequeue(now time.Time) (Chunk, bool)
+	// Peek returns the chunk that leaves next unless it is dropped on the
+	// way out, or nil if the queue is empty.
+	Peek() Chunk
+	// Stats returns the statistics of the queue so far
+	Stats() QueueStats
+}
+
+// QueueStats are the statistics of a QueueDiscipline. The sojourn time is
+// the time a chunk waited in the queue until it left.
+type QueueStats struct {
+	// Enqueued is the number of chunks that entered the queue
+	Enqueued uint64
+	// Dequeued is the number of chunks that left the queue
+	Dequeued uint64
+	// TailDrops is the number of chunks dropped because the queue was full
+	TailDrops uint64
+	// AQMDrops is the number of chunks dropped early to signal congestion,
+	// by RED or CoDel
+	AQMDrops uint64
+	// Length is the number of chunks waiting
+	Length int
+	// Bytes is the size of the payloads of the chunks waiting
+	Bytes int
+	// LastSojourn is the sojourn time of the last chunk that left
+	LastSojourn time.Duration
+	// MeanSojourn is the mean sojourn time of the chunks that left
+	MeanSojourn time.Duration
+	// MaxSojourn is the longest sojourn time of the chunks that left
+	MaxSojourn time.Duration
+}
+
+// Drops returns the number of chunks dropped for any reason
+func (s QueueStats) Drops() uint64 {
+	return s.TailDrops + s.AQMDrops
+}
+
+// queueStats counts the chunks through a queue
+type queueStats struct {
+	enqueued     uint64
+	dequeued     uint64
+	tailDrops    uint64
+	aqmDrops     uint64
+	lastSojourn  time.Duration
+	maxSojourn   time.Duration
+	totalSojourn time.Duration
+}
+
+// leave records a chunk that left the queue at now
+func (s *queueStats) leave(e queuedChunk, now time.Time) {
+	sojourn := now.Sub(e.enqueuedAt)
+	s.dequeued++
+	s.lastSojourn = sojourn
+	s.totalSojourn += sojourn
+	if sojourn > s.maxSojourn {
+		s.maxSojourn = sojourn
+	}
+}
+
+func (s *queueStats) snapshot(length, bytes int) QueueStats {
+	stats := QueueStats{
+		Enqueued:    s.enqueued,
+		Dequeued:    s.dequeued,
+		TailDrops:   s.tailDrops,
+		AQMDrops:    s.aqmDrops,
+		Length:      length,
+		Bytes:       bytes,
+		LastSojourn: s.lastSojourn,
+		MaxSojourn:  s.maxSojourn,
+	}
+	if s.dequeued > 0 {
+		stats.MeanSojourn = s.totalSojourn / time.Duration(s.dequeued)
+	}
+	return stats
+}
+
+// DropTailQueue is a FIFO queue that drops the chunks arriving while it is
+// full, like the pfifo and bfifo qdiscs of Linux.
+type DropTailQueue struct {
+	queue *chunkQueue // read-only
+	stats queueStats  // requires mutex
+	mutex sync.Mutex
+}
+
+// NewDropTailQueue creates a DropTailQueue that holds up to maxSize chunks,
+// whose payloads add up to less than maxBytes. A limit of 0 or less is
+// unlimited.
+func NewDropTailQueue(maxSize, maxBytes int) *DropTailQueue {
+	return &DropTailQueue{
+		queue: newChunkQueue(maxSize, maxBytes),
+	}
+}
+
+// Enqueue implements QueueDiscipline
+func (q *DropTailQueue) Enqueue(c Chunk, now time.Time) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if !q.queue.pushAt(c, now) {
+		q.stats.tailDrops++
+		return false
+	}
+	q.stats.enqueued++
+	return true
+}
+
+// Dequeue implements QueueDiscipline
+func (q *DropTailQueue) Dequeue(now time.Time) (Chunk, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	e, ok := q.queue.popEntry()
+	if !ok {
+		return nil, false
+	}
+	q.stats.leave(e, now)
+	return e.Chunk, true
+}
+
+// Peek implements QueueDiscipline
+func (q *DropTailQueue) Peek() Chunk {
+	return q.queue.peek()
+}
+
+// Stats implements QueueDiscipline
+func (q *DropTailQueue) Stats() QueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.stats.snapshot(q.queue.size())
+}
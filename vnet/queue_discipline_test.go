@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newQueueTestChunk creates a UDP chunk of the flow from the given source
+// port, with a payload of size bytes
+func newQueueTestChunk(srcPort, size int) *chunkUDP {
+	c := newChunkUDP(&net.UDPAddr{
+		IP:   net.ParseIP("192.168.0.2"),
+		Port: srcPort,
+	}, &net.UDPAddr{
+		IP:   net.ParseIP(demoIP),
+		Port: 5678,
+	})
+	c.userData = make([]byte, size)
+	return c
+}
+
+func TestDropTailQueue(t *testing.T) {
+	start := time.Now()
+
+	t.Run("MaxSize", func(t *testing.T) {
+		q := NewDropTailQueue(2, 0)
+		for i := 0; i < 3; i++ {
+			q.Enqueue(newQueueTestChunk(1234, 100), start)
+		}
+
+		stats := q.Stats()
+		assert.Equal(t, uint64(2), stats.Enqueued)
+		assert.Equal(t, uint64(1), stats.TailDrops)
+		assert.Equal(t, uint64(1), stats.Drops())
+		assert.Equal(t, 2, stats.Length)
+		assert.Equal(t, 200, stats.Bytes)
+	})
+
+	t.Run("MaxBytes", func(t *testing.T) {
+		q := NewDropTailQueue(0, 1500)
+		assert.True(t, q.Enqueue(newQueueTestChunk(1234, 1200), start), "should succeed")
+		assert.False(t, q.Enqueue(newQueueTestChunk(1234, 1200), start), "should fail")
+		assert.True(t, q.Enqueue(newQueueTestChunk(1234, 200), start), "should succeed")
+		assert.Equal(t, 1400, q.Stats().Bytes)
+	})
+
+	t.Run("Sojourn", func(t *testing.T) {
+		q := NewDropTailQueue(0, 0)
+		c0 := newQueueTestChunk(1234, 100)
+		c1 := newQueueTestChunk(1234, 100)
+		q.Enqueue(c0, start)
+		q.Enqueue(c1, start.Add(10*time.Millisecond))
+
+		assert.Equal(t, c0, q.Peek(), "should be the first")
+		c, ok := q.Dequeue(start.Add(30 * time.Millisecond))
+		assert.True(t, ok, "should succeed")
+		assert.Equal(t, c0, c, "should be the first")
+		c, ok = q.Dequeue(start.Add(30 * time.Millisecond))
+		assert.True(t, ok, "should succeed")
+		assert.Equal(t, c1, c, "should be the second")
+		_, ok = q.Dequeue(start.Add(30 * time.Millisecond))
+		assert.False(t, ok, "should be empty")
+		assert.Nil(t, q.Peek(), "should be empty")
+
+		stats := q.Stats()
+		assert.Equal(t, uint64(2), stats.Dequeued)
+		assert.Equal(t, 0, stats.Length)
+		assert.Equal(t, 20*time.Millisecond, stats.LastSojourn)
+		assert.Equal(t, 25*time.Millisecond, stats.MeanSojourn)
+		assert.Equal(t, 30*time.Millisecond, stats.MaxSojourn)
+	})
+}
@@ -0,0 +1,242 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultFairQueueLimit = 10240
+
+var errInvalidQuantum = errors.New("quantum must be positive")
+
+// FairQueue is a queue of a FIFO per flow, which it serves in turn by
+// deficit round robin, so that each flow gets the same share of the link
+// in bytes, like the fq_codel qdisc of Linux. A flow is the 5-tuple of a
+// chunk: network, source and destination address and port. When the queue
+// is full, the oldest chunk of the flow holding the most bytes is dropped.
+// Each flow can be managed by CoDel (FairQueueCoDel).
+type FairQueue struct {
+	flows   map[string]*fairFlow // requires mutex
+	active  []*fairFlow          // requires mutex, in the order they are served
+	limit   int                  // read-only
+	quantum int                  // read-only
+	codel   *codel               // read-only, template of the CoDel of each flow
+	length  int                  // requires mutex
+	stats   queueStats           // requires mutex
+	mutex   sync.Mutex
+}
+
+type fairFlow struct {
+	key     string
+	queue   *chunkQueue
+	deficit int    // bytes the flow may send in this round
+	codel   *codel // nil unless CoDel is enabled
+}
+
+// FairQueueOption is the option type to configure a FairQueue
+type FairQueueOption func(*FairQueue)
+
+// FairQueueLimit sets the number of chunks the queue holds at most, across
+// all flows. Defaults to 10240.
+func FairQueueLimit(maxSize int) FairQueueOption {
+	return func(q *FairQueue) {
+		q.limit = maxSize
+	}
+}
+
+// FairQueueQuantum sets the number of bytes each flow sends in a round.
+// Defaults to 1500.
+func FairQueueQuantum(bytes int) FairQueueOption {
+	return func(q *FairQueue) {
+		q.quantum = bytes
+	}
+}
+
+// FairQueueCoDel manages the queue of each flow by CoDel, with the given
+// target and interval (see CoDelQueue).
+func FairQueueCoDel(target, interval time.Duration) FairQueueOption {
+	return func(q *FairQueue) {
+		q.codel = &codel{
+			target:   target,
+			interval: interval,
+		}
+	}
+}
+
+// NewFairQueue creates a new FairQueue
+func NewFairQueue(opts ...FairQueueOption) (*FairQueue, error) {
+	q := &FairQueue{
+		flows:   map[string]*fairFlow{},
+		limit:   defaultFairQueueLimit,
+		quantum: defaultMTU,
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	if q.quantum <= 0 {
+		return nil, fmt.Errorf("%w: %d", errInvalidQuantum, q.quantum)
+	}
+	if q.codel != nil {
+		if err := q.codel.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// flowKey returns the 5-tuple of a chunk
+func flowKey(c Chunk) string {
+	return c.Network() + " " + c.SourceAddr().String() + " " + c.DestinationAddr().String()
+}
+
+// Enqueue implements QueueDiscipline
+func (q *FairQueue) Enqueue(c Chunk, now time.Time) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	key := flowKey(c)
+	flow, ok := q.flows[key]
+	if !ok {
+		flow = &fairFlow{
+			key:     key,
+			queue:   newChunkQueue(0, 0),
+			deficit: q.quantum,
+		}
+		if q.codel != nil {
+			codel := *q.codel
+			flow.codel = &codel
+		}
+		q.flows[key] = flow
+		q.active = append(q.active, flow)
+	}
+
+	flow.queue.pushAt(c, now)
+	q.length++
+	q.stats.enqueued++
+
+	if q.limit > 0 && q.length > q.limit {
+		q.dropFromFattestFlow()
+	}
+	return true
+}
+
+// dropFromFattestFlow drops the oldest chunk of the flow holding the most
+// bytes.
+// caller must hold the mutex
+func (q *FairQueue) dropFromFattestFlow() {
+	var fattest *fairFlow
+	maxBytes := -1
+	for _, flow := range q.active {
+		if _, bytes := flow.queue.size(); bytes > maxBytes {
+			fattest, maxBytes = flow, bytes
+		}
+	}
+
+	fattest.queue.popEntry()
+	q.length--
+	q.stats.tailDrops++
+	if length, _ := fattest.queue.size(); length == 0 {
+		q.removeFlow(fattest)
+	}
+}
+
+// caller must hold the mutex
+func (q *FairQueue) removeFlow(flow *fairFlow) {
+	delete(q.flows, flow.key)
+	for i, other := range q.active {
+		if other == flow {
+			q.active = append(q.active[:i], q.active[i+1:]...)
+			return
+		}
+	}
+}
+
+// Dequeue implements QueueDiscipline
+func (q *FairQueue) Dequeue(now time.Time) (Chunk, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for len(q.active) > 0 {
+		flow := q.active[0]
+		head := flow.queue.peek()
+		if head == nil {
+			q.removeFlow(flow)
+			continue
+		}
+
+		// a flow that used up its share goes to the back for the next round
+		if flow.deficit < len(head.UserData()) {
+			flow.deficit += q.quantum
+			q.active = append(q.active[1:], flow)
+			continue
+		}
+
+		e, ok := q.dequeueFlow(flow, now)
+		if length, _ := flow.queue.size(); length == 0 {
+			q.removeFlow(flow)
+		}
+		if !ok {
+			continue // CoDel dropped the rest of the flow
+		}
+
+		flow.deficit -= len(e.UserData())
+		q.length--
+		q.stats.leave(e, now)
+		return e.Chunk, true
+	}
+	return nil, false
+}
+
+// caller must hold the mutex
+func (q *FairQueue) dequeueFlow(flow *fairFlow, now time.Time) (queuedChunk, bool) {
+	if flow.codel == nil {
+		return flow.queue.popEntry()
+	}
+	return flow.codel.dequeue(flow.queue, now, func() {
+		q.length--
+		q.stats.aqmDrops++
+	})
+}
+
+// Peek implements QueueDiscipline
+func (q *FairQueue) Peek() Chunk {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	// the next chunk is the one of the first flow, in the order they are
+	// served, that needs the fewest rounds to make up its deficit
+	var next Chunk
+	minRounds := -1
+	for _, flow := range q.active {
+		head := flow.queue.peek()
+		if head == nil {
+			continue
+		}
+		rounds := 0
+		if missing := len(head.UserData()) - flow.deficit; missing > 0 {
+			rounds = (missing + q.quantum - 1) / q.quantum
+		}
+		if minRounds < 0 || rounds < minRounds {
+			next, minRounds = head, rounds
+		}
+	}
+	return next
+}
+
+// Stats implements QueueDiscipline
+func (q *FairQueue) Stats() QueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var bytes int
+	for _, flow := range q.active {
+		_, flowBytes := flow.queue.size()
+		bytes += flowBytes
+	}
+	return q.stats.snapshot(q.length, bytes)
+}
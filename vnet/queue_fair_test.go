@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFairQueue(t *testing.T) {
+	start := time.Now()
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := NewFairQueue(FairQueueQuantum(0))
+		assert.ErrorIs(t, err, errInvalidQuantum, "should fail")
+		_, err = NewFairQueue(FairQueueCoDel(0, time.Second))
+		assert.ErrorIs(t, err, errInvalidCoDelTimes, "should fail")
+	})
+
+	t.Run("FairShare", func(t *testing.T) {
+		q, err := NewFairQueue()
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		// a flow of large chunks, and one of small chunks
+		for i := 0; i < 20; i++ {
+			q.Enqueue(newQueueTestChunk(1000, 1000), start)
+			q.Enqueue(newQueueTestChunk(2000, 250), start)
+		}
+
+		bytes := map[int]int{}
+		for i := 0; i < 15; i++ {
+			next := q.Peek()
+			c, ok := q.Dequeue(start)
+			if !assert.True(t, ok, "should succeed") {
+				return
+			}
+			assert.Equal(t, next, c, "should peek the next chunk")
+			bytes[c.SourceAddr().(*net.UDPAddr).Port] += len(c.UserData()) //nolint:forcetypeassert
+		}
+		assert.InDelta(t, bytes[1000], bytes[2000], defaultMTU, "should share the bytes")
+	})
+
+	t.Run("DropFromFattestFlow", func(t *testing.T) {
+		q, err := NewFairQueue(FairQueueLimit(5))
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		fat := make([]Chunk, 4)
+		for i := range fat {
+			fat[i] = newQueueTestChunk(1000, 1000)
+			q.Enqueue(fat[i], start)
+		}
+		assert.True(t, q.Enqueue(newQueueTestChunk(2000, 100), start), "should succeed")
+		assert.True(t, q.Enqueue(newQueueTestChunk(2000, 100), start), "should succeed")
+
+		stats := q.Stats()
+		assert.Equal(t, uint64(1), stats.TailDrops)
+		assert.Equal(t, 5, stats.Length)
+		assert.Equal(t, 3200, stats.Bytes)
+
+		c, ok := q.Dequeue(start)
+		assert.True(t, ok, "should succeed")
+		assert.Equal(t, fat[1], c, "should have dropped the oldest chunk of the fat flow")
+	})
+
+	t.Run("CoDel", func(t *testing.T) {
+		q, err := NewFairQueue(FairQueueCoDel(5*time.Millisecond, 100*time.Millisecond))
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		for i := 0; i < 100; i++ {
+			q.Enqueue(newQueueTestChunk(1000, 1200), start)
+			q.Enqueue(newQueueTestChunk(2000, 1200), start)
+		}
+		for elapsed := 10 * time.Millisecond; q.Peek() != nil; elapsed += 10 * time.Millisecond {
+			q.Dequeue(start.Add(elapsed))
+		}
+
+		stats := q.Stats()
+		assert.Greater(t, stats.AQMDrops, uint64(0), "should drop from the standing queues")
+		assert.Equal(t, uint64(200), stats.Dequeued+stats.AQMDrops)
+		assert.Equal(t, 0, stats.Length)
+		assert.Equal(t, 0, stats.Bytes)
+	})
+}
@@ -0,0 +1,188 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultREDLimit          = 1000
+	defaultREDMinThreshold   = 5
+	defaultREDMaxThreshold   = 15
+	defaultREDMaxProbability = 10
+	defaultREDWeight         = 0.002
+)
+
+var (
+	errInvalidREDThresholds = errors.New("RED thresholds must satisfy 0 <= min < max")
+	errInvalidREDWeight     = errors.New("RED weight must be within (0, 1]")
+)
+
+// REDQueue is a FIFO queue with Random Early Detection, like the red qdisc
+// of Linux. It tracks the average length of the queue, and drops arriving
+// chunks with a probability that grows from 0 at the min threshold to the
+// max probability at the max threshold. Above the max threshold, every
+// arriving chunk is dropped.
+type REDQueue struct {
+	queue          *chunkQueue // read-only
+	minThreshold   float64     // read-only, chunks
+	maxThreshold   float64     // read-only, chunks
+	maxProbability float64     // read-only, percent
+	weight         float64     // read-only
+	avg            float64     // requires mutex, average length
+	count          int         // requires mutex, chunks since the last drop
+	stats          queueStats  // requires mutex
+	rand           *rand.Rand  // requires mutex
+	mutex          sync.Mutex
+}
+
+// REDQueueOption is the option type to configure a REDQueue
+type REDQueueOption func(*REDQueue)
+
+// REDQueueLimit sets the number of chunks the queue holds at most. Defaults
+// to 1000.
+func REDQueueLimit(maxSize int) REDQueueOption {
+	return func(q *REDQueue) {
+		q.queue = newChunkQueue(maxSize, 0)
+	}
+}
+
+// REDQueueThresholds sets the average queue lengths, in chunks, between
+// which chunks are dropped early. Default to 5 and 15.
+func REDQueueThresholds(minThreshold, maxThreshold int) REDQueueOption {
+	return func(q *REDQueue) {
+		q.minThreshold = float64(minThreshold)
+		q.maxThreshold = float64(maxThreshold)
+	}
+}
+
+// REDQueueMaxProbability sets the percentage of chunks dropped at the max
+// threshold. Defaults to 10.
+func REDQueueMaxProbability(percent float64) REDQueueOption {
+	return func(q *REDQueue) {
+		q.maxProbability = percent
+	}
+}
+
+// REDQueueWeight sets the weight of the current queue length in the
+// average, which is updated on each arriving chunk. Defaults to 0.002.
+func REDQueueWeight(weight float64) REDQueueOption {
+	return func(q *REDQueue) {
+		q.weight = weight
+	}
+}
+
+// REDQueueRand sets the random number generator that decides which chunks
+// are dropped, for a reproducible run. It must not be shared. Defaults to a
+// generator seeded from the system clock.
+func REDQueueRand(rng *rand.Rand) REDQueueOption {
+	return func(q *REDQueue) {
+		q.rand = randOrSeeded(rng)
+	}
+}
+
+// NewREDQueue creates a new REDQueue
+func NewREDQueue(opts ...REDQueueOption) (*REDQueue, error) {
+	q := &REDQueue{
+		queue:          newChunkQueue(defaultREDLimit, 0),
+		minThreshold:   defaultREDMinThreshold,
+		maxThreshold:   defaultREDMaxThreshold,
+		maxProbability: defaultREDMaxProbability,
+		weight:         defaultREDWeight,
+		count:          -1,
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	if q.minThreshold < 0 || q.minThreshold >= q.maxThreshold {
+		return nil, fmt.Errorf("%w: %v, %v", errInvalidREDThresholds, q.minThreshold, q.maxThreshold)
+	}
+	if q.maxProbability < 0 || q.maxProbability > 100 {
+		return nil, fmt.Errorf("%w: %v", errInvalidPercentage, q.maxProbability)
+	}
+	if q.weight <= 0 || q.weight > 1 {
+		return nil, fmt.Errorf("%w: %v", errInvalidREDWeight, q.weight)
+	}
+	if q.rand == nil {
+		q.rand = randOrSeeded(nil)
+	}
+	return q, nil
+}
+
+// Enqueue implements QueueDiscipline
+func (q *REDQueue) Enqueue(c Chunk, now time.Time) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	length, _ := q.queue.size()
+	q.avg = (1-q.weight)*q.avg + q.weight*float64(length)
+
+	if q.dropEarly() {
+		q.stats.aqmDrops++
+		return false
+	}
+	if !q.queue.pushAt(c, now) {
+		q.stats.tailDrops++
+		return false
+	}
+	q.stats.enqueued++
+	return true
+}
+
+// dropEarly decides whether to drop an arriving chunk, spacing the drops
+// out evenly as the original RED does.
+// caller must hold the mutex
+func (q *REDQueue) dropEarly() bool {
+	switch {
+	case q.avg < q.minThreshold:
+		q.count = -1
+		return false
+	case q.avg >= q.maxThreshold:
+		q.count = 0
+		return true
+	}
+
+	q.count++
+	pb := q.maxProbability / 100 * (q.avg - q.minThreshold) / (q.maxThreshold - q.minThreshold)
+	pa := 1.0
+	if d := 1 - float64(q.count)*pb; d > 0 {
+		pa = pb / d
+	}
+	if q.rand.Float64() < pa {
+		q.count = 0
+		return true
+	}
+	return false
+}
+
+// Dequeue implements QueueDiscipline
+func (q *REDQueue) Dequeue(now time.Time) (Chunk, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	e, ok := q.queue.popEntry()
+	if !ok {
+		return nil, false
+	}
+	q.stats.leave(e, now)
+	return e.Chunk, true
+}
+
+// Peek implements QueueDiscipline
+func (q *REDQueue) Peek() Chunk {
+	return q.queue.peek()
+}
+
+// Stats implements QueueDiscipline
+func (q *REDQueue) Stats() QueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.stats.snapshot(q.queue.size())
+}
@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestREDQueue(t *testing.T) {
+	start := time.Now()
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := NewREDQueue(REDQueueThresholds(10, 10))
+		assert.ErrorIs(t, err, errInvalidREDThresholds, "should fail")
+		_, err = NewREDQueue(REDQueueWeight(0))
+		assert.ErrorIs(t, err, errInvalidREDWeight, "should fail")
+		_, err = NewREDQueue(REDQueueMaxProbability(101))
+		assert.ErrorIs(t, err, errInvalidPercentage, "should fail")
+	})
+
+	t.Run("Thresholds", func(t *testing.T) {
+		// the average is the current length, and the drop probability
+		// reaches 1 right above the min threshold
+		q, err := NewREDQueue(
+			REDQueueThresholds(2, 4),
+			REDQueueMaxProbability(100),
+			REDQueueWeight(1),
+		)
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		for i := 0; i < 10; i++ {
+			q.Enqueue(newQueueTestChunk(1234, 100), start)
+		}
+
+		stats := q.Stats()
+		assert.Equal(t, 3, stats.Length, "should drop early above the min threshold")
+		assert.Equal(t, uint64(7), stats.AQMDrops)
+		assert.Equal(t, uint64(0), stats.TailDrops)
+	})
+
+	t.Run("EarlyDrops", func(t *testing.T) {
+		q, err := NewREDQueue(
+			REDQueueThresholds(0, 20),
+			REDQueueMaxProbability(10),
+			REDQueueWeight(1),
+			REDQueueRand(rand.New(rand.NewSource(1))), //nolint:gosec
+		)
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		// hold the queue at 10 chunks, with a drop probability of 5%; the
+		// drops are spread evenly, one within every 1/0.05 chunks, which
+		// drops about one in 10
+		for i := 0; i < 10; i++ {
+			q.Enqueue(newQueueTestChunk(1234, 100), start)
+		}
+		sent := 10000
+		for i := 0; i < sent; i++ {
+			if q.Enqueue(newQueueTestChunk(1234, 100), start) {
+				q.Dequeue(start)
+			}
+		}
+
+		stats := q.Stats()
+		assert.InDelta(t, 0.1, float64(stats.AQMDrops)/float64(sent), 0.01, "should drop one in 10")
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		q, err := NewREDQueue(REDQueueLimit(3), REDQueueThresholds(10, 20))
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		for i := 0; i < 5; i++ {
+			q.Enqueue(newQueueTestChunk(1234, 100), start)
+		}
+		assert.Equal(t, uint64(2), q.Stats().TailDrops)
+	})
+}
@@ -0,0 +1,230 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mahimahiPacketSize is the size of a packet delivery opportunity of a
+// Mahimahi trace, in bytes
+const mahimahiPacketSize = 1500
+
+var (
+	errEmptyRateTrace       = errors.New("rate trace is empty")
+	errInvalidRateTrace     = errors.New("invalid rate trace")
+	errInvalidTraceInterval = errors.New("rate trace interval must be positive")
+	errInvalidTimeScale     = errors.New("rate trace time scale must be positive")
+)
+
+// RateTrace is a bandwidth that changes over time, recorded from a real
+// link like an LTE or WiFi connection. A TokenBucketFilter driven by a
+// trace (TBFTrace) refills its tokens at the rate of the trace.
+type RateTrace struct {
+	steps     []rateStep    // read-only, in the order of time
+	duration  time.Duration // read-only
+	totalBits float64       // read-only
+	loop      bool          // read-only
+	timeScale float64       // read-only
+}
+
+// rateStep is a constant rate from start on, until the next step
+type rateStep struct {
+	start time.Duration
+	rate  float64 // bits per second
+	bits  float64 // bits delivered before start
+}
+
+// RateTraceOption is the option type to configure a RateTrace
+type RateTraceOption func(*RateTrace)
+
+// RateTraceLoop sets whether the trace starts over once it ends. Otherwise
+// the last rate holds after the end. Defaults to true.
+func RateTraceLoop(loop bool) RateTraceOption {
+	return func(t *RateTrace) {
+		t.loop = loop
+	}
+}
+
+// RateTraceTimeScale stretches the trace in time: with a scale of 2, every
+// rate lasts twice as long as recorded. Defaults to 1.
+func RateTraceTimeScale(scale float64) RateTraceOption {
+	return func(t *RateTrace) {
+		t.timeScale = scale
+	}
+}
+
+// NewRateTrace creates a RateTrace from a series of rates in bits per
+// second, each lasting for the interval.
+func NewRateTrace(interval time.Duration, rates []int, opts ...RateTraceOption) (*RateTrace, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("%w: %v", errInvalidTraceInterval, interval)
+	}
+
+	steps := make([]rateStep, 0, len(rates))
+	for i, rate := range rates {
+		if rate < 0 {
+			return nil, fmt.Errorf("%w: negative rate %d", errInvalidRateTrace, rate)
+		}
+		steps = append(steps, rateStep{start: time.Duration(i) * interval, rate: float64(rate)})
+	}
+	return newRateTrace(steps, time.Duration(len(rates))*interval, opts...)
+}
+
+// ParseKbpsTrace reads a RateTrace from a series of rates in kbit/s, one per
+// line, each lasting for the interval. Empty lines and lines starting with
+// '#' are skipped.
+func ParseKbpsTrace(r io.Reader, interval time.Duration, opts ...RateTraceOption) (*RateTrace, error) {
+	var rates []int
+	err := scanTraceLines(r, func(line string) error {
+		kbps, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return err
+		}
+		rates = append(rates, int(kbps*KBit))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewRateTrace(interval, rates, opts...)
+}
+
+// ParseMahimahiTrace reads a RateTrace in the format of the Mahimahi link
+// shell: each line is the time in milliseconds at which a packet of 1500
+// bytes can be delivered. The trace lasts until the last of these times, or
+// one millisecond if they are all zero.
+func ParseMahimahiTrace(r io.Reader, opts ...RateTraceOption) (*RateTrace, error) {
+	var opportunities []int
+	err := scanTraceLines(r, func(line string) error {
+		ms, err := strconv.Atoi(line)
+		if err != nil {
+			return err
+		}
+		if ms < 0 || (len(opportunities) > 0 && ms < opportunities[len(opportunities)-1]) {
+			return fmt.Errorf("%w: time %d is out of order", errInvalidRateTrace, ms)
+		}
+		opportunities = append(opportunities, ms)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(opportunities) == 0 {
+		return nil, errEmptyRateTrace
+	}
+
+	// the opportunities of each millisecond make a step; the one at time
+	// ms is delivered within (ms-1, ms], and those at time 0 within the
+	// first millisecond
+	duration := opportunities[len(opportunities)-1]
+	if duration == 0 {
+		duration = 1
+	}
+	counts := make([]int, duration)
+	for _, ms := range opportunities {
+		if ms > 0 {
+			counts[ms-1]++
+		} else {
+			counts[0]++
+		}
+	}
+	rates := make([]int, duration)
+	for i, count := range counts {
+		rates[i] = count * mahimahiPacketSize * 8 * 1000
+	}
+	return NewRateTrace(time.Millisecond, rates, opts...)
+}
+
+// scanTraceLines calls parse for each line that is neither empty nor a
+// comment.
+func scanTraceLines(r io.Reader, parse func(line string) error) error {
+	scanner := bufio.NewScanner(r)
+	for lineNr := 1; scanner.Scan(); lineNr++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := parse(line); err != nil {
+			return fmt.Errorf("%w: line %d: %s", errInvalidRateTrace, lineNr, err.Error())
+		}
+	}
+	return scanner.Err()
+}
+
+func newRateTrace(steps []rateStep, duration time.Duration, opts ...RateTraceOption) (*RateTrace, error) {
+	if len(steps) == 0 {
+		return nil, errEmptyRateTrace
+	}
+
+	t := &RateTrace{
+		steps:     steps,
+		duration:  duration,
+		loop:      true,
+		timeScale: 1,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if t.timeScale <= 0 {
+		return nil, fmt.Errorf("%w: %v", errInvalidTimeScale, t.timeScale)
+	}
+
+	for i := range t.steps {
+		if i > 0 {
+			prev := t.steps[i-1]
+			t.steps[i].bits = prev.bits + prev.rate*(t.steps[i].start-prev.start).Seconds()
+		}
+	}
+	last := t.steps[len(t.steps)-1]
+	t.totalBits = last.bits + last.rate*(duration-last.start).Seconds()
+	return t, nil
+}
+
+// Duration returns the length of the trace, stretched by its time scale
+func (t *RateTrace) Duration() time.Duration {
+	return time.Duration(float64(t.duration) * t.timeScale)
+}
+
+// bits returns the number of bits the trace delivers between two times,
+// since its start.
+func (t *RateTrace) bits(from, to time.Duration) float64 {
+	return t.cumulativeBits(to) - t.cumulativeBits(from)
+}
+
+// cumulativeBits returns the number of bits the trace delivers from its
+// start until elapsed.
+func (t *RateTrace) cumulativeBits(elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+
+	// a stretched trace has the same rates, each for longer
+	at := time.Duration(float64(elapsed) / t.timeScale)
+
+	var bits float64
+	if t.loop {
+		bits = float64(at/t.duration) * t.totalBits
+		at %= t.duration
+	} else if at > t.duration {
+		last := t.steps[len(t.steps)-1]
+		return t.timeScale * (t.totalBits + last.rate*(at-t.duration).Seconds())
+	}
+
+	i := sort.Search(len(t.steps), func(i int) bool {
+		return t.steps[i].start > at
+	}) - 1
+	if i >= 0 {
+		step := t.steps[i]
+		bits += step.bits + step.rate*(at-step.start).Seconds()
+	}
+	return t.timeScale * bits
+}
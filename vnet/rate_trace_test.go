@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateTrace(t *testing.T) {
+	t.Run("Bits", func(t *testing.T) {
+		trace, err := NewRateTrace(time.Second, []int{1000, 3000, 0})
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		assert.Equal(t, 3*time.Second, trace.Duration())
+		assert.InDelta(t, 500, trace.bits(0, 500*time.Millisecond), 1e-6)
+		assert.InDelta(t, 2000, trace.bits(500*time.Millisecond, 1500*time.Millisecond), 1e-6)
+		assert.InDelta(t, 0, trace.bits(2*time.Second, 3*time.Second), 1e-6)
+		assert.InDelta(t, 0, trace.bits(-time.Second, 0), 1e-6, "should deliver nothing before the start")
+	})
+
+	t.Run("Loop", func(t *testing.T) {
+		trace, err := NewRateTrace(time.Second, []int{1000, 3000})
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		assert.InDelta(t, 4000, trace.bits(2*time.Second, 4*time.Second), 1e-6, "should start over")
+		assert.InDelta(t, 3*4000+1000, trace.bits(0, 7*time.Second), 1e-6)
+	})
+
+	t.Run("NoLoop", func(t *testing.T) {
+		trace, err := NewRateTrace(time.Second, []int{1000, 3000}, RateTraceLoop(false))
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		assert.InDelta(t, 6000, trace.bits(2*time.Second, 4*time.Second), 1e-6, "should hold the last rate")
+	})
+
+	t.Run("TimeScale", func(t *testing.T) {
+		trace, err := NewRateTrace(time.Second, []int{1000, 3000}, RateTraceTimeScale(2))
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		assert.Equal(t, 4*time.Second, trace.Duration())
+		assert.InDelta(t, 2000, trace.bits(0, 2*time.Second), 1e-6, "should last twice as long")
+		assert.InDelta(t, 3000, trace.bits(2*time.Second, 3*time.Second), 1e-6, "should keep the rate")
+
+		_, err = NewRateTrace(time.Second, []int{1000}, RateTraceTimeScale(0))
+		assert.ErrorIs(t, err, errInvalidTimeScale, "should fail")
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := NewRateTrace(0, []int{1000})
+		assert.ErrorIs(t, err, errInvalidTraceInterval, "should fail")
+		_, err = NewRateTrace(time.Second, nil)
+		assert.ErrorIs(t, err, errEmptyRateTrace, "should fail")
+		_, err = NewRateTrace(time.Second, []int{1000, -1})
+		assert.ErrorIs(t, err, errInvalidRateTrace, "should fail")
+	})
+
+	t.Run("Kbps", func(t *testing.T) {
+		trace, err := ParseKbpsTrace(strings.NewReader("# LTE\n1000\n\n2500.5\n"), 100*time.Millisecond)
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		assert.Equal(t, 200*time.Millisecond, trace.Duration())
+		assert.InDelta(t, 100000+250050, trace.bits(0, 200*time.Millisecond), 1e-6)
+
+		_, err = ParseKbpsTrace(strings.NewReader("1000\nfast\n"), time.Second)
+		assert.ErrorIs(t, err, errInvalidRateTrace, "should fail")
+		_, err = ParseKbpsTrace(strings.NewReader("# nothing\n"), time.Second)
+		assert.ErrorIs(t, err, errEmptyRateTrace, "should fail")
+	})
+
+	t.Run("Mahimahi", func(t *testing.T) {
+		// two packets in the first millisecond, none in the second, one in
+		// the third
+		trace, err := ParseMahimahiTrace(strings.NewReader("1\n1\n3\n"))
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+
+		assert.Equal(t, 3*time.Millisecond, trace.Duration())
+		assert.InDelta(t, 2*1500*8, trace.bits(0, time.Millisecond), 1e-6)
+		assert.InDelta(t, 0, trace.bits(time.Millisecond, 2*time.Millisecond), 1e-6)
+		assert.InDelta(t, 3*1500*8, trace.bits(0, 3*time.Millisecond), 1e-6)
+
+		// opportunities at time 0 count towards the first millisecond
+		trace, err = ParseMahimahiTrace(strings.NewReader("0\n0\n2\n"))
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+		assert.Equal(t, 2*time.Millisecond, trace.Duration())
+		assert.InDelta(t, 2*1500*8, trace.bits(0, time.Millisecond), 1e-6)
+		assert.InDelta(t, 3*1500*8, trace.bits(0, 2*time.Millisecond), 1e-6)
+
+		trace, err = ParseMahimahiTrace(strings.NewReader("0\n"))
+		if !assert.NoError(t, err, "should succeed") {
+			return
+		}
+		assert.Equal(t, time.Millisecond, trace.Duration())
+		assert.InDelta(t, 1500*8, trace.bits(0, time.Millisecond), 1e-6)
+
+		_, err = ParseMahimahiTrace(strings.NewReader("3\n1\n"))
+		assert.ErrorIs(t, err, errInvalidRateTrace, "should fail")
+		_, err = ParseMahimahiTrace(strings.NewReader("-1\n2\n"))
+		assert.ErrorIs(t, err, errInvalidRateTrace, "should fail")
+		_, err = ParseMahimahiTrace(strings.NewReader("# no times\n"))
+		assert.ErrorIs(t, err, errEmptyRateTrace, "should fail")
+	})
+}
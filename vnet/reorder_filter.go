@@ -0,0 +1,182 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const defaultReorderTimeout = 100 * time.Millisecond
+
+var errInvalidReorderGap = errors.New("reorder gap must be at least 1")
+
+// ReorderFilter is a wrapper around NICs, that holds back some of the
+// packets passed to onInboundChunk until gap later packets have been passed
+// on, so that they arrive out of order, like netem reorder. A packet held
+// back for longer than the timeout, because no more packets follow, is
+// passed on then.
+type ReorderFilter struct {
+	NIC
+	chance  float64       // read-only, percent
+	gap     int           // read-only
+	timeout time.Duration // read-only
+	clock   Clock         // read-only
+	held    []*heldChunk  // requires mutex, in the order they were held
+	rand    *rand.Rand    // requires mutex
+	mutex   sync.Mutex
+	wg      sync.WaitGroup // timeout goroutines
+}
+
+type heldChunk struct {
+	Chunk
+	remaining int           // packets to pass on before this one
+	released  chan struct{} // closed when passed on before the timeout
+}
+
+// ReorderFilterOption is the option type to configure a ReorderFilter
+type ReorderFilterOption func(*ReorderFilter)
+
+// ReorderFilterGap sets the number of packets passed on before a held back
+// packet. Defaults to 1, which swaps the packet with the next one.
+func ReorderFilterGap(gap int) ReorderFilterOption {
+	return func(f *ReorderFilter) {
+		f.gap = gap
+	}
+}
+
+// ReorderFilterTimeout sets how long a packet is held back at most.
+// Defaults to 100ms.
+func ReorderFilterTimeout(timeout time.Duration) ReorderFilterOption {
+	return func(f *ReorderFilter) {
+		f.timeout = timeout
+	}
+}
+
+// ReorderFilterClock sets the clock the timeout is measured with. Defaults
+// to the system clock.
+func ReorderFilterClock(clock Clock) ReorderFilterOption {
+	return func(f *ReorderFilter) {
+		f.clock = clockOrSystem(clock)
+	}
+}
+
+// ReorderFilterRand sets the random number generator that decides which
+// packets are held back, for a reproducible run. It must not be shared.
+// Defaults to a generator seeded from the system clock.
+func ReorderFilterRand(rng *rand.Rand) ReorderFilterOption {
+	return func(f *ReorderFilter) {
+		f.rand = randOrSeeded(rng)
+	}
+}
+
+// NewReorderFilter creates a new ReorderFilter that holds back every packet
+// with a probability of chance/100.
+func NewReorderFilter(nic NIC, chance float64, opts ...ReorderFilterOption) (*ReorderFilter, error) {
+	if chance < 0 || chance > 100 {
+		return nil, fmt.Errorf("%w: %v", errInvalidPercentage, chance)
+	}
+
+	f := &ReorderFilter{
+		NIC:     nic,
+		chance:  chance,
+		gap:     1,
+		timeout: defaultReorderTimeout,
+		clock:   systemClock{},
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.gap < 1 {
+		return nil, fmt.Errorf("%w: %d", errInvalidReorderGap, f.gap)
+	}
+	if f.rand == nil {
+		f.rand = randOrSeeded(nil)
+	}
+	return f, nil
+}
+
+func (f *ReorderFilter) onInboundChunk(c Chunk) {
+	f.mutex.Lock()
+	if f.rand.Float64()*100 < f.chance {
+		held := &heldChunk{
+			Chunk:     c,
+			remaining: f.gap,
+			released:  make(chan struct{}),
+		}
+		f.wg.Add(1)
+		f.held = append(f.held, held)
+		f.mutex.Unlock()
+
+		go f.releaseAfterTimeout(held)
+		return
+	}
+
+	// the chunks held back for long enough follow this one
+	var due []Chunk
+	held := f.held[:0]
+	for _, h := range f.held {
+		if h.remaining--; h.remaining > 0 {
+			held = append(held, h)
+			continue
+		}
+		close(h.released)
+		due = append(due, h.Chunk)
+	}
+	f.held = held
+	f.mutex.Unlock()
+
+	f.NIC.onInboundChunk(c)
+	for _, d := range due {
+		f.NIC.onInboundChunk(d)
+	}
+}
+
+// releaseAfterTimeout passes a held chunk on after the timeout, unless it
+// was passed on before.
+func (f *ReorderFilter) releaseAfterTimeout(h *heldChunk) {
+	defer f.wg.Done()
+
+	timer := f.clock.NewTimer(f.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-h.released:
+		return
+	case <-timer.C():
+	}
+
+	f.mutex.Lock()
+	for i, other := range f.held {
+		if other == h {
+			f.held = append(f.held[:i], f.held[i+1:]...)
+			f.mutex.Unlock()
+
+			f.NIC.onInboundChunk(h.Chunk)
+			return
+		}
+	}
+	f.mutex.Unlock()
+}
+
+// Close passes on the packets held back, and waits for their timeouts to
+// end.
+func (f *ReorderFilter) Close() error {
+	f.mutex.Lock()
+	held := f.held
+	f.held = nil
+	for _, h := range held {
+		close(h.released)
+	}
+	f.mutex.Unlock()
+
+	for _, h := range held {
+		f.NIC.onInboundChunk(h.Chunk)
+	}
+	f.wg.Wait()
+	return nil
+}
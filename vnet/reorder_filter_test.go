@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReorderFilter(t *testing.T) {
+	newFilter := func(t *testing.T, chance float64, opts ...ReorderFilterOption) (*ReorderFilter, func() []int) {
+		t.Helper()
+
+		mnic := newMockNIC(t)
+		f, err := NewReorderFilter(mnic, chance, opts...)
+		assert.NoError(t, err, "should succeed")
+
+		var mutex sync.Mutex
+		var received []int
+		mnic.mockOnInboundChunk = func(c Chunk) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			received = append(received, int(c.UserData()[0]))
+		}
+		return f, func() []int {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return append([]int{}, received...)
+		}
+	}
+
+	send := func(f *ReorderFilter, nrs ...int) {
+		for _, nr := range nrs {
+			f.onInboundChunk(&chunkUDP{userData: []byte{byte(nr)}})
+		}
+	}
+
+	t.Run("NoReorder", func(t *testing.T) {
+		f, received := newFilter(t, 0)
+		send(f, 0, 1, 2, 3)
+		assert.NoError(t, f.Close(), "should succeed")
+		assert.Equal(t, []int{0, 1, 2, 3}, received(), "should keep the order")
+	})
+
+	t.Run("Gap", func(t *testing.T) {
+		// hold back about half of the packets for two others
+		f, received := newFilter(t, 50, ReorderFilterGap(2), ReorderFilterRand(rand.New(rand.NewSource(1)))) //nolint:gosec
+		send(f, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+		assert.NoError(t, f.Close(), "should succeed")
+
+		got := received()
+		assert.ElementsMatch(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, got, "should pass every packet once")
+		assert.NotEqual(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, got, "should reorder")
+	})
+
+	t.Run("Swap", func(t *testing.T) {
+		f, received := newFilter(t, 100)
+		send(f, 0)
+		assert.Equal(t, 0, len(received()), "should hold the packet")
+		f.chance = 0
+		send(f, 1)
+		assert.Equal(t, []int{1, 0}, received(), "should follow the next packet")
+		assert.NoError(t, f.Close(), "should succeed")
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		clock := NewManualClock(time.Now())
+		f, received := newFilter(t, 100, ReorderFilterTimeout(10*time.Millisecond), ReorderFilterClock(clock))
+		send(f, 0)
+		clock.BlockUntil(1)
+		clock.Advance(10 * time.Millisecond)
+		assert.Eventually(t, func() bool {
+			return len(received()) == 1
+		}, time.Second, time.Millisecond, "should pass the packet on after the timeout")
+		assert.NoError(t, f.Close(), "should succeed")
+		assert.Equal(t, []int{0}, received(), "should pass the packet once")
+	})
+
+	t.Run("InvalidGap", func(t *testing.T) {
+		_, err := NewReorderFilter(newMockNIC(t), 10, ReorderFilterGap(0))
+		assert.ErrorIs(t, err, errInvalidReorderGap, "should fail")
+	})
+}
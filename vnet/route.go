@@ -0,0 +1,178 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// maxHops is the number of routers a chunk may be forwarded by before it is
+// dropped, like the TTL of an IP packet. It ends routing loops between peers.
+const maxHops = 64
+
+var (
+	errInvalidRouteDestination = errors.New("invalid route destination")
+	errNextHopNotNeighbor      = errors.New("next hop is neither a parent nor a peer")
+	errRouteNotFound           = errors.New("route not found")
+	errPeerIsSelf              = errors.New("router cannot peer with itself")
+	errAlreadyPeers            = errors.New("routers are already peers")
+)
+
+// Route is an entry of the static routing table of a Router. Chunks to a
+// destination outside of the router's subnet are forwarded to the next hop
+// of the route with the longest matching prefix and, among those, the
+// lowest metric. Chunks that match no route are sent to the first parent
+// router, if there is one.
+type Route struct {
+	// Destination in CIDR notation, like "10.1.0.0/16". "0.0.0.0/0" and
+	// "::/0" are default routes.
+	Destination string
+	// NextHop is a parent router of this router, or a peer added with AddPeer
+	NextHop *Router
+	// Metric is the cost of the route. Lower is preferred.
+	Metric int
+}
+
+type route struct {
+	destination *net.IPNet
+	nextHop     *Router
+	metric      int
+}
+
+// uplink is the link to a parent router, with the NAT of the addresses of
+// our eth0 on the parent's subnet.
+type uplink struct {
+	router *Router
+	nat    *networkAddressTranslator
+}
+
+// AddPeer connects the router with another one. Peers forward chunks to each
+// other as their routing tables tell, without NAT. Peer links may form
+// loops; chunks forwarded more than 64 times are dropped.
+func (r *Router) AddPeer(peer *Router) error {
+	if peer == r {
+		return errPeerIsSelf
+	}
+
+	r.mutex.Lock()
+	for _, p := range r.peers {
+		if p == peer {
+			r.mutex.Unlock()
+			return errAlreadyPeers
+		}
+	}
+	r.peers = append(r.peers, peer)
+	r.mutex.Unlock()
+
+	peer.mutex.Lock()
+	peer.peers = append(peer.peers, r)
+	peer.mutex.Unlock()
+
+	return nil
+}
+
+// AddRoute adds a route to the routing table. The next hop must be a parent
+// or a peer of the router.
+func (r *Router) AddRoute(rt Route) error {
+	_, dst, err := net.ParseCIDR(rt.Destination)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errInvalidRouteDestination, rt.Destination)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.isNeighbor(rt.NextHop) {
+		return errNextHopNotNeighbor
+	}
+
+	r.routes = append(r.routes, &route{
+		destination: dst,
+		nextHop:     rt.NextHop,
+		metric:      rt.Metric,
+	})
+	return nil
+}
+
+// RemoveRoute removes a route added with AddRoute.
+func (r *Router) RemoveRoute(rt Route) error {
+	_, dst, err := net.ParseCIDR(rt.Destination)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errInvalidRouteDestination, rt.Destination)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, entry := range r.routes {
+		if entry.destination.String() == dst.String() && entry.nextHop == rt.NextHop && entry.metric == rt.Metric {
+			r.routes = append(r.routes[:i], r.routes[i+1:]...)
+			return nil
+		}
+	}
+	return errRouteNotFound
+}
+
+// Routes returns the routing table
+func (r *Router) Routes() []Route {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	routes := make([]Route, 0, len(r.routes))
+	for _, entry := range r.routes {
+		routes = append(routes, Route{
+			Destination: entry.destination.String(),
+			NextHop:     entry.nextHop,
+			Metric:      entry.metric,
+		})
+	}
+	return routes
+}
+
+// caller must hold the mutex
+func (r *Router) isNeighbor(router *Router) bool {
+	return r.findUplink(router) != nil || r.isPeer(router)
+}
+
+// caller must hold the mutex
+func (r *Router) isPeer(router *Router) bool {
+	for _, peer := range r.peers {
+		if peer == router {
+			return true
+		}
+	}
+	return false
+}
+
+// caller must hold the mutex
+func (r *Router) findUplink(router *Router) *uplink {
+	for _, up := range r.uplinks {
+		if up.router == router {
+			return up
+		}
+	}
+	return nil
+}
+
+// lookupRoute returns the route to dstIP with the longest prefix and the
+// lowest metric, the first one added on a tie. It returns nil if no route
+// matches.
+// caller must hold the mutex
+func (r *Router) lookupRoute(dstIP net.IP) *route {
+	var best *route
+	var bestLen int
+	for _, entry := range r.routes {
+		if !entry.destination.Contains(dstIP) || !sameFamily(entry.destination.IP, dstIP) {
+			continue
+		}
+
+		prefixLen, _ := entry.destination.Mask.Size()
+		if best == nil || prefixLen > bestLen || (prefixLen == bestLen && entry.metric < best.metric) {
+			best, bestLen = entry, prefixLen
+		}
+	}
+	return best
+}
@@ -0,0 +1,197 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouterRoutes(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	newRouter := func(t *testing.T, cidr string) *Router {
+		t.Helper()
+
+		r, err := NewRouter(&RouterConfig{CIDR: cidr, LoggerFactory: loggerFactory})
+		assert.NoError(t, err, "should succeed")
+		return r
+	}
+
+	newNet := func(t *testing.T, r *Router, ip string) *Net {
+		t.Helper()
+
+		n, err := NewNet(&NetConfig{StaticIPs: []string{ip}})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, r.AddNet(n), "should succeed")
+		return n
+	}
+
+	// exchange sends a datagram from one Net to the other and back, and
+	// returns the source address seen by the receiver.
+	exchange := func(t *testing.T, from, to *Net, toAddr string) (net.Addr, error) {
+		t.Helper()
+
+		conn2, err := to.ListenPacket(udp, toAddr)
+		assert.NoError(t, err, "should succeed")
+		defer func() {
+			assert.NoError(t, conn2.Close(), "should succeed")
+		}()
+
+		conn1, err := from.ListenPacket(udp, "0.0.0.0:0")
+		assert.NoError(t, err, "should succeed")
+		defer func() {
+			assert.NoError(t, conn1.Close(), "should succeed")
+		}()
+
+		dst, err := net.ResolveUDPAddr(udp, toAddr)
+		assert.NoError(t, err, "should succeed")
+		_, err = conn1.WriteTo([]byte("ping"), dst)
+		assert.NoError(t, err, "should succeed")
+
+		buf := make([]byte, 1500)
+		assert.NoError(t, conn2.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+		_, srcAddr, err := conn2.ReadFrom(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = conn2.WriteTo([]byte("pong"), srcAddr)
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, conn1.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+		_, _, err = conn1.ReadFrom(buf)
+		return srcAddr, err
+	}
+
+	t.Run("routing table", func(t *testing.T) {
+		wan := newRouter(t, "1.2.3.0/24")
+		lan := newRouter(t, "192.168.0.0/24")
+		peer := newRouter(t, "10.0.0.0/24")
+		assert.NoError(t, wan.AddRouter(lan), "should succeed")
+
+		assert.ErrorIs(t, lan.AddRoute(Route{Destination: "10.0.0.0", NextHop: wan}), errInvalidRouteDestination, "should fail")
+		assert.ErrorIs(t, lan.AddRoute(Route{Destination: "10.0.0.0/8", NextHop: peer}), errNextHopNotNeighbor, "should fail")
+		assert.ErrorIs(t, lan.AddPeer(lan), errPeerIsSelf, "should fail")
+		assert.NoError(t, lan.AddPeer(peer), "should succeed")
+		assert.ErrorIs(t, peer.AddPeer(lan), errAlreadyPeers, "should fail")
+
+		routes := []Route{
+			{Destination: "0.0.0.0/0", NextHop: wan},
+			{Destination: "10.0.0.0/8", NextHop: wan, Metric: 10},
+			{Destination: "10.0.0.0/8", NextHop: peer, Metric: 5},
+			{Destination: "10.0.0.0/24", NextHop: wan, Metric: 100},
+		}
+		for _, rt := range routes {
+			assert.NoError(t, lan.AddRoute(rt), "should succeed")
+		}
+		assert.Equal(t, routes, lan.Routes(), "should match")
+
+		lookup := func(ip string) *Router {
+			lan.mutex.RLock()
+			defer lan.mutex.RUnlock()
+
+			if rt := lan.lookupRoute(net.ParseIP(ip)); rt != nil {
+				return rt.nextHop
+			}
+			return nil
+		}
+		assert.Equal(t, wan, lookup("10.0.0.1"), "longest prefix should win")
+		assert.Equal(t, peer, lookup("10.1.0.1"), "lowest metric should win")
+		assert.Equal(t, wan, lookup("8.8.8.8"), "should take the default route")
+		assert.Nil(t, lookup("2001:db8::1"), "should not match another family")
+
+		assert.NoError(t, lan.RemoveRoute(routes[2]), "should succeed")
+		assert.Equal(t, wan, lookup("10.1.0.1"), "should match")
+		assert.ErrorIs(t, lan.RemoveRoute(routes[2]), errRouteNotFound, "should fail")
+	})
+
+	t.Run("peers", func(t *testing.T) {
+		isp1 := newRouter(t, "1.1.1.0/24")
+		isp2 := newRouter(t, "2.2.2.0/24")
+		net1 := newNet(t, isp1, "1.1.1.1")
+		net2 := newNet(t, isp2, "2.2.2.2")
+
+		assert.NoError(t, isp1.AddPeer(isp2), "should succeed")
+		assert.NoError(t, isp1.Start(), "should succeed")
+		assert.NoError(t, isp2.Start(), "should succeed")
+		defer func() {
+			assert.NoError(t, isp1.Stop(), "should succeed")
+			assert.NoError(t, isp2.Stop(), "should succeed")
+		}()
+
+		_, err := exchange(t, net1, net2, "2.2.2.2:5678")
+		assert.Error(t, err, "should have no route")
+
+		assert.NoError(t, isp1.AddRoute(Route{Destination: "2.2.2.0/24", NextHop: isp2}), "should succeed")
+		assert.NoError(t, isp2.AddRoute(Route{Destination: "1.1.1.0/24", NextHop: isp1}), "should succeed")
+
+		srcAddr, err := exchange(t, net1, net2, "2.2.2.2:5678")
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, "1.1.1.1", srcAddr.(*net.UDPAddr).IP.String(), "should not be translated") //nolint:forcetypeassert
+	})
+
+	t.Run("multi-homed", func(t *testing.T) {
+		isp1 := newRouter(t, "1.1.1.0/24")
+		isp2 := newRouter(t, "2.2.2.0/24")
+		server1 := newNet(t, isp1, "1.1.1.100")
+		server2 := newNet(t, isp2, "2.2.2.100")
+
+		site := newRouter(t, "192.168.0.0/24")
+		host := newNet(t, site, "192.168.0.2")
+		assert.NoError(t, isp1.AddRouter(site), "should succeed")
+		assert.NoError(t, isp2.AddRouter(site), "should succeed")
+		assert.NoError(t, site.AddRoute(Route{Destination: "2.2.2.0/24", NextHop: isp2}), "should succeed")
+
+		assert.NoError(t, isp1.Start(), "should succeed")
+		assert.NoError(t, isp2.Start(), "should succeed")
+		defer func() {
+			assert.NoError(t, isp1.Stop(), "should succeed")
+			assert.NoError(t, isp2.Stop(), "should succeed")
+		}()
+
+		// each uplink has its own mapped address
+		srcAddr, err := exchange(t, host, server1, "1.1.1.100:5678")
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, "1.1.1.1", srcAddr.(*net.UDPAddr).IP.String(), "should be mapped on isp1") //nolint:forcetypeassert
+
+		srcAddr, err = exchange(t, host, server2, "2.2.2.100:5678")
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, "2.2.2.1", srcAddr.(*net.UDPAddr).IP.String(), "should be mapped on isp2") //nolint:forcetypeassert
+	})
+
+	t.Run("loop", func(t *testing.T) {
+		r1 := newRouter(t, "1.1.1.0/24")
+		r2 := newRouter(t, "2.2.2.0/24")
+		assert.NoError(t, r1.AddPeer(r2), "should succeed")
+		assert.NoError(t, r1.AddRoute(Route{Destination: "0.0.0.0/0", NextHop: r2}), "should succeed")
+		assert.NoError(t, r2.AddRoute(Route{Destination: "0.0.0.0/0", NextHop: r1}), "should succeed")
+
+		var routed int32
+		count := func(Chunk) bool {
+			atomic.AddInt32(&routed, 1)
+			return true
+		}
+		r1.AddChunkFilter(count)
+		r2.AddChunkFilter(count)
+
+		assert.NoError(t, r1.Start(), "should succeed")
+		assert.NoError(t, r2.Start(), "should succeed")
+		defer func() {
+			assert.NoError(t, r1.Stop(), "should succeed")
+			assert.NoError(t, r2.Stop(), "should succeed")
+		}()
+
+		r1.push(newChunkUDP(&net.UDPAddr{IP: net.ParseIP("1.1.1.1"), Port: 1}, &net.UDPAddr{IP: net.ParseIP("9.9.9.9"), Port: 2}))
+		assert.Eventually(t, func() bool {
+			return atomic.LoadInt32(&routed) == maxHops+1
+		}, time.Second, 10*time.Millisecond, "should be dropped after max hops")
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, int32(maxHops+1), atomic.LoadInt32(&routed), "should not be routed anymore")
+	})
+}
@@ -4,6 +4,7 @@
 package vnet
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -17,10 +18,6 @@ import (
 	"github.com/pion/transport/v3"
 )
 
-const (
-	defaultRouterQueueSize = 0 // unlimited
-)
-
 var (
 	errInvalidLocalIPinStaticIPs     = errors.New("invalid local IP in StaticIPs")
 	errLocalIPBeyondStaticIPsSubset  = errors.New("mapped in StaticIPs is beyond subnet")
@@ -30,6 +27,13 @@ var (
 	errStaticIPisBeyondSubnet        = errors.New("static IP is beyond subnet")
 	errAddressSpaceExhausted         = errors.New("address space exhausted")
 	errNoIPAddrEth0                  = errors.New("no IP address is assigned for eth0")
+	errInvalidCIDRv6                 = errors.New("CIDRv6 must be an IPv6 CIDR")
+	errInvalidMTU                    = errors.New("MTU is too small")
+	errNICNotFound                   = errors.New("NIC is not attached to the router")
+	errIPAddressInUse                = errors.New("IP address is in use")
+	errRouterNotChild                = errors.New("router is not a child of this router")
+	errQueueSizeWithDiscipline       = errors.New("QueueSize cannot be combined with QueueDiscipline")
+	errInvalidRouterRate             = errors.New("router rate must not be negative")
 )
 
 // Generate a unique router name
@@ -46,8 +50,15 @@ var assignRouterName = func() func() string { //nolint:gochecknoglobals
 type RouterConfig struct {
 	// Name of router. If not specified, a unique name will be assigned.
 	Name string
-	// CIDR notation, like "192.0.2.0/24"
+	// CIDR notation, like "192.0.2.0/24". An IPv6 CIDR, like "2001:db8::/64",
+	// makes an IPv6-only router.
 	CIDR string
+	// CIDRv6 is an optional IPv6 CIDR, like "2001:db8::/64", which makes the
+	// router dual-stack together with an IPv4 CIDR.
+	// IPv6 addresses are assigned SLAAC-like, from the prefix and the EUI-64
+	// interface identifier of the NIC, if the prefix is /64 or shorter.
+	// IPv6 chunks are routed without NAT.
+	CIDRv6 string
 	// StaticIPs is an array of static IP addresses to be assigned for this router.
 	// If no static IP address is given, the router will automatically assign
 	// an IP address.
@@ -55,14 +66,42 @@ type RouterConfig struct {
 	StaticIPs []string
 	// StaticIP is deprecated. Use StaticIPs.
 	StaticIP string
-	// Internal queue size
+	// QueueSize is the number of chunks the default DropTailQueue holds. It
+	// cannot be combined with QueueDiscipline. 0 is unlimited.
 	QueueSize int
+	// QueueDiscipline holds the chunks arriving at the router while it is
+	// busy forwarding the previous ones at its Rate. Defaults to a
+	// DropTailQueue of QueueSize chunks.
+	QueueDiscipline QueueDiscipline
+	// Rate in bits per second the router forwards chunks at, one after the
+	// other. The chunks wait in the QueueDiscipline meanwhile, and start
+	// their delay once forwarded. 0 is unlimited.
+	Rate int
 	// Effective only when this router has a parent router
 	NATType *NATType
+	// Firewall checks the chunks across the edge of the subnet of this
+	// router. Defaults to none.
+	Firewall *FirewallConfig
 	// Minimum Delay
 	MinDelay time.Duration
-	// Max Jitter
+	// Max Jitter. Each chunk is delayed by MinDelay plus up to MaxJitter,
+	// uniformly distributed.
 	MaxJitter time.Duration
+	// Jitter varies the delay of each chunk around MinDelay, from the given
+	// distribution. It replaces MaxJitter.
+	Jitter *Jitter
+	// MTU of eth0, the link to the parent router. Defaults to 1500.
+	MTU int
+	// MTUPolicy defines what happens to chunks larger than the MTU of the
+	// link they are forwarded on. Defaults to MTUPolicyFragment.
+	MTUPolicy MTUPolicy
+	// Clock is the source of time for the delays, the jitter and the NAT
+	// mapping lifetimes of this router. Defaults to the system clock.
+	Clock Clock
+	// Rand is the random number generator for the jitter and the NAT port
+	// allocation of this router, for a reproducible run. It must not be
+	// shared. Defaults to a generator seeded from the system clock.
+	Rand *rand.Rand
 	// Logger factory
 	LoggerFactory logging.LoggerFactory
 }
@@ -73,6 +112,7 @@ type NIC interface {
 	onInboundChunk(c Chunk)
 	getStaticIPs() []net.IP
 	setRouter(r *Router) error
+	unsetRouter(r *Router) error
 }
 
 // ChunkFilter is a handler users can add to filter chunks.
@@ -82,22 +122,38 @@ type ChunkFilter func(c Chunk) bool
 // Router ...
 type Router struct {
 	name           string                    // read-only
-	interfaces     []*transport.Interface    // read-only
-	ipv4Net        *net.IPNet                // read-only
+	interfaces     []*transport.Interface    // requires mutex [x]
+	ipv4Net        *net.IPNet                // read-only, nil on an IPv6-only router
+	ipv6Net        *net.IPNet                // read-only, nil on an IPv4-only router
 	staticIPs      []net.IP                  // read-only
 	staticLocalIPs map[string]net.IP         // read-only,
 	lastID         byte                      // requires mutex [x], used to assign the last digit of IPv4 address
-	queue          *chunkQueue               // read-only
-	parent         *Router                   // read-only
-	children       []*Router                 // read-only
+	lastIDv6       uint64                    // requires mutex [x], used to assign IPv6 address when SLAAC is not possible
+	qdisc          QueueDiscipline           // read-only
+	queue          *delayQueue               // read-only, chunks forwarded and in their delay
+	rate           int                       // read-only, bits per second
+	busy           bool                      // requires mutex [x], forwarding a chunk at the rate
+	busyUntil      time.Time                 // requires mutex [x]
+	parent         *Router                   // requires mutex [x], the first parent
+	children       []*Router                 // requires mutex [x]
+	uplinks        []*uplink                 // requires mutex [x], one per parent
+	peers          []*Router                 // requires mutex [x]
+	routes         []*route                  // requires mutex [x]
 	natType        *NATType                  // read-only
-	nat            *networkAddressTranslator // read-only
-	nics           map[string]NIC            // read-only
+	nat            *networkAddressTranslator // requires mutex [x], NAT of the first uplink
+	nics           map[string]NIC            // requires mutex [x]
+	nicMTUs        map[NIC]int               // requires mutex [x], MTU of the link to each NIC
+	mtu            int                       // read-only, MTU of eth0
+	mtuPolicy      MTUPolicy                 // read-only
 	stopFunc       func()                    // requires mutex [x]
 	resolver       *resolver                 // read-only
 	chunkFilters   []ChunkFilter             // requires mutex [x]
-	minDelay       time.Duration             // requires mutex [x]
-	maxJitter      time.Duration             // requires mutex [x]
+	firewall       *firewall                 // requires mutex [x]
+	delay          *delayGenerator           // requires mutex [x]
+	stats          *routerStats              // requires mutex [x]
+	events         *eventBus                 // read-only
+	clock          Clock                     // read-only
+	rand           *rand.Rand                // requires mutex [x]
 	mutex          sync.RWMutex              // thread-safe
 	pushCh         chan struct{}             // writer requires mutex
 	loggerFactory  logging.LoggerFactory     // read-only
@@ -109,14 +165,39 @@ func NewRouter(config *RouterConfig) (*Router, error) {
 	loggerFactory := config.LoggerFactory
 	log := loggerFactory.NewLogger("vnet")
 
-	_, ipv4Net, err := net.ParseCIDR(config.CIDR)
+	_, ipNet, err := net.ParseCIDR(config.CIDR)
 	if err != nil {
 		return nil, err
 	}
 
-	queueSize := defaultRouterQueueSize
-	if config.QueueSize > 0 {
-		queueSize = config.QueueSize
+	var ipv4Net, ipv6Net *net.IPNet
+	if ipNet.IP.To4() != nil {
+		ipv4Net = ipNet
+	} else {
+		ipv6Net = ipNet
+	}
+
+	if len(config.CIDRv6) > 0 {
+		_, ipNet, err = net.ParseCIDR(config.CIDRv6)
+		if err != nil {
+			return nil, err
+		}
+		if ipNet.IP.To4() != nil || ipv6Net != nil {
+			return nil, errInvalidCIDRv6
+		}
+		ipv6Net = ipNet
+	}
+
+	mtu := defaultMTU
+	if config.MTU != 0 {
+		if config.MTU < minMTU {
+			return nil, fmt.Errorf("%w: %d", errInvalidMTU, config.MTU)
+		}
+		mtu = config.MTU
+	}
+
+	if config.Rate < 0 {
+		return nil, fmt.Errorf("%w: %d", errInvalidRouterRate, config.Rate)
 	}
 
 	// set up network interface, lo0
@@ -132,7 +213,7 @@ func NewRouter(config *RouterConfig) (*Router, error) {
 	// set up network interface, eth0
 	eth0 := transport.NewInterface(net.Interface{
 		Index:        2,
-		MTU:          1500,
+		MTU:          mtu,
 		Name:         "eth0",
 		HardwareAddr: newMACAddress(),
 		Flags:        net.FlagUp | net.FlagMulticast,
@@ -158,7 +239,7 @@ func NewRouter(config *RouterConfig) (*Router, error) {
 				if locIP == nil {
 					return nil, errInvalidLocalIPinStaticIPs
 				}
-				if !ipv4Net.Contains(locIP) {
+				if !subnetContains(ipv4Net, ipv6Net, locIP) {
 					return nil, fmt.Errorf("local IP %s %w", locIP.String(), errLocalIPBeyondStaticIPsSubset)
 				}
 				staticLocalIPs[ip.String()] = locIP
@@ -179,18 +260,52 @@ func NewRouter(config *RouterConfig) (*Router, error) {
 		}
 	}
 
+	// MaxJitter is a uniform jitter between MinDelay and MinDelay+MaxJitter
+	baseDelay := config.MinDelay + config.MaxJitter/2
+	jitter := Jitter{Deviation: config.MaxJitter / 2}
+	if config.Jitter != nil {
+		baseDelay, jitter = config.MinDelay, *config.Jitter
+	}
+	rng := randOrSeeded(config.Rand)
+	delay, err := newDelayGenerator(baseDelay, jitter, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	qdisc := config.QueueDiscipline
+	if qdisc == nil {
+		qdisc = NewDropTailQueue(config.QueueSize, 0)
+	} else if config.QueueSize != 0 {
+		return nil, errQueueSizeWithDiscipline
+	}
+
+	fw, err := newFirewall(config.Firewall)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Router{
 		name:           name,
 		interfaces:     []*transport.Interface{lo0, eth0},
 		ipv4Net:        ipv4Net,
+		ipv6Net:        ipv6Net,
 		staticIPs:      staticIPs,
 		staticLocalIPs: staticLocalIPs,
-		queue:          newChunkQueue(queueSize, 0),
+		qdisc:          qdisc,
+		queue:          newDelayQueue(0),
+		rate:           config.Rate,
 		natType:        config.NATType,
 		nics:           map[string]NIC{},
+		nicMTUs:        map[NIC]int{},
+		mtu:            mtu,
+		mtuPolicy:      config.MTUPolicy,
 		resolver:       resolver,
-		minDelay:       config.MinDelay,
-		maxJitter:      config.MaxJitter,
+		delay:          delay,
+		firewall:       fw,
+		stats:          newRouterStats(),
+		events:         newEventBus(),
+		clock:          clockOrSystem(config.Clock),
+		rand:           rng,
 		pushCh:         make(chan struct{}, 1),
 		loggerFactory:  loggerFactory,
 		log:            log,
@@ -206,6 +321,15 @@ func (r *Router) getInterfaces() ([]*transport.Interface, error) {
 	return r.interfaces, nil
 }
 
+// eth0IPs returns the addresses of eth0. The parents of the router call it
+// holding their own mutex.
+func (r *Router) eth0IPs() []net.IP {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return interfaceIPs(r.interfaces)
+}
+
 func (r *Router) getInterface(ifName string) (*transport.Interface, error) {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
@@ -250,10 +374,13 @@ func (r *Router) Start() error {
 					break loop
 				}
 			} else {
-				t := time.NewTimer(d)
+				t := r.clock.NewTimer(d)
 				select {
-				case <-t.C:
+				case <-t.C():
+				case <-r.pushCh:
+					t.Stop()
 				case <-cancelCh:
+					t.Stop()
 					break loop
 				}
 			}
@@ -265,7 +392,8 @@ func (r *Router) Start() error {
 	}
 
 	for _, child := range r.children {
-		if err := child.Start(); err != nil {
+		// a child with several parents is started by the first one
+		if err := child.Start(); err != nil && !errors.Is(err, errRouterAlreadyStarted) {
 			return err
 		}
 	}
@@ -287,7 +415,7 @@ func (r *Router) Stop() error {
 		err := router.Stop()
 		r.mutex.Lock()
 
-		if err != nil {
+		if err != nil && !errors.Is(err, errRouterAlreadyStopped) {
 			return err
 		}
 	}
@@ -297,6 +425,22 @@ func (r *Router) Stop() error {
 	return nil
 }
 
+// subnetContains returns true if ip is in either of the (possibly nil) subnets.
+func subnetContains(ipv4Net, ipv6Net *net.IPNet, ip net.IP) bool {
+	if ip.To4() != nil {
+		return ipv4Net != nil && ipv4Net.Contains(ip)
+	}
+	return ipv6Net != nil && ipv6Net.Contains(ip)
+}
+
+// caller must hold the mutex
+func (r *Router) subnetFor(ip net.IP) *net.IPNet {
+	if ip.To4() != nil {
+		return r.ipv4Net
+	}
+	return r.ipv6Net
+}
+
 // caller must hold the mutex
 func (r *Router) addNIC(nic NIC) error {
 	ifc, err := nic.getInterface("eth0")
@@ -304,34 +448,160 @@ func (r *Router) addNIC(nic NIC) error {
 		return err
 	}
 
-	var ips []net.IP
+	ips := nic.getStaticIPs()
+
+	// a router with another parent has static IPs on that parent's subnet
+	if router, ok := nic.(*Router); ok && router.hasUplink() {
+		var own []net.IP
+		for _, ip := range ips {
+			if subnetContains(r.ipv4Net, r.ipv6Net, ip) {
+				own = append(own, ip)
+			}
+		}
+		ips = own
+	}
+
+	var hasIPv4, hasIPv6 bool
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			hasIPv4 = true
+		} else {
+			hasIPv6 = true
+		}
+	}
 
-	if ips = nic.getStaticIPs(); len(ips) == 0 {
-		// assign an IP address
+	// assign an IP address for each address family with no static IP
+	if !hasIPv4 && r.ipv4Net != nil {
 		ip, err2 := r.assignIPAddress()
 		if err2 != nil {
 			return err2
 		}
 		ips = append(ips, ip)
 	}
+	if !hasIPv6 && r.ipv6Net != nil {
+		ip, err2 := r.assignIPv6Address(ifc.HardwareAddr)
+		if err2 != nil {
+			return err2
+		}
+		ips = append(ips, ip)
+	}
 
 	for _, ip := range ips {
-		if !r.ipv4Net.Contains(ip) {
-			return fmt.Errorf("%w: %s", errStaticIPisBeyondSubnet, r.ipv4Net.String())
+		subnet := r.subnetFor(ip)
+		if subnet == nil || !subnet.Contains(ip) {
+			return fmt.Errorf("%w: %s", errStaticIPisBeyondSubnet, ip.String())
 		}
 
 		ifc.AddAddress(&net.IPNet{
 			IP:   ip,
-			Mask: r.ipv4Net.Mask,
+			Mask: subnet.Mask,
 		})
 
 		r.nics[ip.String()] = nic
 	}
 
-	return nic.setRouter(r)
+	// the link to a NIC has the MTU of its eth0
+	r.nicMTUs[nic] = ifc.MTU
+	if _, ok := r.stats.nics[nic]; !ok {
+		r.stats.nics[nic] = &NICStats{}
+	}
+
+	if err = nic.setRouter(r); err != nil {
+		return err
+	}
+
+	r.publish(Event{Kind: EventInterfaceChanged, NIC: nic, IPs: ips})
+	return nil
+}
+
+// renumberNIC replaces the addresses of a NIC with the given IPs, and
+// assigns a new one for each address family with none, like a DHCP server
+// handing out a new lease. It returns the new addresses.
+func (r *Router) renumberNIC(nic NIC, hwAddr net.HardwareAddr, ips []net.IP) ([]*net.IPNet, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.nicMTUs[nic]; !ok {
+		return nil, errNICNotFound
+	}
+
+	var hasIPv4, hasIPv6 bool
+	for _, ip := range ips {
+		subnet := r.subnetFor(ip)
+		if subnet == nil || !subnet.Contains(ip) {
+			return nil, fmt.Errorf("%w: %s", errStaticIPisBeyondSubnet, ip.String())
+		}
+		if other, ok := r.nics[ip.String()]; ok && other != nic {
+			return nil, fmt.Errorf("%w: %s", errIPAddressInUse, ip.String())
+		}
+		if ip.To4() != nil {
+			hasIPv4 = true
+		} else {
+			hasIPv6 = true
+		}
+	}
+
+	var old []string
+	for ipStr, other := range r.nics {
+		if other == nic {
+			old = append(old, ipStr)
+		}
+	}
+	for _, ipStr := range old {
+		delete(r.nics, ipStr)
+	}
+	// the NIC keeps its addresses if no new one can be assigned
+	restore := func() {
+		for _, ipStr := range old {
+			r.nics[ipStr] = nic
+		}
+	}
+
+	if !hasIPv4 && r.ipv4Net != nil {
+		ip, err := r.assignFreeIPAddress()
+		if err != nil {
+			restore()
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	if !hasIPv6 && r.ipv6Net != nil {
+		ip, err := r.assignIPv6Address(hwAddr)
+		if err != nil {
+			restore()
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+
+	addrs := make([]*net.IPNet, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, &net.IPNet{
+			IP:   ip,
+			Mask: r.subnetFor(ip).Mask,
+		})
+		r.nics[ip.String()] = nic
+	}
+
+	// remove the NAT bindings of the addresses the NIC no longer has, as
+	// removeNIC does
+	var released []net.IP
+	for _, ipStr := range old {
+		if r.nics[ipStr] != nic {
+			released = append(released, net.ParseIP(ipStr))
+		}
+	}
+	for _, up := range r.uplinks {
+		up.nat.removeMappingsOf(released)
+	}
+
+	r.publish(Event{Kind: EventInterfaceChanged, NIC: nic, IPs: ips})
+	return addrs, nil
 }
 
-// AddRouter adds a child Router.
+// AddRouter adds a child Router. A router may be added to several parents,
+// each being an uplink with a NAT of its own. Chunks are sent to the first
+// parent unless a route tells otherwise.
 func (r *Router) AddRouter(router *Router) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
@@ -374,6 +644,76 @@ func (r *Router) AddNet(nic NIC) error {
 	return r.addNIC(nic)
 }
 
+// RemoveNet detaches a NIC added with AddNet, while the router runs or not.
+// The addresses of the NIC are released, its NAT bindings are removed, and
+// a Net closes the UDPConns and TCPListeners bound to them and resets the
+// TCPConns.
+func (r *Router) RemoveNet(nic NIC) error {
+	r.mutex.Lock()
+	err := r.removeNIC(nic)
+	r.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return nic.unsetRouter(r)
+}
+
+// RemoveRouter detaches a child router added with AddRouter or
+// AddChildRouter, while the routers run or not. The child loses the uplink
+// to this router, with its NAT and the routes through it. It is not stopped.
+func (r *Router) RemoveRouter(router *Router) error {
+	r.mutex.Lock()
+	index := -1
+	for i, child := range r.children {
+		if child == router {
+			index = i
+		}
+	}
+	if index < 0 {
+		r.mutex.Unlock()
+		return errRouterNotChild
+	}
+	r.children = append(r.children[:index], r.children[index+1:]...)
+
+	// the child may have been added with a wrapper around it. remove the
+	// NICs registered for its addresses.
+	for _, ip := range router.eth0IPs() {
+		if nic, ok := r.nics[ip.String()]; ok {
+			_ = r.removeNIC(nic) //nolint:errcheck
+		}
+	}
+	r.mutex.Unlock()
+
+	return router.unsetRouter(r)
+}
+
+// removeNIC releases the addresses of a NIC and removes the NAT bindings
+// of the uplinks for them.
+// caller must hold the mutex
+func (r *Router) removeNIC(nic NIC) error {
+	if _, ok := r.nicMTUs[nic]; !ok {
+		return errNICNotFound
+	}
+	delete(r.nicMTUs, nic)
+	delete(r.stats.nics, nic)
+
+	var ips []net.IP
+	for ipStr, other := range r.nics {
+		if other == nic {
+			ips = append(ips, net.ParseIP(ipStr))
+			delete(r.nics, ipStr)
+		}
+	}
+
+	for _, up := range r.uplinks {
+		up.nat.removeMappingsOf(ips)
+	}
+
+	r.publish(Event{Kind: EventInterfaceChanged, NIC: nic})
+	return nil
+}
+
 // AddHost adds a mapping of hostname and an IP address to the local resolver.
 func (r *Router) AddHost(hostName string, ipAddr string) error {
 	return r.resolver.addHost(hostName, ipAddr)
@@ -404,69 +744,169 @@ func (r *Router) assignIPAddress() (net.IP, error) {
 	return ip, nil
 }
 
+// assignFreeIPAddress assigns the next IPv4 address no NIC has. Once the
+// sequential host IDs run out, it takes the first free one of the subnet.
+// caller must hold the mutex
+func (r *Router) assignFreeIPAddress() (net.IP, error) {
+	for {
+		ip, err := r.assignIPAddress()
+		if err != nil {
+			break
+		}
+		if _, ok := r.nics[ip.String()]; !ok {
+			return ip, nil
+		}
+	}
+
+	for id := 1; id < 0xff; id++ {
+		ip := make(net.IP, 4)
+		copy(ip, r.ipv4Net.IP[:3])
+		ip[3] = byte(id)
+		if _, ok := r.nics[ip.String()]; !ok {
+			return ip, nil
+		}
+	}
+	return nil, errAddressSpaceExhausted
+}
+
+// assignIPv6Address derives the interface identifier from the MAC address
+// (modified EUI-64, RFC 4291 Appendix A) like SLAAC does. If the prefix is
+// longer than /64 or the address is taken, it assigns addresses sequentially
+// from the end of the prefix instead.
+// caller should hold the mutex
+func (r *Router) assignIPv6Address(hwAddr net.HardwareAddr) (net.IP, error) {
+	ones, _ := r.ipv6Net.Mask.Size()
+
+	if ones <= 64 && len(hwAddr) == 6 {
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, r.ipv6Net.IP[:8])
+		ip[8] = hwAddr[0] ^ 0x02
+		ip[9] = hwAddr[1]
+		ip[10] = hwAddr[2]
+		ip[11] = 0xff
+		ip[12] = 0xfe
+		ip[13] = hwAddr[3]
+		ip[14] = hwAddr[4]
+		ip[15] = hwAddr[5]
+
+		if _, ok := r.nics[ip.String()]; !ok {
+			return ip, nil
+		}
+	}
+
+	hostBits := 128 - ones
+	for {
+		r.lastIDv6++
+		if hostBits < 64 && r.lastIDv6 >= 1<<uint(hostBits)-1 {
+			return nil, errAddressSpaceExhausted
+		}
+
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, r.ipv6Net.IP)
+		binary.BigEndian.PutUint64(ip[8:], binary.BigEndian.Uint64(ip[8:])|r.lastIDv6)
+
+		if _, ok := r.nics[ip.String()]; !ok {
+			return ip, nil
+		}
+	}
+}
+
 func (r *Router) push(c Chunk) {
+	r.mutex.Lock()
+	nic := r.nics[c.getSourceIP().String()]
+	if stats, ok := r.stats.nics[nic]; ok {
+		stats.Sent.add(c)
+	}
+	r.mutex.Unlock()
+
+	// the chunks a NIC behind a Link sends cross its uplink first
+	if link, ok := nic.(*Link); ok {
+		link.sendUplink(c)
+		return
+	}
+
+	r.enqueue(c)
+}
+
+// enqueue adds a chunk to the queue of the router
+func (r *Router) enqueue(c Chunk) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
 	r.log.Debugf("[%s] route %s", r.name, c.String())
 	if r.stopFunc != nil {
-		c.setTimestamp()
-		if r.queue.push(c) {
+		now := r.clock.Now()
+		c.setTimestamp(now)
+		r.stats.received.add(c)
+		r.stats.queueDepth.add(r.qdisc.Stats().Length + r.queue.len())
+		if r.qdisc.Enqueue(c, now) {
 			select {
 			case r.pushCh <- struct{}{}:
 			default:
 			}
 		} else {
 			r.log.Warnf("[%s] queue was full. dropped a chunk", r.name)
+			r.countDrop(c, DropQueueFull)
 		}
 	}
 }
 
+// QueueStats returns the statistics of the queue discipline of the router
+func (r *Router) QueueStats() QueueStats {
+	return r.qdisc.Stats()
+}
+
 func (r *Router) processChunks() (time.Duration, error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	// Introduce jitter by delaying the processing of chunks.
-	if r.maxJitter > 0 {
-		jitter := time.Duration(rand.Int63n(int64(r.maxJitter))) //nolint:gosec
-		time.Sleep(jitter)
-	}
+	enteredAt := r.clock.Now()
 
-	//      cutOff
-	//         v min delay
-	//         |<--->|
-	//  +------------:--
-	//  |OOOOOOXXXXX :   --> time
-	//  +------------:--
-	//  |<--->|     now
-	//    due
+	// Each chunk is delayed by its own delay, drawn when it was forwarded.
+	// The next delay is waited for by the caller, without the mutex.
+	var d time.Duration // the next sleep duration
 
-	enteredAt := time.Now()
-	cutOff := enteredAt.Add(-r.minDelay)
+	// The chunks leave the queue one after the other at the rate, and their
+	// delay starts once they are forwarded. Without a rate, they leave as
+	// they arrive, and their delay starts when they were pushed.
+	for !r.busy || !r.busyUntil.After(enteredAt) {
+		c, ok := r.qdisc.Dequeue(enteredAt)
+		if !ok {
+			r.busy = false
+			break
+		}
 
-	var d time.Duration // the next sleep duration
+		forwardedAt := c.getTimestamp()
+		if r.rate > 0 {
+			start := enteredAt
+			if r.busy {
+				start = r.busyUntil
+			}
+			r.busy = true
+			r.busyUntil = start.Add(transmissionTime(c, r.rate))
+			forwardedAt = r.busyUntil
+		}
+		r.queue.push(c, forwardedAt.Add(r.delay.next()))
+	}
 
 	for {
 		d = 0
 
-		c := r.queue.peek()
-		if c == nil {
+		next, ok := r.queue.peek()
+		if !ok {
 			break // no more chunk in the queue
 		}
 
-		// check timestamp to find if the chunk is due
-		if c.getTimestamp().After(cutOff) {
+		// check the deadline to find if the chunk is due
+		if next.deadline.After(enteredAt) {
 			// There is one or more chunk in the queue but none of them are due.
 			// Calculate the next sleep duration here.
-			nextExpire := c.getTimestamp().Add(r.minDelay)
-			d = nextExpire.Sub(enteredAt)
+			d = next.deadline.Sub(enteredAt)
 			break
 		}
 
-		var ok bool
-		if c, ok = r.queue.pop(); !ok {
-			break // no more chunk in the queue
-		}
+		r.queue.pop() // ignore result because we already got it from peek
+		c := next.Chunk
 
 		blocked := false
 		for i := 0; i < len(r.chunkFilters); i++ {
@@ -477,75 +917,309 @@ func (r *Router) processChunks() (time.Duration, error) {
 			}
 		}
 		if blocked {
+			r.publish(Event{Kind: EventFilterRejected, Chunk: c})
+			r.countDrop(c, DropFiltered)
 			continue // discard
 		}
 
-		dstIP := c.getDestinationIP()
+		if !r.checkFirewall(c) {
+			r.log.Debugf("[%s] firewall dropped %s", r.name, c.String())
+			r.countDrop(c, DropFirewall)
+			continue // discard
+		}
 
-		// check if the destination is in our subnet
-		if r.ipv4Net.Contains(dstIP) {
-			// search for the destination NIC
-			var nic NIC
-			if nic, ok = r.nics[dstIP.String()]; !ok {
-				// NIC not found. drop it.
-				r.log.Debugf("[%s] %s unreachable", r.name, c.String())
-				continue
-			}
+		dstIP := c.getDestinationIP()
 
+		// search for the destination NIC
+		if nic, found := r.findNIC(dstIP); found {
 			// found the NIC, forward the chunk to the NIC.
+			chunks := r.fitMTU(c, r.nicMTUs[nic])
+			for _, c := range chunks {
+				r.countForward(c, nic)
+			}
 			// call to NIC must unlock mutex
 			r.mutex.Unlock()
-			nic.onInboundChunk(c)
+			for _, c := range chunks {
+				nic.onInboundChunk(c)
+			}
 			r.mutex.Lock()
 			continue
 		}
 
+		// check if the destination is in our subnet
+		if subnetContains(r.ipv4Net, r.ipv6Net, dstIP) {
+			// NIC not found. drop it.
+			r.log.Debugf("[%s] %s unreachable", r.name, c.String())
+			r.countDrop(c, DropNoRoute)
+			r.replyUnreachable(c, icmpHostUnreachable, 0)
+			continue
+		}
+
 		// the destination is outside of this subnet
+		if rt := r.lookupRoute(dstIP); rt != nil {
+			if err := r.forward(c, rt.nextHop); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
 		// is this WAN?
 		if r.parent == nil {
 			// this WAN. No route for this chunk
 			r.log.Debugf("[%s] no route found for %s", r.name, c.String())
+			r.countDrop(c, DropNoRoute)
+			r.replyUnreachable(c, icmpNetUnreachable, 0)
 			continue
 		}
 
-		// Pass it to the parent via NAT
-		toParent, err := r.nat.translateOutbound(c)
-		if err != nil {
+		if err := r.forward(c, r.parent); err != nil {
 			return 0, err
 		}
+	}
 
-		if toParent == nil {
-			continue
+	// wake up when the chunk is forwarded, to take the next one
+	if r.busy {
+		if untilForwarded := r.busyUntil.Sub(enteredAt); d <= 0 || untilForwarded < d {
+			d = untilForwarded
 		}
+	}
+	return d, nil
+}
 
-		//nolint:godox
-		/* FIXME: this implementation would introduce a duplicate packet!
-		if r.nat.natType.Hairpinning {
-			hairpinned, err := r.nat.translateInbound(toParent)
-			if err != nil {
-				r.log.Warnf("[%s] %s", r.name, err.Error())
-			} else {
-				go func() {
-					r.push(hairpinned)
-				}()
-			}
+// forward passes a chunk to a neighbor router: via NAT to a parent, as it is
+// to a peer. IPv6 is never translated.
+// caller must hold the mutex
+func (r *Router) forward(c Chunk, next *Router) error {
+	if c.addHop() > maxHops {
+		r.log.Debugf("[%s] drop %s forwarded too many times", r.name, c.String())
+		r.countDrop(c, DropHopLimit)
+		return nil
+	}
+
+	// The link to a neighbor has the MTU of our eth0. Chunks are fitted
+	// before NAT, so hairpinned chunks are subject to it as well.
+	chunks := r.fitMTU(c, r.mtu)
+
+	up := r.findUplink(next)
+	if up == nil || c.getDestinationIP().To4() == nil {
+		for _, c := range chunks {
+			r.countForward(c, nil)
+		}
+		r.mutex.Unlock()
+		for _, c := range chunks {
+			next.push(c)
+		}
+		r.mutex.Lock()
+		return nil
+	}
+
+	for _, c := range chunks {
+		if err := r.forwardToUplink(up, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forwardToUplink passes an IPv4 chunk to a parent router via NAT, or
+// hairpins it back to one of our NICs.
+// caller must hold the mutex
+func (r *Router) forwardToUplink(up *uplink, c Chunk) error {
+	toParent, err := up.nat.translateOutbound(c)
+	if err != nil {
+		return err
+	}
+
+	if toParent == nil {
+		r.countDrop(c, DropNAT)
+		return nil
+	}
+
+	// The destination is one of our own mapped addresses. Turn the
+	// chunk around here instead of sending it to the parent, which
+	// would route it right back to us.
+	if r.isMappedIP(toParent.getDestinationIP()) {
+		if !up.nat.natType.Hairpinning {
+			r.log.Debugf("[%s] drop %s as hairpinning is disabled", r.name, toParent.String())
+			r.countDrop(c, DropNAT)
+			return nil
 		}
-		*/
 
-		// call to parent router mutex unlock mutex
+		hairpinned, err := r.translateHairpin(toParent)
+		if err != nil {
+			r.log.Debugf("[%s] %s", r.name, err.Error())
+			r.countDrop(c, DropNAT)
+			return nil
+		}
+
+		nic, found := r.findNIC(hairpinned.getDestinationIP())
+		if !found {
+			r.log.Debugf("[%s] %s unreachable", r.name, hairpinned.String())
+			r.countDrop(c, DropNoRoute)
+			return nil
+		}
+
+		r.log.Debugf("[%s] hairpin %s", r.name, hairpinned.String())
+		r.countForward(hairpinned, nic)
 		r.mutex.Unlock()
-		r.parent.push(toParent)
+		nic.onInboundChunk(hairpinned)
 		r.mutex.Lock()
+		return nil
 	}
 
-	return d, nil
+	r.countForward(toParent, nil)
+
+	// call to parent router mutex unlock mutex
+	r.mutex.Unlock()
+	up.router.push(toParent)
+	r.mutex.Lock()
+
+	return nil
 }
 
+// isMappedIP returns true if ip is a mapped address of any uplink.
 // caller must hold the mutex
-func (r *Router) setRouter(parent *Router) error {
-	r.parent = parent
-	r.resolver.setParent(parent.resolver)
+func (r *Router) isMappedIP(ip net.IP) bool {
+	for _, up := range r.uplinks {
+		if up.nat.isMappedIP(ip) {
+			return true
+		}
+	}
+	return false
+}
 
+// translateHairpin translates a hairpinned chunk with the NAT of the uplink
+// of its destination.
+// caller must hold the mutex
+func (r *Router) translateHairpin(c Chunk) (Chunk, error) {
+	for _, up := range r.uplinks {
+		if up.nat.isMappedIP(c.getDestinationIP()) {
+			return up.nat.translateInbound(c)
+		}
+	}
+	return nil, errNoNATBindingFound
+}
+
+// fitMTU returns the chunks to forward on a link with the given MTU: the
+// chunk itself if it fits, its fragments, or none if it is dropped.
+// caller must hold the mutex
+func (r *Router) fitMTU(c Chunk, mtu int) []Chunk {
+	if chunkSize(c) <= mtu {
+		return []Chunk{c}
+	}
+
+	if r.mtuPolicy == MTUPolicyFragment && c.getDestinationIP().To4() != nil {
+		if fragments := fragmentChunk(c, mtu); fragments != nil {
+			r.log.Debugf("[%s] fragment %s into %d chunks", r.name, c.String(), len(fragments))
+			return fragments
+		}
+	}
+
+	r.log.Debugf("[%s] drop %s larger than MTU %d", r.name, c.String(), mtu)
+	r.countDrop(c, DropTooBig)
+	r.replyUnreachable(c, icmpFragmentationNeeded, mtu)
+	return nil
+}
+
+// findNIC returns the NIC that has the IP address. IPv6 chunks are also
+// routed to the child router that serves the destination prefix.
+// caller must hold the mutex
+func (r *Router) findNIC(dstIP net.IP) (NIC, bool) {
+	if nic, ok := r.nics[dstIP.String()]; ok {
+		return nic, true
+	}
+
+	if dstIP.To4() != nil {
+		return nil, false
+	}
+
+	for _, child := range r.children {
+		if child.ipv6Net == nil || !child.ipv6Net.Contains(dstIP) {
+			continue
+		}
+
+		// the child may have been added with a wrapper around it. use the
+		// NIC registered for its eth0 address.
+		for _, ip := range child.eth0IPs() {
+			if nic, ok := r.nics[ip.String()]; ok {
+				return nic, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// replyUnreachable sends an ICMP destination unreachable back to the sender
+// of the chunk. mtu is the next-hop MTU reported with icmpFragmentationNeeded.
+// No ICMP error is sent about an ICMP error (RFC 1122).
+// caller must hold the mutex
+func (r *Router) replyUnreachable(c Chunk, code icmpUnreachableCode, mtu int) {
+	if _, ok := c.(*chunkICMP); ok {
+		return
+	}
+
+	srcIP := r.icmpSourceIP(c.getSourceIP())
+	if srcIP == nil {
+		return
+	}
+
+	// call to push must unlock mutex
+	r.mutex.Unlock()
+	icmp := newChunkICMPUnreachable(srcIP, c, code)
+	icmp.mtu = mtu
+	r.push(icmp)
+	r.mutex.Lock()
+}
+
+// icmpSourceIP returns the address the router sends ICMP messages to dstIP
+// from: its own address of the same family if it has one, otherwise the
+// address of its subnet.
+// caller must hold the mutex
+func (r *Router) icmpSourceIP(dstIP net.IP) net.IP {
+	for _, ip := range interfaceIPs(r.interfaces) {
+		if sameFamily(ip, dstIP) {
+			return ip
+		}
+	}
+
+	if subnet := r.subnetFor(dstIP); subnet != nil {
+		return subnet.IP
+	}
+
+	return nil
+}
+
+// interfaceIPs returns all IP addresses assigned to the eth0 interface.
+func interfaceIPs(ifs []*transport.Interface) []net.IP {
+	ips := []net.IP{}
+
+	for _, ifc := range ifs {
+		if ifc.Name != "eth0" {
+			continue
+		}
+
+		addrs, err := ifc.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, ifcAddr := range addrs {
+			switch addr := ifcAddr.(type) {
+			case *net.IPNet:
+				ips = append(ips, addr.IP)
+			case *net.IPAddr:
+				ips = append(ips, addr.IP)
+			default:
+			}
+		}
+	}
+
+	return ips
+}
+
+// setRouter adds an uplink to the parent. The parent must hold its mutex.
+func (r *Router) setRouter(parent *Router) error {
 	// when this method is called, one or more IP address has already been assigned by
 	// the parent router.
 	ifc, err := r.getInterface("eth0")
@@ -571,7 +1245,9 @@ func (r *Router) setRouter(parent *Router) error {
 		default:
 		}
 
-		if ip == nil {
+		// IPv6 is not translated, and the addresses on the subnets of other
+		// parents belong to their uplinks
+		if ip == nil || ip.To4() == nil || !subnetContains(parent.ipv4Net, nil, ip) {
 			continue
 		}
 
@@ -582,6 +1258,14 @@ func (r *Router) setRouter(parent *Router) error {
 		}
 	}
 
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// AddRouter sets the parent once through addNIC, and once more itself
+	if r.findUplink(parent) != nil {
+		return nil
+	}
+
 	// Set up NAT here
 	if r.natType == nil {
 		r.natType = &NATType{
@@ -592,24 +1276,123 @@ func (r *Router) setRouter(parent *Router) error {
 			MappingLifeTime:   30 * time.Second,
 		}
 	}
-	r.nat, err = newNAT(&natConfig{
+	nat, err := newNAT(&natConfig{
 		name:          r.name,
 		natType:       *r.natType,
 		mappedIPs:     mappedIPs,
 		localIPs:      localIPs,
+		clock:         r.clock,
+		rand:          rand.New(rand.NewSource(r.rand.Int63())), //nolint:gosec
+		events:        r.events,
 		loggerFactory: r.loggerFactory,
 	})
 	if err != nil {
 		return err
 	}
 
+	if r.parent == nil {
+		r.parent = parent
+		r.nat = nat
+		r.resolver.setParent(parent.resolver)
+	}
+	r.uplinks = append(r.uplinks, &uplink{router: parent, nat: nat})
+
+	return nil
+}
+
+// unsetRouter removes the uplink to the parent, after the parent removed
+// this router. The next uplink, if any, becomes the default one.
+func (r *Router) unsetRouter(parent *Router) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	up := r.findUplink(parent)
+	if up == nil {
+		return errNICNotFound
+	}
+	for i, other := range r.uplinks {
+		if other == up {
+			r.uplinks = append(r.uplinks[:i], r.uplinks[i+1:]...)
+			break
+		}
+	}
+
+	// routes through the parent are gone with it
+	routes := r.routes[:0]
+	for _, entry := range r.routes {
+		if entry.nextHop != parent {
+			routes = append(routes, entry)
+		}
+	}
+	r.routes = routes
+
+	if r.parent == parent {
+		r.parent, r.nat = nil, nil
+		r.resolver.setParent(nil)
+		if len(r.uplinks) > 0 {
+			r.parent, r.nat = r.uplinks[0].router, r.uplinks[0].nat
+			r.resolver.setParent(r.parent.resolver)
+		}
+	}
+
+	// release the addresses on the parent's subnets. The interface is
+	// replaced, not modified, as other routers may hold it.
+	for i, ifc := range r.interfaces {
+		if ifc.Name != "eth0" {
+			continue
+		}
+		eth0 := transport.NewInterface(ifc.Interface)
+		for _, addr := range interfaceIPNets(ifc) {
+			if !subnetContains(parent.ipv4Net, parent.ipv6Net, addr.IP) {
+				eth0.AddAddress(addr)
+			}
+		}
+		r.interfaces[i] = eth0
+	}
 	return nil
 }
 
+func (r *Router) hasUplink() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return len(r.uplinks) > 0
+}
+
 func (r *Router) onInboundChunk(c Chunk) {
-	fromParent, err := r.nat.translateInbound(c)
+	if dstIP := c.getDestinationIP(); dstIP.To4() == nil {
+		// IPv6 is routed without NAT
+		if !subnetContains(nil, r.ipv6Net, dstIP) {
+			r.log.Debugf("[%s] %s unreachable", r.name, c.String())
+			r.countInboundDrop(c, DropNoRoute)
+			return
+		}
+		r.push(c)
+		return
+	}
+
+	// the chunk is for one of the mapped addresses of an uplink
+	r.mutex.RLock()
+	nat := r.nat
+	for _, up := range r.uplinks {
+		if up.nat.isMappedIP(c.getDestinationIP()) {
+			nat = up.nat
+			break
+		}
+	}
+	r.mutex.RUnlock()
+
+	if nat == nil {
+		// the router was removed from its parents
+		r.log.Debugf("[%s] %s unreachable", r.name, c.String())
+		r.countInboundDrop(c, DropNoRoute)
+		return
+	}
+
+	fromParent, err := nat.translateInbound(c)
 	if err != nil {
 		r.log.Warnf("[%s] %s", r.name, err.Error())
+		r.countInboundDrop(c, DropNAT)
 		return
 	}
 
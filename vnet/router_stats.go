@@ -0,0 +1,322 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// DropReason is the reason a router dropped a chunk
+type DropReason int
+
+const (
+	// DropQueueFull is a chunk that found the queue of the router full
+	DropQueueFull DropReason = iota
+	// DropFiltered is a chunk a ChunkFilter rejected
+	DropFiltered
+	// DropNoRoute is a chunk with no NIC or route for its destination
+	DropNoRoute
+	// DropNAT is a chunk the NAT blocked, or found no binding for
+	DropNAT
+	// DropHopLimit is a chunk that was forwarded too many times
+	DropHopLimit
+	// DropTooBig is a chunk larger than the MTU that could not be
+	// fragmented
+	DropTooBig
+	// DropFirewall is a chunk the firewall denied
+	DropFirewall
+)
+
+func (d DropReason) String() string {
+	switch d {
+	case DropQueueFull:
+		return "queue-full"
+	case DropFiltered:
+		return "filtered"
+	case DropNoRoute:
+		return "no-route"
+	case DropNAT:
+		return "nat"
+	case DropHopLimit:
+		return "hop-limit"
+	case DropTooBig:
+		return "too-big"
+	case DropFirewall:
+		return "firewall"
+	default:
+		return "unknown"
+	}
+}
+
+var (
+	defaultLatencyBounds = []time.Duration{ //nolint:gochecknoglobals
+		time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+		10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+		100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond,
+		time.Second,
+	}
+	defaultDepthBounds = []int{0, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000} //nolint:gochecknoglobals
+)
+
+// TrafficCounters count chunks and the bytes of their payloads
+type TrafficCounters struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+func (t *TrafficCounters) add(c Chunk) {
+	t.Packets++
+	t.Bytes += uint64(len(c.UserData()))
+}
+
+// RouterStats is a snapshot of the statistics of a Router
+type RouterStats struct {
+	// Received counts the chunks that arrived at the router, from its NICs,
+	// its neighbors, or its parents through NAT
+	Received TrafficCounters
+	// Forwarded counts the chunks passed on to a NIC or another router.
+	// Each fragment of a chunk counts.
+	Forwarded TrafficCounters
+	// Dropped counts the chunks dropped, by reason
+	Dropped map[DropReason]TrafficCounters
+	// Queue are the statistics of the queue discipline of the router
+	Queue QueueStats
+	// QueueDepth samples the number of chunks waiting in the router,
+	// queued or delayed, as each chunk arrives
+	QueueDepth DepthHistogram
+	// Latency samples the time from the arrival of each chunk until it is
+	// passed on, with its delay
+	Latency LatencyHistogram
+}
+
+// TotalDropped returns the chunks dropped for any reason
+func (s RouterStats) TotalDropped() TrafficCounters {
+	var total TrafficCounters
+	for _, dropped := range s.Dropped {
+		total.Packets += dropped.Packets
+		total.Bytes += dropped.Bytes
+	}
+	return total
+}
+
+// NICStats is a snapshot of the statistics of a NIC, as its router sees
+// them
+type NICStats struct {
+	// Sent counts the chunks the NIC sent to the router
+	Sent TrafficCounters
+	// Received counts the chunks the router passed on to the NIC
+	Received TrafficCounters
+}
+
+// LatencyHistogram counts durations in buckets. Counts[i] is the number of
+// samples up to Bounds[i]; the last count is the number of samples above
+// the last bound.
+type LatencyHistogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+	Max    time.Duration
+}
+
+func newLatencyHistogram(bounds []time.Duration) LatencyHistogram {
+	return LatencyHistogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (h *LatencyHistogram) add(d time.Duration) {
+	i := sort.Search(len(h.Bounds), func(i int) bool {
+		return d <= h.Bounds[i]
+	})
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+func (h LatencyHistogram) clone() LatencyHistogram {
+	h.Counts = append([]uint64{}, h.Counts...)
+	return h
+}
+
+// Mean returns the mean of the samples
+func (h LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns the upper bound of the bucket of the q-quantile, or Max
+// if it is above the last bound.
+func (h LatencyHistogram) Quantile(q float64) time.Duration {
+	i := quantileBucket(h.Counts, h.Count, q)
+	if i < len(h.Bounds) {
+		return h.Bounds[i]
+	}
+	return h.Max
+}
+
+// DepthHistogram counts queue lengths in buckets. Counts[i] is the number
+// of samples up to Bounds[i]; the last count is the number of samples above
+// the last bound.
+type DepthHistogram struct {
+	Bounds []int
+	Counts []uint64
+	Count  uint64
+	Sum    uint64
+	Max    int
+}
+
+func newDepthHistogram(bounds []int) DepthHistogram {
+	return DepthHistogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (h *DepthHistogram) add(depth int) {
+	i := sort.SearchInts(h.Bounds, depth)
+	h.Counts[i]++
+	h.Count++
+	h.Sum += uint64(depth)
+	if depth > h.Max {
+		h.Max = depth
+	}
+}
+
+func (h DepthHistogram) clone() DepthHistogram {
+	h.Counts = append([]uint64{}, h.Counts...)
+	return h
+}
+
+// Mean returns the mean of the samples
+func (h DepthHistogram) Mean() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return float64(h.Sum) / float64(h.Count)
+}
+
+// Quantile returns the upper bound of the bucket of the q-quantile, or Max
+// if it is above the last bound.
+func (h DepthHistogram) Quantile(q float64) int {
+	i := quantileBucket(h.Counts, h.Count, q)
+	if i < len(h.Bounds) {
+		return h.Bounds[i]
+	}
+	return h.Max
+}
+
+// quantileBucket returns the index of the bucket of the q-quantile, by the
+// nearest rank
+func quantileBucket(counts []uint64, total uint64, q float64) int {
+	rank := uint64(math.Ceil(q * float64(total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for i, count := range counts {
+		seen += count
+		if seen >= rank {
+			return i
+		}
+	}
+	return len(counts) - 1
+}
+
+// routerStats counts the chunks through a router
+type routerStats struct {
+	received   TrafficCounters
+	forwarded  TrafficCounters
+	dropped    map[DropReason]TrafficCounters
+	queueDepth DepthHistogram
+	latency    LatencyHistogram
+	nics       map[NIC]*NICStats
+}
+
+func newRouterStats() *routerStats {
+	return &routerStats{
+		dropped:    map[DropReason]TrafficCounters{},
+		queueDepth: newDepthHistogram(defaultDepthBounds),
+		latency:    newLatencyHistogram(defaultLatencyBounds),
+		nics:       map[NIC]*NICStats{},
+	}
+}
+
+func (s *routerStats) drop(c Chunk, reason DropReason) {
+	dropped := s.dropped[reason]
+	dropped.add(c)
+	s.dropped[reason] = dropped
+}
+
+// Stats returns a snapshot of the statistics of the router
+func (r *Router) Stats() RouterStats {
+	queue := r.qdisc.Stats()
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	dropped := make(map[DropReason]TrafficCounters, len(r.stats.dropped))
+	for reason, counters := range r.stats.dropped {
+		dropped[reason] = counters
+	}
+	return RouterStats{
+		Received:   r.stats.received,
+		Forwarded:  r.stats.forwarded,
+		Dropped:    dropped,
+		Queue:      queue,
+		QueueDepth: r.stats.queueDepth.clone(),
+		Latency:    r.stats.latency.clone(),
+	}
+}
+
+// NICStats returns a snapshot of the statistics of a NIC attached to the
+// router
+func (r *Router) NICStats(nic NIC) (NICStats, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	stats, ok := r.stats.nics[nic]
+	if !ok {
+		return NICStats{}, errNICNotFound
+	}
+	return *stats, nil
+}
+
+// countDrop counts a chunk dropped for the reason
+// caller must hold the mutex
+func (r *Router) countDrop(c Chunk, reason DropReason) {
+	r.stats.drop(c, reason)
+	r.publish(Event{Kind: EventDropped, Chunk: c, Reason: reason})
+}
+
+// countInboundDrop counts a chunk from a parent dropped before it entered
+// the queue
+func (r *Router) countInboundDrop(c Chunk, reason DropReason) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.stats.received.add(c)
+	r.countDrop(c, reason)
+}
+
+// countForward counts a chunk passed on, to the NIC if it is not nil
+// caller must hold the mutex
+func (r *Router) countForward(c Chunk, nic NIC) {
+	r.stats.forwarded.add(c)
+	if !c.getTimestamp().IsZero() {
+		r.stats.latency.add(r.clock.Now().Sub(c.getTimestamp()))
+	}
+	if stats, ok := r.stats.nics[nic]; ok {
+		stats.Received.add(c)
+	}
+	r.publish(Event{Kind: EventRouted, Chunk: c, NIC: nic})
+}
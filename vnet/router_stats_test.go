@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouterStats(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	r, err := NewRouter(&RouterConfig{
+		CIDR:          "1.2.3.0/24",
+		MinDelay:      30 * time.Millisecond,
+		Clock:         clock,
+		LoggerFactory: logging.NewDefaultLoggerFactory(),
+	})
+	if !assert.NoError(t, err, "should succeed") {
+		return
+	}
+
+	nics := make([]*dummyNIC, 2)
+	arrivalChs := make([]chan Chunk, 2)
+	for i := range nics {
+		n, err := NewNet(&NetConfig{StaticIPs: []string{fmt.Sprintf("1.2.3.%d", i+1)}})
+		assert.NoError(t, err, "should succeed")
+		arrivalCh := make(chan Chunk, 10)
+		arrivalChs[i] = arrivalCh
+		nics[i] = &dummyNIC{Net: n, onInboundChunkHandler: func(c Chunk) {
+			arrivalCh <- c
+		}}
+		assert.NoError(t, r.AddNet(nics[i]), "should succeed")
+	}
+
+	r.AddChunkFilter(func(c Chunk) bool {
+		return len(c.UserData()) != 7
+	})
+
+	assert.NoError(t, r.Start(), "should succeed")
+	defer func() {
+		assert.NoError(t, r.Stop(), "should succeed")
+	}()
+
+	push := func(dstIP string, size int) {
+		c := newChunkUDP(
+			&net.UDPAddr{IP: net.ParseIP("1.2.3.1"), Port: 1111},
+			&net.UDPAddr{IP: net.ParseIP(dstIP), Port: 2222},
+		)
+		c.userData = make([]byte, size)
+		r.push(c)
+	}
+	for i := 0; i < 3; i++ {
+		push("1.2.3.2", 100)
+	}
+	push("1.2.3.2", 7)   // filtered
+	push("1.2.3.99", 50) // no NIC, replied to with ICMP
+
+	clock.BlockUntil(1)
+	clock.Advance(30 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		<-arrivalChs[1]
+	}
+
+	// the ICMP reply has a delay of its own
+	clock.BlockUntil(1)
+	clock.Advance(30 * time.Millisecond)
+	assert.Equal(t, "icmp", (<-arrivalChs[0]).Network(), "should reply with ICMP")
+
+	stats := r.Stats()
+	assert.Equal(t, uint64(6), stats.Received.Packets)
+	assert.Equal(t, uint64(4), stats.Forwarded.Packets)
+	assert.Equal(t, TrafficCounters{Packets: 1, Bytes: 7}, stats.Dropped[DropFiltered])
+	assert.Equal(t, TrafficCounters{Packets: 1, Bytes: 50}, stats.Dropped[DropNoRoute])
+	assert.Equal(t, uint64(2), stats.TotalDropped().Packets)
+	assert.Equal(t, uint64(6), stats.Queue.Enqueued)
+
+	assert.Equal(t, uint64(4), stats.Latency.Count)
+	assert.Equal(t, 30*time.Millisecond, stats.Latency.Max)
+	assert.Equal(t, 30*time.Millisecond, stats.Latency.Mean())
+	assert.Equal(t, 50*time.Millisecond, stats.Latency.Quantile(0.5), "should be in the bucket up to 50ms")
+
+	assert.Equal(t, uint64(6), stats.QueueDepth.Count)
+	assert.Equal(t, 4, stats.QueueDepth.Max, "should count the chunks waiting for their delay")
+	assert.Equal(t, 0, stats.QueueDepth.Quantile(0), "should be empty for the first chunk")
+
+	nicStats, err := r.NICStats(nics[0])
+	assert.NoError(t, err, "should succeed")
+	assert.Equal(t, uint64(5), nicStats.Sent.Packets)
+	assert.Equal(t, uint64(1), nicStats.Received.Packets)
+
+	nicStats, err = r.NICStats(nics[1])
+	assert.NoError(t, err, "should succeed")
+	assert.Equal(t, NICStats{Received: TrafficCounters{Packets: 3, Bytes: 300}}, nicStats)
+
+	_, err = r.NICStats(newMockNIC(t))
+	assert.ErrorIs(t, err, errNICNotFound, "should fail")
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	h := newLatencyHistogram([]time.Duration{10 * time.Millisecond, 100 * time.Millisecond})
+	assert.Equal(t, time.Duration(0), h.Mean(), "should be 0 when empty")
+
+	for _, d := range []time.Duration{5, 10, 20, 50, 300} {
+		h.add(d * time.Millisecond)
+	}
+	assert.Equal(t, []uint64{2, 2, 1}, h.Counts)
+	assert.Equal(t, uint64(5), h.Count)
+	assert.Equal(t, 77*time.Millisecond, h.Mean())
+	assert.Equal(t, 10*time.Millisecond, h.Quantile(0.4))
+	assert.Equal(t, 100*time.Millisecond, h.Quantile(0.5))
+	assert.Equal(t, 300*time.Millisecond, h.Quantile(1), "should be the max above the last bound")
+
+	clone := h.clone()
+	h.add(time.Millisecond)
+	assert.Equal(t, []uint64{2, 2, 1}, clone.Counts, "should not share the counts")
+	assert.Equal(t, "no-route", DropNoRoute.String())
+}
@@ -5,12 +5,16 @@ package vnet
 
 import (
 	"errors"
+	"fmt"
+	"math/rand"
 	"net"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/pion/logging"
+	"github.com/pion/transport/v3"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -391,6 +395,314 @@ func TestRouterDelay(t *testing.T) {
 	subTest(t, "Delay and Jitter", 20*time.Millisecond, 10*time.Millisecond)
 }
 
+func TestRouterManualClock(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+	const minDelay, maxJitter = 20 * time.Millisecond, 10 * time.Millisecond
+
+	// run returns the delays of chunks routed with the given seed, in virtual
+	// time
+	run := func(t *testing.T, seed int64) []time.Duration {
+		t.Helper()
+
+		clock := NewManualClock(time.Now())
+		r, err := NewRouter(&RouterConfig{
+			CIDR:          "1.2.3.0/24",
+			MinDelay:      minDelay,
+			MaxJitter:     maxJitter,
+			Clock:         clock,
+			Rand:          rand.New(rand.NewSource(seed)), //nolint:gosec
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		nics := make([]*dummyNIC, 2)
+		for i := range nics {
+			n, err := NewNet(&NetConfig{StaticIPs: []string{fmt.Sprintf("1.2.3.%d", i+1)}})
+			assert.NoError(t, err, "should succeed")
+			nics[i] = &dummyNIC{Net: n}
+			assert.NoError(t, r.AddNet(nics[i]), "should succeed")
+		}
+
+		delayCh := make(chan time.Duration, 1)
+		nics[1].onInboundChunkHandler = func(c Chunk) {
+			delayCh <- clock.Now().Sub(c.getTimestamp())
+		}
+
+		assert.NoError(t, r.Start(), "should succeed")
+		defer func() {
+			assert.NoError(t, r.Stop(), "should succeed")
+		}()
+
+		src := &net.UDPAddr{IP: net.ParseIP("1.2.3.1"), Port: 1111}
+		dst := &net.UDPAddr{IP: net.ParseIP("1.2.3.2"), Port: 2222}
+
+		var delays []time.Duration
+		for i := 0; i < 5; i++ {
+			r.push(newChunkUDP(src, dst))
+
+			// advance by 1ms whenever the router waits for its timer
+			for delivered := false; !delivered; {
+				select {
+				case d := <-delayCh:
+					delays = append(delays, d)
+					delivered = true
+				default:
+					clock.mutex.Lock()
+					pending := len(clock.timers)
+					clock.mutex.Unlock()
+					if pending > 0 {
+						clock.Advance(time.Millisecond)
+					} else {
+						time.Sleep(time.Millisecond)
+					}
+				}
+			}
+		}
+		return delays
+	}
+
+	delays := run(t, 1)
+	for _, d := range delays {
+		assert.True(t, d >= minDelay, "should delay >= minDelay")
+		assert.True(t, d <= minDelay+maxJitter+time.Millisecond, "should delay <= minDelay + maxJitter")
+	}
+	assert.Equal(t, delays, run(t, 1), "should replay the same delays")
+}
+
+func TestRouterJitter(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	clock := NewManualClock(time.Now())
+	r, err := NewRouter(&RouterConfig{
+		CIDR:          "1.2.3.0/24",
+		MinDelay:      50 * time.Millisecond,
+		Jitter:        &Jitter{Distribution: DelayNormal, Deviation: 20 * time.Millisecond},
+		Clock:         clock,
+		Rand:          rand.New(rand.NewSource(1)), //nolint:gosec
+		LoggerFactory: loggerFactory,
+	})
+	assert.NoError(t, err, "should succeed")
+
+	nics := make([]*dummyNIC, 2)
+	for i := range nics {
+		n, err := NewNet(&NetConfig{StaticIPs: []string{fmt.Sprintf("1.2.3.%d", i+1)}})
+		assert.NoError(t, err, "should succeed")
+		nics[i] = &dummyNIC{Net: n}
+		assert.NoError(t, r.AddNet(nics[i]), "should succeed")
+	}
+
+	type arrival struct {
+		nr    int
+		delay time.Duration
+	}
+	arrivalCh := make(chan arrival, 10)
+	nics[1].onInboundChunkHandler = func(c Chunk) {
+		arrivalCh <- arrival{int(c.UserData()[0]), clock.Now().Sub(c.getTimestamp())}
+	}
+
+	assert.NoError(t, r.Start(), "should succeed")
+	defer func() {
+		assert.NoError(t, r.Stop(), "should succeed")
+	}()
+
+	src := &net.UDPAddr{IP: net.ParseIP("1.2.3.1"), Port: 1111}
+	dst := &net.UDPAddr{IP: net.ParseIP("1.2.3.2"), Port: 2222}
+	for i := 0; i < 10; i++ {
+		c := newChunkUDP(src, dst)
+		c.userData = []byte{byte(i)}
+		r.push(c)
+	}
+
+	// advance by 1ms whenever the router waits for its timer
+	var arrivals []arrival
+	for len(arrivals) < 10 {
+		select {
+		case a := <-arrivalCh:
+			arrivals = append(arrivals, a)
+		default:
+			clock.mutex.Lock()
+			pending := len(clock.timers)
+			clock.mutex.Unlock()
+			if pending > 0 {
+				clock.Advance(time.Millisecond)
+			} else {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+
+	// chunks pushed at once leave at their own time, not all together
+	reordered := false
+	for i, a := range arrivals {
+		if i > 0 {
+			assert.GreaterOrEqual(t, a.delay, arrivals[i-1].delay, "should arrive by delay")
+			reordered = reordered || a.nr < arrivals[i-1].nr
+		}
+	}
+	assert.NotEqual(t, arrivals[0].delay, arrivals[9].delay, "should have different delays")
+	assert.True(t, reordered, "should be reordered by the jitter")
+}
+
+func TestRouterQueueDiscipline(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	queue, err := NewFairQueue()
+	assert.NoError(t, err, "should succeed")
+
+	_, err = NewRouter(&RouterConfig{
+		CIDR:            "1.2.3.0/24",
+		QueueSize:       10,
+		QueueDiscipline: queue,
+		LoggerFactory:   loggerFactory,
+	})
+	assert.ErrorIs(t, err, errQueueSizeWithDiscipline, "should fail")
+	_, err = NewRouter(&RouterConfig{
+		CIDR:          "1.2.3.0/24",
+		Rate:          -1,
+		LoggerFactory: loggerFactory,
+	})
+	assert.ErrorIs(t, err, errInvalidRouterRate, "should fail")
+
+	r, err := NewRouter(&RouterConfig{
+		CIDR:            "1.2.3.0/24",
+		QueueDiscipline: queue,
+		LoggerFactory:   loggerFactory,
+	})
+	assert.NoError(t, err, "should succeed")
+
+	nics := make([]*dummyNIC, 2)
+	for i := range nics {
+		n, err := NewNet(&NetConfig{StaticIPs: []string{fmt.Sprintf("1.2.3.%d", i+1)}})
+		assert.NoError(t, err, "should succeed")
+		nics[i] = &dummyNIC{Net: n}
+		assert.NoError(t, r.AddNet(nics[i]), "should succeed")
+	}
+
+	arrivalCh := make(chan Chunk, 10)
+	nics[1].onInboundChunkHandler = func(c Chunk) {
+		arrivalCh <- c
+	}
+
+	assert.NoError(t, r.Start(), "should succeed")
+	defer func() {
+		assert.NoError(t, r.Stop(), "should succeed")
+	}()
+
+	dst := &net.UDPAddr{IP: net.ParseIP("1.2.3.2"), Port: 2222}
+	for i := 0; i < 10; i++ {
+		src := &net.UDPAddr{IP: net.ParseIP("1.2.3.1"), Port: 1111 + i%2}
+		r.push(newChunkUDP(src, dst))
+	}
+	for i := 0; i < 10; i++ {
+		<-arrivalCh
+	}
+
+	stats := r.QueueStats()
+	assert.Equal(t, uint64(10), stats.Enqueued)
+	assert.Equal(t, uint64(10), stats.Dequeued)
+	assert.Equal(t, uint64(0), stats.Drops())
+	assert.Equal(t, 0, stats.Length)
+}
+
+func TestRouterRate(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	// run pushes n chunks of 100 bytes at once through a router forwarding
+	// 8 kbit/s, one every 100ms, and returns their arrival times until done
+	run := func(t *testing.T, queue QueueDiscipline, n int, done func(arrivals int) bool) []time.Duration {
+		t.Helper()
+
+		clock := NewManualClock(time.Unix(0, 0))
+		r, err := NewRouter(&RouterConfig{
+			CIDR:            "1.2.3.0/24",
+			QueueDiscipline: queue,
+			Rate:            8 * KBit,
+			Clock:           clock,
+			LoggerFactory:   loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		nics := make([]*dummyNIC, 2)
+		for i := range nics {
+			n, err := NewNet(&NetConfig{StaticIPs: []string{fmt.Sprintf("1.2.3.%d", i+1)}})
+			assert.NoError(t, err, "should succeed")
+			nics[i] = &dummyNIC{Net: n}
+			assert.NoError(t, r.AddNet(nics[i]), "should succeed")
+		}
+
+		var mutex sync.Mutex
+		var arrivals []time.Duration
+		nics[1].onInboundChunkHandler = func(Chunk) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			arrivals = append(arrivals, clock.Now().Sub(time.Unix(0, 0)))
+		}
+
+		assert.NoError(t, r.Start(), "should succeed")
+		defer func() {
+			assert.NoError(t, r.Stop(), "should succeed")
+		}()
+
+		src := &net.UDPAddr{IP: net.ParseIP("1.2.3.1"), Port: 1111}
+		dst := &net.UDPAddr{IP: net.ParseIP("1.2.3.2"), Port: 2222}
+		for i := 0; i < n; i++ {
+			c := newChunkUDP(src, dst)
+			c.userData = make([]byte, 100)
+			r.push(c)
+		}
+
+		// advance by 1ms whenever the router waits for its timer
+		for {
+			mutex.Lock()
+			finished := done(len(arrivals))
+			mutex.Unlock()
+			if finished {
+				break
+			}
+
+			clock.mutex.Lock()
+			pending := len(clock.timers)
+			clock.mutex.Unlock()
+			if pending > 0 {
+				clock.Advance(time.Millisecond)
+			} else {
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		return arrivals
+	}
+
+	t.Run("DropTail", func(t *testing.T) {
+		queue := NewDropTailQueue(0, 0)
+		arrivals := run(t, queue, 5, func(arrivals int) bool {
+			return arrivals == 5
+		})
+
+		for i, arrival := range arrivals {
+			assert.GreaterOrEqual(t, arrival, time.Duration(i+1)*100*time.Millisecond, "should forward at the rate")
+		}
+		stats := queue.Stats()
+		assert.Equal(t, uint64(5), stats.Dequeued)
+		assert.GreaterOrEqual(t, stats.MaxSojourn, 400*time.Millisecond, "should wait in the queue")
+	})
+
+	t.Run("CoDel", func(t *testing.T) {
+		queue, err := NewCoDelQueue()
+		assert.NoError(t, err, "should succeed")
+		arrivals := run(t, queue, 30, func(arrivals int) bool {
+			stats := queue.Stats()
+			return stats.Length == 0 && uint64(arrivals) == stats.Dequeued
+		})
+
+		stats := queue.Stats()
+		assert.Greater(t, stats.AQMDrops, uint64(0), "should drop chunks that waited too long")
+		assert.Equal(t, 30, len(arrivals)+int(stats.AQMDrops))
+	})
+}
+
 func TestRouterOneChild(t *testing.T) {
 	loggerFactory := logging.NewDefaultLoggerFactory()
 	log := loggerFactory.NewLogger("test")
@@ -733,3 +1045,731 @@ func TestRouterFailures(t *testing.T) {
 		assert.Error(t, err, "should fail")
 	})
 }
+
+func TestRouterIPv6(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	getIPs := func(t *testing.T, n NIC) (ipv4, ipv6 net.IP) {
+		t.Helper()
+
+		eth0, err := n.getInterface("eth0")
+		assert.NoError(t, err, "should succeed")
+		addrs, err := eth0.Addrs()
+		assert.NoError(t, err, "should succeed")
+
+		for _, addr := range addrs {
+			ip := addr.(*net.IPNet).IP //nolint:forcetypeassert
+			if ip.To4() != nil {
+				ipv4 = ip
+			} else {
+				ipv6 = ip
+			}
+		}
+		return ipv4, ipv6
+	}
+
+	t.Run("CIDR parsing", func(t *testing.T) {
+		r, err := NewRouter(&RouterConfig{
+			CIDR:          "2001:db8::/64",
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+		assert.Nil(t, r.ipv4Net, "should be IPv6-only")
+		assert.Equal(t, "2001:db8::/64", r.ipv6Net.String(), "should match")
+
+		r, err = NewRouter(&RouterConfig{
+			CIDR:          "1.2.3.0/24",
+			CIDRv6:        "2001:db8::/64",
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, "1.2.3.0/24", r.ipv4Net.String(), "should match")
+		assert.Equal(t, "2001:db8::/64", r.ipv6Net.String(), "should match")
+
+		_, err = NewRouter(&RouterConfig{
+			CIDR:          "1.2.3.0/24",
+			CIDRv6:        "1.2.4.0/24",
+			LoggerFactory: loggerFactory,
+		})
+		assert.ErrorIs(t, err, errInvalidCIDRv6, "should fail")
+	})
+
+	t.Run("SLAAC", func(t *testing.T) {
+		r, err := NewRouter(&RouterConfig{
+			CIDR:          "1.2.3.0/24",
+			CIDRv6:        "2001:db8::/64",
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		nw, err := NewNet(&NetConfig{})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, r.AddNet(nw), "should succeed")
+
+		ipv4, ipv6 := getIPs(t, nw)
+		assert.Equal(t, "1.2.3.1", ipv4.String(), "should match")
+
+		eth0, err := nw.getInterface("eth0")
+		assert.NoError(t, err, "should succeed")
+		mac := eth0.HardwareAddr
+		expected := net.IP{
+			0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0,
+			mac[0] ^ 0x02, mac[1], mac[2], 0xff, 0xfe, mac[3], mac[4], mac[5],
+		}
+		assert.Equal(t, expected.String(), ipv6.String(), "should be EUI-64 based")
+
+		ips := nw.getAllIPAddrs(true)
+		assert.Equal(t, 1, len(ips), "should match")
+		assert.True(t, ips[0].Equal(ipv6), "should match")
+	})
+
+	t.Run("Sequential on long prefix", func(t *testing.T) {
+		r, err := NewRouter(&RouterConfig{
+			CIDR:          "2001:db8::/120",
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		for i := 1; i <= 2; i++ {
+			nw, err := NewNet(&NetConfig{})
+			assert.NoError(t, err, "should succeed")
+			assert.NoError(t, r.AddNet(nw), "should succeed")
+
+			ipv4, ipv6 := getIPs(t, nw)
+			assert.Nil(t, ipv4, "should be IPv6-only")
+			assert.Equal(t, fmt.Sprintf("2001:db8::%d", i), ipv6.String(), "should match")
+		}
+	})
+
+	t.Run("Static IPv6", func(t *testing.T) {
+		r, err := NewRouter(&RouterConfig{
+			CIDR:          "1.2.3.0/24",
+			CIDRv6:        "2001:db8::/64",
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		nw, err := NewNet(&NetConfig{StaticIPs: []string{"2001:db8::beef"}})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, r.AddNet(nw), "should succeed")
+
+		ipv4, ipv6 := getIPs(t, nw)
+		assert.Equal(t, "1.2.3.1", ipv4.String(), "IPv4 should be still assigned")
+		assert.Equal(t, "2001:db8::beef", ipv6.String(), "should match")
+
+		nw, err = NewNet(&NetConfig{StaticIPs: []string{"2001:db9::beef"}})
+		assert.NoError(t, err, "should succeed")
+		assert.ErrorIs(t, r.AddNet(nw), errStaticIPisBeyondSubnet, "should fail")
+	})
+
+	t.Run("Dual-stack routing", func(t *testing.T) {
+		wan, err := NewRouter(&RouterConfig{
+			CIDR:          "1.2.3.0/24",
+			CIDRv6:        "2001:db8::/64",
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		lan, err := NewRouter(&RouterConfig{
+			CIDR:          "192.168.0.0/24",
+			CIDRv6:        "2001:db8:1::/64",
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, wan.AddRouter(lan), "should succeed")
+
+		server, err := NewNet(&NetConfig{})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, wan.AddNet(server), "should succeed")
+
+		client, err := NewNet(&NetConfig{})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, lan.AddNet(client), "should succeed")
+
+		assert.NoError(t, wan.Start(), "should succeed")
+		defer func() {
+			assert.NoError(t, wan.Stop(), "should succeed")
+		}()
+
+		_, serverIPv6 := getIPs(t, server)
+		clientIPv4, clientIPv6 := getIPs(t, client)
+
+		serverConn, err := server.ListenUDP(udp6, &net.UDPAddr{Port: 3478})
+		assert.NoError(t, err, "should succeed")
+		serverConn4, err := server.ListenUDP(udp4, &net.UDPAddr{Port: 3478})
+		assert.NoError(t, err, "should succeed")
+
+		clientConn, err := client.ListenUDP(udp6, nil)
+		assert.NoError(t, err, "should succeed")
+		clientConn4, err := client.ListenUDP(udp4, nil)
+		assert.NoError(t, err, "should succeed")
+
+		buf := make([]byte, 1500)
+
+		// IPv6 is routed end-to-end without NAT, both ways
+		_, err = clientConn.WriteTo([]byte("hello"), &net.UDPAddr{IP: serverIPv6, Port: 3478})
+		assert.NoError(t, err, "should succeed")
+		n, addr, err := serverConn.ReadFrom(buf)
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, "hello", string(buf[:n]), "should match")
+		assert.True(t, addr.(*net.UDPAddr).IP.Equal(clientIPv6), "should not be translated") //nolint:forcetypeassert
+
+		_, err = serverConn.WriteTo([]byte("world"), addr)
+		assert.NoError(t, err, "should succeed")
+		n, _, err = clientConn.ReadFrom(buf)
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, "world", string(buf[:n]), "should match")
+
+		// IPv4 still goes through the NAT
+		serverIPv4, _ := getIPs(t, server)
+		_, err = clientConn4.WriteTo([]byte("hello"), &net.UDPAddr{IP: serverIPv4, Port: 3478})
+		assert.NoError(t, err, "should succeed")
+		n, addr, err = serverConn4.ReadFrom(buf)
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, "hello", string(buf[:n]), "should match")
+		assert.False(t, addr.(*net.UDPAddr).IP.Equal(clientIPv4), "should be translated") //nolint:forcetypeassert
+
+		for _, c := range []transport.UDPConn{serverConn, serverConn4, clientConn, clientConn4} {
+			assert.NoError(t, c.Close(), "should succeed")
+		}
+	})
+}
+
+func TestRouterHairpinning(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	setup := func(t *testing.T, hairpinning bool) (wan *Router, stun, connA, connB transport.UDPConn) {
+		t.Helper()
+
+		wan, err := NewRouter(&RouterConfig{
+			CIDR:          "1.2.3.0/24",
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		lan, err := NewRouter(&RouterConfig{
+			CIDR: "192.168.0.0/24",
+			NATType: &NATType{
+				MappingBehavior:   EndpointIndependent,
+				FilteringBehavior: EndpointIndependent,
+				Hairpinning:       hairpinning,
+			},
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, wan.AddRouter(lan), "should succeed")
+
+		stunNet, err := NewNet(&NetConfig{StaticIPs: []string{"1.2.3.4"}})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, wan.AddNet(stunNet), "should succeed")
+
+		netA, err := NewNet(&NetConfig{})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, lan.AddNet(netA), "should succeed")
+
+		netB, err := NewNet(&NetConfig{})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, lan.AddNet(netB), "should succeed")
+
+		assert.NoError(t, wan.Start(), "should succeed")
+
+		stun, err = stunNet.ListenUDP(udp4, &net.UDPAddr{Port: 3478})
+		assert.NoError(t, err, "should succeed")
+		connA, err = netA.ListenUDP(udp4, nil)
+		assert.NoError(t, err, "should succeed")
+		connB, err = netB.ListenUDP(udp4, nil)
+		assert.NoError(t, err, "should succeed")
+
+		return wan, stun, connA, connB
+	}
+
+	// mappedAddr learns the mapped address of conn like a STUN binding request
+	mappedAddr := func(t *testing.T, stun, conn transport.UDPConn) net.Addr {
+		t.Helper()
+
+		_, err := conn.WriteTo([]byte("binding"), &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 3478})
+		assert.NoError(t, err, "should succeed")
+		_, addr, err := stun.ReadFrom(make([]byte, 1500))
+		assert.NoError(t, err, "should succeed")
+		return addr
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		wan, stun, connA, connB := setup(t, true)
+		defer func() {
+			assert.NoError(t, wan.Stop(), "should succeed")
+		}()
+
+		mappedA := mappedAddr(t, stun, connA)
+		mappedB := mappedAddr(t, stun, connB)
+
+		_, err := connA.WriteTo([]byte("hello"), mappedB)
+		assert.NoError(t, err, "should succeed")
+
+		buf := make([]byte, 1500)
+		n, from, err := connB.ReadFrom(buf)
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, "hello", string(buf[:n]), "should match")
+		assert.Equal(t, mappedA.String(), from.String(), "source should be the mapped address of A")
+
+		// no duplicate packet, and nothing leaks to the WAN
+		assert.NoError(t, connB.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+		_, _, err = connB.ReadFrom(buf)
+		assert.Error(t, err, "should time out")
+		assert.NoError(t, stun.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+		_, _, err = stun.ReadFrom(buf)
+		assert.Error(t, err, "should time out")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		wan, stun, connA, connB := setup(t, false)
+		defer func() {
+			assert.NoError(t, wan.Stop(), "should succeed")
+		}()
+
+		mappedAddr(t, stun, connA)
+		mappedB := mappedAddr(t, stun, connB)
+
+		_, err := connA.WriteTo([]byte("hello"), mappedB)
+		assert.NoError(t, err, "should succeed")
+
+		assert.NoError(t, connB.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+		_, _, err = connB.ReadFrom(make([]byte, 1500))
+		assert.Error(t, err, "should time out")
+	})
+}
+
+func TestRouterICMP(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	wan, err := NewRouter(&RouterConfig{
+		CIDR:          "1.2.3.0/24",
+		LoggerFactory: loggerFactory,
+	})
+	assert.NoError(t, err, "should succeed")
+
+	lan, err := NewRouter(&RouterConfig{
+		CIDR: "192.168.0.0/24",
+		NATType: &NATType{
+			MappingBehavior:   EndpointIndependent,
+			FilteringBehavior: EndpointAddrPortDependent,
+		},
+		LoggerFactory: loggerFactory,
+	})
+	assert.NoError(t, err, "should succeed")
+	assert.NoError(t, wan.AddRouter(lan), "should succeed")
+
+	server, err := NewNet(&NetConfig{StaticIPs: []string{"1.2.3.4"}})
+	assert.NoError(t, err, "should succeed")
+	assert.NoError(t, wan.AddNet(server), "should succeed")
+
+	client, err := NewNet(&NetConfig{})
+	assert.NoError(t, err, "should succeed")
+	assert.NoError(t, lan.AddNet(client), "should succeed")
+
+	assert.NoError(t, wan.Start(), "should succeed")
+	defer func() {
+		assert.NoError(t, wan.Stop(), "should succeed")
+	}()
+
+	dialAndRead := func(t *testing.T, address string) error {
+		t.Helper()
+
+		conn, err := client.Dial(udp, address)
+		assert.NoError(t, err, "should succeed")
+		defer func() {
+			assert.NoError(t, conn.Close(), "should succeed")
+		}()
+
+		_, err = conn.Write([]byte("Hello"))
+		assert.NoError(t, err, "should succeed")
+
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)), "should succeed")
+		_, err = conn.Read(make([]byte, 1500))
+		return err
+	}
+
+	t.Run("port unreachable through NAT", func(t *testing.T) {
+		err := dialAndRead(t, "1.2.3.4:9999")
+		assert.ErrorIs(t, err, errConnectionRefused, "should be refused")
+
+		var opErr *net.OpError
+		assert.True(t, errors.As(err, &opErr), "should be a net.OpError")
+		assert.Equal(t, "1.2.3.4:9999", opErr.Addr.String(), "should match")
+	})
+
+	t.Run("host unreachable", func(t *testing.T) {
+		assert.ErrorIs(t, dialAndRead(t, "1.2.3.99:9999"), errHostUnreachable, "should fail")
+	})
+
+	t.Run("net unreachable", func(t *testing.T) {
+		assert.ErrorIs(t, dialAndRead(t, "5.6.7.8:9999"), errNetUnreachable, "should fail")
+	})
+
+	t.Run("unconnected socket ignores errors", func(t *testing.T) {
+		conn, err := client.ListenPacket(udp4, "0.0.0.0:0")
+		assert.NoError(t, err, "should succeed")
+		defer func() {
+			assert.NoError(t, conn.Close(), "should succeed")
+		}()
+
+		_, err = conn.WriteTo([]byte("Hello"), &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 9999})
+		assert.NoError(t, err, "should succeed")
+
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(100*time.Millisecond)), "should succeed")
+		_, _, err = conn.ReadFrom(make([]byte, 1500))
+		var netErr net.Error
+		assert.True(t, errors.As(err, &netErr) && netErr.Timeout(), "should time out")
+	})
+}
+
+func TestRouterMTU(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	// setup returns two Nets on a WAN, the second one behind a link with a
+	// small MTU
+	setup := func(t *testing.T, policy MTUPolicy) (*Router, *Net, *Net) {
+		t.Helper()
+
+		wan, err := NewRouter(&RouterConfig{
+			CIDR:          "1.2.3.0/24",
+			MTUPolicy:     policy,
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		net1, err := NewNet(&NetConfig{StaticIPs: []string{"1.2.3.4"}})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, wan.AddNet(net1), "should succeed")
+
+		net2, err := NewNet(&NetConfig{StaticIPs: []string{"1.2.3.5"}, MTU: 576})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, wan.AddNet(net2), "should succeed")
+
+		assert.NoError(t, wan.Start(), "should succeed")
+		return wan, net1, net2
+	}
+
+	sendAndReceive := func(t *testing.T, from, to *Net, size int) ([]byte, error) {
+		t.Helper()
+
+		conn2, err := to.ListenPacket(udp, "1.2.3.5:5678")
+		assert.NoError(t, err, "should succeed")
+		defer func() {
+			assert.NoError(t, conn2.Close(), "should succeed")
+		}()
+
+		conn1, err := from.Dial(udp, "1.2.3.5:5678")
+		assert.NoError(t, err, "should succeed")
+		defer func() {
+			assert.NoError(t, conn1.Close(), "should succeed")
+		}()
+
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		_, err = conn1.Write(data)
+		assert.NoError(t, err, "should succeed")
+
+		buf := make([]byte, 65536)
+		assert.NoError(t, conn2.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+		n, _, err := conn2.ReadFrom(buf)
+		if err != nil {
+			// tell the sender's error, if any
+			assert.NoError(t, conn1.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+			_, err = conn1.Read(buf)
+			return nil, err
+		}
+		assert.Equal(t, data, buf[:n], "should match")
+		return buf[:n], nil
+	}
+
+	t.Run("invalid MTU", func(t *testing.T) {
+		_, err := NewNet(&NetConfig{MTU: 67})
+		assert.ErrorIs(t, err, errInvalidMTU, "should fail")
+		_, err = NewRouter(&RouterConfig{CIDR: "1.2.3.0/24", MTU: 67, LoggerFactory: loggerFactory})
+		assert.ErrorIs(t, err, errInvalidMTU, "should fail")
+	})
+
+	t.Run("fragment", func(t *testing.T) {
+		wan, net1, net2 := setup(t, MTUPolicyFragment)
+		defer func() {
+			assert.NoError(t, wan.Stop(), "should succeed")
+		}()
+
+		// fragmented by the router
+		_, err := sendAndReceive(t, net1, net2, 1400)
+		assert.NoError(t, err, "should succeed")
+
+		// fragmented by the sender
+		_, err = sendAndReceive(t, net1, net2, 10000)
+		assert.NoError(t, err, "should succeed")
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		wan, net1, net2 := setup(t, MTUPolicyDrop)
+		defer func() {
+			assert.NoError(t, wan.Stop(), "should succeed")
+		}()
+
+		_, err := sendAndReceive(t, net1, net2, 500)
+		assert.NoError(t, err, "should succeed")
+
+		_, err = sendAndReceive(t, net1, net2, 1400)
+		assert.ErrorIs(t, err, errMessageTooLong, "should be too big")
+	})
+
+	t.Run("fragment before NAT", func(t *testing.T) {
+		wan, err := NewRouter(&RouterConfig{
+			CIDR:          "1.2.3.0/24",
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+
+		lan, err := NewRouter(&RouterConfig{
+			CIDR:          "192.168.0.0/24",
+			MTU:           1000,
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, wan.AddRouter(lan), "should succeed")
+
+		net1, err := NewNet(&NetConfig{})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, lan.AddNet(net1), "should succeed")
+
+		net2, err := NewNet(&NetConfig{StaticIPs: []string{"1.2.3.5"}})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, wan.AddNet(net2), "should succeed")
+
+		assert.NoError(t, wan.Start(), "should succeed")
+		defer func() {
+			assert.NoError(t, wan.Stop(), "should succeed")
+		}()
+
+		_, err = sendAndReceive(t, net1, net2, 1400)
+		assert.NoError(t, err, "should succeed")
+	})
+
+	t.Run("TCP", func(t *testing.T) {
+		wan, net1, net2 := setup(t, MTUPolicyDrop)
+		defer func() {
+			assert.NoError(t, wan.Stop(), "should succeed")
+		}()
+
+		l, err := net2.ListenTCP(tcp, &net.TCPAddr{Port: 443})
+		assert.NoError(t, err, "should succeed")
+		echo(t, l)
+
+		conn, err := net1.DialTCP(tcp, nil, &net.TCPAddr{IP: net.ParseIP("1.2.3.5"), Port: 443})
+		assert.NoError(t, err, "should succeed")
+
+		// segments of both ends fit the small link
+		transferAndVerify(t, conn, 64*1024)
+		assert.NoError(t, conn.Close(), "should succeed")
+		assert.NoError(t, l.Close(), "should succeed")
+	})
+}
+
+func TestRouterRemove(t *testing.T) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	// setup creates a WAN with a server, and a LAN behind a NAT with a host
+	setup := func(t *testing.T) (wan, lan *Router, server, host *Net) {
+		t.Helper()
+
+		var err error
+		wan, err = NewRouter(&RouterConfig{CIDR: "1.2.3.0/24", LoggerFactory: loggerFactory})
+		assert.NoError(t, err, "should succeed")
+		lan, err = NewRouter(&RouterConfig{CIDR: "192.168.0.0/24", LoggerFactory: loggerFactory})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, wan.AddRouter(lan), "should succeed")
+
+		server, err = NewNet(&NetConfig{StaticIPs: []string{"1.2.3.100"}})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, wan.AddNet(server), "should succeed")
+		host, err = NewNet(&NetConfig{})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, lan.AddNet(host), "should succeed")
+		return wan, lan, server, host
+	}
+
+	// ping sends a datagram from conn to the server and waits for it
+	ping := func(t *testing.T, conn, serverConn net.PacketConn) error {
+		t.Helper()
+
+		if _, err := conn.WriteTo([]byte("ping"), serverConn.LocalAddr()); err != nil {
+			return err
+		}
+		buf := make([]byte, 1500)
+		assert.NoError(t, serverConn.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+		_, _, err := serverConn.ReadFrom(buf)
+		return err
+	}
+
+	natMappings := func(r *Router) int {
+		r.mutex.RLock()
+		nat := r.nat
+		r.mutex.RUnlock()
+
+		nat.mutex.RLock()
+		defer nat.mutex.RUnlock()
+		return len(nat.outboundMap)
+	}
+
+	t.Run("RemoveNet", func(t *testing.T) {
+		wan, lan, server, host := setup(t)
+		assert.NoError(t, wan.Start(), "should succeed")
+		defer func() {
+			assert.NoError(t, wan.Stop(), "should succeed")
+		}()
+
+		serverConn, err := server.ListenPacket(udp, "1.2.3.100:5678")
+		assert.NoError(t, err, "should succeed")
+		defer func() {
+			assert.NoError(t, serverConn.Close(), "should succeed")
+		}()
+
+		conn, err := host.ListenPacket(udp, "0.0.0.0:1234")
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, ping(t, conn, serverConn), "should succeed")
+		assert.Equal(t, 1, natMappings(lan), "should have a binding")
+
+		hostIP, err := getIPAddr(host)
+		assert.NoError(t, err, "should succeed")
+
+		assert.NoError(t, lan.RemoveNet(host), "should succeed")
+		assert.ErrorIs(t, lan.RemoveNet(host), errNICNotFound, "should fail")
+
+		_, _, err = conn.ReadFrom(make([]byte, 1500))
+		assert.Error(t, err, "should be closed")
+		assert.Equal(t, 0, natMappings(lan), "should have no binding")
+		_, err = getIPAddr(host)
+		assert.Error(t, err, "should have no address")
+		lan.mutex.RLock()
+		_, ok := lan.nics[hostIP]
+		lan.mutex.RUnlock()
+		assert.False(t, ok, "should release the address")
+
+		// the host comes back, like after a reboot
+		assert.NoError(t, lan.AddNet(host), "should succeed")
+		conn, err = host.ListenPacket(udp, "0.0.0.0:1234")
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, ping(t, conn, serverConn), "should succeed")
+		assert.NoError(t, conn.Close(), "should succeed")
+	})
+
+	t.Run("Renumber", func(t *testing.T) {
+		wan, lan, server, host := setup(t)
+		assert.NoError(t, wan.Start(), "should succeed")
+		defer func() {
+			assert.NoError(t, wan.Stop(), "should succeed")
+		}()
+
+		serverConn, err := server.ListenPacket(udp, "1.2.3.100:5678")
+		assert.NoError(t, err, "should succeed")
+		defer func() {
+			assert.NoError(t, serverConn.Close(), "should succeed")
+		}()
+
+		conn, err := host.ListenPacket(udp, "0.0.0.0:1234")
+		assert.NoError(t, err, "should succeed")
+		defer func() {
+			assert.NoError(t, conn.Close(), "should succeed")
+		}()
+		assert.NoError(t, ping(t, conn, serverConn), "should succeed")
+		assert.Equal(t, 1, natMappings(lan), "should have a binding")
+
+		hostIP, err := getIPAddr(host)
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, host.Renumber("eth0", hostIP), "should succeed")
+		assert.Equal(t, 1, natMappings(lan), "should keep the binding of a kept address")
+
+		assert.NoError(t, host.Renumber("eth0"), "should succeed")
+		assert.Equal(t, 0, natMappings(lan), "should remove the binding of the old address")
+		assert.NoError(t, ping(t, conn, serverConn), "should succeed")
+		assert.Equal(t, 1, natMappings(lan), "should bind the new address")
+	})
+
+	t.Run("RemoveNet with TCP", func(t *testing.T) {
+		wan, lan, server, host := setup(t)
+		assert.NoError(t, wan.Start(), "should succeed")
+		defer func() {
+			assert.NoError(t, wan.Stop(), "should succeed")
+		}()
+
+		serverListener, err := server.ListenTCP(tcp4, &net.TCPAddr{IP: net.ParseIP("1.2.3.100"), Port: 5678})
+		assert.NoError(t, err, "should succeed")
+		defer func() {
+			assert.NoError(t, serverListener.Close(), "should succeed")
+		}()
+
+		conn, err := host.Dial(tcp, "1.2.3.100:5678")
+		assert.NoError(t, err, "should succeed")
+		accepted, err := serverListener.Accept()
+		assert.NoError(t, err, "should succeed")
+		defer func() {
+			_ = accepted.Close()
+		}()
+		listener, err := host.ListenTCP(tcp4, &net.TCPAddr{Port: 80})
+		assert.NoError(t, err, "should succeed")
+
+		assert.NoError(t, lan.RemoveNet(host), "should succeed")
+
+		_, err = conn.Read(make([]byte, 1500))
+		assert.ErrorIs(t, err, errConnectionReset, "should be reset")
+		_, err = listener.Accept()
+		assert.Error(t, err, "should be closed")
+		assert.Equal(t, 0, host.tcpConns.size(), "should release the addresses")
+	})
+
+	t.Run("RemoveRouter", func(t *testing.T) {
+		wan, lan, server, host := setup(t)
+		assert.NoError(t, wan.Start(), "should succeed")
+		defer func() {
+			assert.NoError(t, wan.Stop(), "should succeed")
+		}()
+
+		serverConn, err := server.ListenPacket(udp, "1.2.3.100:5678")
+		assert.NoError(t, err, "should succeed")
+		defer func() {
+			assert.NoError(t, serverConn.Close(), "should succeed")
+		}()
+
+		conn, err := host.ListenPacket(udp, "0.0.0.0:1234")
+		assert.NoError(t, err, "should succeed")
+		defer func() {
+			assert.NoError(t, conn.Close(), "should succeed")
+		}()
+		assert.NoError(t, ping(t, conn, serverConn), "should succeed")
+
+		lanIP, err := getIPAddr(lan)
+		assert.NoError(t, err, "should succeed")
+
+		assert.NoError(t, wan.RemoveRouter(lan), "should succeed")
+		assert.ErrorIs(t, wan.RemoveRouter(lan), errRouterNotChild, "should fail")
+		defer func() {
+			assert.NoError(t, lan.Stop(), "should be stopped separately")
+		}()
+
+		wan.mutex.RLock()
+		_, ok := wan.nics[lanIP]
+		wan.mutex.RUnlock()
+		assert.False(t, ok, "should release the address")
+		assert.False(t, lan.hasUplink(), "should have no uplink")
+		_, err = getIPAddr(lan)
+		assert.Error(t, err, "should have no address")
+
+		assert.Error(t, ping(t, conn, serverConn), "should be unreachable")
+
+		// a chunk in flight when the router was removed
+		udpChunk := newChunkUDP(
+			&net.UDPAddr{IP: net.ParseIP("1.2.3.100"), Port: 5678},
+			&net.UDPAddr{IP: net.ParseIP(lanIP), Port: 1234},
+		)
+		dropped := lan.Stats().Dropped[DropNoRoute].Packets
+		lan.onInboundChunk(udpChunk)
+		assert.Equal(t, dropped+1, lan.Stats().Dropped[DropNoRoute].Packets, "should drop the chunk")
+	})
+}
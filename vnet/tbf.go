@@ -25,13 +25,17 @@ type TokenBucketFilter struct {
 	NIC
 	currentTokensInBucket float64
 	c                     chan Chunk
-	queue                 *chunkQueue
-	queueSize             int // in bytes
+	queue                 QueueDiscipline
+	queueSize             int   // in bytes, of the default queue
+	head                  Chunk // owned by the run goroutine, left the queue and waits for tokens
 
 	mutex             sync.Mutex
 	rate              int
+	trace             *RateTrace // requires mutex, replaces the rate if set
+	traceStart        time.Time  // requires mutex
 	maxBurst          int
 	minRefillDuration time.Duration
+	clock             Clock
 
 	wg   sync.WaitGroup
 	done chan struct{}
@@ -52,6 +56,27 @@ func TBFQueueSizeInBytes(bytes int) TBFOption {
 	}
 }
 
+// TBFQueueDiscipline sets the queue the chunks wait in for tokens, which
+// replaces the drop-tail queue of TBFQueueSizeInBytes. Can only be set in
+// constructor before using the TBF.
+func TBFQueueDiscipline(queue QueueDiscipline) TBFOption {
+	return func(t *TokenBucketFilter) TBFOption {
+		prev := t.queue
+		t.queue = queue
+		return TBFQueueDiscipline(prev)
+	}
+}
+
+// TBFClock sets the clock the tokens are refilled with. Can only be set in
+// constructor before using the TBF. Defaults to the system clock.
+func TBFClock(clock Clock) TBFOption {
+	return func(t *TokenBucketFilter) TBFOption {
+		prev := t.clock
+		t.clock = clockOrSystem(clock)
+		return TBFClock(prev)
+	}
+}
+
 // TBFRate sets the bit rate of a TokenBucketFilter
 func TBFRate(rate int) TBFOption {
 	return func(t *TokenBucketFilter) TBFOption {
@@ -63,6 +88,19 @@ func TBFRate(rate int) TBFOption {
 	}
 }
 
+// TBFTrace drives the rate of a TokenBucketFilter by a trace, which starts
+// when it is set. A nil trace returns to the rate set with TBFRate.
+func TBFTrace(trace *RateTrace) TBFOption {
+	return func(t *TokenBucketFilter) TBFOption {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		previous := t.trace
+		t.trace = trace
+		t.traceStart = t.clock.Now()
+		return TBFTrace(previous)
+	}
+}
+
 // TBFMaxBurst sets the bucket size of the token bucket filter. This is the
 // maximum size that can instantly leave the filter, if the bucket is full.
 func TBFMaxBurst(size int) TBFOption {
@@ -95,12 +133,15 @@ func NewTokenBucketFilter(n NIC, opts ...TBFOption) (*TokenBucketFilter, error)
 		rate:                  1 * MBit,
 		maxBurst:              8 * KBit,
 		minRefillDuration:     100 * time.Millisecond,
+		clock:                 systemClock{},
 		wg:                    sync.WaitGroup{},
 		done:                  make(chan struct{}),
 		log:                   logging.NewDefaultLoggerFactory().NewLogger("tbf"),
 	}
 	tbf.Set(opts...)
-	tbf.queue = newChunkQueue(0, tbf.queueSize)
+	if tbf.queue == nil {
+		tbf.queue = NewDropTailQueue(0, tbf.queueSize)
+	}
 	tbf.wg.Add(1)
 	go tbf.run()
 	return tbf, nil
@@ -113,8 +154,18 @@ func (t *TokenBucketFilter) onInboundChunk(c Chunk) {
 func (t *TokenBucketFilter) run() {
 	defer t.wg.Done()
 
-	t.refillTokens(t.minRefillDuration)
-	lastRefill := time.Now()
+	// the options may have set the trace before the clock
+	t.mutex.Lock()
+	t.traceStart = t.clock.Now()
+	t.mutex.Unlock()
+
+	t.refillTokens(t.traceStart.Add(-t.minRefillDuration), t.traceStart)
+	lastRefill := t.clock.Now()
+
+	// refill while chunks are waiting, as the rate may change without any
+	// chunk arriving
+	timer := t.clock.NewTimer(t.minRefillDuration)
+	defer timer.Stop()
 
 	for {
 		select {
@@ -122,43 +173,69 @@ func (t *TokenBucketFilter) run() {
 			t.drainQueue()
 			return
 		case chunk := <-t.c:
-			if time.Since(lastRefill) > t.minRefillDuration {
-				t.refillTokens(time.Since(lastRefill))
-				lastRefill = time.Now()
+			if now := t.clock.Now(); now.Sub(lastRefill) > t.minRefillDuration {
+				t.refillTokens(lastRefill, now)
+				lastRefill = now
 			}
-			t.queue.push(chunk)
+			t.queue.Enqueue(chunk, t.clock.Now())
 			t.drainQueue()
+		case now := <-timer.C():
+			if t.head != nil || t.queue.Peek() != nil {
+				t.refillTokens(lastRefill, now)
+				lastRefill = now
+				t.drainQueue()
+			}
+			timer.Reset(t.minRefillDuration)
 		}
 	}
 }
 
-func (t *TokenBucketFilter) refillTokens(dt time.Duration) {
-	m := 1000.0 / float64(dt.Milliseconds())
-	add := (float64(t.rate) / m) / 8.0
+// refillTokens adds the tokens for the time between from and to
+func (t *TokenBucketFilter) refillTokens(from, to time.Time) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
+
+	var add float64
+	if t.trace != nil {
+		add = t.trace.bits(from.Sub(t.traceStart), to.Sub(t.traceStart)) / 8.0
+	} else {
+		dt := to.Sub(from)
+		m := 1000.0 / float64(dt.Milliseconds())
+		add = (float64(t.rate) / m) / 8.0
+	}
 	t.currentTokensInBucket = math.Min(float64(t.maxBurst), t.currentTokensInBucket+add)
-	t.log.Tracef("add=(%v / %v) / 8 = %v, currentTokensInBucket=%v, maxBurst=%v", t.rate, m, add, t.currentTokensInBucket, t.maxBurst)
+	t.log.Tracef("add=%v, currentTokensInBucket=%v, maxBurst=%v", add, t.currentTokensInBucket, t.maxBurst)
 }
 
 func (t *TokenBucketFilter) drainQueue() {
+	now := t.clock.Now()
 	for {
-		next := t.queue.peek()
-		if next == nil {
-			break
+		// the queue may drop chunks on the way out, and pass a later one,
+		// so the chunk that waits for the tokens is the one it passed
+		if t.head == nil {
+			c, ok := t.queue.Dequeue(now)
+			if !ok {
+				break
+			}
+			t.head = c
 		}
-		tokens := float64(len(next.UserData()))
+		tokens := float64(len(t.head.UserData()))
 		if t.currentTokensInBucket < tokens {
 			t.log.Tracef("currentTokensInBucket=%v, tokens=%v, stop drain", t.currentTokensInBucket, tokens)
 			break
 		}
 		t.log.Tracef("currentTokensInBucket=%v, tokens=%v, pop chunk", t.currentTokensInBucket, tokens)
-		t.queue.pop()
-		t.NIC.onInboundChunk(next)
+		t.NIC.onInboundChunk(t.head)
 		t.currentTokensInBucket -= tokens
+		t.head = nil
 	}
 }
 
+// QueueStats returns the statistics of the queue the chunks wait in
+func (t *TokenBucketFilter) QueueStats() QueueStats {
+	return t.queue.Stats()
+}
+
 // Close closes and stops the token bucket filter queue
 func (t *TokenBucketFilter) Close() error {
 	close(t.done)
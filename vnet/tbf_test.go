@@ -9,6 +9,7 @@ package vnet
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,6 +17,19 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// emptyPeekQueue is a queue whose Peek differs from what Dequeue passes,
+// like a queue that drops chunks on the way out
+type emptyPeekQueue struct {
+	*DropTailQueue
+}
+
+func (q *emptyPeekQueue) Peek() Chunk {
+	if q.DropTailQueue.Peek() == nil {
+		return nil
+	}
+	return &chunkUDP{}
+}
+
 func TestTokenBucketFilter(t *testing.T) {
 	t.Run("bitrateBelowCapacity", func(t *testing.T) {
 		mnic := newMockNIC(t)
@@ -42,6 +56,88 @@ func TestTokenBucketFilter(t *testing.T) {
 		assert.Equal(t, sent, received)
 	})
 
+	t.Run("Trace", func(t *testing.T) {
+		mnic := newMockNIC(t)
+
+		// 1200 bytes every 100ms for a second, then nothing for a second
+		trace, err := NewRateTrace(time.Second, []int{96 * KBit, 0})
+		assert.NoError(t, err, "should succeed")
+
+		clock := NewManualClock(time.Unix(0, 0))
+		tbf, err := NewTokenBucketFilter(mnic, TBFClock(clock), TBFTrace(trace), TBFMaxBurst(1*MBit))
+		assert.NoError(t, err, "should succeed")
+
+		var received int32
+		mnic.mockOnInboundChunk = func(Chunk) {
+			atomic.AddInt32(&received, 1)
+		}
+
+		clock.BlockUntil(1)
+		for i := 0; i < 20; i++ {
+			tbf.onInboundChunk(&chunkUDP{
+				userData: make([]byte, 1200),
+			})
+		}
+
+		advance := func(d time.Duration) {
+			for ; d > 0; d -= 100 * time.Millisecond {
+				clock.Advance(100 * time.Millisecond)
+				clock.BlockUntil(1)
+			}
+		}
+
+		advance(1500 * time.Millisecond)
+		assert.Equal(t, int32(10), atomic.LoadInt32(&received), "should pass the rate of the first second")
+		advance(400 * time.Millisecond)
+		assert.Equal(t, int32(10), atomic.LoadInt32(&received), "should pass nothing at a rate of 0")
+		advance(600 * time.Millisecond)
+		assert.Equal(t, int32(15), atomic.LoadInt32(&received), "should start the trace over")
+
+		assert.NoError(t, tbf.Close())
+	})
+
+	t.Run("QueueDiscipline", func(t *testing.T) {
+		mnic := newMockNIC(t)
+
+		// too few tokens for any chunk, so that they all wait
+		clock := NewManualClock(time.Unix(0, 0))
+		queue := NewDropTailQueue(3, 0)
+		tbf, err := NewTokenBucketFilter(mnic, TBFClock(clock), TBFRate(8*KBit), TBFQueueDiscipline(queue))
+		assert.NoError(t, err, "should succeed")
+
+		for i := 0; i < 10; i++ {
+			tbf.onInboundChunk(&chunkUDP{
+				userData: make([]byte, 1200),
+			})
+		}
+		assert.NoError(t, tbf.Close())
+
+		// the first chunk left the queue to wait for the tokens
+		stats := tbf.QueueStats()
+		assert.Equal(t, uint64(4), stats.Enqueued)
+		assert.Equal(t, uint64(1), stats.Dequeued)
+		assert.Equal(t, uint64(6), stats.TailDrops)
+		assert.Equal(t, 3, stats.Length)
+	})
+
+	t.Run("QueueDiscipline passing another chunk", func(t *testing.T) {
+		mnic := newMockNIC(t)
+
+		// the queue peeks an empty chunk, but passes the large ones
+		clock := NewManualClock(time.Unix(0, 0))
+		queue := &emptyPeekQueue{DropTailQueue: NewDropTailQueue(0, 0)}
+		tbf, err := NewTokenBucketFilter(mnic, TBFClock(clock), TBFRate(8*KBit), TBFQueueDiscipline(queue))
+		assert.NoError(t, err, "should succeed")
+
+		for i := 0; i < 3; i++ {
+			tbf.onInboundChunk(&chunkUDP{
+				userData: make([]byte, 1200),
+			})
+		}
+		assert.NoError(t, tbf.Close())
+		assert.Equal(t, uint64(1), tbf.QueueStats().Dequeued, "should wait for the tokens of the chunk passed")
+	})
+
 	subTest := func(t *testing.T, capacity int, maxBurst int, duration time.Duration) {
 		log := logging.NewDefaultLoggerFactory().NewLogger("test")
 
@@ -0,0 +1,983 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/transport/v3"
+	"github.com/pion/transport/v3/deadline"
+)
+
+const (
+	tcpMSS             = 1460      // max payload size of a single segment
+	tcpSendWindow      = 64 * 1024 // max number of unacknowledged bytes in flight
+	tcpReceiveWindow   = 0xFFFF    // max number of bytes buffered for the reader, the largest unscaled window
+	tcpInitialRTO      = 200 * time.Millisecond
+	tcpMinRTO          = 200 * time.Millisecond
+	tcpMaxRTO          = 5 * time.Second
+	tcpMaxRetransmits  = 10               // consecutive retransmissions before the connection is aborted
+	tcpDupAckThreshold = 3                // duplicate ACKs that trigger a fast retransmit
+	tcpTimeWait        = 60 * time.Second // 2*MSL, as on Linux
+)
+
+var (
+	errConnectionRefused = errors.New("connection refused")
+	errConnectionReset   = errors.New("connection reset by peer")
+	errBrokenPipe        = errors.New("broken pipe")
+)
+
+type tcpState uint8
+
+const (
+	tcpStateClosed tcpState = iota
+	tcpStateSynSent
+	tcpStateSynReceived
+	tcpStateEstablished
+	tcpStateFinWait1
+	tcpStateFinWait2
+	tcpStateCloseWait
+	tcpStateClosing
+	tcpStateLastAck
+	tcpStateTimeWait
+)
+
+func (s tcpState) String() string {
+	switch s {
+	case tcpStateClosed:
+		return "CLOSED"
+	case tcpStateSynSent:
+		return "SYN-SENT"
+	case tcpStateSynReceived:
+		return "SYN-RECEIVED"
+	case tcpStateEstablished:
+		return "ESTABLISHED"
+	case tcpStateFinWait1:
+		return "FIN-WAIT-1"
+	case tcpStateFinWait2:
+		return "FIN-WAIT-2"
+	case tcpStateCloseWait:
+		return "CLOSE-WAIT"
+	case tcpStateClosing:
+		return "CLOSING"
+	case tcpStateLastAck:
+		return "LAST-ACK"
+	case tcpStateTimeWait:
+		return "TIME-WAIT"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// seqLT reports whether sequence number a precedes b, taking wrap-around
+// into account (RFC 793 Section 3.3).
+func seqLT(a, b uint32) bool {
+	return int32(a-b) < 0 //nolint:gosec
+}
+
+// vNet implements this
+type tcpConnObserver interface {
+	write(c Chunk) error
+	onTCPConnClosed(conn *TCPConn)
+}
+
+type tcpSegment struct {
+	seq           uint32
+	flags         tcpFlag
+	data          []byte
+	sentAt        time.Time
+	retransmitted bool
+}
+
+func (s *tcpSegment) len() uint32 {
+	n := uint32(len(s.data))
+	if s.flags&(tcpSYN|tcpFIN) != 0 {
+		n++
+	}
+	return n
+}
+
+// TCPConn is the implementation of the Conn interface for TCP network
+// connections over the virtual network. It provides an ordered, reliable
+// byte stream using a three-way handshake, cumulative acknowledgements and
+// retransmissions, paced by the receive window of the peer, and is torn down
+// with FIN or RST just like a real TCP connection. The side that closes
+// first keeps the 4-tuple in TIME-WAIT for tcpTimeWait.
+type TCPConn struct {
+	locAddr  *net.TCPAddr    // read-only
+	remAddr  *net.TCPAddr    // read-only
+	obs      tcpConnObserver // read-only
+	clock    Clock           // read-only
+	listener *TCPListener    // read-only, set on passive open
+	mss      int             // read-only, max payload size of a single segment we receive
+
+	state        tcpState             // requires mutex
+	sndMSS       int                  // requires mutex, max payload size of a single segment we send
+	sndUna       uint32               // requires mutex, oldest unacknowledged sequence number
+	sndNxt       uint32               // requires mutex, next sequence number to send
+	sndWnd       int                  // requires mutex, receive window advertised by the peer
+	rcvNxt       uint32               // requires mutex, next sequence number expected
+	unacked      []*tcpSegment        // requires mutex, segments in flight
+	outOfOrder   map[uint32]*chunkTCP // requires mutex, received ahead of rcvNxt
+	readBuf      bytes.Buffer         // requires mutex
+	finSent      bool                 // requires mutex
+	finAcked     bool                 // requires mutex
+	finReceived  bool                 // requires mutex
+	readClosed   bool                 // requires mutex
+	writeClosed  bool                 // requires mutex
+	closed       bool                 // requires mutex
+	err          error                // requires mutex, terminal error of the connection
+	dupAcks      int                  // requires mutex
+	srtt         time.Duration        // requires mutex
+	rttvar       time.Duration        // requires mutex
+	rto          time.Duration        // requires mutex
+	retries      int                  // requires mutex
+	stopRtx      func()               // requires mutex, stops the retransmission timer
+	stopTimeWait func()               // requires mutex, stops the TIME-WAIT timer
+	linger       int                  // requires mutex
+	wakeCh       chan struct{}        // requires mutex, closed and replaced on every state change
+	established  chan struct{}        // closed when the handshake completes or fails
+	readTimer    *deadline.Deadline   // thread-safe
+	writeTimer   *deadline.Deadline   // thread-safe
+	mutex        sync.Mutex           // thread-safe
+}
+
+var _ transport.TCPConn = &TCPConn{}
+
+func newTCPConn(locAddr, remAddr *net.TCPAddr, obs tcpConnObserver, clock Clock, isn uint32) (*TCPConn, error) {
+	if obs == nil {
+		return nil, errObsCannotBeNil
+	}
+
+	return &TCPConn{
+		locAddr:     locAddr,
+		remAddr:     remAddr,
+		obs:         obs,
+		clock:       clockOrSystem(clock),
+		mss:         tcpMSS,
+		sndUna:      isn,
+		sndNxt:      isn,
+		outOfOrder:  map[uint32]*chunkTCP{},
+		rto:         tcpInitialRTO,
+		linger:      -1,
+		wakeCh:      make(chan struct{}),
+		established: make(chan struct{}),
+		readTimer:   deadline.New(),
+		writeTimer:  deadline.New(),
+	}, nil
+}
+
+// connect performs the active open. It blocks until the handshake completes
+// or fails.
+func (c *TCPConn) connect() error {
+	c.mutex.Lock()
+	c.state = tcpStateSynSent
+	c.sndMSS = c.mss
+	out := c.queueSegment(tcpSYN, nil)
+	c.mutex.Unlock()
+
+	c.send(out)
+
+	<-c.established
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.err
+}
+
+// acceptSYN performs the passive open upon a SYN received by a listener.
+func (c *TCPConn) acceptSYN(syn *chunkTCP) {
+	c.mutex.Lock()
+	c.state = tcpStateSynReceived
+	c.sndMSS = c.mss
+	c.updateSndMSS(syn)
+	c.sndWnd = syn.window
+	c.rcvNxt = syn.seq + 1
+	out := c.queueSegment(tcpSYN|tcpACK, nil)
+	c.mutex.Unlock()
+
+	c.send(out)
+}
+
+// caller must hold the mutex
+func (c *TCPConn) queueSegment(flags tcpFlag, data []byte) []*chunkTCP {
+	seg := &tcpSegment{
+		seq:   c.sndNxt,
+		flags: flags,
+		data:  data,
+	}
+	c.sndNxt += seg.len()
+	c.unacked = append(c.unacked, seg)
+	if c.stopRtx == nil {
+		c.armRetransmitTimer()
+	}
+
+	return []*chunkTCP{c.transmit(seg)}
+}
+
+// caller must hold the mutex
+func (c *TCPConn) transmit(seg *tcpSegment) *chunkTCP {
+	seg.sentAt = c.clock.Now()
+	chunk := c.newChunk(seg.flags, seg.seq)
+	if len(seg.data) > 0 {
+		chunk.flags |= tcpPSH
+		chunk.userData = seg.data
+	}
+	return chunk
+}
+
+// caller must hold the mutex
+func (c *TCPConn) newChunk(flags tcpFlag, seq uint32) *chunkTCP {
+	chunk := newChunkTCP(c.locAddr, c.remAddr, flags)
+	chunk.seq = seq
+	chunk.window = c.rcvWindow()
+	if flags&tcpSYN != 0 {
+		chunk.mss = c.mss
+	}
+	if c.state != tcpStateSynSent {
+		chunk.flags |= tcpACK
+		chunk.ack = c.rcvNxt
+	}
+	return chunk
+}
+
+// rcvWindow returns the number of bytes the reader can still buffer.
+// caller must hold the mutex
+func (c *TCPConn) rcvWindow() int {
+	return tcpReceiveWindow - c.readBuf.Len()
+}
+
+// updateSndMSS limits the size of the segments we send to the MSS option
+// of the peer's SYN.
+// caller must hold the mutex
+func (c *TCPConn) updateSndMSS(syn *chunkTCP) {
+	if syn.mss > 0 && syn.mss < c.sndMSS {
+		c.sndMSS = syn.mss
+	}
+}
+
+// send must be called without holding the mutex as the chunk may be
+// delivered synchronously (e.g. over the loopback).
+func (c *TCPConn) send(chunks []*chunkTCP) {
+	for _, chunk := range chunks {
+		if err := c.obs.write(chunk); err != nil {
+			return
+		}
+	}
+}
+
+// caller must hold the mutex
+func (c *TCPConn) armRetransmitTimer() {
+	if c.stopRtx != nil {
+		c.stopRtx()
+	}
+	if len(c.unacked) == 0 {
+		c.stopRtx = nil
+		return
+	}
+	c.stopRtx = afterFunc(c.clock, c.rto, c.onRetransmitTimeout)
+}
+
+func (c *TCPConn) onRetransmitTimeout() {
+	c.mutex.Lock()
+
+	if c.state == tcpStateClosed || len(c.unacked) == 0 {
+		c.mutex.Unlock()
+		return
+	}
+
+	c.retries++
+	if c.retries > tcpMaxRetransmits {
+		c.teardown(newTimeoutError("connection timed out"))
+		c.mutex.Unlock()
+		c.obs.onTCPConnClosed(c)
+		return
+	}
+
+	c.rto *= 2
+	if c.rto > tcpMaxRTO {
+		c.rto = tcpMaxRTO
+	}
+
+	// The receiver buffers out-of-order segments, so resending the whole
+	// window is harmless and recovers from bursty loss quickly.
+	out := make([]*chunkTCP, 0, len(c.unacked))
+	for _, seg := range c.unacked {
+		seg.retransmitted = true
+		out = append(out, c.transmit(seg))
+	}
+	c.armRetransmitTimer()
+	c.mutex.Unlock()
+
+	c.send(out)
+}
+
+// caller must hold the mutex
+func (c *TCPConn) updateRTO(sample time.Duration) {
+	// See RFC 6298 Section 2
+	if c.srtt == 0 {
+		c.srtt = sample
+		c.rttvar = sample / 2
+	} else {
+		delta := c.srtt - sample
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttvar = (3*c.rttvar + delta) / 4
+		c.srtt = (7*c.srtt + sample) / 8
+	}
+
+	c.rto = c.srtt + 4*c.rttvar
+	if c.rto < tcpMinRTO {
+		c.rto = tcpMinRTO
+	} else if c.rto > tcpMaxRTO {
+		c.rto = tcpMaxRTO
+	}
+}
+
+// caller must hold the mutex
+func (c *TCPConn) wake() {
+	close(c.wakeCh)
+	c.wakeCh = make(chan struct{})
+}
+
+// caller must hold the mutex
+func (c *TCPConn) setEstablished() {
+	select {
+	case <-c.established:
+	default:
+		close(c.established)
+	}
+}
+
+// teardown moves the connection to CLOSED. The caller must hold the mutex
+// and call obs.onTCPConnClosed after releasing it.
+func (c *TCPConn) teardown(err error) {
+	if c.state == tcpStateClosed {
+		return
+	}
+
+	c.state = tcpStateClosed
+	if c.err == nil {
+		c.err = err
+	}
+	if c.stopRtx != nil {
+		c.stopRtx()
+		c.stopRtx = nil
+	}
+	if c.stopTimeWait != nil {
+		c.stopTimeWait()
+		c.stopTimeWait = nil
+	}
+	c.unacked = nil
+	c.setEstablished()
+	c.wake()
+}
+
+// caller must hold the mutex
+func (c *TCPConn) isFinished() bool {
+	return c.finSent && c.finAcked && c.finReceived
+}
+
+// finish ends a connection once both FINs are acknowledged. The side that
+// closed first enters TIME-WAIT, the other one closes. It returns whether
+// the connection got closed.
+// caller must hold the mutex
+func (c *TCPConn) finish() bool {
+	switch c.state {
+	case tcpStateTimeWait:
+		return false
+	case tcpStateLastAck:
+		c.teardown(nil)
+		return true
+	default:
+		c.state = tcpStateTimeWait
+		c.restartTimeWait()
+		return false
+	}
+}
+
+// restartTimeWait keeps the 4-tuple in use for tcpTimeWait, so that no new
+// connection takes it while segments of this one may still be in flight.
+// caller must hold the mutex
+func (c *TCPConn) restartTimeWait() {
+	if c.stopTimeWait != nil {
+		c.stopTimeWait()
+	}
+	c.stopTimeWait = afterFunc(c.clock, tcpTimeWait, c.onTimeWaitTimeout)
+}
+
+func (c *TCPConn) onTimeWaitTimeout() {
+	c.mutex.Lock()
+	if c.state != tcpStateTimeWait {
+		c.mutex.Unlock()
+		return
+	}
+	c.teardown(nil)
+	c.mutex.Unlock()
+
+	c.obs.onTCPConnClosed(c)
+}
+
+func (c *TCPConn) onInboundChunk(chunk Chunk) {
+	seg, ok := chunk.(*chunkTCP)
+	if !ok {
+		return
+	}
+
+	c.mutex.Lock()
+	out, closed, accepted := c.handleSegment(seg)
+	c.mutex.Unlock()
+
+	c.send(out)
+
+	if accepted && c.listener != nil && !c.listener.enqueue(c) {
+		// the listener could not take it. reset the connection.
+		c.abort(errConnectionRefused)
+		return
+	}
+
+	if closed {
+		c.obs.onTCPConnClosed(c)
+	}
+}
+
+// handleSegment processes an inbound segment and returns the chunks to send
+// in response, whether the connection got closed, and whether a passive open
+// has just completed.
+// caller must hold the mutex
+func (c *TCPConn) handleSegment(seg *chunkTCP) ([]*chunkTCP, bool, bool) { //nolint:gocognit,cyclop
+	var out []*chunkTCP
+
+	if c.state == tcpStateClosed {
+		return nil, false, false
+	}
+
+	if c.state == tcpStateTimeWait {
+		// only a retransmitted FIN is expected, as our last ACK got lost.
+		// acknowledge it again (RFC 793 Section 3.9). anything else, a RST
+		// included, is ignored (RFC 1337).
+		if seg.flags&tcpFIN == 0 {
+			return nil, false, false
+		}
+		c.restartTimeWait()
+		return []*chunkTCP{c.newChunk(0, c.sndNxt)}, false, false
+	}
+
+	if seg.flags&tcpRST != 0 {
+		if c.state == tcpStateSynSent {
+			if seg.flags&tcpACK == 0 || seg.ack != c.sndNxt {
+				return nil, false, false
+			}
+			c.teardown(errConnectionRefused)
+		} else {
+			c.teardown(errConnectionReset)
+		}
+		return nil, true, false
+	}
+
+	accepted := false
+
+	switch c.state {
+	case tcpStateSynSent:
+		if seg.flags&(tcpSYN|tcpACK) != tcpSYN|tcpACK || seg.ack != c.sndNxt {
+			return nil, false, false
+		}
+		c.rcvNxt = seg.seq + 1
+		c.state = tcpStateEstablished
+		c.updateSndMSS(seg)
+		_ = c.handleAck(seg.ack, seg.window)
+		c.setEstablished()
+		c.wake()
+		return []*chunkTCP{c.newChunk(0, c.sndNxt)}, false, false
+
+	case tcpStateSynReceived:
+		if seg.flags&tcpSYN != 0 {
+			// our SYN-ACK got lost. the retransmission timer will take care
+			return nil, false, false
+		}
+		if seg.flags&tcpACK == 0 || seg.ack != c.sndNxt {
+			return nil, false, false
+		}
+		c.state = tcpStateEstablished
+		c.setEstablished()
+		accepted = true
+
+	default:
+		if seg.flags&tcpSYN != 0 {
+			// our final ACK of the handshake got lost. acknowledge again.
+			return []*chunkTCP{c.newChunk(0, c.sndNxt)}, false, false
+		}
+	}
+
+	if seg.flags&tcpACK != 0 {
+		out = append(out, c.handleAck(seg.ack, seg.window)...)
+	}
+
+	if seg.segmentLen() > 0 {
+		c.handleData(seg)
+		out = append(out, c.newChunk(0, c.sndNxt))
+	}
+
+	closed := false
+	if c.isFinished() {
+		closed = c.finish()
+	}
+
+	c.wake()
+
+	return out, closed, accepted
+}
+
+// handleAck processes a cumulative acknowledgement with the receive window
+// of the peer and returns the chunks to retransmit, if any.
+// caller must hold the mutex
+func (c *TCPConn) handleAck(ack uint32, window int) []*chunkTCP {
+	if !seqLT(ack, c.sndUna) && !seqLT(c.sndNxt, ack) {
+		c.sndWnd = window
+		if window == 0 {
+			// the peer answers our window probes. keep probing for as long
+			// as its window stays closed (RFC 1122 Section 4.2.2.17).
+			c.retries = 0
+		}
+	}
+
+	if !seqLT(c.sndUna, ack) || seqLT(c.sndNxt, ack) {
+		if ack == c.sndUna && len(c.unacked) > 0 {
+			c.dupAcks++
+			if c.dupAcks == tcpDupAckThreshold {
+				// fast retransmit (RFC 5681 Section 3.2)
+				seg := c.unacked[0]
+				seg.retransmitted = true
+				return []*chunkTCP{c.transmit(seg)}
+			}
+		}
+		return nil
+	}
+
+	c.sndUna = ack
+	c.dupAcks = 0
+	c.retries = 0
+
+	i := 0
+	for ; i < len(c.unacked); i++ {
+		seg := c.unacked[i]
+		if seqLT(ack, seg.seq+seg.len()) {
+			break
+		}
+		if !seg.retransmitted {
+			// Karn's algorithm: only sample segments sent once
+			c.updateRTO(c.clock.Now().Sub(seg.sentAt))
+		}
+		if seg.flags&tcpFIN != 0 {
+			c.finAcked = true
+		}
+	}
+	c.unacked = c.unacked[i:]
+	c.armRetransmitTimer()
+
+	if c.finAcked {
+		switch c.state {
+		case tcpStateFinWait1:
+			c.state = tcpStateFinWait2
+		case tcpStateClosing, tcpStateLastAck:
+			// isFinished() will close the connection
+		default:
+		}
+	}
+
+	return nil
+}
+
+// caller must hold the mutex
+func (c *TCPConn) handleData(seg *chunkTCP) {
+	if seqLT(seg.seq, c.rcvNxt) {
+		return // duplicate. just acknowledge.
+	}
+
+	if seqLT(c.rcvNxt+uint32(c.rcvWindow()), seg.seq+uint32(len(seg.userData))) { //nolint:gosec
+		return // beyond the receive window. the sender will retransmit.
+	}
+
+	if seg.seq != c.rcvNxt {
+		c.outOfOrder[seg.seq] = seg
+		return
+	}
+
+	for seg != nil {
+		if !c.readClosed {
+			c.readBuf.Write(seg.userData)
+		}
+		c.rcvNxt += uint32(len(seg.userData))
+
+		if seg.flags&tcpFIN != 0 {
+			c.rcvNxt++
+			c.finReceived = true
+			switch c.state {
+			case tcpStateEstablished:
+				c.state = tcpStateCloseWait
+			case tcpStateFinWait1:
+				c.state = tcpStateClosing
+			default:
+			}
+			break
+		}
+
+		next, ok := c.outOfOrder[c.rcvNxt]
+		if ok {
+			delete(c.outOfOrder, c.rcvNxt)
+		}
+		seg = next
+	}
+
+	// discard anything that is now stale
+	for seq := range c.outOfOrder {
+		if seqLT(seq, c.rcvNxt) {
+			delete(c.outOfOrder, seq)
+		}
+	}
+}
+
+// abort resets the connection, discarding any unsent data.
+func (c *TCPConn) abort(err error) {
+	c.mutex.Lock()
+	if c.state == tcpStateClosed {
+		c.mutex.Unlock()
+		return
+	}
+	rst := c.newChunk(tcpRST, c.sndNxt)
+	c.teardown(err)
+	c.mutex.Unlock()
+
+	c.send([]*chunkTCP{rst})
+	c.obs.onTCPConnClosed(c)
+}
+
+// reset tears the connection down without sending a RST, as its local
+// address is gone.
+func (c *TCPConn) reset(err error) {
+	c.mutex.Lock()
+	if c.state == tcpStateClosed {
+		c.mutex.Unlock()
+		return
+	}
+	c.teardown(err)
+	c.mutex.Unlock()
+
+	c.obs.onTCPConnClosed(c)
+}
+
+// Read reads data from the connection.
+// Read can be made to time out and return an Error with Timeout() == true
+// after a fixed time limit; see SetDeadline and SetReadDeadline.
+func (c *TCPConn) Read(b []byte) (int, error) {
+	for {
+		c.mutex.Lock()
+		if c.closed {
+			c.mutex.Unlock()
+			return 0, c.opError("read", errUseClosedNetworkConn)
+		}
+		if c.readBuf.Len() > 0 {
+			window := c.rcvWindow()
+			n, _ := c.readBuf.Read(b)
+			var out []*chunkTCP
+			if window < c.mss && c.rcvWindow() >= c.mss && !c.finReceived && c.state != tcpStateClosed {
+				// the window reopens. tell the peer rather than leave it to
+				// its next window probe.
+				out = append(out, c.newChunk(0, c.sndNxt))
+			}
+			c.mutex.Unlock()
+
+			c.send(out)
+			return n, nil
+		}
+		if c.finReceived || c.readClosed {
+			c.mutex.Unlock()
+			return 0, io.EOF
+		}
+		if c.err != nil {
+			err := c.err
+			c.mutex.Unlock()
+			return 0, c.opError("read", err)
+		}
+		wakeCh := c.wakeCh
+		c.mutex.Unlock()
+
+		select {
+		case <-wakeCh:
+		case <-c.readTimer.Done():
+			return 0, c.opError("read", newTimeoutError("i/o timeout"))
+		}
+	}
+}
+
+// Write writes data to the connection.
+// Write can be made to time out and return an Error with Timeout() == true
+// after a fixed time limit; see SetDeadline and SetWriteDeadline.
+func (c *TCPConn) Write(b []byte) (int, error) {
+	written := 0
+
+	for {
+		c.mutex.Lock()
+		if c.closed {
+			c.mutex.Unlock()
+			return written, c.opError("write", errUseClosedNetworkConn)
+		}
+		if c.err != nil {
+			err := c.err
+			c.mutex.Unlock()
+			return written, c.opError("write", err)
+		}
+		if c.writeClosed {
+			c.mutex.Unlock()
+			return written, c.opError("write", errBrokenPipe)
+		}
+
+		window := c.sndWnd
+		if window > tcpSendWindow {
+			window = tcpSendWindow
+		}
+		var out []*chunkTCP
+		for written < len(b) {
+			inFlight := int(c.sndNxt - c.sndUna)
+			room := window - inFlight
+			if room <= 0 {
+				if window > 0 || inFlight > 0 {
+					break
+				}
+				// the window of the peer is closed. a single byte probes
+				// it until it reopens (RFC 1122 Section 4.2.2.17).
+				room = 1
+			}
+			n := len(b) - written
+			if n > c.sndMSS {
+				n = c.sndMSS
+			}
+			if n > room {
+				n = room
+			}
+			data := make([]byte, n)
+			copy(data, b[written:written+n])
+			out = append(out, c.queueSegment(0, data)...)
+			written += n
+		}
+		wakeCh := c.wakeCh
+		c.mutex.Unlock()
+
+		c.send(out)
+
+		if written == len(b) {
+			return written, nil
+		}
+
+		select {
+		case <-wakeCh:
+		case <-c.writeTimer.Done():
+			return written, c.opError("write", newTimeoutError("i/o timeout"))
+		}
+	}
+}
+
+// ReadFrom implements the io.ReaderFrom ReadFrom method.
+func (c *TCPConn) ReadFrom(r io.Reader) (int64, error) {
+	// hide ReadFrom from io.Copy to avoid recursion
+	return io.Copy(struct{ io.Writer }{c}, r)
+}
+
+// caller must hold the mutex
+func (c *TCPConn) shutdownWrite() []*chunkTCP {
+	if c.writeClosed {
+		return nil
+	}
+	c.writeClosed = true
+
+	switch c.state {
+	case tcpStateEstablished:
+		c.state = tcpStateFinWait1
+	case tcpStateCloseWait:
+		c.state = tcpStateLastAck
+	default:
+		return nil
+	}
+
+	c.finSent = true
+	return c.queueSegment(tcpFIN, nil)
+}
+
+// Close closes the connection. Unless SetLinger(0) was called, data that
+// has already been written is delivered in the background before the
+// connection is shut down with a FIN.
+func (c *TCPConn) Close() error {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return errAlreadyClosed
+	}
+	c.closed = true
+	c.readClosed = true
+	c.readBuf.Reset()
+
+	if c.linger == 0 || c.state == tcpStateSynSent || c.state == tcpStateSynReceived {
+		c.mutex.Unlock()
+		c.abort(errUseClosedNetworkConn)
+		return nil
+	}
+
+	out := c.shutdownWrite()
+	closed := false
+	if c.state == tcpStateClosed {
+		closed = true
+	} else if c.isFinished() {
+		closed = c.finish()
+	}
+	c.wake()
+	c.mutex.Unlock()
+
+	c.send(out)
+	if closed {
+		c.obs.onTCPConnClosed(c)
+	}
+	return nil
+}
+
+// CloseRead shuts down the reading side of the TCP connection.
+// Most callers should just use Close.
+func (c *TCPConn) CloseRead() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return c.opError("close", errUseClosedNetworkConn)
+	}
+	c.readClosed = true
+	c.readBuf.Reset()
+	c.wake()
+	return nil
+}
+
+// CloseWrite shuts down the writing side of the TCP connection.
+// Most callers should just use Close.
+func (c *TCPConn) CloseWrite() error {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return c.opError("close", errUseClosedNetworkConn)
+	}
+	out := c.shutdownWrite()
+	c.wake()
+	c.mutex.Unlock()
+
+	c.send(out)
+	return nil
+}
+
+// LocalAddr returns the local network address.
+func (c *TCPConn) LocalAddr() net.Addr {
+	return c.locAddr
+}
+
+// RemoteAddr returns the remote network address.
+func (c *TCPConn) RemoteAddr() net.Addr {
+	return c.remAddr
+}
+
+// SetDeadline sets the read and write deadlines associated
+// with the connection. It is equivalent to calling both
+// SetReadDeadline and SetWriteDeadline.
+func (c *TCPConn) SetDeadline(t time.Time) error {
+	c.readTimer.Set(t)
+	c.writeTimer.Set(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline for future Read calls
+// and any currently-blocked Read call.
+// A zero value for t means Read will not time out.
+func (c *TCPConn) SetReadDeadline(t time.Time) error {
+	c.readTimer.Set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for future Write calls
+// and any currently-blocked Write call.
+// Even if write times out, it may return n > 0, indicating that
+// some of the data was successfully written.
+// A zero value for t means Write will not time out.
+func (c *TCPConn) SetWriteDeadline(t time.Time) error {
+	c.writeTimer.Set(t)
+	return nil
+}
+
+// SetLinger sets the behavior of Close on a connection which still
+// has data waiting to be sent or to be acknowledged.
+// If sec == 0, Close discards any unsent or unacknowledged data and resets
+// the connection. Any other value delivers the data in the background.
+func (c *TCPConn) SetLinger(sec int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.linger = sec
+	return nil
+}
+
+// SetKeepAlive is a no-op, as idle virtual connections never break.
+func (c *TCPConn) SetKeepAlive(bool) error {
+	return nil
+}
+
+// SetKeepAlivePeriod is a no-op, as idle virtual connections never break.
+func (c *TCPConn) SetKeepAlivePeriod(time.Duration) error {
+	return nil
+}
+
+// SetNoDelay is a no-op. Data is always sent as soon as possible after a
+// Write.
+func (c *TCPConn) SetNoDelay(bool) error {
+	return nil
+}
+
+// SetWriteBuffer sets the size of the operating system's
+// transmit buffer associated with the connection.
+func (c *TCPConn) SetWriteBuffer(int) error {
+	return transport.ErrNotSupported
+}
+
+// SetReadBuffer sets the size of the operating system's
+// receive buffer associated with the connection.
+func (c *TCPConn) SetReadBuffer(int) error {
+	return transport.ErrNotSupported
+}
+
+func (c *TCPConn) opError(op string, err error) error {
+	return &net.OpError{
+		Op:     op,
+		Net:    tcp,
+		Source: c.locAddr,
+		Addr:   c.remAddr,
+		Err:    err,
+	}
+}
+
+// newTCPReset creates a RST in response to a segment that does not belong
+// to any connection (RFC 793 Section 3.4, "Reset Generation").
+func newTCPReset(seg *chunkTCP) *chunkTCP {
+	src := seg.DestinationAddr().(*net.TCPAddr) //nolint:forcetypeassert
+	dst := seg.SourceAddr().(*net.TCPAddr)      //nolint:forcetypeassert
+
+	if seg.flags&tcpACK != 0 {
+		rst := newChunkTCP(src, dst, tcpRST)
+		rst.seq = seg.ack
+		return rst
+	}
+
+	rst := newChunkTCP(src, dst, tcpRST|tcpACK)
+	rst.ack = seg.seq + seg.segmentLen()
+	return rst
+}
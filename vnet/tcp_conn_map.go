@@ -0,0 +1,178 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+var errNoSuchTCPListener = errors.New("no such TCPListener")
+
+// tcpConnMap keeps track of TCP listeners, keyed by local port, and of TCP
+// connections, keyed by their local and remote transport addresses.
+type tcpConnMap struct {
+	portMap map[int][]*TCPListener
+	conns   map[string]*TCPConn // key: "<local-addr>-<remote-addr>"
+	mutex   sync.RWMutex
+}
+
+func newTCPConnMap() *tcpConnMap {
+	return &tcpConnMap{
+		portMap: map[int][]*TCPListener{},
+		conns:   map[string]*TCPConn{},
+	}
+}
+
+func tcpConnKey(locAddr, remAddr net.Addr) string {
+	return locAddr.String() + "-" + remAddr.String()
+}
+
+func (m *tcpConnMap) insertListener(l *TCPListener) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	tcpAddr := l.locAddr
+
+	listeners, ok := m.portMap[tcpAddr.Port]
+	if ok {
+		for _, l2 := range listeners {
+			if ipMatches(tcpAddr.IP, l2.locAddr.IP) || ipMatches(l2.locAddr.IP, tcpAddr.IP) {
+				return errAddressAlreadyInUse
+			}
+		}
+	}
+
+	m.portMap[tcpAddr.Port] = append(listeners, l)
+	return nil
+}
+
+func (m *tcpConnMap) findListener(addr net.Addr) (*TCPListener, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	tcpAddr := addr.(*net.TCPAddr) //nolint:forcetypeassert
+
+	for _, l := range m.portMap[tcpAddr.Port] {
+		if ipMatches(l.locAddr.IP, tcpAddr.IP) {
+			return l, true
+		}
+	}
+
+	return nil, false
+}
+
+func (m *tcpConnMap) deleteListener(addr net.Addr) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	tcpAddr := addr.(*net.TCPAddr) //nolint:forcetypeassert
+
+	listeners, ok := m.portMap[tcpAddr.Port]
+	if !ok {
+		return errNoSuchTCPListener
+	}
+
+	newListeners := []*TCPListener{}
+	for _, l := range listeners {
+		if l.locAddr.IP.Equal(tcpAddr.IP) {
+			continue
+		}
+		newListeners = append(newListeners, l)
+	}
+
+	if len(newListeners) == 0 {
+		delete(m.portMap, tcpAddr.Port)
+	} else {
+		m.portMap[tcpAddr.Port] = newListeners
+	}
+
+	return nil
+}
+
+func (m *tcpConnMap) insertConn(conn *TCPConn) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := tcpConnKey(conn.locAddr, conn.remAddr)
+	if _, ok := m.conns[key]; ok {
+		return errAddressAlreadyInUse
+	}
+
+	m.conns[key] = conn
+	return nil
+}
+
+func (m *tcpConnMap) findConn(locAddr, remAddr net.Addr) (*TCPConn, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	conn, ok := m.conns[tcpConnKey(locAddr, remAddr)]
+	return conn, ok
+}
+
+func (m *tcpConnMap) deleteConn(conn *TCPConn) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := tcpConnKey(conn.locAddr, conn.remAddr)
+	if m.conns[key] == conn {
+		delete(m.conns, key)
+	}
+}
+
+// inUse returns true if a listener or a connection is bound to the address.
+// Connections in TIME-WAIT stay in the map, and so keep the address in use.
+func (m *tcpConnMap) inUse(addr *net.TCPAddr) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, l := range m.portMap[addr.Port] {
+		if ipMatches(addr.IP, l.locAddr.IP) || ipMatches(l.locAddr.IP, addr.IP) {
+			return true
+		}
+	}
+
+	for _, conn := range m.conns {
+		if conn.locAddr.Port != addr.Port {
+			continue
+		}
+		if ipMatches(addr.IP, conn.locAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// all returns the TCP listeners and connections
+func (m *tcpConnMap) all() ([]*TCPListener, []*TCPConn) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var listeners []*TCPListener
+	for _, ls := range m.portMap {
+		listeners = append(listeners, ls...)
+	}
+	conns := make([]*TCPConn, 0, len(m.conns))
+	for _, conn := range m.conns {
+		conns = append(conns, conn)
+	}
+
+	return listeners, conns
+}
+
+// size returns the number of TCP listeners and connections
+func (m *tcpConnMap) size() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	n := len(m.conns)
+	for _, listeners := range m.portMap {
+		n += len(listeners)
+	}
+
+	return n
+}
@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type myTCPConnObserver struct{}
+
+func (obs *myTCPConnObserver) write(Chunk) error {
+	return nil
+}
+
+func (obs *myTCPConnObserver) onTCPConnClosed(*TCPConn) {
+}
+
+func TestTCPConnMap(t *testing.T) {
+	t.Run("listeners", func(t *testing.T) {
+		connMap := newTCPConnMap()
+
+		wildcard, err := newTCPListener(&net.TCPAddr{IP: net.IPv4zero, Port: 443}, &myConnObserver{})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, connMap.insertListener(wildcard), "should succeed")
+
+		specific, err := newTCPListener(&net.TCPAddr{IP: net.ParseIP(demoIP), Port: 443}, &myConnObserver{})
+		assert.NoError(t, err, "should succeed")
+		assert.ErrorIs(t, connMap.insertListener(specific), errAddressAlreadyInUse, "should fail")
+
+		l, ok := connMap.findListener(&net.TCPAddr{IP: net.ParseIP(demoIP), Port: 443})
+		assert.True(t, ok, "should find the wildcard listener")
+		assert.Equal(t, wildcard, l, "should match")
+
+		_, ok = connMap.findListener(&net.TCPAddr{IP: net.ParseIP(demoIP), Port: 80})
+		assert.False(t, ok, "should not find")
+
+		assert.True(t, connMap.inUse(&net.TCPAddr{IP: net.ParseIP(demoIP), Port: 443}), "should be in use")
+		assert.NoError(t, connMap.deleteListener(wildcard.locAddr), "should succeed")
+		assert.ErrorIs(t, connMap.deleteListener(wildcard.locAddr), errNoSuchTCPListener, "should fail")
+		assert.Equal(t, 0, connMap.size(), "should match")
+	})
+
+	t.Run("conns", func(t *testing.T) {
+		connMap := newTCPConnMap()
+
+		locAddr := &net.TCPAddr{IP: net.ParseIP(demoIP), Port: 5000}
+		remAddr := &net.TCPAddr{IP: net.ParseIP("5.6.7.8"), Port: 443}
+
+		conn, err := newTCPConn(locAddr, remAddr, &myTCPConnObserver{}, nil, 0)
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, connMap.insertConn(conn), "should succeed")
+
+		dup, err := newTCPConn(locAddr, remAddr, &myTCPConnObserver{}, nil, 0)
+		assert.NoError(t, err, "should succeed")
+		assert.ErrorIs(t, connMap.insertConn(dup), errAddressAlreadyInUse, "should fail")
+
+		found, ok := connMap.findConn(locAddr, remAddr)
+		assert.True(t, ok, "should find")
+		assert.Equal(t, conn, found, "should match")
+
+		_, ok = connMap.findConn(remAddr, locAddr)
+		assert.False(t, ok, "should not find")
+
+		assert.True(t, connMap.inUse(&net.TCPAddr{IP: net.IPv4zero, Port: 5000}), "should be in use")
+		assert.False(t, connMap.inUse(&net.TCPAddr{IP: net.IPv4zero, Port: 5001}), "should not be in use")
+
+		// deleting a different conn with the same key must be a no-op
+		connMap.deleteConn(dup)
+		assert.Equal(t, 1, connMap.size(), "should match")
+		connMap.deleteConn(conn)
+		assert.Equal(t, 0, connMap.size(), "should match")
+	})
+}
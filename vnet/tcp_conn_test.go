@@ -0,0 +1,461 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/transport/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+// tcpTestNetwork builds a WAN with a server Net and a client Net. If natType
+// is not nil, the client sits behind a LAN router with that NAT.
+type tcpTestNetwork struct {
+	wan      *Router
+	server   *Net
+	client   *Net
+	serverIP string
+}
+
+func newTCPTestNetwork(t *testing.T, natType *NATType, wrap func(NIC) NIC) *tcpTestNetwork {
+	t.Helper()
+
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	wan, err := NewRouter(&RouterConfig{
+		CIDR:          "1.2.3.0/24",
+		LoggerFactory: loggerFactory,
+	})
+	assert.NoError(t, err, "should succeed")
+
+	server, err := NewNet(&NetConfig{StaticIPs: []string{"1.2.3.4"}})
+	assert.NoError(t, err, "should succeed")
+
+	var serverNIC NIC = server
+	if wrap != nil {
+		serverNIC = wrap(server)
+	}
+	assert.NoError(t, wan.AddNet(serverNIC), "should succeed")
+
+	client, err := NewNet(&NetConfig{})
+	assert.NoError(t, err, "should succeed")
+
+	if natType == nil {
+		var clientNIC NIC = client
+		if wrap != nil {
+			clientNIC = wrap(client)
+		}
+		assert.NoError(t, wan.AddNet(clientNIC), "should succeed")
+	} else {
+		lan, err := NewRouter(&RouterConfig{
+			CIDR:          "192.168.0.0/24",
+			NATType:       natType,
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, wan.AddRouter(lan), "should succeed")
+		assert.NoError(t, lan.AddNet(client), "should succeed")
+	}
+
+	assert.NoError(t, wan.Start(), "should succeed")
+
+	return &tcpTestNetwork{
+		wan:      wan,
+		server:   server,
+		client:   client,
+		serverIP: "1.2.3.4",
+	}
+}
+
+// echo accepts a single connection and echoes everything back until EOF.
+func echo(t *testing.T, l transport.TCPListener) <-chan net.Addr {
+	t.Helper()
+
+	remoteCh := make(chan net.Addr, 1)
+	go func() {
+		conn, err := l.Accept()
+		if !assert.NoError(t, err, "should succeed") {
+			close(remoteCh)
+			return
+		}
+		remoteCh <- conn.RemoteAddr()
+		_, err = io.Copy(conn, conn)
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, conn.Close(), "should succeed")
+	}()
+
+	return remoteCh
+}
+
+func transferAndVerify(t *testing.T, conn net.Conn, size int) {
+	t.Helper()
+
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	assert.NoError(t, err, "should succeed")
+
+	go func() {
+		_, err := conn.Write(data)
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, conn.(transport.TCPConn).CloseWrite(), "should succeed") //nolint:forcetypeassert
+	}()
+
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(30*time.Second)))
+	received, err := io.ReadAll(conn)
+	assert.NoError(t, err, "should succeed")
+	assert.True(t, bytes.Equal(data, received), "echoed data should match")
+}
+
+// tcpConnState returns the state of the connection between the addresses,
+// or CLOSED if there is none.
+func tcpConnState(nw *Net, locAddr, remAddr net.Addr) tcpState {
+	conn, ok := nw.tcpConns.findConn(locAddr, remAddr)
+	if !ok {
+		return tcpStateClosed
+	}
+
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	return conn.state
+}
+
+func TestTCPConn(t *testing.T) { //nolint:maintidx
+	t.Run("Loopback", func(t *testing.T) {
+		clock := NewManualClock(time.Now())
+		nw, err := NewNet(&NetConfig{Clock: clock})
+		assert.NoError(t, err, "should succeed")
+
+		l, err := nw.ListenTCP(tcp, &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+		assert.NoError(t, err, "should succeed")
+		echo(t, l)
+
+		conn, err := nw.Dial(tcp, l.Addr().String())
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, l.Addr().String(), conn.RemoteAddr().String(), "should match")
+
+		transferAndVerify(t, conn, 100*1000)
+
+		assert.NoError(t, conn.Close(), "should succeed")
+		assert.NoError(t, l.Close(), "should succeed")
+		assert.Eventually(t, func() bool {
+			return nw.tcpConns.size() == 1
+		}, time.Second, 10*time.Millisecond, "only the conn in TIME-WAIT should remain")
+
+		clock.Advance(tcpTimeWait)
+		assert.Eventually(t, func() bool {
+			return nw.tcpConns.size() == 0
+		}, time.Second, 10*time.Millisecond, "all conns should be removed")
+	})
+
+	t.Run("End-to-End", func(t *testing.T) {
+		tn := newTCPTestNetwork(t, nil, nil)
+		defer func() {
+			assert.NoError(t, tn.wan.Stop(), "should succeed")
+		}()
+
+		l, err := tn.server.ListenTCP(tcp, &net.TCPAddr{Port: 443})
+		assert.NoError(t, err, "should succeed")
+		defer l.Close() //nolint:errcheck
+		remoteCh := echo(t, l)
+
+		conn, err := tn.client.DialTCP(tcp, nil, &net.TCPAddr{IP: net.ParseIP(tn.serverIP), Port: 443})
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, conn.LocalAddr().String(), (<-remoteCh).String(), "should match")
+
+		transferAndVerify(t, conn, 200*1000)
+		assert.NoError(t, conn.Close(), "should succeed")
+	})
+
+	t.Run("Retransmission", func(t *testing.T) {
+		tn := newTCPTestNetwork(t, nil, func(nic NIC) NIC {
+			f, err := NewLossFilter(nic, 20)
+			assert.NoError(t, err, "should succeed")
+			return f
+		})
+		defer func() {
+			assert.NoError(t, tn.wan.Stop(), "should succeed")
+		}()
+
+		l, err := tn.server.ListenTCP(tcp, &net.TCPAddr{Port: 443})
+		assert.NoError(t, err, "should succeed")
+		defer l.Close() //nolint:errcheck
+		echo(t, l)
+
+		conn, err := tn.client.Dial(tcp, fmt.Sprintf("%s:443", tn.serverIP))
+		assert.NoError(t, err, "should succeed")
+
+		transferAndVerify(t, conn, 50*1000)
+		assert.NoError(t, conn.Close(), "should succeed")
+	})
+
+	t.Run("Through NAT", func(t *testing.T) {
+		tn := newTCPTestNetwork(t, &NATType{
+			MappingBehavior:   EndpointIndependent,
+			FilteringBehavior: EndpointAddrPortDependent,
+		}, nil)
+		defer func() {
+			assert.NoError(t, tn.wan.Stop(), "should succeed")
+		}()
+
+		l, err := tn.server.ListenTCP(tcp, &net.TCPAddr{Port: 443})
+		assert.NoError(t, err, "should succeed")
+		defer l.Close() //nolint:errcheck
+		remoteCh := echo(t, l)
+
+		conn, err := tn.client.Dial(tcp, fmt.Sprintf("%s:443", tn.serverIP))
+		assert.NoError(t, err, "should succeed")
+
+		remote := (<-remoteCh).(*net.TCPAddr) //nolint:forcetypeassert
+		assert.Equal(t, "1.2.3.1", remote.IP.String(), "should see the mapped address")
+		assert.NotEqual(t, conn.LocalAddr().String(), remote.String(), "should be translated")
+
+		transferAndVerify(t, conn, 10*1000)
+		assert.NoError(t, conn.Close(), "should succeed")
+	})
+
+	t.Run("Connection refused", func(t *testing.T) {
+		tn := newTCPTestNetwork(t, nil, nil)
+		defer func() {
+			assert.NoError(t, tn.wan.Stop(), "should succeed")
+		}()
+
+		_, err := tn.client.Dial(tcp, fmt.Sprintf("%s:443", tn.serverIP))
+		assert.ErrorIs(t, err, errConnectionRefused, "should be refused")
+		assert.Equal(t, 0, tn.client.tcpConns.size(), "should match")
+	})
+
+	t.Run("FIN", func(t *testing.T) {
+		tn := newTCPTestNetwork(t, nil, nil)
+		defer func() {
+			assert.NoError(t, tn.wan.Stop(), "should succeed")
+		}()
+
+		l, err := tn.server.ListenTCP(tcp, &net.TCPAddr{Port: 443})
+		assert.NoError(t, err, "should succeed")
+		defer l.Close() //nolint:errcheck
+
+		acceptCh := make(chan net.Conn)
+		go func() {
+			conn, err := l.Accept()
+			assert.NoError(t, err, "should succeed")
+			acceptCh <- conn
+		}()
+
+		conn, err := tn.client.Dial(tcp, fmt.Sprintf("%s:443", tn.serverIP))
+		assert.NoError(t, err, "should succeed")
+		server := <-acceptCh
+
+		_, err = conn.Write([]byte("bye"))
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, conn.Close(), "should succeed")
+
+		received, err := io.ReadAll(server)
+		assert.NoError(t, err, "should succeed")
+		assert.Equal(t, "bye", string(received), "should match")
+		assert.NoError(t, server.Close(), "should succeed")
+
+		assert.Eventually(t, func() bool {
+			return tn.server.tcpConns.size() == 1
+		}, time.Second, 10*time.Millisecond, "only the listener should remain")
+		assert.Equal(t, tcpStateTimeWait, tcpConnState(tn.client, conn.LocalAddr(), conn.RemoteAddr()),
+			"the side that closed first should be in TIME-WAIT")
+	})
+
+	t.Run("TIME-WAIT", func(t *testing.T) {
+		clock := NewManualClock(time.Now())
+		nw, err := NewNet(&NetConfig{Clock: clock})
+		assert.NoError(t, err, "should succeed")
+
+		l, err := nw.ListenTCP(tcp, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 443})
+		assert.NoError(t, err, "should succeed")
+		defer l.Close() //nolint:errcheck
+
+		acceptCh := make(chan net.Conn)
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					close(acceptCh)
+					return
+				}
+				acceptCh <- conn
+			}
+		}()
+
+		locAddr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5555}
+		remAddr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 443}
+		conn, err := nw.DialTCP(tcp, locAddr, remAddr)
+		assert.NoError(t, err, "should succeed")
+		server := <-acceptCh
+
+		assert.NoError(t, conn.Close(), "should succeed")
+		_, err = io.ReadAll(server)
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, server.Close(), "should succeed")
+		assert.Eventually(t, func() bool {
+			return tcpConnState(nw, remAddr, locAddr) == tcpStateClosed
+		}, time.Second, 10*time.Millisecond, "the side that closed last should be closed")
+		assert.Equal(t, tcpStateTimeWait, tcpConnState(nw, locAddr, remAddr), "should be in TIME-WAIT")
+
+		// the 4-tuple may not be reused right away
+		_, err = nw.DialTCP(tcp, locAddr, remAddr)
+		assert.ErrorIs(t, err, errAddressAlreadyInUse, "should fail")
+
+		clock.Advance(tcpTimeWait - time.Millisecond)
+		assert.Equal(t, tcpStateTimeWait, tcpConnState(nw, locAddr, remAddr), "should still be in TIME-WAIT")
+
+		clock.Advance(time.Millisecond)
+		assert.Eventually(t, func() bool {
+			return tcpConnState(nw, locAddr, remAddr) == tcpStateClosed
+		}, time.Second, 10*time.Millisecond, "should be closed")
+
+		conn, err = nw.DialTCP(tcp, locAddr, remAddr)
+		assert.NoError(t, err, "should succeed")
+		server = <-acceptCh
+		assert.NoError(t, conn.SetLinger(0), "should succeed")
+		assert.NoError(t, conn.Close(), "should succeed")
+		assert.NoError(t, server.Close(), "should succeed")
+	})
+
+	t.Run("Receive window", func(t *testing.T) {
+		nw, err := NewNet(&NetConfig{})
+		assert.NoError(t, err, "should succeed")
+
+		l, err := nw.ListenTCP(tcp, &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+		assert.NoError(t, err, "should succeed")
+		defer l.Close() //nolint:errcheck
+
+		acceptCh := make(chan net.Conn)
+		go func() {
+			conn, err := l.Accept()
+			assert.NoError(t, err, "should succeed")
+			acceptCh <- conn
+		}()
+
+		conn, err := nw.Dial(tcp, l.Addr().String())
+		assert.NoError(t, err, "should succeed")
+		server := (<-acceptCh).(*TCPConn) //nolint:forcetypeassert
+
+		data := make([]byte, 4*tcpReceiveWindow)
+		_, err = rand.Read(data)
+		assert.NoError(t, err, "should succeed")
+
+		writeDone := make(chan struct{})
+		go func() {
+			defer close(writeDone)
+			_, err := conn.Write(data)
+			assert.NoError(t, err, "should succeed")
+			assert.NoError(t, conn.(transport.TCPConn).CloseWrite(), "should succeed") //nolint:forcetypeassert
+		}()
+
+		// nobody reads: the sender stops once the window is full
+		assert.Eventually(t, func() bool {
+			server.mutex.Lock()
+			defer server.mutex.Unlock()
+			return server.readBuf.Len() == tcpReceiveWindow
+		}, 5*time.Second, 10*time.Millisecond, "should fill the receive window")
+		select {
+		case <-writeDone:
+			assert.Fail(t, "should block the writer")
+		case <-time.After(100 * time.Millisecond):
+		}
+		server.mutex.Lock()
+		assert.Equal(t, tcpReceiveWindow, server.readBuf.Len(), "should not buffer beyond the window")
+		server.mutex.Unlock()
+
+		assert.NoError(t, server.SetReadDeadline(time.Now().Add(30*time.Second)))
+		received, err := io.ReadAll(server)
+		assert.NoError(t, err, "should succeed")
+		assert.True(t, bytes.Equal(data, received), "received data should match")
+		<-writeDone
+
+		assert.NoError(t, conn.Close(), "should succeed")
+		assert.NoError(t, server.Close(), "should succeed")
+	})
+
+	t.Run("RST", func(t *testing.T) {
+		tn := newTCPTestNetwork(t, nil, nil)
+		defer func() {
+			assert.NoError(t, tn.wan.Stop(), "should succeed")
+		}()
+
+		l, err := tn.server.ListenTCP(tcp, &net.TCPAddr{Port: 443})
+		assert.NoError(t, err, "should succeed")
+		defer l.Close() //nolint:errcheck
+
+		acceptCh := make(chan transport.TCPConn)
+		go func() {
+			conn, err := l.AcceptTCP()
+			assert.NoError(t, err, "should succeed")
+			acceptCh <- conn
+		}()
+
+		conn, err := tn.client.DialTCP(tcp, nil, &net.TCPAddr{IP: net.ParseIP(tn.serverIP), Port: 443})
+		assert.NoError(t, err, "should succeed")
+		server := <-acceptCh
+
+		assert.NoError(t, server.SetLinger(0), "should succeed")
+		assert.NoError(t, server.Close(), "should succeed")
+
+		_, err = conn.Read(make([]byte, 10))
+		assert.ErrorIs(t, err, errConnectionReset, "should be reset")
+		_, err = conn.Write([]byte("hello"))
+		assert.ErrorIs(t, err, errConnectionReset, "should be reset")
+		assert.NoError(t, conn.Close(), "should succeed")
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		nw, err := NewNet(&NetConfig{})
+		assert.NoError(t, err, "should succeed")
+
+		l, err := nw.ListenTCP(tcp, &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+		assert.NoError(t, err, "should succeed")
+		defer l.Close() //nolint:errcheck
+
+		assert.NoError(t, l.SetDeadline(time.Now().Add(50*time.Millisecond)))
+		_, err = l.Accept()
+		var netErr net.Error
+		assert.True(t, errors.As(err, &netErr) && netErr.Timeout(), "should time out")
+		assert.NoError(t, l.SetDeadline(time.Time{}))
+
+		go func() {
+			_, _ = l.Accept()
+		}()
+
+		conn, err := nw.Dial(tcp, l.Addr().String())
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+		_, err = conn.Read(make([]byte, 10))
+		assert.True(t, errors.As(err, &netErr) && netErr.Timeout(), "should time out")
+		assert.NoError(t, conn.Close(), "should succeed")
+	})
+
+	t.Run("Address in use", func(t *testing.T) {
+		nw, err := NewNet(&NetConfig{})
+		assert.NoError(t, err, "should succeed")
+
+		l, err := nw.ListenTCP(tcp4, &net.TCPAddr{Port: 8080})
+		assert.NoError(t, err, "should succeed")
+
+		_, err = nw.ListenTCP(tcp4, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8080})
+		assert.ErrorIs(t, err, errAddressAlreadyInUse, "should fail")
+
+		assert.NoError(t, l.Close(), "should succeed")
+		assert.ErrorIs(t, l.Close(), errAlreadyClosed, "should fail")
+
+		l, err = nw.ListenTCP(tcp4, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8080})
+		assert.NoError(t, err, "should succeed")
+		assert.NoError(t, l.Close(), "should succeed")
+	})
+}
@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package vnet
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/transport/v3"
+	"github.com/pion/transport/v3/deadline"
+)
+
+const (
+	tcpAcceptBacklog = 128
+)
+
+// TCPListener is a TCP network listener on the virtual network.
+type TCPListener struct {
+	locAddr  *net.TCPAddr       // read-only
+	obs      connObserver       // read-only
+	acceptCh chan *TCPConn      // thread-safe
+	closeCh  chan struct{}      // thread-safe
+	closed   bool               // requires mutex
+	mutex    sync.Mutex         // to mutex closed flag
+	timer    *deadline.Deadline // thread-safe
+}
+
+var _ transport.TCPListener = &TCPListener{}
+
+func newTCPListener(locAddr *net.TCPAddr, obs connObserver) (*TCPListener, error) {
+	if obs == nil {
+		return nil, errObsCannotBeNil
+	}
+
+	return &TCPListener{
+		locAddr:  locAddr,
+		obs:      obs,
+		acceptCh: make(chan *TCPConn, tcpAcceptBacklog),
+		closeCh:  make(chan struct{}),
+		timer:    deadline.New(),
+	}, nil
+}
+
+// enqueue hands an established connection to Accept. It returns false if
+// the listener is closed or its backlog is full.
+func (l *TCPListener) enqueue(conn *TCPConn) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.closed {
+		return false
+	}
+
+	select {
+	case l.acceptCh <- conn:
+		return true
+	default:
+		return false
+	}
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *TCPListener) Accept() (net.Conn, error) {
+	return l.AcceptTCP()
+}
+
+// AcceptTCP accepts the next incoming call and returns the new
+// connection.
+func (l *TCPListener) AcceptTCP() (transport.TCPConn, error) {
+	select {
+	case conn := <-l.acceptCh:
+		return conn, nil
+	case <-l.closeCh:
+		return nil, l.opError(errUseClosedNetworkConn)
+	case <-l.timer.Done():
+		return nil, l.opError(newTimeoutError("i/o timeout"))
+	}
+}
+
+// Close closes the listener. Any blocked Accept operations will be
+// unblocked and return errors. Connections that have not been accepted yet
+// are reset.
+func (l *TCPListener) Close() error {
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		return errAlreadyClosed
+	}
+	l.closed = true
+	close(l.closeCh)
+	l.mutex.Unlock()
+
+	l.obs.onClosed(l.locAddr)
+
+	for {
+		select {
+		case conn := <-l.acceptCh:
+			conn.abort(errConnectionReset)
+		default:
+			return nil
+		}
+	}
+}
+
+// Addr returns the listener's network address.
+func (l *TCPListener) Addr() net.Addr {
+	return l.locAddr
+}
+
+// SetDeadline sets the deadline associated with the listener.
+// A zero time value disables the deadline.
+func (l *TCPListener) SetDeadline(t time.Time) error {
+	l.timer.Set(t)
+	return nil
+}
+
+func (l *TCPListener) opError(err error) error {
+	return &net.OpError{
+		Op:   "accept",
+		Net:  tcp,
+		Addr: l.locAddr,
+		Err:  err,
+	}
+}